@@ -1,6 +1,7 @@
 package bptree
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"math/rand"
@@ -526,6 +527,93 @@ func BenchmarkComparison_Mixed(b *testing.B) {
 	})
 }
 
+// ============================================================================
+// SCENARIO: IN-NODE SEARCH
+// ============================================================================
+//
+// findindex binary-searches a node's keys. At MaxKeys = 4 this barely
+// matters, but the cost of the old linear scan grows with node width.
+// Compare with: go test -bench=FindIndex -run=^$ ./bptree
+// ============================================================================
+
+// findIndexLinear is the linear scan findindex used before binary search.
+func findIndexLinear(node *Node, key []byte) int {
+	for i := range node.keys {
+		if bytes.Compare(node.keys[i], key) >= 0 {
+			return i
+		}
+	}
+	return len(node.keys)
+}
+
+// wideNode builds a node with width sorted keys, bypassing MaxKeys.
+func wideNode(width int) *Node {
+	node := &Node{isleaf: true}
+	for i := 0; i < width; i++ {
+		node.keys = append(node.keys, intToBytes(i*2))
+		node.values = append(node.values, nil)
+	}
+	return node
+}
+
+func TestFindIndexMatchesLinearScan(t *testing.T) {
+	for _, width := range []int{0, 1, 4, 33, 256} {
+		node := wideNode(width)
+		for i := -1; i <= width*2+1; i++ {
+			key := intToBytes(i)
+			if got, want := node.findindex(key), findIndexLinear(node, key); got != want {
+				t.Errorf("width %d, key %s: findindex = %d, linear = %d", width, key, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkFindIndex(b *testing.B) {
+	for _, width := range []int{4, 16, 64, 256, 1024} {
+		node := wideNode(width)
+		keys := make([][]byte, 1024)
+		for i := range keys {
+			keys[i] = intToBytes(rand.Intn(width * 2))
+		}
+
+		b.Run(fmt.Sprintf("Binary_%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				node.findindex(keys[i%len(keys)])
+			}
+		})
+		b.Run(fmt.Sprintf("Linear_%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				findIndexLinear(node, keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+// ============================================================================
+// SCENARIO: NODE CHURN
+// ============================================================================
+//
+// Inserting and deleting a sliding window of keys splits and merges nodes
+// constantly. Merged-away nodes are recycled through the tree's node pool,
+// so allocations per op stay flat.
+// Run with: go test -bench=NodeChurn -benchmem -run=^$ ./bptree
+// ============================================================================
+
+func BenchmarkNodeChurn(b *testing.B) {
+	const window = 10000
+	tree := &Btree{}
+	for i := 0; i < window; i++ {
+		tree.Insert(intToBytes(i), []byte("value"))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Insert(intToBytes(window+i), []byte("value"))
+		tree.Delete(intToBytes(i))
+	}
+}
+
 // ============================================================================
 // HELPER FUNCTIONS
 // ============================================================================
@@ -4,26 +4,45 @@ import (
 	"bytes"
 	"errors"
 	"sync"
+	"sync/atomic"
 )
 
 // Btree is a concurrent B+Tree implementation.
 //
-// CONCURRENCY MODEL:
-// - All write operations (Insert, Put, Delete) acquire treeLock.Lock()
-// - All read operations (Find, Get, GetRange) acquire treeLock.RLock()
-// - This ensures writes are serialized and readers see consistent state
+// CONCURRENCY MODEL (latch crabbing, B-Link reads):
+// - Point operations hold treeLock shared and latch nodes top-down
+// - Find latches one node at a time, using fences and right-sibling links to recover from splits
+// - Writers first descend with shared latches and latch only the leaf exclusively
+// - If the leaf could split or underflow, they retry with exclusive latches
+// - On that retry an ancestor stays latched only while the child below it is unsafe
+// - rootLock guards the root pointer while the root may split or collapse
+// - Whole-tree reads (GetRange, ScanRange, ...) keep their path read-latched
+// - Whole-tree writes take treeLock exclusively and need no node latches
 //
-// This approach provides:
-// - Correctness: Single lock prevents all race conditions
-// - Read concurrency: Multiple readers can proceed simultaneously
-// - Simplicity: Easy to reason about
-//
-// Tradeoff: Writes block all reads. For higher throughput, consider:
-// - Partitioning data across multiple trees
-// - Implementing full B-Link tree protocol with fine-grained locking
+// Latches are only ever acquired downwards (or on siblings while their parent
+// is held exclusively, or rightwards by Find holding nothing else), so the
+// protocol is deadlock-free. Writers on disjoint
+// subtrees, including concurrent deletes, proceed in parallel.
 type Btree struct {
 	root     *Node
-	treeLock sync.RWMutex // Single lock for all operations
+	treeLock sync.RWMutex // Shared by point operations, exclusive for whole-tree writes
+	rootLock sync.RWMutex // Guards the root pointer
+	size     int64        // Number of keys, updated atomically
+
+	nodes     atomic.Int64 // Nodes reachable from the root (see MemoryUsage)
+	dataBytes atomic.Int64 // Bytes of the keys and values stored
+
+	panicFree atomic.Bool                    // Recover panics into errors (see InvariantError)
+	failure   atomic.Pointer[InvariantError] // First violation, fails the tree
+
+	pool nodePool // Recycled nodes (see nodePool)
+
+	snapGen   atomic.Uint64 // Generation of the latest snapshot
+	snapshots atomic.Int32  // Unreleased snapshots
+
+	maxKeySize, maxValueSize atomic.Int64 // Size limits in bytes, 0 for none (see SetSizeLimits)
+
+	waits lockWaits // Contended point-operation latches (see LockWait)
 }
 
 // isSafe checks if a node has space for insertion (not full)
@@ -36,7 +55,7 @@ func (n *Node) getHighKey() Keytype {
 	if len(n.keys) == 0 {
 		return nil
 	}
-	return n.keys[len(n.keys)-1]
+	return n.key(len(n.keys) - 1)
 }
 
 // Put performs a thread-safe insert. Alias for Insert.
@@ -45,17 +64,21 @@ func (t *Btree) Put(key Keytype, value Valuetype) {
 }
 
 // splitNodeSimple splits a full node and inserts a new key.
-// Called under treeLock - no per-node locking needed.
+// The caller holds the node and its parent latched; newNode is not yet
+// reachable by other goroutines.
 func (t *Btree) splitNodeSimple(node *Node, insertKey Keytype, insertValue Valuetype, insertChildPos int, insertChild *Node) (Keytype, Valuetype, *Node) {
-	tempKeys := make([]Keytype, 0, len(node.keys)+1)
-	tempValues := make([]Valuetype, 0, len(node.values)+1)
+	var keyBuf [MaxKeys + 1]Keytype
+	var valueBuf [MaxKeys + 1]Valuetype
+	tempKeys := keyBuf[:0]
+	tempValues := valueBuf[:0]
 
 	insertPos := node.findindex(insertKey)
 
-	// Build temporary list with new key
-	tempKeys = append(tempKeys, node.keys[:insertPos]...)
-	tempKeys = append(tempKeys, insertKey)
-	tempKeys = append(tempKeys, node.keys[insertPos:]...)
+	// Build temporary list of full keys with the new key
+	tempKeys = node.appendKeys(tempKeys)
+	tempKeys = append(tempKeys, nil)
+	copy(tempKeys[insertPos+1:], tempKeys[insertPos:])
+	tempKeys[insertPos] = insertKey
 
 	tempValues = append(tempValues, node.values[:insertPos]...)
 	tempValues = append(tempValues, insertValue)
@@ -67,19 +90,16 @@ func (t *Btree) splitNodeSimple(node *Node, insertKey Keytype, insertValue Value
 	midKey := tempKeys[mid]
 	midValue := tempValues[mid]
 
-	newNode := NewNode(node.isleaf)
+	newNode := t.newNode(node.isleaf)
 
-	// Right node gets keys after median - copy to avoid shared backing array
-	rightKeys := tempKeys[mid+1:]
-	rightValues := tempValues[mid+1:]
-	newNode.keys = make([]Keytype, len(rightKeys), MaxKeys)
-	copy(newNode.keys, rightKeys)
-	newNode.values = make([]Valuetype, len(rightValues), MaxKeys)
-	copy(newNode.values, rightValues)
+	// Right node gets keys after median - copied out of the temporary buffers
+	newNode.setKeys(tempKeys[mid+1:])
+	newNode.values = append(newNode.values, tempValues[mid+1:]...)
 
 	// Handle children for internal nodes
 	if !node.isleaf {
-		tempChildren := make([]*Node, 0, len(node.children)+1)
+		var childBuf [MaxKeys + 2]*Node
+		tempChildren := childBuf[:0]
 
 		// Build temporary children list with new child
 		if insertChildPos >= 0 {
@@ -87,182 +107,400 @@ func (t *Btree) splitNodeSimple(node *Node, insertKey Keytype, insertValue Value
 			tempChildren = append(tempChildren, insertChild)
 			tempChildren = append(tempChildren, node.children[insertChildPos:]...)
 		} else {
-			tempChildren = node.children
+			tempChildren = append(tempChildren, node.children...)
 		}
 
-		rightChildren := tempChildren[mid+1:]
-		newNode.children = make([]*Node, len(rightChildren), MaxKeys+1)
-		copy(newNode.children, rightChildren)
-
-		leftChildren := tempChildren[:mid+1]
-		node.children = make([]*Node, len(leftChildren), MaxKeys+1)
-		copy(node.children, leftChildren)
+		newNode.children = append(newNode.children, tempChildren[mid+1:]...)
+		clear(node.children)
+		node.children = append(node.children[:0], tempChildren[:mid+1]...)
 	}
 
-	// Left node gets keys up to (but not including) median - copy to avoid shared backing array
-	leftKeys := tempKeys[:mid]
-	leftValues := tempValues[:mid]
-	node.keys = make([]Keytype, len(leftKeys), MaxKeys)
-	copy(node.keys, leftKeys)
-	node.values = make([]Valuetype, len(leftValues), MaxKeys)
-	copy(node.values, leftValues)
+	// Left node keeps keys up to (but not including) median, in place
+	node.setKeys(tempKeys[:mid])
+	clear(node.values)
+	node.values = append(node.values[:0], tempValues[:mid]...)
 
+	node.splitFences(newNode, midKey)
 	return midKey, midValue, newNode
 }
 
-// Insert inserts a key-value pair into the tree. Thread-safe.
+// Insert inserts a key-value pair into the tree. Thread-safe. It panics
+// with a *SizeLimitError for an entry over the tree's size limits (see
+// SetSizeLimits); TryInsert returns the error instead.
 func (tree *Btree) Insert(key Keytype, value Valuetype) {
-	tree.treeLock.Lock()
-	defer tree.treeLock.Unlock()
-
-	if tree.root == nil {
-		tree.root = NewNode(true)
-		tree.root.insertAt(0, key, value)
-		return
+	if err := tree.TryInsert(key, value); errors.Is(err, ErrTooLarge) {
+		panic(err)
 	}
+}
 
-	node := tree.root
-	var path []*Node
-	for !node.isleaf {
-		path = append(path, node)
-		idx := node.findindex(key)
-		node = node.children[idx]
-	}
+// TryInsert is Insert reporting an oversized entry, and an invariant
+// violation in panic-free mode.
+func (tree *Btree) TryInsert(key Keytype, value Valuetype) error {
+	_, _, err := tree.upsert(key, value)
+	return err
+}
 
-	idx := node.findindex(key)
-	if idx < len(node.keys) && bytes.Equal(node.keys[idx], key) {
-		node.values[idx] = value
-		return
+// ErrKeyExists is returned by InsertNX for a key that is already present.
+var ErrKeyExists = errors.New("key already exists")
+
+// Upsert inserts or overwrites key and reports whether it replaced a value.
+// Thread-safe.
+func (tree *Btree) Upsert(key Keytype, value Valuetype) (replaced bool, err error) {
+	_, replaced, err = tree.upsert(key, value)
+	return replaced, err
+}
+
+// InsertNX inserts key only if it is absent, and returns ErrKeyExists
+// otherwise. Thread-safe: the check and the insert happen under one latch.
+func (tree *Btree) InsertNX(key Keytype, value Valuetype) (err error) {
+	defer tree.guard("InsertNX", key, &err)
+	if err := tree.Err(); err != nil {
+		return err
 	}
+	if err := tree.checkSizes(key, value); err != nil {
+		return err
+	}
+	tree.maybeReclaim()
 
-	if len(node.keys) < MaxKeys {
-		node.insertAt(idx, key, value)
-		return
+	tree.waitRLock(&tree.treeLock)
+	defer tree.treeLock.RUnlock()
+
+	p := tree.latchForInsert(key)
+	defer p.release()
+
+	if p.found != nil {
+		return ErrKeyExists
 	}
+	p.insert(key, value)
+	return nil
+}
 
-	// Split logic
-	midKey, midValue, newNode := tree.splitNodeSimple(node, key, value, -1, nil)
+// upsert is TryInsert also returning the value key held, if any. The old
+// value is the stored slice, not a copy.
+func (tree *Btree) upsert(key Keytype, value Valuetype) (old Valuetype, existed bool, err error) {
+	defer tree.guard("Insert", key, &err)
+	if err := tree.Err(); err != nil {
+		return nil, false, err
+	}
+	if err := tree.checkSizes(key, value); err != nil {
+		return nil, false, err
+	}
+	tree.maybeReclaim()
 
-	for i := len(path) - 1; i >= 0; i-- {
-		parent := path[i]
-		childIdx := parent.findindex(midKey)
+	tree.waitRLock(&tree.treeLock)
+	defer tree.treeLock.RUnlock()
+	old, existed = tree.put(key, value)
+	return old, existed, nil
+}
 
-		if len(parent.keys) < MaxKeys {
-			parent.insertAt(childIdx, midKey, midValue)
-			parent.insertChildAt(childIdx+1, newNode)
-			return
-		}
+// put is upsert's write. Called under treeLock.
+func (tree *Btree) put(key Keytype, value Valuetype) (old Valuetype, existed bool) {
+	p := tree.latchForInsert(key)
+	defer p.release()
 
-		midKey, midValue, newNode = tree.splitNodeSimple(parent, midKey, midValue, childIdx+1, newNode)
+	if p.found != nil {
+		old = p.found.values[p.foundPos]
+		p.update(value)
+		return old, true
 	}
+	p.insert(key, value)
+	return nil, false
+}
 
-	// Need new root
-	newRoot := NewNode(false)
-	newRoot.keys = append(newRoot.keys, midKey)
-	newRoot.values = append(newRoot.values, midValue)
-	newRoot.children = append(newRoot.children, tree.root, newNode)
-	tree.root = newRoot
+// Len returns the number of keys in the tree.
+// O(1): reads a counter maintained by Insert and Delete, without locking.
+func (t *Btree) Len() int64 {
+	return atomic.LoadInt64(&t.size)
 }
 
-func (tree *Btree) splitNodeWithInsert(node *Node, insertKey Keytype, insertValue Valuetype, insertChildPos int, insertChild *Node) (Keytype, Valuetype, *Node) {
-	tempKeys := make([]Keytype, 0, len(node.keys)+1)
-	tempValues := make([]Valuetype, 0, len(node.values)+1)
+// Modify performs an atomic read-modify-write of a single key.
+// fn receives the current value (nil if the key is absent) and whether the key
+// exists, and returns the value to store and whether to store it at all.
+// fn runs under the node's write latch: it must not call back into the tree,
+// and it must not retain or modify old. Returns true if a value was written;
+// TryModify tells a declined write from a failed one.
+func (t *Btree) Modify(key Keytype, fn func(old Valuetype, exists bool) (Valuetype, bool)) bool {
+	written, _ := t.TryModify(key, fn)
+	return written
+}
 
-	insertPos := node.findindex(insertKey)
+// TryModify is Modify also returning why a value fn asked to store was not
+// written: a *SizeLimitError, or the tree's invariant violation.
+func (t *Btree) TryModify(key Keytype, fn func(old Valuetype, exists bool) (Valuetype, bool)) (written bool, err error) {
+	defer t.guard("Modify", key, &err)
+	if err := t.Err(); err != nil {
+		return false, err
+	}
 
-	tempKeys = append(tempKeys, node.keys[:insertPos]...)
-	tempKeys = append(tempKeys, insertKey)
-	tempKeys = append(tempKeys, node.keys[insertPos:]...)
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
 
-	tempValues = append(tempValues, node.values[:insertPos]...)
-	tempValues = append(tempValues, insertValue)
-	tempValues = append(tempValues, node.values[insertPos:]...)
+	p := t.latchForInsert(key)
+	defer p.release()
 
-	mid := len(tempKeys) / 2
+	if p.found != nil {
+		newValue, ok := fn(p.found.values[p.foundPos], true)
+		if !ok {
+			return false, nil
+		}
+		if err := t.checkSizes(nil, newValue); err != nil {
+			return false, err
+		}
+		p.update(newValue)
+		return true, nil
+	}
 
-	midKey := tempKeys[mid]
-	midValue := tempValues[mid]
+	newValue, ok := fn(nil, false)
+	if !ok {
+		return false, nil
+	}
+	if err := t.checkSizes(key, newValue); err != nil {
+		return false, err
+	}
+	p.insert(key, newValue)
+	return true, nil
+}
 
-	newNode := NewNode(node.isleaf)
-	newNode.mu.Lock()
+// CompareAndSwap sets key to newValue only if its current value equals oldValue.
+// Returns true if the swap happened; TryCompareAndSwap tells a mismatch from a
+// failure. Thread-safe.
+func (t *Btree) CompareAndSwap(key Keytype, oldValue, newValue Valuetype) bool {
+	swapped, _ := t.TryCompareAndSwap(key, oldValue, newValue)
+	return swapped
+}
 
-	newNode.keys = append(newNode.keys, tempKeys[mid+1:]...)
-	newNode.values = append(newNode.values, tempValues[mid+1:]...)
+// TryCompareAndSwap is CompareAndSwap also returning why it could not swap
+// at all: a *SizeLimitError for newValue, or the tree's invariant violation.
+// A mismatched or missing key is no error.
+func (t *Btree) TryCompareAndSwap(key Keytype, oldValue, newValue Valuetype) (swapped bool, err error) {
+	defer t.guard("CompareAndSwap", key, &err)
+	if err := t.Err(); err != nil {
+		return false, err
+	}
+	if err := t.checkSizes(nil, newValue); err != nil {
+		return false, err
+	}
 
-	if !node.isleaf {
-		tempChildren := make([]*Node, 0, len(node.children)+1)
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
 
-		tempChildren = append(tempChildren, node.children[:insertChildPos]...)
-		tempChildren = append(tempChildren, insertChild)
-		tempChildren = append(tempChildren, node.children[insertChildPos:]...)
+	p := t.latchForUpdate(key)
+	defer p.release()
 
-		newNode.children = append(newNode.children, tempChildren[mid+1:]...)
-		node.children = tempChildren[:mid+1]
+	if p.found == nil || !bytes.Equal(p.found.values[p.foundPos], oldValue) {
+		return false, nil
 	}
+	p.update(newValue)
+	return true, nil
+}
 
-	node.keys = tempKeys[:mid]
-	node.values = tempValues[:mid]
-	if newNode != nil {
-		newNode.mu.Unlock()
+// CompareAndDelete removes key only if its current value equals oldValue.
+// Returns true if the key was deleted. Thread-safe.
+func (t *Btree) CompareAndDelete(key Keytype, oldValue Valuetype) bool {
+	var err error
+	defer t.guard("CompareAndDelete", key, &err)
+	if t.Err() != nil {
+		return false
 	}
-	return midKey, midValue, newNode
+
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
+
+	p := t.latchForDelete(key)
+	defer p.release()
+
+	if p.found == nil || !bytes.Equal(p.found.values[p.foundPos], oldValue) {
+		return false
+	}
+	return p.remove()
 }
 
 // Delete removes a key from the tree. Thread-safe.
 func (t *Btree) Delete(key []byte) bool {
-	t.treeLock.Lock()
-	defer t.treeLock.Unlock()
+	deleted, _ := t.TryDelete(key)
+	return deleted
+}
 
-	if t.root == nil {
-		return false
+// TryDelete is Delete reporting an invariant violation in panic-free mode.
+func (t *Btree) TryDelete(key []byte) (bool, error) {
+	_, deleted, err := t.extract(key)
+	return deleted, err
+}
+
+// extract is TryDelete also returning the deleted value, the stored slice.
+func (t *Btree) extract(key []byte) (old Valuetype, deleted bool, err error) {
+	defer t.guard("Delete", key, &err)
+	if err := t.Err(); err != nil {
+		return nil, false, err
 	}
 
-	deletedkey, _ := t.root.delete(key, false)
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
+	old, deleted = t.remove(key)
+	return old, deleted, nil
+}
 
-	if len(t.root.keys) == 0 {
-		if t.root.isleaf {
-			t.root = nil
-		} else if len(t.root.children) > 0 {
-			t.root = t.root.children[0]
-		}
+// remove is extract's delete. Called under treeLock.
+func (t *Btree) remove(key []byte) (old Valuetype, deleted bool) {
+	p := t.latchForDelete(key)
+	defer p.release()
+	if p.found != nil {
+		old = p.found.values[p.foundPos]
 	}
-
-	return deletedkey != nil
+	return old, p.remove()
 }
 
 // Find searches for a key in the tree. Thread-safe.
-func (t *Btree) Find(key []byte) ([]byte, error) {
-	t.treeLock.RLock()
+//
+// B-Link traversal: at most one node is read-latched at a time, so a reader
+// never waits on a writer further down the tree. A reader that lands on a
+// node split after it read the parent's pointer follows the right-sibling
+// link; one that lands on a merged-away node or outside the node's fences
+// restarts from the root.
+func (t *Btree) Find(key []byte) (value []byte, err error) {
+	return t.find("Find", key, func(stored []byte) []byte {
+		// Make a copy of the value to return
+		valueCopy := make([]byte, len(stored))
+		copy(valueCopy, stored)
+		return valueCopy
+	})
+}
+
+// FindInto is Find copying the value into dst, which it grows only if the
+// value does not fit, and returning the filled slice. Reusing the result as
+// the next dst avoids an allocation per read.
+func (t *Btree) FindInto(key, dst []byte) ([]byte, error) {
+	return t.find("FindInto", key, func(stored []byte) []byte {
+		return append(dst[:0], stored...)
+	})
+}
+
+// FindUnsafe is Find without the copy: it returns the stored value itself.
+// The caller must not modify it, and should treat it as valid only until the
+// next mutation of key: it is the slice the writer passed to Insert, and
+// nothing stops the writer from reusing that buffer.
+func (t *Btree) FindUnsafe(key []byte) ([]byte, error) {
+	return t.find("FindUnsafe", key, func(stored []byte) []byte { return stored })
+}
+
+// find looks key up, passing the stored value to read while its node is
+// read-latched and returning what read returns.
+func (t *Btree) find(op string, key []byte, read func(stored []byte) []byte) (value []byte, err error) {
+	defer t.guard(op, key, &err)
+	if err := t.Err(); err != nil {
+		return nil, err
+	}
+
+	t.waitRLock(&t.treeLock)
 	defer t.treeLock.RUnlock()
+	return t.lookup(key, read)
+}
 
-	if t.root == nil {
-		return nil, errors.New("key not found")
+// lookup is find's descent. Called under treeLock.
+func (t *Btree) lookup(key []byte, read func(stored []byte) []byte) ([]byte, error) {
+	for attempt := 0; attempt < maxFindRestarts; attempt++ {
+		value, done, err := t.findBLink(key, read)
+		if done {
+			return value, err
+		}
 	}
+	// Persistent interference: fall back to lock coupling, which cannot miss
+	return t.findCoupled(key, read)
+}
+
+// maxFindRestarts bounds B-Link restarts before Find falls back to coupling.
+const maxFindRestarts = 4
 
+// findBLink is one B-Link descent. done is false if the reader must restart.
+func (t *Btree) findBLink(key []byte, read func(stored []byte) []byte) (value []byte, done bool, err error) {
+	t.rootLock.RLock()
 	current := t.root
+	t.rootLock.RUnlock()
+	if current == nil {
+		return nil, true, errors.New("key not found")
+	}
+
+	t.waitRLock(&current.mu)
 	for {
-		pos := current.findindex(key)
+		if current.dead || (current.hasLowKey && bytes.Compare(key, current.lowKey) <= 0) {
+			current.mu.RUnlock()
+			return nil, false, nil
+		}
+		if current.hasHighKey {
+			switch cmp := bytes.Compare(key, current.highKey); {
+			case cmp == 0:
+				// key is a separator above us that moved up after we passed
+				current.mu.RUnlock()
+				return nil, false, nil
+			case cmp > 0:
+				// Split since we read the pointer: move right
+				next := current.rightSibling
+				current.mu.RUnlock()
+				if next == nil {
+					return nil, false, nil
+				}
+				t.waitRLock(&next.mu)
+				current = next
+				continue
+			}
+		}
 
-		if pos < len(current.keys) && bytes.Equal(current.keys[pos], key) {
-			// Make a copy of the value to return
-			valueCopy := make([]byte, len(current.values[pos]))
-			copy(valueCopy, current.values[pos])
-			return valueCopy, nil
+		pos := current.findindex(key)
+		if current.hasKeyAt(pos, key) {
+			value = read(current.values[pos])
+			current.mu.RUnlock()
+			return value, true, nil
 		}
 
 		if current.isleaf {
-			return nil, errors.New("key not found")
+			current.mu.RUnlock()
+			return nil, true, errors.New("key not found")
 		}
 
-		if pos >= len(current.children) {
-			return nil, errors.New("invalid tree structure")
+		if pos >= len(current.children) || current.children[pos] == nil {
+			violation := invariantf(current, "no child %d to descend to", pos)
+			current.mu.RUnlock()
+			return nil, true, violation
 		}
 
 		next := current.children[pos]
-		if next == nil {
-			return nil, errors.New("invalid tree structure")
+		current.mu.RUnlock()
+		t.waitRLock(&next.mu)
+		current = next
+	}
+}
+
+// findCoupled searches with read-latch coupling. Called under treeLock.
+func (t *Btree) findCoupled(key []byte, read func(stored []byte) []byte) ([]byte, error) {
+	current := t.rlockRoot()
+	if current == nil {
+		return nil, errors.New("key not found")
+	}
+
+	for {
+		pos := current.findindex(key)
+
+		if current.hasKeyAt(pos, key) {
+			value := read(current.values[pos])
+			current.mu.RUnlock()
+			return value, nil
 		}
 
+		if current.isleaf {
+			current.mu.RUnlock()
+			return nil, errors.New("key not found")
+		}
+
+		if pos >= len(current.children) || current.children[pos] == nil {
+			violation := invariantf(current, "no child %d to descend to", pos)
+			current.mu.RUnlock()
+			return nil, violation
+		}
+
+		// Lock coupling: latch the child before releasing the parent
+		next := current.children[pos]
+		next.mu.RLock()
+		current.mu.RUnlock()
 		current = next
 	}
 }
@@ -272,91 +510,451 @@ func (t *Btree) Get(key []byte) ([]byte, error) {
 	return t.Find(key)
 }
 
-func (n *Node) delete(key []byte, isSeekingSuccessor bool) (Keytype, Valuetype) {
-	pos := n.findindex(key)
+// rlockRoot returns the root read-latched, or nil if the tree is empty.
+// Called under treeLock.
+func (t *Btree) rlockRoot() *Node {
+	t.rootLock.RLock()
+	defer t.rootLock.RUnlock()
 
-	if n.isleaf && isSeekingSuccessor {
-		return n.removeAt(0)
+	root := t.root
+	if root != nil {
+		root.mu.RLock()
 	}
+	return root
+}
 
-	found := false
-	if pos < len(n.keys) && bytes.Equal(n.keys[pos], key) {
-		found = true
+// Safety predicates for descend: a safe node absorbs the operation without
+// changing its parent.
+func insertSafe(n *Node) bool { return len(n.keys) < MaxKeys }
+func deleteSafe(n *Node) bool { return len(n.keys) > MinKeys }
+func alwaysSafe(*Node) bool   { return true }
+
+// writePath is the set of nodes a writer holds exclusive latches on.
+type writePath struct {
+	tree      *Btree
+	nodes     []*Node // Latched suffix of the descent, top-down
+	idxs      []int   // idxs[i] is the child index taken from nodes[i]
+	holdsRoot bool    // rootLock is held, so nodes[0] is the root
+
+	found     *Node // Node holding the key, nil if absent
+	foundPos  int
+	foundHeld bool // found is latched but no longer part of nodes
+}
+
+// latchForInsert latches the path an insert or overwrite of key needs.
+func (t *Btree) latchForInsert(key []byte) *writePath {
+	if p := t.descendOptimistic(key); p != nil {
+		if p.found != nil || insertSafe(p.nodes[0]) {
+			return p
+		}
+		p.release()
 	}
+	return t.descend(key, insertSafe, false)
+}
 
-	if !found && n.isleaf {
-		return nil, nil // Key doesn't exist in the tree
+// latchForDelete latches the path a delete of key needs.
+func (t *Btree) latchForDelete(key []byte) *writePath {
+	if p := t.descendOptimistic(key); p != nil {
+		if p.found == nil || deleteSafe(p.nodes[0]) {
+			return p
+		}
+		p.release()
 	}
+	return t.descend(key, deleteSafe, true)
+}
 
-	var next *Node
-	if found {
-		if n.isleaf {
-			return n.removeAt(pos)
+// latchForUpdate latches the node holding key for an in-place value change.
+func (t *Btree) latchForUpdate(key []byte) *writePath {
+	if p := t.descendOptimistic(key); p != nil {
+		return p
+	}
+	// A value swap never changes structure, so every node is safe
+	return t.descend(key, alwaysSafe, false)
+}
+
+// descendOptimistic walks to the leaf for key with shared latches and
+// returns a path holding only that leaf, exclusively latched. Returns nil if
+// the tree is empty or key sits in an internal node, whose shared latch
+// cannot be upgraded. Called under treeLock (shared).
+func (t *Btree) descendOptimistic(key []byte) *writePath {
+	t.rootLock.RLock()
+	node := t.root
+	if node == nil {
+		t.rootLock.RUnlock()
+		return nil
+	}
+	t.latchForLeaf(node)
+	t.rootLock.RUnlock()
+
+	for {
+		pos := node.findindex(key)
+		found := node.hasKeyAt(pos, key)
+		if node.isleaf {
+			p := &writePath{tree: t, nodes: []*Node{node}}
+			if found {
+				p.found, p.foundPos = node, pos
+			}
+			return p
+		}
+		if found {
+			node.mu.RUnlock()
+			return nil
+		}
+
+		if pos >= len(node.children) || node.children[pos] == nil {
+			violation := invariantf(node, "no child %d to descend to", pos)
+			node.mu.RUnlock()
+			panic(violation)
 		}
-		next, isSeekingSuccessor = n.children[pos+1], true
+		child := node.children[pos]
+		t.latchForLeaf(child)
+		node.mu.RUnlock()
+		node = child
+	}
+}
+
+// latchForLeaf latches a leaf exclusively and an internal node shared.
+// isleaf never changes once a node is reachable, so reading it unlatched is safe.
+func (t *Btree) latchForLeaf(n *Node) {
+	if n.isleaf {
+		t.waitLock(&n.mu)
 	} else {
-		if pos >= len(n.children) {
-			return nil, nil // Key would be past last child, doesn't exist
+		t.waitRLock(&n.mu)
+	}
+}
+
+// descend walks from the root towards key taking exclusive latches hand over
+// hand. Ancestors are released as soon as a child satisfies safe, since no
+// structural change can propagate above it. It stops at the node holding key
+// or at the leaf where key belongs; with seekSuccessor, a key found in an
+// internal node is kept latched and the descent continues to the leaf holding
+// its successor. Called under treeLock (shared).
+func (t *Btree) descend(key []byte, safe func(*Node) bool, seekSuccessor bool) *writePath {
+	p := &writePath{tree: t}
+
+	t.waitLock(&t.rootLock)
+	p.holdsRoot = true
+	node := t.root
+	if node == nil {
+		return p
+	}
+	t.waitLock(&node.mu)
+	p.nodes = append(p.nodes, node)
+	if safe(node) {
+		p.releaseAncestors()
+	}
+
+	for {
+		var pos int
+		if p.found != nil {
+			pos = 0 // Successor is the leftmost key of the right subtree
+		} else {
+			pos = node.findindex(key)
+			if node.hasKeyAt(pos, key) {
+				p.found, p.foundPos = node, pos
+				if node.isleaf || !seekSuccessor {
+					return p
+				}
+				pos++
+			}
+		}
+		if node.isleaf {
+			return p
+		}
+
+		if pos >= len(node.children) || node.children[pos] == nil {
+			violation := invariantf(node, "no child %d to descend to", pos)
+			p.release()
+			panic(violation)
+		}
+		child := node.children[pos]
+		t.waitLock(&child.mu)
+		p.idxs = append(p.idxs, pos)
+		p.nodes = append(p.nodes, child)
+		if safe(child) {
+			p.releaseAncestors()
+		}
+		node = child
+	}
+}
+
+// releaseAncestors unlatches every held node above the deepest one, except
+// the node holding the key, which a delete still has to rewrite.
+func (p *writePath) releaseAncestors() {
+	if p.holdsRoot {
+		p.tree.rootLock.Unlock()
+		p.holdsRoot = false
+	}
+	last := len(p.nodes) - 1
+	for _, n := range p.nodes[:last] {
+		if n == p.found {
+			p.foundHeld = true
+			continue
 		}
-		next = n.children[pos]
+		n.mu.Unlock()
 	}
+	p.nodes[0] = p.nodes[last]
+	p.nodes = p.nodes[:1]
+	p.idxs = p.idxs[:0]
+}
 
-	deletedkey, deletedvalue := next.delete(key, isSeekingSuccessor)
+// release unlatches everything the path still holds.
+func (p *writePath) release() {
+	for _, n := range p.nodes {
+		n.mu.Unlock()
+	}
+	if p.foundHeld {
+		p.found.mu.Unlock()
+	}
+	if p.holdsRoot {
+		p.tree.rootLock.Unlock()
+	}
+	p.nodes = nil
+	p.foundHeld = false
+	p.holdsRoot = false
+}
 
-	if deletedkey == nil {
-		return nil, nil
+// update overwrites the value of the key descend found.
+func (p *writePath) update(value Valuetype) {
+	p.tree.preserve(p.found)
+	p.tree.dataBytes.Add(int64(len(value) - len(p.found.values[p.foundPos])))
+	p.found.values[p.foundPos] = value
+}
+
+// insert adds a key that descend did not find, splitting full nodes up the
+// latched path.
+func (p *writePath) insert(key Keytype, value Valuetype) {
+	tree := p.tree
+	atomic.AddInt64(&tree.size, 1)
+	tree.dataBytes.Add(int64(len(key) + len(value)))
+
+	if len(p.nodes) == 0 {
+		// Empty tree: rootLock is still held
+		root := tree.newNode(true)
+		root.insertAt(0, key, value)
+		tree.root = root
+		return
 	}
 
-	if found && isSeekingSuccessor {
-		n.keys[pos] = deletedkey
-		n.values[pos] = deletedvalue
+	node := p.nodes[len(p.nodes)-1]
+	tree.preserve(node)
+	idx := node.findindex(key)
+
+	if len(node.keys) < MaxKeys {
+		node.insertAt(idx, key, value)
+		return
 	}
 
-	if len(next.keys) < MinKeys {
-		if found && isSeekingSuccessor {
-			n.fillChildAt(pos + 1)
-		} else {
-			n.fillChildAt(pos)
+	// Split logic
+	midKey, midValue, newNode := tree.splitNodeSimple(node, key, value, -1, nil)
+
+	for i := len(p.nodes) - 2; i >= 0; i-- {
+		parent := p.nodes[i]
+		childIdx := p.idxs[i]
+		tree.preserve(parent)
+
+		if len(parent.keys) < MaxKeys {
+			parent.insertAt(childIdx, midKey, midValue)
+			parent.insertChildAt(childIdx+1, newNode)
+			return
 		}
+
+		midKey, midValue, newNode = tree.splitNodeSimple(parent, midKey, midValue, childIdx+1, newNode)
 	}
 
-	return deletedkey, deletedvalue
+	// Need new root: the whole path was unsafe, so rootLock is still held
+	newRoot := tree.newNode(false)
+	newRoot.insertAt(0, midKey, midValue)
+	newRoot.children = append(newRoot.children, p.nodes[0], newNode)
+	tree.root = newRoot
 }
 
-func (n *Node) fillChildAt(pos int) {
+// remove deletes the key descend found, replacing an internal key with its
+// successor and rebalancing underflowing nodes up the latched path.
+// Returns false if the key was not found.
+func (p *writePath) remove() bool {
+	if p.found == nil {
+		return false
+	}
+	tree := p.tree
+	tree.dataBytes.Add(-p.found.entryBytes(p.foundPos))
+
+	leaf := p.nodes[len(p.nodes)-1]
+	tree.preserve(leaf)
+	tree.preserve(p.found)
+	if p.found == leaf {
+		leaf.removeAt(p.foundPos)
+	} else {
+		succKey, succValue := leaf.removeAt(0)
+		p.found.setKey(p.foundPos, succKey)
+		p.found.values[p.foundPos] = succValue
+
+		// The separator moved up from the key to its successor: the leaf it
+		// came from now starts after it, and the left subtree extends to it
+		leaf.lowKey = succKey
+		if p.foundPos >= len(p.found.children) {
+			panic(invariantf(p.found, "no left subtree for key %d", p.foundPos))
+		}
+		p.found.children[p.foundPos].raiseRightFences(succKey)
+	}
+	atomic.AddInt64(&tree.size, -1)
+
+	for i := len(p.nodes) - 1; i > 0; i-- {
+		if len(p.nodes[i].keys) >= MinKeys {
+			break
+		}
+		if merged := tree.fillChildLatched(p.nodes[i-1], p.idxs[i-1]); merged != nil {
+			tree.retire(merged)
+		}
+	}
+
+	if p.holdsRoot {
+		root := tree.root
+		if len(root.keys) == 0 {
+			if root.isleaf {
+				tree.root = nil
+				root.dead = true
+				tree.retire(root)
+			} else if len(root.children) > 0 {
+				tree.root = root.children[0]
+				root.dead = true
+				tree.retire(root)
+			}
+		}
+	}
+
+	return true
+}
+
+// raiseRightFences sets the high fence of n and of every node on its
+// rightmost path to key, latching hand over hand. The caller holds n's parent
+// exclusively, so no split can move that path's high fence meanwhile.
+func (n *Node) raiseRightFences(key Keytype) {
+	n.mu.Lock()
+	for {
+		n.highKey = key
+		if n.isleaf {
+			n.mu.Unlock()
+			return
+		}
+		if len(n.children) == 0 {
+			violation := invariantf(n, "internal node has no children")
+			n.mu.Unlock()
+			panic(violation)
+		}
+		next := n.children[len(n.children)-1]
+		next.mu.Lock()
+		n.mu.Unlock()
+		n = next
+	}
+}
+
+// fillChildLatched rebalances the child at pos of n, latching its siblings
+// first. The caller holds n and the child exclusively. Returns the node a
+// merge unlinked, or nil.
+func (t *Btree) fillChildLatched(n *Node, pos int) *Node {
+	if pos >= len(n.children) || len(n.children) < 2 {
+		panic(invariantf(n, "cannot rebalance child %d", pos))
+	}
+
+	var siblings []*Node
+	if pos > 0 {
+		siblings = append(siblings, n.children[pos-1])
+	}
+	if pos < len(n.children)-1 {
+		siblings = append(siblings, n.children[pos+1])
+	}
+
+	for _, sibling := range siblings {
+		sibling.mu.Lock()
+		defer sibling.mu.Unlock()
+		t.preserve(sibling)
+	}
+	t.preserve(n)
+	t.preserve(n.children[pos])
+	return n.fillChildAt(pos)
+}
+
+func (tree *Btree) splitNodeWithInsert(node *Node, insertKey Keytype, insertValue Valuetype, insertChildPos int, insertChild *Node) (Keytype, Valuetype, *Node) {
+	tempKeys := make([]Keytype, 0, len(node.keys)+1)
+	tempValues := make([]Valuetype, 0, len(node.values)+1)
+
+	insertPos := node.findindex(insertKey)
+
+	tempKeys = node.appendKeys(tempKeys)
+	tempKeys = append(tempKeys, nil)
+	copy(tempKeys[insertPos+1:], tempKeys[insertPos:])
+	tempKeys[insertPos] = insertKey
+
+	tempValues = append(tempValues, node.values[:insertPos]...)
+	tempValues = append(tempValues, insertValue)
+	tempValues = append(tempValues, node.values[insertPos:]...)
+
+	mid := len(tempKeys) / 2
+
+	midKey := tempKeys[mid]
+	midValue := tempValues[mid]
+
+	newNode := NewNode(node.isleaf)
+	newNode.mu.Lock()
+
+	newNode.setKeys(tempKeys[mid+1:])
+	newNode.values = append(newNode.values, tempValues[mid+1:]...)
+
+	if !node.isleaf {
+		tempChildren := make([]*Node, 0, len(node.children)+1)
+
+		tempChildren = append(tempChildren, node.children[:insertChildPos]...)
+		tempChildren = append(tempChildren, insertChild)
+		tempChildren = append(tempChildren, node.children[insertChildPos:]...)
+
+		newNode.children = append(newNode.children, tempChildren[mid+1:]...)
+		node.children = tempChildren[:mid+1]
+	}
+
+	node.setKeys(tempKeys[:mid])
+	node.values = tempValues[:mid]
+	if newNode != nil {
+		newNode.mu.Unlock()
+	}
+	return midKey, midValue, newNode
+}
+
+func (n *Node) fillChildAt(pos int) *Node {
 	switch {
 
 	case pos > 0 && len(n.children[pos-1].keys) > MinKeys:
 		left, right := n.children[pos-1], n.children[pos]
 
-		right.keys = append([]Keytype{n.keys[pos-1]}, right.keys...)
-		right.values = append([]Valuetype{n.values[pos-1]}, right.values...)
+		right.insertAt(0, n.key(pos-1), n.values[pos-1])
 
 		if !right.isleaf {
-			right.children = append([]*Node{left.children[len(left.children)-1]}, right.children...)
+			right.insertChildAt(0, left.children[len(left.children)-1])
+			left.children[len(left.children)-1] = nil
 			left.children = left.children[:len(left.children)-1]
 		}
 
-		n.keys[pos-1] = left.keys[len(left.keys)-1]
-		n.values[pos-1] = left.values[len(left.values)-1]
-		left.keys = left.keys[:len(left.keys)-1]
-		left.values = left.values[:len(left.values)-1]
+		separator, value := left.removeAt(len(left.keys) - 1)
+		n.setKey(pos-1, separator)
+		n.values[pos-1] = value
+		left.highKey, right.lowKey = separator, separator
 
 	case pos < len(n.children)-1 && len(n.children[pos+1].keys) > MinKeys:
 		left, right := n.children[pos], n.children[pos+1]
 
-		left.keys = append(left.keys, n.keys[pos])
-		left.values = append(left.values, n.values[pos])
+		left.insertAt(len(left.keys), n.key(pos), n.values[pos])
 
 		if !left.isleaf {
 			left.children = append(left.children, right.children[0])
-			right.children = right.children[1:]
+			copy(right.children, right.children[1:])
+			right.children[len(right.children)-1] = nil
+			right.children = right.children[:len(right.children)-1]
 		}
 
-		n.keys[pos] = right.keys[0]
-		n.values[pos] = right.values[0]
-		right.keys = right.keys[1:]
-		right.values = right.values[1:]
+		separator, value := right.removeAt(0)
+		n.setKey(pos, separator)
+		n.values[pos] = value
+		left.highKey, right.lowKey = separator, separator
 
 	// Merge casee
 	default:
@@ -366,21 +964,29 @@ func (n *Node) fillChildAt(pos int) {
 
 		left, right := n.children[pos], n.children[pos+1]
 
-		// Append parent key to left node
-		left.keys = append(left.keys, n.keys[pos])
+		// Append parent key and all right node keys to left node
+		var keyBuf [MaxKeys + 1]Keytype
+		merged := left.appendKeys(keyBuf[:0])
+		merged = append(merged, n.key(pos))
+		merged = right.appendKeys(merged)
+		left.setKeys(merged)
 		left.values = append(left.values, n.values[pos])
-
-		// Append all right node keys to left node
-		left.keys = append(left.keys, right.keys...)
 		left.values = append(left.values, right.values...)
 
 		if !left.isleaf {
 			left.children = append(left.children, right.children...)
 		}
 
+		// Left takes over right's key range and sibling link
+		left.highKey, left.hasHighKey = right.highKey, right.hasHighKey
+		left.rightSibling = right.rightSibling
+		right.dead = true
+
 		// Remove the parent key and right child pointer
 		n.keys = append(n.keys[:pos], n.keys[pos+1:]...)
 		n.values = append(n.values[:pos], n.values[pos+1:]...)
 		n.children = append(n.children[:pos+1], n.children[pos+2:]...)
+		return right
 	}
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/rand"
 	_ "net/http/pprof"
 	"sort"
 	"strings"
@@ -596,61 +597,339 @@ func TestBTreePut(t *testing.T) {
 	}
 }
 
-func validateBTreeProperties(tree *Btree) error {
-	if tree.root == nil {
-		return nil
+func TestBTreeInsertOverwritesInternalKey(t *testing.T) {
+	tree := &Btree{}
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tree.Insert([]byte(k), []byte(k))
 	}
 
-	return validateNode(tree.root, nil, nil, true)
+	// "c" is promoted to the root by the first split
+	tree.Insert([]byte("c"), []byte("updated"))
+
+	value, err := tree.Find([]byte("c"))
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if string(value) != "updated" {
+		t.Errorf("Find returned %q, want %q", value, "updated")
+	}
+	if n := tree.countKeys(); n != 5 {
+		t.Errorf("Expected 5 keys after overwrite, got %d", n)
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Errorf("Tree invalid after overwrite: %v", err)
+	}
 }
 
-func validateNode(node *Node, min, max []byte, isRoot bool) error {
-	if !isRoot && len(node.keys) < MinKeys {
-		return fmt.Errorf("node has fewer than minimum keys required")
+func TestBTreeLen(t *testing.T) {
+	tree := &Btree{}
+	if tree.Len() != 0 {
+		t.Errorf("Empty tree Len() = %d, want 0", tree.Len())
 	}
 
-	if len(node.keys) > MaxKeys {
-		return fmt.Errorf("node has more than maximum keys allowed")
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), []byte("v"))
+	}
+	// Overwrites, including keys held in internal nodes, must not be counted
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), []byte("v2"))
+	}
+	if tree.Len() != 100 {
+		t.Errorf("Len() = %d after overwrites, want 100", tree.Len())
 	}
 
-	for i := 1; i < len(node.keys); i++ {
-		if bytes.Compare(node.keys[i-1], node.keys[i]) >= 0 {
-			return fmt.Errorf("keys are not in strictly ascending order")
+	for i := 0; i < 100; i += 3 {
+		tree.Delete([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	tree.Delete([]byte("missing"))
+	if tree.Len() != tree.countKeys() {
+		t.Errorf("Len() = %d, but tree holds %d keys", tree.Len(), tree.countKeys())
+	}
+
+	for i := 0; i < 100; i++ {
+		tree.Delete([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	if tree.Len() != 0 {
+		t.Errorf("Len() = %d after deleting everything, want 0", tree.Len())
+	}
+}
+
+func TestBTreeCompareAndSwap(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		tree.Insert(key, []byte("v1"))
+	}
+
+	if tree.CompareAndSwap([]byte("key05"), []byte("wrong"), []byte("v2")) {
+		t.Error("CompareAndSwap should fail when old value does not match")
+	}
+	if tree.CompareAndSwap([]byte("missing"), []byte("v1"), []byte("v2")) {
+		t.Error("CompareAndSwap should fail for a missing key")
+	}
+
+	// Every key must be swappable, whether it lives in a leaf or internal node
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if !tree.CompareAndSwap(key, []byte("v1"), []byte("v2")) {
+			t.Errorf("CompareAndSwap failed for %s", key)
+		}
+		value, _ := tree.Find(key)
+		if string(value) != "v2" {
+			t.Errorf("Key %s: got %q, want %q", key, value, "v2")
 		}
 	}
+}
 
-	if min != nil && bytes.Compare(node.keys[0], min) < 0 {
-		return fmt.Errorf("key less than minimum allowed")
+func TestBTreeCompareAndDelete(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		tree.Insert(key, key)
+	}
+
+	if tree.CompareAndDelete([]byte("key03"), []byte("wrong")) {
+		t.Error("CompareAndDelete should fail when value does not match")
 	}
-	if max != nil && bytes.Compare(node.keys[len(node.keys)-1], max) > 0 {
-		return fmt.Errorf("key greater than maximum allowed")
+	if _, err := tree.Find([]byte("key03")); err != nil {
+		t.Error("Key should still exist after failed CompareAndDelete")
 	}
 
-	if !node.isleaf {
-		if len(node.children) != len(node.keys)+1 {
-			return fmt.Errorf("invalid number of children")
+	for i := 0; i < 20; i += 2 {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if !tree.CompareAndDelete(key, key) {
+			t.Errorf("CompareAndDelete failed for %s", key)
 		}
+	}
 
-		for i, child := range node.children {
-			var childMin, childMax []byte
-			if i > 0 {
-				childMin = node.keys[i-1]
-			} else {
-				childMin = min
-			}
-			if i < len(node.keys) {
-				childMax = node.keys[i]
-			} else {
-				childMax = max
+	if n := tree.countKeys(); n != 10 {
+		t.Errorf("Expected 10 keys remaining, got %d", n)
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Errorf("Tree invalid after CompareAndDelete: %v", err)
+	}
+}
+
+func TestBTreeCompareAndSwapConcurrentCounter(t *testing.T) {
+	tree := &Btree{}
+	key := []byte("counter")
+	tree.Insert(key, []byte("0"))
+
+	const goroutines = 8
+	const increments = 100
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					old, _ := tree.Find(key)
+					var n int
+					fmt.Sscanf(string(old), "%d", &n)
+					if tree.CompareAndSwap(key, old, []byte(fmt.Sprintf("%d", n+1))) {
+						break
+					}
+				}
 			}
+		}()
+	}
+	wg.Wait()
 
-			if err := validateNode(child, childMin, childMax, false); err != nil {
-				return err
+	value, _ := tree.Find(key)
+	if want := fmt.Sprintf("%d", goroutines*increments); string(value) != want {
+		t.Errorf("Counter = %s, want %s", value, want)
+	}
+}
+
+func TestBTreeModify(t *testing.T) {
+	tree := &Btree{}
+
+	// Absent key: fn sees exists=false and may create it
+	written := tree.Modify([]byte("list"), func(old Valuetype, exists bool) (Valuetype, bool) {
+		if exists || old != nil {
+			t.Errorf("Expected absent key, got exists=%v old=%q", exists, old)
+		}
+		return Valuetype("a"), true
+	})
+	if !written {
+		t.Error("Modify should report a write when fn returns true")
+	}
+
+	// Existing key: append to the stored value
+	tree.Modify([]byte("list"), func(old Valuetype, exists bool) (Valuetype, bool) {
+		if !exists {
+			t.Error("Expected key to exist")
+		}
+		return append(append(Valuetype{}, old...), ",b"...), true
+	})
+	if v, _ := tree.Find([]byte("list")); string(v) != "a,b" {
+		t.Errorf("Find returned %q, want %q", v, "a,b")
+	}
+
+	// Declining to write leaves the tree untouched
+	if tree.Modify([]byte("other"), func(Valuetype, bool) (Valuetype, bool) { return nil, false }) {
+		t.Error("Modify should report no write when fn returns false")
+	}
+	if _, err := tree.Find([]byte("other")); err == nil {
+		t.Error("Key should not be created when fn returns false")
+	}
+}
+
+func TestBTreeModifyConcurrentCounter(t *testing.T) {
+	tree := &Btree{}
+	// Spread counters across many keys so some live in internal nodes
+	const keys = 20
+	const goroutines = 8
+	const increments = 50
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for k := 0; k < keys; k++ {
+					key := []byte(fmt.Sprintf("counter%02d", k))
+					tree.Modify(key, func(old Valuetype, exists bool) (Valuetype, bool) {
+						n := 0
+						if exists {
+							fmt.Sscanf(string(old), "%d", &n)
+						}
+						return Valuetype(fmt.Sprintf("%d", n+1)), true
+					})
+				}
 			}
+		}()
+	}
+	wg.Wait()
+
+	want := fmt.Sprintf("%d", goroutines*increments)
+	for k := 0; k < keys; k++ {
+		key := []byte(fmt.Sprintf("counter%02d", k))
+		if v, _ := tree.Find(key); string(v) != want {
+			t.Errorf("%s = %s, want %s", key, v, want)
 		}
 	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Errorf("Tree invalid after concurrent Modify: %v", err)
+	}
+}
 
-	return nil
+func TestBTreeScanRange(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		tree.Insert(key, key)
+	}
+
+	var got []string
+	err := tree.ScanRange([]byte("key020"), []byte("key039"), func(key Keytype, value Valuetype) bool {
+		got = append(got, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("ScanRange failed: %v", err)
+	}
+	if len(got) != 20 || got[0] != "key020" || got[19] != "key039" || !sort.StringsAreSorted(got) {
+		t.Errorf("ScanRange visited %v", got)
+	}
+
+	// Early termination
+	visited := 0
+	tree.ScanRange([]byte("key000"), []byte("key099"), func(key Keytype, value Valuetype) bool {
+		visited++
+		return visited < 5
+	})
+	if visited != 5 {
+		t.Errorf("Expected ScanRange to stop after 5 pairs, visited %d", visited)
+	}
+
+	if err := tree.ScanRange([]byte("b"), []byte("a"), func(Keytype, Valuetype) bool { return true }); err == nil {
+		t.Error("Expected error for inverted range")
+	}
+
+	empty := &Btree{}
+	if err := empty.ScanRange([]byte("a"), []byte("z"), func(Keytype, Valuetype) bool {
+		t.Error("Callback should not run on an empty tree")
+		return true
+	}); err != nil {
+		t.Errorf("ScanRange on empty tree failed: %v", err)
+	}
+}
+
+func TestBTreeRangeIterator(t *testing.T) {
+	tree := &Btree{}
+	// More keys than rangeBatchSize so iteration spans several batches
+	const n = 300
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		tree.Insert(key, key)
+	}
+
+	var got []string
+	for k, v := range tree.All() {
+		if !bytes.Equal(k, v) {
+			t.Errorf("Key %s has value %s", k, v)
+		}
+		got = append(got, string(k))
+	}
+	if len(got) != n {
+		t.Fatalf("All yielded %d pairs, want %d", len(got), n)
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Error("All did not yield keys in ascending order")
+	}
+
+	count := 0
+	for k := range tree.Range([]byte("key0100"), []byte("key0249")) {
+		if want := fmt.Sprintf("key%04d", 100+count); string(k) != want {
+			t.Fatalf("Range yielded %s, want %s", k, want)
+		}
+		count++
+	}
+	if count != 150 {
+		t.Errorf("Range yielded %d pairs, want 150", count)
+	}
+
+	// Early break
+	count = 0
+	for range tree.All() {
+		count++
+		if count == 10 {
+			break
+		}
+	}
+	if count != 10 {
+		t.Errorf("Expected early break after 10 pairs, got %d", count)
+	}
+
+	// Inverted range yields nothing
+	for k := range tree.Range([]byte("z"), []byte("a")) {
+		t.Errorf("Inverted range yielded %s", k)
+	}
+}
+
+func TestBTreeRangeIteratorMutationInLoop(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		tree.Insert(key, key)
+	}
+
+	// The lock is not held while yielding, so deleting inside the loop must not deadlock
+	for k := range tree.All() {
+		tree.Delete(k)
+	}
+
+	if n := tree.countKeys(); n != 0 {
+		t.Errorf("Expected empty tree, got %d keys", n)
+	}
+}
+
+func validateBTreeProperties(tree *Btree) error {
+	return tree.CheckInvariants()
 }
 
 func traverseBFS(root *Node, visit func(key Keytype, value Valuetype)) {
@@ -665,7 +944,7 @@ func traverseBFS(root *Node, visit func(key Keytype, value Valuetype)) {
 		queue = queue[1:]
 
 		for i := 0; i < len(current.keys); i++ {
-			visit(current.keys[i], current.values[i])
+			visit(current.key(i), current.values[i])
 		}
 
 		if !current.isleaf {
@@ -702,9 +981,9 @@ func printBFS(t *testing.T, root *Node) {
 
 			// Include parent information in the log
 			if parentNode != nil {
-				t.Logf("%s Node %d keys: %v (Parent keys: %v)", indent, i, currentNode.keys, parentNode.keys)
+				t.Logf("%s Node %d keys: %v (Parent keys: %v)", indent, i, currentNode.appendKeys(nil), parentNode.appendKeys(nil))
 			} else {
-				t.Logf("%s Node %d keys: %v (Parent: nil)", indent, i, currentNode.keys)
+				t.Logf("%s Node %d keys: %v (Parent: nil)", indent, i, currentNode.appendKeys(nil))
 			}
 
 			// Add children to the queue along with their parent information
@@ -895,6 +1174,193 @@ func TestConcurrentDeleteAndInsert(t *testing.T) {
 	}
 }
 
+func TestConcurrentDeletesWithReadersAndWriters(t *testing.T) {
+	tree := &Btree{}
+	const (
+		numDeleters = 8
+		perDeleter  = 500
+		numWriters  = 4
+		perWriter   = 500
+	)
+	key := func(prefix string, g, i int) []byte {
+		return []byte(fmt.Sprintf("%s-%02d-%04d", prefix, g, i))
+	}
+
+	for g := 0; g < numDeleters; g++ {
+		for i := 0; i < perDeleter; i++ {
+			tree.Insert(key("del", g, i), key("del", g, i))
+			tree.Insert(key("keep", g, i), key("keep", g, i))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < numDeleters; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perDeleter; i++ {
+				if !tree.Delete(key("del", g, i)) {
+					t.Errorf("Delete(%s) = false", key("del", g, i))
+				}
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perDeleter; i++ {
+				if _, err := tree.Find(key("keep", g, i)); err != nil {
+					t.Errorf("Find(%s) during deletes: %v", key("keep", g, i), err)
+				}
+			}
+		}(g)
+	}
+	for g := 0; g < numWriters; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				tree.Insert(key("new", g, i), key("new", g, i))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatalf("Tree invalid after concurrent deletes: %v", err)
+	}
+	want := int64(numDeleters*perDeleter + numWriters*perWriter)
+	if tree.Len() != want || tree.countKeys() != want {
+		t.Errorf("Len() = %d, countKeys() = %d, want %d", tree.Len(), tree.countKeys(), want)
+	}
+	for g := 0; g < numDeleters; g++ {
+		for i := 0; i < perDeleter; i++ {
+			if _, err := tree.Find(key("del", g, i)); err == nil {
+				t.Errorf("Key %s still present after delete", key("del", g, i))
+			}
+		}
+	}
+}
+
+func TestBTreeLinksAfterRandomOps(t *testing.T) {
+	tree := &Btree{}
+	rng := rand.New(rand.NewSource(42))
+	for i := 0; i < 5000; i++ {
+		key := []byte(fmt.Sprintf("k%04d", rng.Intn(500)))
+		if rng.Intn(3) == 0 {
+			tree.Delete(key)
+		} else {
+			tree.Insert(key, key)
+		}
+		if i%50 == 0 {
+			if err := validateBTreeProperties(tree); err != nil {
+				t.Fatalf("After op %d: %v", i, err)
+			}
+		}
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTreeFindDuringSplitsAndMerges(t *testing.T) {
+	tree := &Btree{}
+	const stable = 200
+	for i := 0; i < stable; i++ {
+		key := []byte(fmt.Sprintf("stable-%04d", i))
+		tree.Insert(key, key)
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 0; !stop.Load(); round++ {
+				// Interleave churn keys with the stable ones to force splits and merges around them
+				for i := 0; i < stable; i++ {
+					tree.Insert([]byte(fmt.Sprintf("stable-%04d-%d", i, w)), []byte("churn"))
+				}
+				for i := 0; i < stable; i++ {
+					tree.Delete([]byte(fmt.Sprintf("stable-%04d-%d", i, w)))
+				}
+			}
+		}(w)
+	}
+
+	for pass := 0; pass < 20; pass++ {
+		for i := 0; i < stable; i++ {
+			key := []byte(fmt.Sprintf("stable-%04d", i))
+			value, err := tree.Find(key)
+			if err != nil || !bytes.Equal(value, key) {
+				t.Fatalf("Find(%s) during churn = %q, %v", key, value, err)
+			}
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatalf("Tree invalid after churn: %v", err)
+	}
+}
+
+func TestBTreePrefixCompression(t *testing.T) {
+	tree := &Btree{}
+	rng := rand.New(rand.NewSource(11))
+	present := make(map[string]bool)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("user:%07d", rng.Intn(600))
+		if rng.Intn(3) == 0 {
+			tree.Delete([]byte(key))
+			delete(present, key)
+		} else {
+			tree.Insert([]byte(key), []byte(key))
+			present[key] = true
+		}
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaves hold neighbouring keys, so they share more than "user:"
+	leaf := tree.root
+	for !leaf.isleaf {
+		leaf = leaf.children[0]
+	}
+	if len(leaf.prefix) <= len("user:") {
+		t.Errorf("Leaf prefix = %q, want longer than %q", leaf.prefix, "user:")
+	}
+	for i := range leaf.keys {
+		if full := leaf.key(i); !bytes.HasPrefix(full, leaf.prefix) || len(full) != len("user:0000000") {
+			t.Errorf("key(%d) = %q does not reconstruct a full key", i, full)
+		}
+	}
+
+	for key := range present {
+		if value, err := tree.Find([]byte(key)); err != nil || string(value) != key {
+			t.Errorf("Find(%s) = %q, %v", key, value, err)
+		}
+	}
+	keys, _, err := tree.GetRange([]byte("user:"), []byte("user:9999999"))
+	if err != nil || len(keys) != len(present) {
+		t.Fatalf("GetRange returned %d keys, %v; want %d", len(keys), err, len(present))
+	}
+	for i, key := range keys {
+		if !present[string(key)] || (i > 0 && bytes.Compare(keys[i-1], key) >= 0) {
+			t.Fatalf("GetRange key %d = %q out of order or unknown", i, key)
+		}
+	}
+
+	// A key outside the shared prefix shrinks it without losing neighbours
+	tree.Insert([]byte("admin"), []byte("admin"))
+	if value, err := tree.Find([]byte("admin")); err != nil || string(value) != "admin" {
+		t.Errorf("Find(admin) = %q, %v", value, err)
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGranularConcurrency(t *testing.T) {
 	// This test verifies concurrent operations work correctly
 	// Each worker operates on its own key range
@@ -1086,9 +1552,9 @@ func verifyTreeStructure(node *Node) error {
 
 	// Verify key ordering within node
 	for i := 1; i < len(node.keys); i++ {
-		if bytes.Compare(node.keys[i-1], node.keys[i]) >= 0 {
+		if bytes.Compare(node.key(i-1), node.key(i)) >= 0 {
 			return fmt.Errorf("keys not in order: %s >= %s",
-				string(node.keys[i-1]), string(node.keys[i]))
+				string(node.key(i-1)), string(node.key(i)))
 		}
 	}
 
@@ -1109,3 +1575,39 @@ func verifyTreeStructure(node *Node) error {
 
 	return nil
 }
+
+func TestFindInto(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i)))
+	}
+
+	buf := make([]byte, 0, 4)
+	buf, err := tree.FindInto([]byte("key042"), buf)
+	if err != nil || string(buf) != "value042" {
+		t.Fatalf("FindInto = %q, %v", buf, err)
+	}
+	if _, err := tree.FindInto([]byte("missing"), buf); err == nil {
+		t.Error("FindInto found a missing key")
+	}
+
+	// With a large enough buffer, reads do not allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = tree.FindInto([]byte("key007"), buf)
+	})
+	if allocs != 0 || string(buf) != "value007" {
+		t.Errorf("FindInto allocated %.0f times per read, got %q", allocs, buf)
+	}
+
+	stored := []byte("stored")
+	tree.Insert([]byte("shared"), stored)
+	if value, _ := tree.FindUnsafe([]byte("shared")); &value[0] != &stored[0] {
+		t.Error("FindUnsafe copied the value")
+	}
+
+	sharded := NewShardedBTree(ShardConfig{NumShards: 2})
+	sharded.Insert([]byte("k"), []byte("v"))
+	if value, err := sharded.FindInto([]byte("k"), buf); err != nil || string(value) != "v" {
+		t.Errorf("ShardedBTree.FindInto = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,146 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrTreeNotEmpty is returned by BulkLoad for a tree that already holds keys.
+var ErrTreeNotEmpty = errors.New("bulk load needs an empty tree")
+
+// BulkLoad fills an empty tree from strictly ascending keys in O(n), building
+// it bottom-up instead of inserting keys one by one.
+//
+// DESIGN:
+// - The height is the least that fits every key; each node gets as few children as the height allows
+// - Keys are spread evenly among siblings, so nodes are packed as full as the B-tree shape allows
+// - Right-sibling links and fences are set as a split would set them
+//
+// LIMITATIONS:
+// - Holds treeLock exclusively for the whole build, blocking other operations
+// - Keys and values are stored as given, as Insert stores them
+// - Packed nodes split on the next insert into them: bulk load data that is mostly read
+func (t *Btree) BulkLoad(keys []Keytype, values []Valuetype) (err error) {
+	defer t.guard("BulkLoad", nil, &err)
+	if err := t.Err(); err != nil {
+		return err
+	}
+	if len(keys) != len(values) {
+		return errors.New("keys and values must have the same length")
+	}
+	for i := range keys {
+		if i > 0 && bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			return fmt.Errorf("keys must be strictly ascending: %q at %d follows %q", keys[i], i, keys[i-1])
+		}
+		if err := t.checkSizes(keys[i], values[i]); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+
+	if atomic.LoadInt64(&t.size) != 0 {
+		return ErrTreeNotEmpty
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	height := 1
+	for subtreeCapacity(height) < len(keys) {
+		height++
+	}
+	b := bulkBuilder{tree: t, keys: keys, values: values, last: make([]*Node, height)}
+	root := b.build(0, len(keys), height, 0, nil, false, nil, false)
+
+	if t.root != nil {
+		t.retireSubtree(t.root)
+	}
+	t.rootLock.Lock()
+	t.root = root
+	t.rootLock.Unlock()
+	atomic.StoreInt64(&t.size, int64(len(keys)))
+	var dataBytes int64
+	for i := range keys {
+		dataBytes += int64(len(keys[i]) + len(values[i]))
+	}
+	t.dataBytes.Store(dataBytes)
+	return nil
+}
+
+// subtreeCapacity returns the most keys a subtree of the given height holds.
+func subtreeCapacity(height int) int {
+	capacity := 0
+	for i := 0; i < height; i++ {
+		capacity = capacity*(MaxKeys+1) + MaxKeys
+	}
+	return capacity
+}
+
+// bulkBuilder carries the input and each level's last node across BulkLoad's
+// recursion.
+type bulkBuilder struct {
+	tree   *Btree
+	keys   []Keytype
+	values []Valuetype
+	last   []*Node // Rightmost node built so far at each depth, for sibling links
+}
+
+// build returns a subtree of the given height holding keys[lo:hi], bounded
+// by the given fences.
+func (b *bulkBuilder) build(lo, hi, height, depth int, lowKey Keytype, hasLowKey bool, highKey Keytype, hasHighKey bool) *Node {
+	n := b.tree.newNode(height == 1)
+	n.lowKey, n.hasLowKey = lowKey, hasLowKey
+	n.highKey, n.hasHighKey = highKey, hasHighKey
+	if prev := b.last[depth]; prev != nil {
+		prev.rightSibling = n
+	}
+	b.last[depth] = n
+
+	if height == 1 {
+		n.setKeys(b.keys[lo:hi])
+		n.values = append(n.values, b.values[lo:hi]...)
+		return n
+	}
+
+	// Fewest children that hold the keys, but enough for a non-root node
+	// to have MinKeys separators
+	childCapacity := subtreeCapacity(height - 1)
+	children := (hi - lo + childCapacity + 1) / (childCapacity + 1)
+	if depth > 0 {
+		children = max(children, MinKeys+1)
+	}
+
+	// Spread the keys not used as separators evenly over the children
+	spread := hi - lo - (children - 1)
+	separators := make([]int, 0, children-1)
+	start := lo
+	for i := 0; i < children; i++ {
+		end := start + spread/children
+		if i < spread%children {
+			end++
+		}
+		childLow, childHasLow := lowKey, hasLowKey
+		if i > 0 {
+			childLow, childHasLow = b.keys[start-1], true
+		}
+		childHigh, childHasHigh := highKey, hasHighKey
+		if i < children-1 {
+			childHigh, childHasHigh = b.keys[end], true
+			separators = append(separators, end)
+		}
+		n.children = append(n.children, b.build(start, end, height-1, depth+1, childLow, childHasLow, childHigh, childHasHigh))
+		start = end + 1
+	}
+
+	full := make([]Keytype, 0, len(separators))
+	for _, i := range separators {
+		full = append(full, b.keys[i])
+		n.values = append(n.values, b.values[i])
+	}
+	n.setKeys(full)
+	return n
+}
@@ -0,0 +1,101 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func bulkLoadInput(n int) ([]Keytype, []Valuetype) {
+	keys := make([]Keytype, n)
+	values := make([]Valuetype, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key:%05d", i))
+		values[i] = []byte(fmt.Sprintf("value:%d", i))
+	}
+	return keys, values
+}
+
+func TestBulkLoadShapes(t *testing.T) {
+	// Sizes around every capacity boundary of the first few heights
+	for n := 0; n <= 700; n++ {
+		keys, values := bulkLoadInput(n)
+		tree := &Btree{}
+		if err := tree.BulkLoad(keys, values); err != nil {
+			t.Fatalf("BulkLoad(%d keys): %v", n, err)
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("BulkLoad(%d keys): %v", n, err)
+		}
+		for i, key := range keys {
+			if value, err := tree.Find(key); err != nil || string(value) != string(values[i]) {
+				t.Fatalf("BulkLoad(%d keys): Find(%s) = %q, %v", n, key, value, err)
+			}
+		}
+	}
+}
+
+func TestBulkLoadThenWrite(t *testing.T) {
+	keys, values := bulkLoadInput(2000)
+	tree := &Btree{}
+	if err := tree.BulkLoad(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	if fill := tree.TreeStats().FillFactor; fill < 0.9 {
+		t.Errorf("FillFactor after BulkLoad = %.2f, want packed nodes", fill)
+	}
+
+	for i := 0; i < 2000; i += 3 {
+		tree.Delete(keys[i])
+	}
+	for i := 0; i < 500; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%05d+", i)), []byte("new"))
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(2000 - 667 + 500); tree.Len() != want {
+		t.Errorf("Len = %d, want %d", tree.Len(), want)
+	}
+}
+
+func TestBulkLoadRejects(t *testing.T) {
+	tree := &Btree{}
+	if err := tree.BulkLoad([]Keytype{[]byte("b"), []byte("a")}, []Valuetype{nil, nil}); err == nil {
+		t.Error("Expected error for unsorted keys")
+	}
+	if err := tree.BulkLoad([]Keytype{[]byte("a"), []byte("a")}, []Valuetype{nil, nil}); err == nil {
+		t.Error("Expected error for duplicate keys")
+	}
+	if err := tree.BulkLoad([]Keytype{[]byte("a")}, nil); err == nil {
+		t.Error("Expected error for mismatched lengths")
+	}
+
+	tree.Insert([]byte("x"), []byte("1"))
+	if err := tree.BulkLoad([]Keytype{[]byte("a")}, []Valuetype{nil}); !errors.Is(err, ErrTreeNotEmpty) {
+		t.Errorf("BulkLoad into non-empty tree = %v, want ErrTreeNotEmpty", err)
+	}
+}
+
+func TestShardedBulkInsertSorted(t *testing.T) {
+	keys, values := bulkLoadInput(3000)
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	if err := tree.BulkInsert(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tree.Stats(false); stats.TotalKeys != 3000 || stats.TotalInserts != 3000 || stats.Tree.FillFactor < 0.9 {
+		t.Errorf("After sorted BulkInsert: %d keys, %d inserts, fill %.2f", stats.TotalKeys, stats.TotalInserts, stats.Tree.FillFactor)
+	}
+
+	// Non-empty shards fall back to inserts, later duplicates winning
+	more := []Keytype{[]byte("key:00001"), []byte("zzz"), []byte("key:00001")}
+	if err := tree.BulkInsert(more, []Valuetype{[]byte("a"), []byte("b"), []byte("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := tree.Find([]byte("key:00001")); string(value) != "c" {
+		t.Errorf("key:00001 = %q, want c", value)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// IndexField is one field of a composite index (see CreateCompositeIndex).
+type IndexField struct {
+	Name  string    // JSON path of the field, as for JSONPathExtractor
+	Order SortOrder // Ascending (default) or Descending
+	Type  FieldType // How values compare (default: StringField)
+}
+
+// SortOrder is the direction a composite index field sorts in.
+type SortOrder uint8
+
+const (
+	Ascending SortOrder = iota
+	Descending
+)
+
+// FieldType is how a composite index field's values are encoded and ordered.
+type FieldType uint8
+
+const (
+	StringField FieldType = iota // Bytewise
+	IntField                     // Signed 64-bit integers
+	FloatField                   // 64-bit floats
+)
+
+// String returns the type's name.
+func (t FieldType) String() string {
+	switch t {
+	case StringField:
+		return "string"
+	case IntField:
+		return "int"
+	case FloatField:
+		return "float"
+	default:
+		return fmt.Sprintf("FieldType(%d)", int(t))
+	}
+}
+
+// CreateCompositeIndex creates an index over several fields of JSON records
+// and populates it with existing data, as CreateIndexWithRebuild does.
+// FindByComposite queries it by field values.
+//
+// DESIGN:
+// - Each field is encoded so that byte order is value order: integers and floats sort numerically, Descending fields in reverse
+// - Encoded fields are self-delimiting, so the key of the leading fields is a prefix of every full key that starts with them
+// - Records missing a field, or whose field does not parse as its Type, are not indexed
+//
+// LIMITATIONS:
+// - Empty-string and null fields are treated as missing, as for JSONFieldExtractor
+//
+// USAGE:
+//
+//	db.CreateCompositeIndex("city_age", []IndexField{
+//		{Name: "address.city"},
+//		{Name: "age", Type: IntField, Order: Descending},
+//	}, false)
+//
+//	oldestFirst, _ := db.FindByComposite("city_age", "London")
+//	exact, _ := db.FindByComposite("city_age", "London", 42)
+func (db *IndexedBTree) CreateCompositeIndex(name string, fields []IndexField, unique bool) error {
+	idx, err := newCompositeIndex(name, fields, unique)
+	if err != nil {
+		return err
+	}
+	return db.addIndex(idx, true)
+}
+
+// newCompositeIndex returns an empty composite index over fields.
+func newCompositeIndex(name string, fields []IndexField, unique bool) (*SecondaryIndex, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("composite index needs at least one field")
+	}
+	for _, field := range fields {
+		if _, err := parseJSONPath(field.Name); err != nil {
+			return nil, err
+		}
+		if field.Type > FloatField || field.Order > Descending {
+			return nil, fmt.Errorf("invalid type or order for field %q", field.Name)
+		}
+	}
+
+	fields = append([]IndexField(nil), fields...)
+	idx := newIndex(name, compositeKeyExtractor(fields), unique)
+	idx.fields = fields
+	return idx, nil
+}
+
+// FindByComposite returns the primary keys of records whose leading fields
+// in composite index name equal values, in index key order. Values for
+// every field make an exact match; fewer match on the leading fields only.
+// A value may be given as a Go string, integer or float, or as text.
+func (db *IndexedBTree) FindByComposite(name string, values ...any) ([]Keytype, error) {
+	idx, probe, err := db.compositeProbe(name, values)
+	if err != nil {
+		return nil, err
+	}
+	return idx.FindPrefix(probe)
+}
+
+// CompositeKey returns the index key a composite index stores for the given
+// leading field values, for use with FindRangeByIndex and RangeByIndex.
+// A key of fewer values than fields sorts before every key that extends it.
+func (db *IndexedBTree) CompositeKey(name string, values ...any) ([]byte, error) {
+	_, probe, err := db.compositeProbe(name, values)
+	return probe, err
+}
+
+// compositeProbe encodes values as the leading fields of composite index
+// name.
+func (db *IndexedBTree) compositeProbe(name string, values []any) (*SecondaryIndex, []byte, error) {
+	idx, err := db.index(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if idx.fields == nil {
+		return nil, nil, fmt.Errorf("index %q is not a composite index", name)
+	}
+	if len(values) > len(idx.fields) {
+		return nil, nil, fmt.Errorf("%d values for composite index %q of %d fields", len(values), name, len(idx.fields))
+	}
+
+	probe := []byte{}
+	for i, value := range values {
+		var err error
+		if probe, err = idx.fields[i].appendKey(probe, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return idx, probe, nil
+}
+
+// compositeKeyExtractor extracts fields from a JSON record into a composite
+// index key.
+func compositeKeyExtractor(fields []IndexField) KeyExtractor {
+	extractors := make([]KeyExtractor, len(fields))
+	for i, field := range fields {
+		extractors[i] = JSONPathExtractor(field.Name)
+	}
+
+	return func(value Valuetype) []byte {
+		var key []byte
+		for i, field := range fields {
+			text := extractors[i](value)
+			if text == nil {
+				return nil
+			}
+			var err error
+			if key, err = field.appendKey(key, text); err != nil {
+				return nil
+			}
+		}
+		return key
+	}
+}
+
+// appendKey appends value in the field's order-preserving encoding:
+//   - strings: each 0x00 escaped as 0x00 0xff, then a 0x00 0x01 terminator
+//   - integers: 8 bytes big-endian with the sign bit flipped
+//   - floats: 8 bytes big-endian, sign bit flipped for positives and all bits for negatives
+//
+// and, for Descending, every byte inverted.
+func (f IndexField) appendKey(dst []byte, value any) ([]byte, error) {
+	start := len(dst)
+	switch f.Type {
+	case StringField:
+		s, err := stringValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		for i := 0; i < len(s); i++ {
+			if s[i] == 0 {
+				dst = append(dst, 0, 0xff)
+			} else {
+				dst = append(dst, s[i])
+			}
+		}
+		dst = append(dst, 0, 1)
+	case IntField:
+		n, err := intValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		dst = binary.BigEndian.AppendUint64(dst, uint64(n)^1<<63)
+	case FloatField:
+		x, err := floatValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		if x == 0 {
+			x = 0 // -0 sorts with 0
+		}
+		bits := math.Float64bits(x)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		dst = binary.BigEndian.AppendUint64(dst, bits)
+	}
+
+	if f.Order == Descending {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+	return dst, nil
+}
+
+func stringValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("%T is not a string", value)
+}
+
+func intValue(value any) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	}
+	return 0, fmt.Errorf("%T is not an integer", value)
+}
+
+func floatValue(value any) (x float64, err error) {
+	switch v := value.(type) {
+	case float64:
+		x = v
+	case float32:
+		x = float64(v)
+	case int:
+		x = float64(v)
+	case int64:
+		x = float64(v)
+	case string:
+		x, err = strconv.ParseFloat(v, 64)
+	case []byte:
+		x, err = strconv.ParseFloat(string(v), 64)
+	default:
+		return 0, fmt.Errorf("%T is not a number", value)
+	}
+	if err == nil && math.IsNaN(x) {
+		err = errors.New("NaN cannot be indexed")
+	}
+	return x, err
+}
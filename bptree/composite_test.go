@@ -0,0 +1,121 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestCreateCompositeIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	people := []struct {
+		city string
+		age  int
+	}{
+		{"London", 30}, {"Paris", 25}, {"London", 42}, {"London", 7}, {"Paris", 61}, {"Berlin", 30},
+	}
+	// Half the records exist before the index and are indexed by the rebuild
+	for i, p := range people[:3] {
+		db.Insert([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf(`{"address":{"city":%q},"age":%d}`, p.city, p.age)))
+	}
+	err := db.CreateCompositeIndex("city_age", []IndexField{
+		{Name: "address.city"},
+		{Name: "age", Type: IntField, Order: Descending},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range people[3:] {
+		db.Insert([]byte(fmt.Sprintf("user:%d", i+3)), []byte(fmt.Sprintf(`{"address":{"city":%q},"age":%d}`, p.city, p.age)))
+	}
+	db.Insert([]byte("user:noage"), []byte(`{"address":{"city":"London"}}`))
+
+	// Leading field only: London, oldest first
+	keys, err := db.FindByComposite("city_age", "London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keyStrings(keys), []string{"user:2", "user:0", "user:3"}; !slices.Equal(got, want) {
+		t.Errorf("FindByComposite(London) = %v, want %v", got, want)
+	}
+
+	// Every field: exact match
+	keys, _ = db.FindByComposite("city_age", "London", 30)
+	if got := keyStrings(keys); !slices.Equal(got, []string{"user:0"}) {
+		t.Errorf("FindByComposite(London, 30) = %v", got)
+	}
+	if keys, _ := db.FindByComposite("city_age", "Lond"); len(keys) != 0 {
+		t.Errorf("FindByComposite(Lond) matched a longer city: %v", keyStrings(keys))
+	}
+
+	// A probe key ranges over the trailing field
+	start, _ := db.CompositeKey("city_age", "Paris", 100)
+	end, _ := db.CompositeKey("city_age", "Paris", 30)
+	keys, _ = db.FindRangeByIndex("city_age", start, end)
+	if got := keyStrings(keys); !slices.Equal(got, []string{"user:4"}) {
+		t.Errorf("Paris aged 30..100 = %v, want [user:4]", got)
+	}
+
+	if _, err := db.FindByComposite("city_age", "London", "old"); err == nil {
+		t.Error("A non-integer age was accepted")
+	}
+	if _, err := db.FindByComposite("city_age", "London", 30, "extra"); err == nil {
+		t.Error("More values than fields were accepted")
+	}
+	db.CreateIndex("plain", JSONFieldExtractor("age"), false)
+	if _, err := db.FindByComposite("plain", "30"); err == nil {
+		t.Error("FindByComposite on a plain index succeeded")
+	}
+	if err := db.CreateCompositeIndex("bad", []IndexField{{Name: "a..b"}}, false); err == nil {
+		t.Error("A malformed field path was accepted")
+	}
+}
+
+func TestCompositeKeyOrder(t *testing.T) {
+	floats := []float64{math.Inf(-1), -1e9, -2.5, -1, 0, 1e-9, 1, 2.5, 1e300, math.Inf(1)}
+	ints := []int64{math.MinInt64, -100, -1, 0, 1, 100, math.MaxInt64}
+	strs := []string{"", "\x00", "\x00\x00", "\x00a", "a", "a\x00", "ab", "b"}
+
+	check := func(name string, f IndexField, values []any) {
+		t.Helper()
+		var prev []byte
+		for i, v := range values {
+			key, err := f.appendKey(nil, v)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if i > 0 {
+				c := bytes.Compare(prev, key)
+				if (f.Order == Ascending && c >= 0) || (f.Order == Descending && c <= 0) {
+					t.Errorf("%s: %v and %v encode out of order", name, values[i-1], v)
+				}
+			}
+			prev = key
+		}
+	}
+	for _, order := range []SortOrder{Ascending, Descending} {
+		var fv, iv, sv []any
+		for _, x := range floats {
+			fv = append(fv, x)
+		}
+		for _, n := range ints {
+			iv = append(iv, n)
+		}
+		for _, s := range strs {
+			sv = append(sv, s)
+		}
+		check("float", IndexField{Type: FloatField, Order: order}, fv)
+		check("int", IndexField{Type: IntField, Order: order}, iv)
+		check("string", IndexField{Type: StringField, Order: order}, sv)
+	}
+}
+
+func keyStrings(keys []Keytype) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = string(k)
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package bptree
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lockWaits counts the latch acquisitions of a tree that found the latch
+// held, and the time spent waiting for them. An uncontended acquisition
+// succeeds on TryLock and reads no clock.
+type lockWaits struct {
+	count atomic.Uint64
+	nanos atomic.Int64
+}
+
+// waitLock locks mu exclusively, recording the wait if it was held.
+func (t *Btree) waitLock(mu *sync.RWMutex) {
+	if mu.TryLock() {
+		return
+	}
+	start := time.Now()
+	mu.Lock()
+	t.waits.record(start)
+}
+
+// waitRLock locks mu shared, recording the wait if it was held exclusively.
+func (t *Btree) waitRLock(mu *sync.RWMutex) {
+	if mu.TryRLock() {
+		return
+	}
+	start := time.Now()
+	mu.RLock()
+	t.waits.record(start)
+}
+
+func (w *lockWaits) record(start time.Time) {
+	w.count.Add(1)
+	w.nanos.Add(int64(time.Since(start)))
+}
+
+// LockWait returns how many point-operation latch acquisitions (the tree
+// lock, the root lock and node latches on the way to a key) had to wait, and
+// the total time they waited. Whole-tree operations are not counted.
+func (t *Btree) LockWait() (waits uint64, total time.Duration) {
+	return t.waits.count.Load(), time.Duration(t.waits.nanos.Load())
+}
+
+// ShardActivity reports what one shard of a ShardedBTree has been doing, to
+// find hot shards.
+//
+// Operation counts and lock waits are always recorded. Average latencies are
+// exponentially weighted moving averages over timed operations: every one
+// with ShardConfig.RecordHistograms, else one in LatencySampleRate (see
+// SetLatencySampling); they stay 0 when nothing is timed.
+type ShardActivity struct {
+	Inserts    uint64
+	Deletes    uint64
+	Finds      uint64
+	RangeScans uint64 // GetRange calls that scanned this shard
+
+	LockWaits uint64        // Latch acquisitions that found the latch held
+	LockWait  time.Duration // Total time spent in them
+
+	AvgInsert    time.Duration
+	AvgDelete    time.Duration
+	AvgFind      time.Duration
+	AvgRangeScan time.Duration
+
+	HotKeys []HotKey // Most accessed keys, with ShardConfig.HotKeySampleRate
+}
+
+// ewmaShift sets the weight of a new sample in the moving averages to 1/8.
+const ewmaShift = 3
+
+// shardCounters holds one shard's operation counts and moving averages.
+type shardCounters struct {
+	ops  [numLatencyOps]atomic.Uint64
+	ewma [numLatencyOps]atomic.Int64 // Nanoseconds
+}
+
+// average folds a latency sample into op's moving average. Concurrent
+// updates may lose a sample, which only delays convergence.
+func (c *shardCounters) average(op LatencyOp, sample time.Duration) {
+	prev := c.ewma[op].Load()
+	if prev == 0 {
+		c.ewma[op].Store(int64(sample))
+		return
+	}
+	c.ewma[op].Store(prev + (int64(sample)-prev)>>ewmaShift)
+}
+
+// SetLatencySampling times one in every rate operations on each shard for
+// the moving averages in ShardStats.Shards. A rate of 0 or less stops
+// sampling; RecordHistograms times every operation regardless.
+func (s *ShardedBTree) SetLatencySampling(rate int) {
+	s.sampleRate.Store(int64(max(rate, 0)))
+}
+
+// activity returns the ShardActivity of shard idx. Called pinned.
+func (s *ShardedBTree) activity(idx int) ShardActivity {
+	c := s.counters[idx]
+	a := ShardActivity{
+		Inserts:      c.ops[LatencyInsert].Load(),
+		Deletes:      c.ops[LatencyDelete].Load(),
+		Finds:        c.ops[LatencyFind].Load(),
+		RangeScans:   c.ops[LatencyGetRange].Load(),
+		AvgInsert:    time.Duration(c.ewma[LatencyInsert].Load()),
+		AvgDelete:    time.Duration(c.ewma[LatencyDelete].Load()),
+		AvgFind:      time.Duration(c.ewma[LatencyFind].Load()),
+		AvgRangeScan: time.Duration(c.ewma[LatencyGetRange].Load()),
+	}
+	a.LockWaits, a.LockWait = s.shards[idx].LockWait()
+	a.HotKeys = s.hotKeys(idx).top(s.hotTopK)
+	return a
+}
@@ -0,0 +1,95 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBtreeLockWait(t *testing.T) {
+	tree := &Btree{}
+	tree.Insert([]byte("a"), []byte("1"))
+	if waits, total := tree.LockWait(); waits != 0 || total != 0 {
+		t.Fatalf("uncontended LockWait = %d, %v", waits, total)
+	}
+
+	// A whole-tree write holding treeLock makes a point insert wait
+	tree.treeLock.Lock()
+	done := make(chan struct{})
+	go func() {
+		tree.Insert([]byte("b"), []byte("2"))
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	tree.treeLock.Unlock()
+	<-done
+
+	waits, total := tree.LockWait()
+	if waits != 1 || total < 10*time.Millisecond {
+		t.Errorf("LockWait = %d, %v, want 1 wait of about 20ms", waits, total)
+	}
+}
+
+func TestShardActivity(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, LatencySampleRate: 1})
+	for i := 0; i < 400; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		tree.Insert(key, []byte("v"))
+		tree.Find(key)
+	}
+	for i := 0; i < 100; i++ {
+		tree.Delete([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	tree.GetRange([]byte("a"), []byte("z"))
+
+	stats := tree.Stats(false)
+	if len(stats.Shards) != 4 {
+		t.Fatalf("Stats().Shards has %d shards, want 4", len(stats.Shards))
+	}
+	var inserts, finds, deletes uint64
+	for i, a := range stats.Shards {
+		inserts += a.Inserts
+		finds += a.Finds
+		deletes += a.Deletes
+		if a.Inserts != uint64(stats.KeysPerShard[i])+a.Deletes {
+			t.Errorf("shard %d: %d inserts, %d deletes, %d keys", i, a.Inserts, a.Deletes, stats.KeysPerShard[i])
+		}
+		if a.RangeScans != 1 {
+			t.Errorf("shard %d: %d range scans, want 1", i, a.RangeScans)
+		}
+		if a.AvgInsert <= 0 || a.AvgFind <= 0 || a.AvgDelete <= 0 || a.AvgRangeScan <= 0 {
+			t.Errorf("shard %d: moving averages not recorded: %+v", i, a)
+		}
+	}
+	if inserts != 400 || finds != 400 || deletes != 100 {
+		t.Errorf("counted %d inserts, %d finds, %d deletes", inserts, finds, deletes)
+	}
+
+	// Without sampling operations are still counted but not timed
+	tree = NewShardedBTree(ShardConfig{NumShards: 2})
+	tree.Insert([]byte("k"), []byte("v"))
+	tree.SetLatencySampling(0)
+	for _, a := range tree.Stats(false).Shards {
+		if a.AvgInsert != 0 {
+			t.Errorf("AvgInsert = %v without sampling", a.AvgInsert)
+		}
+	}
+	if a := tree.Stats(false).Shards[tree.getShardIndex([]byte("k"))]; a.Inserts != 1 {
+		t.Errorf("Inserts = %d, want 1", a.Inserts)
+	}
+}
+
+func TestShardedLatencySampling(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 1})
+	tree.SetLatencySampling(10)
+	for i := 0; i < 9; i++ {
+		tree.Insert([]byte(fmt.Sprintf("k%d", i)), nil)
+	}
+	if avg := tree.Stats(false).Shards[0].AvgInsert; avg != 0 {
+		t.Fatalf("AvgInsert = %v before the first sampled insert", avg)
+	}
+	tree.Insert([]byte("k9"), nil)
+	if avg := tree.Stats(false).Shards[0].AvgInsert; avg <= 0 {
+		t.Errorf("AvgInsert = %v after the 10th insert", avg)
+	}
+}
@@ -0,0 +1,93 @@
+package bptree
+
+import "context"
+
+// ctxCheckInterval is how many keys or WAL entries the ctx variants of
+// long-running operations process between checks of ctx.Done.
+const ctxCheckInterval = 1024
+
+// FindCtx is Find returning ctx's error if ctx is already done. A single
+// lookup is short, so ctx is checked only before it starts.
+func (t *Btree) FindCtx(ctx context.Context, key []byte) (Valuetype, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.Find(key)
+}
+
+// GetRangeCtx is GetRange stopping with ctx's error once ctx is done,
+// checked every ctxCheckInterval keys.
+func (t *Btree) GetRangeCtx(ctx context.Context, startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]Keytype, 0)
+	values := make([]Valuetype, 0)
+	scanned := 0
+	err := t.ScanRange(startKey, endKey, func(key Keytype, value Valuetype) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
+		}
+		keys = append(keys, append(Keytype(nil), key...))
+		values = append(values, append(Valuetype{}, value...))
+		return true
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// FindCtx is Find returning ctx's error if ctx is already done.
+func (s *ShardedBTree) FindCtx(ctx context.Context, key Keytype) (Valuetype, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Find(key)
+}
+
+// ForEachCtx is ForEach stopping with ctx's error once ctx is done, checked
+// every ctxCheckInterval keys. Returns nil if the walk finished or callback
+// stopped it.
+func (s *ShardedBTree) ForEachCtx(ctx context.Context, callback func(key Keytype, value Valuetype) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var cancelled error
+	visited := 0
+	s.ForEach(func(key Keytype, value Valuetype) bool {
+		if visited++; visited%ctxCheckInterval == 0 {
+			if cancelled = ctx.Err(); cancelled != nil {
+				return false
+			}
+		}
+		return callback(key, value)
+	})
+	return cancelled
+}
+
+// FindCtx is Find returning ctx's error if ctx is already done.
+func (db *DurableBTree) FindCtx(ctx context.Context, key Keytype) (Valuetype, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.FindCtx(ctx, key)
+}
+
+// GetRangeCtx is GetRange stopping with ctx's error once ctx is done.
+func (db *DurableBTree) GetRangeCtx(ctx context.Context, startKey, endKey Keytype) ([]Keytype, []Valuetype, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.GetRangeCtx(ctx, startKey, endKey)
+}
+
+// ForEachCtx is ForEach stopping with ctx's error once ctx is done.
+func (db *DurableBTree) ForEachCtx(ctx context.Context, fn func(key Keytype, value Valuetype) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.ForEachCtx(ctx, fn)
+}
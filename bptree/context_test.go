@@ -0,0 +1,74 @@
+package bptree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestContextVariants(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 5000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("k%05d", i)), []byte("v"))
+	}
+
+	keys, _, err := tree.GetRangeCtx(context.Background(), []byte("k00000"), []byte("k99999"))
+	if err != nil || len(keys) != 5000 {
+		t.Fatalf("GetRangeCtx = %d keys, %v", len(keys), err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tree.FindCtx(cancelled, []byte("k00001")); !errors.Is(err, context.Canceled) {
+		t.Errorf("FindCtx = %v, want context.Canceled", err)
+	}
+	if _, _, err := tree.GetRangeCtx(cancelled, []byte("k00000"), []byte("k99999")); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRangeCtx = %v, want context.Canceled", err)
+	}
+	if _, _, err := tree.GetShard(0).GetRangeCtx(cancelled, []byte("k00000"), []byte("k99999")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Btree.GetRangeCtx = %v, want context.Canceled", err)
+	}
+
+	// Cancelled midway: the walk stops within ctxCheckInterval keys
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err = tree.ForEachCtx(ctx, func(Keytype, Valuetype) bool {
+		if visited++; visited == 10 {
+			cancel()
+		}
+		return true
+	})
+	if !errors.Is(err, context.Canceled) || visited >= 10+ctxCheckInterval {
+		t.Errorf("ForEachCtx = %v after %d keys", err, visited)
+	}
+}
+
+func TestReplayCtx(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	for i := 0; i < 3*ctxCheckInterval; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("k%d", i)), nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count, err := wal.ReplayCtx(ctx, func(*LogEntry) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || count != ctxCheckInterval {
+		t.Errorf("ReplayCtx = %d, %v; want %d, context.Canceled", count, err, ctxCheckInterval)
+	}
+
+	// The WAL is still positioned for appending
+	if _, err := wal.AppendInsert([]byte("after"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := wal.Replay(func(*LogEntry) error { return nil }); count != 3*ctxCheckInterval+1 || err != nil {
+		t.Errorf("Replay = %d, %v", count, err)
+	}
+}
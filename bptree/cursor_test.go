@@ -0,0 +1,69 @@
+package bptree
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardCursor(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	for i := 0; i < 1000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	tree.InsertWithTTL([]byte("expired"), []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	want := make([]int, tree.NumShards())
+	for i := 0; i < 1000; i++ {
+		want[tree.getShardIndex([]byte(fmt.Sprintf("key-%04d", i)))]++
+	}
+
+	// Stream every shard in parallel; each is ordered and together they hold every key
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	var wg sync.WaitGroup
+	for i := 0; i < tree.NumShards(); i++ {
+		c, err := tree.ShardCursor(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var last string
+			n := 0
+			for c.Next() {
+				key := string(c.Key())
+				if key <= last {
+					t.Errorf("shard %d: %q after %q", i, key, last)
+				}
+				if tree.getShardIndex(c.Key()) != i {
+					t.Errorf("shard %d yielded %q, placed elsewhere", i, key)
+				}
+				last = key
+				n++
+				mu.Lock()
+				seen[key] = string(c.Value())
+				mu.Unlock()
+			}
+			if c.Next() {
+				t.Errorf("shard %d: Next succeeded after the end", i)
+			}
+			if n != want[i] {
+				t.Errorf("shard %d: cursor yielded %d pairs, want %d", i, n, want[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(seen) != 1000 {
+		t.Fatalf("cursors yielded %d keys, want 1000", len(seen))
+	}
+	if seen["key-0042"] != "v42" {
+		t.Errorf("key-0042 = %q", seen["key-0042"])
+	}
+	if _, err := tree.ShardCursor(4); err == nil {
+		t.Error("ShardCursor accepted an out of range shard")
+	}
+}
@@ -0,0 +1,378 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Document is a structured record: a JSON object whose numbers are decoded
+// as json.Number, so they round-trip exactly.
+type Document map[string]any
+
+// DocumentStore keeps JSON documents in an IndexedBTree and updates them
+// field by field, so clients need not read-modify-write whole records.
+//
+// DESIGN:
+// - Records are JSON objects; fields are addressed by dotted paths ("address.city")
+// - Indexes are declared on a field path and index that field's value
+// - Patch rewrites one field under a per-key lock, so concurrent patches never lose each other's fields
+// - Patch re-runs only the indexes whose path overlaps the patched one; no other index can change
+//
+// LIMITATIONS:
+// - Field names containing '.' cannot be addressed
+// - Writes made directly through Indexed() bypass the per-key locks
+//
+// USAGE:
+//
+//	docs := NewDocumentStore(IndexedConfig{NumShards: 8})
+//	docs.CreateIndex("city", "address.city", false)
+//
+//	docs.Put([]byte("user:1"), map[string]any{"name": "Ada", "address": map[string]any{"city": "London"}})
+//	docs.Patch([]byte("user:1"), "address.city", "Paris") // Only the "city" index is updated
+//
+//	keys, _ := docs.FindByField("city", "Paris")
+type DocumentStore struct {
+	db *IndexedBTree
+
+	pathsMu sync.RWMutex
+	paths   map[string][]string // Index name → indexed field path
+
+	locks [documentLockStripes]sync.Mutex // Writers of keys hashing to a stripe
+}
+
+// documentLockStripes is the number of per-key write locks.
+const documentLockStripes = 64
+
+// NewDocumentStore creates an empty document store.
+// If AsyncIndexThreshold is set, call Close to stop the catch-up worker.
+func NewDocumentStore(config IndexedConfig) *DocumentStore {
+	return &DocumentStore{
+		db:    NewIndexedBTree(config),
+		paths: make(map[string][]string),
+	}
+}
+
+// Indexed returns the underlying indexed tree, for queries it supports
+// directly (index ranges, iteration, stats).
+func (s *DocumentStore) Indexed() *IndexedBTree {
+	return s.db
+}
+
+// lockKey returns the write lock for key.
+func (s *DocumentStore) lockKey(key Keytype) *sync.Mutex {
+	return &s.locks[fnv32a(key)%documentLockStripes]
+}
+
+// CreateIndex indexes the field at fieldPath in every document, including
+// existing ones. Documents without the field are not indexed.
+func (s *DocumentStore) CreateIndex(name, fieldPath string, unique bool) error {
+	path, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return err
+	}
+
+	// Register the path first so no Patch can skip the index once it exists
+	s.pathsMu.Lock()
+	if _, exists := s.paths[name]; exists {
+		s.pathsMu.Unlock()
+		return errors.New("index already exists")
+	}
+	s.paths[name] = path
+	s.pathsMu.Unlock()
+
+	if err := s.db.CreateIndexWithRebuild(name, DocumentFieldExtractor(fieldPath), unique); err != nil {
+		s.pathsMu.Lock()
+		delete(s.paths, name)
+		s.pathsMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// DropIndex removes an index.
+func (s *DocumentStore) DropIndex(name string) error {
+	if err := s.db.DropIndex(name); err != nil {
+		return err
+	}
+	s.pathsMu.Lock()
+	delete(s.paths, name)
+	s.pathsMu.Unlock()
+	return nil
+}
+
+// Put stores doc (any value that marshals to a JSON object) at key,
+// replacing any existing document and updating every index.
+func (s *DocumentStore) Put(key Keytype, doc any) error {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	if _, err := decodeDocument(raw); err != nil {
+		return err
+	}
+
+	mu := s.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := s.db.Find(key); err != nil {
+		return s.db.Insert(key, raw)
+	}
+	return s.db.Update(key, raw)
+}
+
+// Get returns the document at key.
+func (s *DocumentStore) Get(key Keytype) (Document, error) {
+	raw, err := s.db.Find(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(raw)
+}
+
+// GetField returns the value at fieldPath in the document at key.
+func (s *DocumentStore) GetField(key Keytype, fieldPath string) (any, error) {
+	path, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := doc.lookup(path)
+	if !ok {
+		return nil, errors.New("field not found")
+	}
+	return value, nil
+}
+
+// Patch sets the field at fieldPath in the document at key to value,
+// creating missing parent objects. The document is rewritten atomically with
+// respect to other DocumentStore writers, and only indexes on an overlapping
+// path are updated. A unique constraint violation leaves the document as it was.
+func (s *DocumentStore) Patch(key Keytype, fieldPath string, value any) error {
+	path, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return err
+	}
+
+	mu := s.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+	s.db.writers.RLock()
+	defer s.db.writers.RUnlock()
+
+	oldRaw, err := s.db.Find(key)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeDocument(oldRaw)
+	if err != nil {
+		return err
+	}
+	if err := doc.set(path, value); err != nil {
+		return err
+	}
+	newRaw, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+
+	return s.db.replace(key, oldRaw, newRaw, s.affectedIndexes(path))
+}
+
+// Delete removes the document at key and its index entries.
+func (s *DocumentStore) Delete(key Keytype) (bool, error) {
+	mu := s.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return s.db.Delete(key)
+}
+
+// FindByField returns the keys of documents whose indexed field equals value.
+func (s *DocumentStore) FindByField(indexName string, value any) ([]Keytype, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	// Normalize through the same decoding the index extractor sees
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var normalized any
+	if err := decoder.Decode(&normalized); err != nil {
+		return nil, err
+	}
+
+	indexKey := documentIndexKey(normalized)
+	if indexKey == nil {
+		return nil, errors.New("null values are not indexed")
+	}
+	return s.db.FindAllByIndex(indexName, indexKey)
+}
+
+// Count returns the number of documents.
+func (s *DocumentStore) Count() int64 {
+	return s.db.Count()
+}
+
+// Close stops the underlying tree's catch-up worker, if any.
+func (s *DocumentStore) Close() error {
+	return s.db.Close()
+}
+
+// affectedIndexes returns the indexes a change at path can alter: those on
+// the path itself, on a field inside it, or on an object containing it.
+func (s *DocumentStore) affectedIndexes(path []string) []*SecondaryIndex {
+	s.pathsMu.RLock()
+	var names []string
+	for name, indexed := range s.paths {
+		if pathsOverlap(indexed, path) {
+			names = append(names, name)
+		}
+	}
+	s.pathsMu.RUnlock()
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	indexes := make([]*SecondaryIndex, 0, len(names))
+	for _, name := range names {
+		if idx, ok := s.db.indexes[name]; ok {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+// pathsOverlap reports whether one path is a prefix of the other.
+func pathsOverlap(a, b []string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFieldPath splits a dotted field path into its segments.
+func parseFieldPath(fieldPath string) ([]string, error) {
+	path := strings.Split(fieldPath, ".")
+	for _, segment := range path {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid field path %q", fieldPath)
+		}
+	}
+	return path, nil
+}
+
+// DocumentFieldExtractor creates an extractor for the field at a dotted path
+// in a JSON document. Strings are indexed by their contents, other values by
+// their JSON encoding; missing and null fields are not indexed.
+func DocumentFieldExtractor(fieldPath string) KeyExtractor {
+	path, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return func(Valuetype) []byte { return nil }
+	}
+
+	return func(value Valuetype) []byte {
+		doc, err := decodeDocument(value)
+		if err != nil {
+			return nil
+		}
+		field, ok := doc.lookup(path)
+		if !ok {
+			return nil
+		}
+		return documentIndexKey(field)
+	}
+}
+
+// documentIndexKey encodes a decoded field value as an index key.
+func documentIndexKey(value any) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case json.Number:
+		return []byte(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return encoded
+	}
+}
+
+// decodeDocument parses a stored record as a JSON object.
+func decodeDocument(raw []byte) (Document, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var doc Document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("record is not a JSON object: %w", err)
+	}
+	if doc == nil {
+		return nil, errors.New("record is not a JSON object")
+	}
+	return doc, nil
+}
+
+// asObject returns v as a JSON object, if it is one.
+func asObject(v any) (map[string]any, bool) {
+	switch obj := v.(type) {
+	case map[string]any:
+		return obj, true
+	case Document:
+		return obj, true
+	default:
+		return nil, false
+	}
+}
+
+// lookup returns the value at path, and whether it exists.
+func (d Document) lookup(path []string) (any, bool) {
+	current := map[string]any(d)
+	for i, segment := range path {
+		value, ok := current[segment]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return value, true
+		}
+		if current, ok = asObject(value); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// set stores value at path, creating missing parent objects.
+func (d Document) set(path []string, value any) error {
+	current := map[string]any(d)
+	for i, segment := range path[:len(path)-1] {
+		next, exists := current[segment]
+		if !exists || next == nil {
+			created := make(map[string]any)
+			current[segment] = created
+			current = created
+			continue
+		}
+		obj, ok := asObject(next)
+		if !ok {
+			return fmt.Errorf("field %q is not an object", strings.Join(path[:i+1], "."))
+		}
+		current = obj
+	}
+	current[path[len(path)-1]] = value
+	return nil
+}
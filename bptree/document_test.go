@@ -0,0 +1,172 @@
+package bptree
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestDocumentStorePutGetPatch(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+
+	err := docs.Put([]byte("user:1"), map[string]any{
+		"name":    "Ada",
+		"age":     36,
+		"address": map[string]any{"city": "London"},
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if err := docs.Patch([]byte("user:1"), "address.city", "Paris"); err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if err := docs.Patch([]byte("user:1"), "settings.theme", "dark"); err != nil {
+		t.Fatalf("Patch creating a parent failed: %v", err)
+	}
+
+	doc, err := docs.Get([]byte("user:1"))
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if doc["name"] != "Ada" || doc["age"] != json.Number("36") {
+		t.Errorf("Untouched fields changed: %v", doc)
+	}
+	if city, _ := docs.GetField([]byte("user:1"), "address.city"); city != "Paris" {
+		t.Errorf("address.city = %v, want Paris", city)
+	}
+	if theme, _ := docs.GetField([]byte("user:1"), "settings.theme"); theme != "dark" {
+		t.Errorf("settings.theme = %v, want dark", theme)
+	}
+	if _, err := docs.GetField([]byte("user:1"), "address.zip"); err == nil {
+		t.Error("Expected error for missing field")
+	}
+}
+
+func TestDocumentStorePatchErrors(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+	docs.Put([]byte("doc"), map[string]any{"name": "Ada"})
+
+	if err := docs.Patch([]byte("missing"), "name", "x"); err == nil {
+		t.Error("Expected error patching a missing document")
+	}
+	if err := docs.Patch([]byte("doc"), "name.first", "x"); err == nil {
+		t.Error("Expected error patching inside a string field")
+	}
+	if err := docs.Patch([]byte("doc"), "a..b", "x"); err == nil {
+		t.Error("Expected error for an empty path segment")
+	}
+	if err := docs.Put([]byte("array"), []int{1, 2}); err == nil {
+		t.Error("Expected error putting a non-object document")
+	}
+}
+
+func TestDocumentStorePatchMaintainsIndexes(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+	if err := docs.CreateIndex("email", "email", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := docs.CreateIndex("city", "address.city", false); err != nil {
+		t.Fatal(err)
+	}
+
+	docs.Put([]byte("user:1"), map[string]any{"email": "ada@example.com", "address": map[string]any{"city": "London"}})
+	docs.Put([]byte("user:2"), map[string]any{"email": "alan@example.com", "address": map[string]any{"city": "London"}})
+
+	docs.Patch([]byte("user:1"), "address.city", "Paris")
+	if keys, _ := docs.FindByField("city", "Paris"); len(keys) != 1 || string(keys[0]) != "user:1" {
+		t.Errorf("city=Paris -> %q, want [user:1]", keys)
+	}
+	if keys, _ := docs.FindByField("city", "London"); len(keys) != 1 || string(keys[0]) != "user:2" {
+		t.Errorf("city=London -> %q, want [user:2]", keys)
+	}
+
+	// Replacing the parent object re-indexes the nested field
+	docs.Patch([]byte("user:2"), "address", map[string]any{"city": "Paris"})
+	if keys, _ := docs.FindByField("city", "Paris"); len(keys) != 2 {
+		t.Errorf("city=Paris -> %q, want both users", keys)
+	}
+
+	// A unique violation leaves the document untouched
+	if err := docs.Patch([]byte("user:2"), "email", "ada@example.com"); err == nil {
+		t.Error("Expected unique constraint violation")
+	}
+	if email, _ := docs.GetField([]byte("user:2"), "email"); email != "alan@example.com" {
+		t.Errorf("email after failed patch = %v", email)
+	}
+}
+
+func TestDocumentStoreAffectedIndexes(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+	docs.CreateIndex("city", "address.city", false)
+	docs.CreateIndex("address", "address", false)
+	docs.CreateIndex("age", "age", false)
+
+	cases := map[string][]string{
+		"address.city": {"address", "city"},
+		"address.zip":  {"address"},
+		"address":      {"address", "city"},
+		"age":          {"age"},
+		"name":         nil,
+	}
+	for fieldPath, want := range cases {
+		path, _ := parseFieldPath(fieldPath)
+		var got []string
+		for _, idx := range docs.affectedIndexes(path) {
+			got = append(got, idx.Name())
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Patch %q touches %v, want %v", fieldPath, got, want)
+		}
+	}
+}
+
+func TestDocumentStoreNumericIndex(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+	docs.Put([]byte("user:1"), map[string]any{"age": 36})
+	if err := docs.CreateIndex("age", "age", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := docs.FindByField("age", 36); err != nil || len(keys) != 1 {
+		t.Errorf("age=36 -> %q, %v", keys, err)
+	}
+	docs.Patch([]byte("user:1"), "age", 37)
+	if keys, _ := docs.FindByField("age", 36); len(keys) != 0 {
+		t.Errorf("age=36 after patch -> %q, want none", keys)
+	}
+	if keys, _ := docs.FindByField("age", 37); len(keys) != 1 {
+		t.Errorf("age=37 after patch -> %q, want [user:1]", keys)
+	}
+}
+
+func TestDocumentStoreConcurrentPatches(t *testing.T) {
+	docs := NewDocumentStore(IndexedConfig{NumShards: 2})
+	docs.Put([]byte("doc"), map[string]any{})
+
+	const writers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := docs.Patch([]byte("doc"), fmt.Sprintf("field%d", w), i); err != nil {
+					t.Errorf("Patch failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	doc, _ := docs.Get([]byte("doc"))
+	for w := 0; w < writers; w++ {
+		if value := doc[fmt.Sprintf("field%d", w)]; value != json.Number("49") {
+			t.Errorf("field%d = %v, want 49 (lost update)", w, value)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package bptree
 
 import (
 	"fmt"
+	"iter"
 	"sync"
+	"time"
 )
 
 // DurableBTree wraps a ShardedBTree with WAL for durability.
@@ -11,6 +13,11 @@ import (
 // - All mutations are logged to WAL BEFORE being applied to the tree
 // - On crash recovery, replay WAL to restore state
 // - Checkpoint to truncate WAL after tree is stable
+// - Under SyncAlways a write waits for its fsync after releasing the tree lock, so concurrent writes share one
+// - Committed writes are applied in log order, so readers only see writes that are durable
+//
+// LIMITATIONS:
+// - A write whose fsync fails is not applied, though a later fsync may still persist its entry
 //
 // USAGE:
 //
@@ -28,6 +35,11 @@ type DurableBTree struct {
 	wal  *WAL
 	mu   sync.RWMutex
 
+	// Writes waiting for their commit take turns applying (see write)
+	turns   *sync.Cond // On mu
+	logged  uint64     // Turns taken
+	applied uint64     // Turns finished
+
 	// Configuration
 	config DurableConfig
 }
@@ -45,6 +57,33 @@ type DurableConfig struct {
 
 	// BatchSize for SyncBatch mode (default: 100)
 	BatchSize int
+
+	// SyncInterval for SyncInterval mode (default: 5ms)
+	SyncInterval time.Duration
+
+	// PanicFree returns tree invariant violations as errors (see InvariantError)
+	PanicFree bool
+
+	// MaxKeySize and MaxValueSize bound entries in bytes (default: 0, no
+	// limit). Oversized writes fail with a SizeLimitError before reaching
+	// the WAL; entries already logged replay regardless.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// EnableTTL allows InsertWithTTL (default: false). A WAL holding TTL
+	// entries only replays with it set.
+	EnableTTL bool
+
+	// EncryptionKey and DecryptionKeys encrypt the WAL (see WALConfig)
+	EncryptionKey  []byte
+	DecryptionKeys [][]byte
+
+	// PreallocateSize reserves WAL space in extents (see WALConfig)
+	PreallocateSize int64
+
+	// ReplayProgress, if set, is called as recovery replays the WAL (see
+	// WAL.ReplayWithProgress), e.g. to report startup progress to health checks
+	ReplayProgress func(ReplayProgress)
 }
 
 // DurableStats provides statistics for the durable B-Tree.
@@ -56,15 +95,26 @@ type DurableStats struct {
 // NewDurableBTree creates a new durable B-Tree with WAL.
 // If a WAL exists with entries, they will be replayed to restore state.
 func NewDurableBTree(config DurableConfig) (*DurableBTree, error) {
+	return openDurableBTree(config, applyEntry)
+}
+
+// openDurableBTree creates a durable B-Tree, replaying its WAL with apply.
+func openDurableBTree(config DurableConfig, apply func(*ShardedBTree, *LogEntry) error) (*DurableBTree, error) {
 	if config.WALPath == "" {
 		return nil, fmt.Errorf("WAL path is required")
 	}
 
 	// Create WAL first
 	wal, err := NewWAL(WALConfig{
-		Path:      config.WALPath,
-		SyncMode:  config.SyncMode,
-		BatchSize: config.BatchSize,
+		Path:            config.WALPath,
+		SyncMode:        config.SyncMode,
+		BatchSize:       config.BatchSize,
+		SyncInterval:    config.SyncInterval,
+		MaxKeySize:      config.MaxKeySize,
+		MaxValueSize:    config.MaxValueSize,
+		EncryptionKey:   config.EncryptionKey,
+		DecryptionKeys:  config.DecryptionKeys,
+		PreallocateSize: config.PreallocateSize,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create WAL: %w", err)
@@ -73,6 +123,8 @@ func NewDurableBTree(config DurableConfig) (*DurableBTree, error) {
 	// Create tree
 	tree := NewShardedBTree(ShardConfig{
 		NumShards: config.NumShards,
+		PanicFree: config.PanicFree,
+		EnableTTL: config.EnableTTL,
 	})
 
 	db := &DurableBTree{
@@ -80,9 +132,10 @@ func NewDurableBTree(config DurableConfig) (*DurableBTree, error) {
 		wal:    wal,
 		config: config,
 	}
+	db.turns = sync.NewCond(&db.mu)
 
 	// Replay WAL to restore state
-	count, err := db.recover()
+	count, err := db.recover(apply)
 	if err != nil {
 		wal.Close()
 		return nil, fmt.Errorf("failed to recover from WAL: %w", err)
@@ -93,39 +146,157 @@ func NewDurableBTree(config DurableConfig) (*DurableBTree, error) {
 		_ = count // Recovered entries
 	}
 
+	// Limits apply from here on, so entries logged under looser ones replay
+	tree.SetSizeLimits(config.MaxKeySize, config.MaxValueSize)
+
 	return db, nil
 }
 
 // recover replays the WAL to restore tree state.
-func (db *DurableBTree) recover() (int, error) {
-	return db.wal.Replay(func(entry *LogEntry) error {
-		switch entry.Op {
-		case OpInsert:
-			db.tree.Insert(entry.Key, entry.Value)
-		case OpDelete:
-			db.tree.Delete(entry.Key)
-		case OpClear:
-			db.tree.Clear()
+func (db *DurableBTree) recover(apply func(*ShardedBTree, *LogEntry) error) (int, error) {
+	return db.wal.ReplayWithProgress(func(entry *LogEntry) error {
+		return apply(db.tree, entry)
+	}, db.config.ReplayProgress)
+}
+
+// applyEntry applies a logged operation to tree. A TTL entry keeps its
+// original expiry, so a key that expired while down is missing at once.
+func applyEntry(tree *ShardedBTree, entry *LogEntry) error {
+	switch entry.Op {
+	case OpInsert:
+		return tree.TryInsert(entry.Key, entry.Value)
+	case OpInsertTTL:
+		value, expiry, err := decodeTTLValue(entry.Value)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		return tree.insertWithExpiry(entry.Key, value, expiry)
+	case OpDelete:
+		_, err := tree.TryDelete(entry.Key)
+		return err
+	case OpClear:
+		tree.Clear()
+	}
+	return nil
 }
 
 // Insert adds a key-value pair with WAL durability.
 func (db *DurableBTree) Insert(key Keytype, value Valuetype) error {
+	return db.write("insert", OpInsert, key, value, func() error {
+		return db.tree.TryInsert(key, value)
+	})
+}
+
+// write logs an entry, waits for its commit and applies it to the tree with
+// apply. what names the write in errors.
+func (db *DurableBTree) write(what string, op OpType, key, value []byte, apply func() error) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.writeLocked(what, op, key, value, apply)
+}
 
-	// Log to WAL first
-	if _, err := db.wal.AppendInsert(key, value); err != nil {
-		return fmt.Errorf("WAL insert failed: %w", err)
+// writeLocked is write called with db.mu held. Under SyncAlways it waits
+// for the commit with db.mu released, so concurrent writers share one fsync
+// (see GROUP COMMIT on WAL), then waits its turn to apply, so the tree
+// applies writes in the order the log holds them. A failed commit applies
+// nothing.
+func (db *DurableBTree) writeLocked(what string, op OpType, key, value []byte, apply func() error) error {
+	seq, err := db.wal.appendUncommitted(op, key, value)
+	if err != nil {
+		return fmt.Errorf("WAL %s failed: %w", what, err)
+	}
+	if db.wal.syncMode == SyncAlways {
+		turn := db.logged
+		db.logged++
+		db.mu.Unlock()
+		err := db.wal.awaitCommit(seq)
+		db.mu.Lock()
+
+		for db.applied != turn {
+			db.turns.Wait()
+		}
+		defer db.finishTurn()
+		if err != nil {
+			return fmt.Errorf("WAL %s failed: %w", what, err)
+		}
+	}
+	if err := apply(); err != nil {
+		return fmt.Errorf("tree %s failed: %w", what, err)
 	}
-
-	// Then apply to tree
-	db.tree.Insert(key, value)
 	return nil
 }
 
+// finishTurn lets the next committed write apply. Called with db.mu held.
+func (db *DurableBTree) finishTurn() {
+	db.applied++
+	db.turns.Broadcast()
+}
+
+// drain waits until every logged write has been applied or has failed, for
+// callers that read the tree before logging or replace the log. Called with
+// db.mu held; other writers may run meanwhile.
+func (db *DurableBTree) drain() {
+	for db.applied != db.logged {
+		db.turns.Wait()
+	}
+}
+
+// Upsert inserts or overwrites key with WAL durability and reports whether
+// it replaced a value.
+func (db *DurableBTree) Upsert(key Keytype, value Valuetype) (bool, error) {
+	var replaced bool
+	err := db.write("insert", OpInsert, key, value, func() (err error) {
+		replaced, err = db.tree.Upsert(key, value)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return replaced, nil
+}
+
+// InsertNX inserts key with WAL durability only if it is absent, and
+// returns ErrKeyExists otherwise. Nothing is logged for a present key.
+func (db *DurableBTree) InsertNX(key Keytype, value Valuetype) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// With every logged write applied, the tree shows whether the key is
+	// present, and writers logging meanwhile apply after this one
+	db.drain()
+	if _, err := db.tree.Find(key); err == nil {
+		return ErrKeyExists
+	}
+	return db.writeLocked("insert", OpInsert, key, value, func() error {
+		return db.tree.TryInsert(key, value)
+	})
+}
+
+// InsertWithTTL adds a key-value pair that expires after ttl, with WAL
+// durability. The WAL entry carries the expiry time, so the key expires on
+// schedule across restarts. See ShardedBTree.InsertWithTTL.
+func (db *DurableBTree) InsertWithTTL(key Keytype, value Valuetype, ttl time.Duration) error {
+	if !db.config.EnableTTL {
+		return ErrTTLDisabled
+	}
+	expiry := time.Now().Add(ttl)
+	return db.write("insert", OpInsertTTL, key, encodeTTLValue(value, expiry.UnixNano()), func() error {
+		return db.tree.insertWithExpiry(key, value, expiry.UnixNano())
+	})
+}
+
+// SweepExpired deletes every expired key from the tree. Sweeps are not
+// logged: replaying a TTL entry restores its expiry, which has passed.
+func (db *DurableBTree) SweepExpired() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.SweepExpired()
+}
+
+// StartSweeper runs SweepExpired every interval until stop is called.
+func (db *DurableBTree) StartSweeper(interval time.Duration) (stop func()) {
+	return db.tree.StartSweeper(interval)
+}
+
 // Put is an alias for Insert.
 func (db *DurableBTree) Put(key Keytype, value Valuetype) error {
 	return db.Insert(key, value)
@@ -133,16 +304,14 @@ func (db *DurableBTree) Put(key Keytype, value Valuetype) error {
 
 // Delete removes a key with WAL durability.
 func (db *DurableBTree) Delete(key Keytype) (bool, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	// Log to WAL first
-	if _, err := db.wal.AppendDelete(key); err != nil {
-		return false, fmt.Errorf("WAL delete failed: %w", err)
+	var deleted bool
+	err := db.write("delete", OpDelete, key, nil, func() (err error) {
+		deleted, err = db.tree.TryDelete(key)
+		return err
+	})
+	if err != nil {
+		return false, err
 	}
-
-	// Then apply to tree
-	deleted := db.tree.Delete(key)
 	return deleted, nil
 }
 
@@ -153,6 +322,13 @@ func (db *DurableBTree) Find(key Keytype) (Valuetype, error) {
 	return db.tree.Find(key)
 }
 
+// FindInto is Find copying the value into dst. See Btree.FindInto.
+func (db *DurableBTree) FindInto(key Keytype, dst []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.FindInto(key, dst)
+}
+
 // Get is an alias for Find.
 func (db *DurableBTree) Get(key Keytype) (Valuetype, error) {
 	return db.Find(key)
@@ -165,48 +341,128 @@ func (db *DurableBTree) GetRange(startKey, endKey Keytype) ([]Keytype, []Valuety
 	return db.tree.GetRange(startKey, endKey)
 }
 
-// Clear removes all entries with WAL durability.
-func (db *DurableBTree) Clear() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// GetRangeLimit returns the first limit pairs in the range, reading each
+// shard only as far as needed (read-only, no WAL).
+func (db *DurableBTree) GetRangeLimit(startKey, endKey Keytype, limit int) ([]Keytype, []Valuetype, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tree.GetRangeLimit(startKey, endKey, limit)
+}
 
-	// Log to WAL
-	if _, err := db.wal.AppendClear(); err != nil {
-		return fmt.Errorf("WAL clear failed: %w", err)
-	}
+// SplitRanges returns at most n key ranges holding roughly equal numbers of
+// keys, for partitioning a full scan across workers.
+func (db *DurableBTree) SplitRanges(n int) []KeyRange {
+	return db.tree.SplitRanges(n)
+}
 
-	// Apply to tree
-	db.tree.Clear()
-	return nil
+// InRange returns an iterator over the key-value pairs in r, ascending
+// within each shard but not across shards.
+func (db *DurableBTree) InRange(r KeyRange) iter.Seq2[[]byte, []byte] {
+	return db.tree.InRange(r)
+}
+
+// Clear removes all entries with WAL durability.
+func (db *DurableBTree) Clear() error {
+	return db.write("clear", OpClear, nil, nil, func() error {
+		db.tree.Clear()
+		return nil
+	})
 }
 
 // BulkInsert inserts multiple key-value pairs with WAL durability.
-// All entries are logged before any are applied (atomic batch).
+// All entries are logged as one WAL batch before any are applied, so
+// recovery replays all of them or none.
 func (db *DurableBTree) BulkInsert(keys []Keytype, values []Valuetype) error {
 	if len(keys) != len(values) {
 		return fmt.Errorf("keys and values length mismatch")
 	}
 
+	// Reject oversized entries before logging any, keeping the batch whole
+	for i := range keys {
+		if err := checkSizes(keys[i], values[i], db.config.MaxKeySize, db.config.MaxValueSize); err != nil {
+			return fmt.Errorf("bulk insert entry %d: %w", i, err)
+		}
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.drain() // Keep the tree applying writes in log order
 
-	// Log all to WAL first
+	// Log all to WAL first, as one batch
+	entries := make([]LogEntry, len(keys))
 	for i := range keys {
-		if _, err := db.wal.AppendInsert(keys[i], values[i]); err != nil {
-			return fmt.Errorf("WAL bulk insert failed at index %d: %w", i, err)
-		}
+		entries[i] = LogEntry{Op: OpInsert, Key: keys[i], Value: values[i]}
+	}
+	if _, err := db.wal.AppendBatch(entries); err != nil {
+		return fmt.Errorf("WAL bulk insert failed: %w", err)
 	}
 
 	// Sync WAL before applying (ensures durability of batch)
-	if err := db.wal.Sync(); err != nil {
+	if err := db.syncBatch(); err != nil {
 		return fmt.Errorf("WAL sync failed: %w", err)
 	}
 
 	// Apply all to tree
-	db.tree.BulkInsert(keys, values)
+	if err := db.tree.BulkInsert(keys, values); err != nil {
+		return fmt.Errorf("tree bulk insert failed: %w", err)
+	}
+	return nil
+}
+
+// batchOp is one write in a batch passed to writeBatch.
+type batchOp struct {
+	op    OpType // OpInsert or OpDelete
+	key   Keytype
+	value Valuetype
+}
+
+// writeBatch logs every op and syncs the WAL before applying any of them,
+// as BulkInsert does, for writes that mix inserts and deletes.
+func (db *DurableBTree) writeBatch(ops []batchOp) error {
+	for i, op := range ops {
+		if err := checkSizes(op.key, op.value, db.config.MaxKeySize, db.config.MaxValueSize); err != nil {
+			return fmt.Errorf("batch entry %d: %w", i, err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.drain() // Keep the tree applying writes in log order
+
+	entries := make([]LogEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = LogEntry{Op: op.op, Key: op.key, Value: op.value}
+	}
+	if _, err := db.wal.AppendBatch(entries); err != nil {
+		return fmt.Errorf("WAL batch failed: %w", err)
+	}
+	if err := db.syncBatch(); err != nil {
+		return fmt.Errorf("WAL sync failed: %w", err)
+	}
+
+	for _, op := range ops {
+		var err error
+		if op.op == OpDelete {
+			_, err = db.tree.TryDelete(op.key)
+		} else {
+			err = db.tree.TryInsert(op.key, op.value)
+		}
+		if err != nil {
+			return fmt.Errorf("tree batch failed: %w", err)
+		}
+	}
 	return nil
 }
 
+// syncBatch fsyncs a logged batch before it is applied, unless AppendBatch
+// already waited for its commit.
+func (db *DurableBTree) syncBatch() error {
+	if db.wal.syncMode == SyncAlways {
+		return nil
+	}
+	return db.wal.Sync()
+}
+
 // Count returns the total number of keys.
 func (db *DurableBTree) Count() int64 {
 	db.mu.RLock()
@@ -225,9 +481,16 @@ func (db *DurableBTree) ForEach(fn func(key Keytype, value Valuetype) bool) {
 // Call this periodically to prevent unbounded WAL growth.
 // In a real system, this would be called after persisting the tree to disk.
 func (db *DurableBTree) Checkpoint() error {
+	return db.checkpoint(nil)
+}
+
+// checkpoint is Checkpoint starting the truncated WAL with carry (see
+// WAL.checkpoint).
+func (db *DurableBTree) checkpoint(carry []LogEntry) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.wal.Checkpoint()
+	db.drain()
+	return db.wal.checkpoint(carry)
 }
 
 // Sync forces a sync of the WAL to disk.
@@ -235,10 +498,25 @@ func (db *DurableBTree) Sync() error {
 	return db.wal.Sync()
 }
 
+// Corruption returns the WAL damage recovery stopped at, or nil if it
+// replayed the whole file (see WAL.Corruption).
+func (db *DurableBTree) Corruption() *CorruptionReport {
+	return db.wal.Corruption()
+}
+
+// TruncateCorruptTail cuts the damaged tail off the WAL (see
+// WAL.TruncateCorruptTail).
+func (db *DurableBTree) TruncateCorruptTail() (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.drain()
+	return db.wal.TruncateCorruptTail()
+}
+
 // Stats returns combined statistics for tree and WAL.
 func (db *DurableBTree) Stats() DurableStats {
 	return DurableStats{
-		TreeStats: db.tree.Stats(),
+		TreeStats: db.tree.Stats(false),
 		WALStats:  db.wal.Stats(),
 	}
 }
@@ -247,6 +525,7 @@ func (db *DurableBTree) Stats() DurableStats {
 func (db *DurableBTree) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.drain()
 	return db.wal.Close()
 }
 
@@ -255,6 +534,11 @@ func (db *DurableBTree) WALPath() string {
 	return db.wal.Path()
 }
 
+// Epoch returns the WAL's epoch, the number of standby promotions before it.
+func (db *DurableBTree) Epoch() uint64 {
+	return db.wal.Epoch()
+}
+
 // WALSequence returns the current WAL sequence number.
 func (db *DurableBTree) WALSequence() uint64 {
 	return db.wal.Sequence()
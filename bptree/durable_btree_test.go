@@ -2,11 +2,13 @@ package bptree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 // ==================== DurableBTree Creation Tests ====================
@@ -337,6 +339,31 @@ func TestDurableBTreeBulkInsert(t *testing.T) {
 	}
 }
 
+func TestDurableBTreeBatchSyncsOnce(t *testing.T) {
+	db, err := NewDurableBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	keys := []Keytype{[]byte("a"), []byte("b"), []byte("c")}
+	values := []Valuetype{[]byte("1"), []byte("2"), []byte("3")}
+	if err := db.BulkInsert(keys, values); err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if syncs := db.Stats().WALStats.TotalSyncs; syncs != 1 {
+		t.Errorf("BulkInsert under SyncAlways: %d fsyncs, want 1", syncs)
+	}
+
+	err = db.writeBatch([]batchOp{{op: OpDelete, key: []byte("a")}, {op: OpInsert, key: []byte("d"), value: []byte("4")}})
+	if err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	if syncs := db.Stats().WALStats.TotalSyncs; syncs != 2 {
+		t.Errorf("writeBatch under SyncAlways: %d fsyncs in all, want 2", syncs)
+	}
+}
+
 func TestDurableBTreeBulkInsertMismatch(t *testing.T) {
 	tmpDir := t.TempDir()
 	walPath := filepath.Join(tmpDir, "test.wal")
@@ -492,6 +519,87 @@ func TestDurableBTreeConcurrentInserts(t *testing.T) {
 	}
 }
 
+func TestDurableBTreeGroupCommit(t *testing.T) {
+	db, err := NewDurableBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	// Stand in for a leader's fsync: every writer must log its entry and
+	// release db.mu while waiting for it
+	const numWriters = 20
+	db.wal.syncMu.Lock()
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := db.Insert([]byte(fmt.Sprintf("key%d", id)), []byte("value")); err != nil {
+				t.Errorf("Concurrent insert failed: %v", err)
+			}
+		}(w)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for db.WALSequence() < numWriters {
+		if time.Now().After(deadline) {
+			db.wal.syncMu.Unlock()
+			t.Fatalf("Only %d of %d writers logged while an fsync was pending", db.WALSequence(), numWriters)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	db.wal.syncMu.Unlock()
+	wg.Wait()
+
+	if syncs := db.Stats().WALStats.TotalSyncs; syncs >= numWriters {
+		t.Errorf("Expected %d writes to share fsyncs, got %d fsyncs", numWriters, syncs)
+	}
+	if n := db.Count(); n != numWriters {
+		t.Errorf("Expected %d keys, got %d", numWriters, n)
+	}
+}
+
+func TestDurableBTreeAppliesAfterCommit(t *testing.T) {
+	db, err := NewDurableBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	// A logged write stays invisible until its fsync completes
+	db.wal.syncMu.Lock()
+	done := make(chan error, 1)
+	go func() { done <- db.Insert([]byte("key"), []byte("value")) }()
+	for db.WALSequence() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := db.Find([]byte("key")); err == nil {
+		t.Error("Write visible before its fsync")
+	}
+	db.wal.syncMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if _, err := db.Find([]byte("key")); err != nil {
+		t.Errorf("Write not visible after its commit: %v", err)
+	}
+
+	// A failed fsync applies nothing
+	db.wal.syncMu.Lock()
+	go func() { done <- db.Insert([]byte("lost"), []byte("value")) }()
+	for db.WALSequence() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	db.wal.file.Close()
+	db.wal.syncMu.Unlock()
+	if err := <-done; err == nil {
+		t.Error("Insert succeeded though its fsync failed")
+	}
+	if _, err := db.Find([]byte("lost")); err == nil {
+		t.Error("Write applied though its fsync failed")
+	}
+}
+
 func TestDurableBTreeConcurrentMixed(t *testing.T) {
 	tmpDir := t.TempDir()
 	walPath := filepath.Join(tmpDir, "test.wal")
@@ -686,3 +794,58 @@ func TestDurableBTreeForEachEarlyStop(t *testing.T) {
 		t.Errorf("Expected to stop at 10, got %d", count)
 	}
 }
+
+func TestUpsertAndInsertNX(t *testing.T) {
+	db, err := NewDurableBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "test.wal"), NumShards: 2, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type store interface {
+		Upsert(Keytype, Valuetype) (bool, error)
+		InsertNX(Keytype, Valuetype) error
+		FindInto(key Keytype, dst []byte) ([]byte, error)
+	}
+	for name, s := range map[string]store{
+		"ShardedBTree": NewShardedBTree(ShardConfig{NumShards: 2}),
+		"DurableBTree": db,
+	} {
+		if replaced, err := s.Upsert([]byte("k"), []byte("1")); replaced || err != nil {
+			t.Errorf("%s: first Upsert = %v, %v", name, replaced, err)
+		}
+		if replaced, err := s.Upsert([]byte("k"), []byte("2")); !replaced || err != nil {
+			t.Errorf("%s: second Upsert = %v, %v", name, replaced, err)
+		}
+		if err := s.InsertNX([]byte("k"), []byte("3")); !errors.Is(err, ErrKeyExists) {
+			t.Errorf("%s: InsertNX of present key = %v", name, err)
+		}
+		if err := s.InsertNX([]byte("new"), []byte("4")); err != nil {
+			t.Errorf("%s: InsertNX of absent key = %v", name, err)
+		}
+		if value, _ := s.FindInto([]byte("k"), nil); string(value) != "2" {
+			t.Errorf("%s: k = %q, want 2", name, value)
+		}
+	}
+
+	tree := &Btree{}
+	if replaced, _ := tree.Upsert([]byte("k"), []byte("1")); replaced {
+		t.Error("Btree: first Upsert replaced a value")
+	}
+	if replaced, _ := tree.Upsert([]byte("k"), []byte("2")); !replaced {
+		t.Error("Btree: second Upsert did not replace")
+	}
+	if err := tree.InsertNX([]byte("k"), []byte("3")); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Btree: InsertNX of present key = %v", err)
+	}
+	if value, _ := tree.Find([]byte("k")); string(value) != "2" {
+		t.Errorf("Btree: k = %q, want 2", value)
+	}
+
+	// A rejected InsertNX is not logged
+	sequence := db.WALSequence()
+	db.InsertNX([]byte("k"), []byte("5"))
+	if db.WALSequence() != sequence {
+		t.Error("Rejected InsertNX reached the WAL")
+	}
+}
@@ -0,0 +1,377 @@
+package bptree
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DurableIndexedBTree is an IndexedBTree whose records and index
+// definitions are logged in a WAL, so it reopens with its indexes intact.
+//
+// DESIGN:
+// - Records live in a DurableBTree; an IndexedBTree maintains indexes over the same tree
+// - Indexes are declared by IndexDefinition rather than a KeyExtractor, since functions cannot be logged
+// - Definitions are logged as OpCreateIndex and OpDropIndex entries, which a plain DurableBTree skips on replay
+// - On open the WAL is replayed, then each index still defined is rebuilt from the records
+// - Writes are serialized: unique constraints are checked before a write is logged, so every logged write applies
+//
+// LIMITATIONS:
+// - Indexes are rebuilt on every open, in O(n) per index
+// - Index updates are always inline (no AsyncIndexThreshold)
+//
+// USAGE:
+//
+//	db, _ := NewDurableIndexedBTree(DurableConfig{WALPath: "/data/users.wal"})
+//	defer db.Close()
+//
+//	db.CreateIndex(IndexDefinition{Name: "email", Path: "email", Unique: true})
+//	db.Insert([]byte("user:1"), []byte(`{"email":"a@b.com"}`))
+//
+//	// After a restart, the email index is back
+//	pk, _ := db.FindByIndex("email", []byte("a@b.com"))
+type DurableIndexedBTree struct {
+	durable *DurableBTree
+	indexed *IndexedBTree
+	defs    []IndexDefinition // In creation order
+	mu      sync.Mutex        // Serializes writes
+}
+
+// IndexDefinition declares an index of JSON records that a
+// DurableIndexedBTree can log and recreate.
+type IndexDefinition struct {
+	Name string
+	// Path is the JSON path of the indexed field, as for JSONPathExtractor
+	Path string
+	// Fields makes a composite index instead, as for CreateCompositeIndex
+	Fields []IndexField
+	Unique bool
+	// CaseInsensitive indexes Path with CaseFoldNormalizer
+	CaseInsensitive bool
+	// Covering stores records in the index (see IndexConfig.Covering)
+	Covering bool
+}
+
+// index returns an empty index as def declares it.
+func (def IndexDefinition) index() (*SecondaryIndex, error) {
+	if def.Name == "" {
+		return nil, errors.New("index needs a name")
+	}
+	if (def.Path == "") == (def.Fields == nil) {
+		return nil, fmt.Errorf("index %q needs either a path or fields", def.Name)
+	}
+
+	if def.Fields != nil {
+		if def.CaseInsensitive {
+			return nil, fmt.Errorf("composite index %q cannot be case-insensitive", def.Name)
+		}
+		idx, err := newCompositeIndex(def.Name, def.Fields, def.Unique)
+		if err != nil {
+			return nil, err
+		}
+		idx.covering = def.Covering
+		return idx, nil
+	}
+
+	if _, err := parseJSONPath(def.Path); err != nil {
+		return nil, err
+	}
+	config := IndexConfig{
+		Name:      def.Name,
+		Extractor: JSONPathExtractor(def.Path),
+		Unique:    def.Unique,
+		NumShards: 4,
+		Covering:  def.Covering,
+	}
+	if def.CaseInsensitive {
+		config.Normalizer = CaseFoldNormalizer
+	}
+	return NewSecondaryIndex(config), nil
+}
+
+// NewDurableIndexedBTree opens a durable indexed B-Tree, replaying any
+// existing WAL and rebuilding the indexes it defines.
+func NewDurableIndexedBTree(config DurableConfig) (*DurableIndexedBTree, error) {
+	var defs []IndexDefinition
+	durable, err := openDurableBTree(config, func(tree *ShardedBTree, entry *LogEntry) error {
+		switch entry.Op {
+		case OpCreateIndex:
+			var def IndexDefinition
+			if err := json.Unmarshal(entry.Value, &def); err != nil {
+				return fmt.Errorf("bad definition of index %q: %w", entry.Key, err)
+			}
+			defs = append(removeDefinition(defs, def.Name), def)
+		case OpDropIndex:
+			defs = removeDefinition(defs, string(entry.Key))
+		default:
+			return applyEntry(tree, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DurableIndexedBTree{
+		durable: durable,
+		indexed: newIndexedBTreeOn(durable.tree, IndexedConfig{}),
+	}
+	for _, def := range defs {
+		if err := db.addDefinition(def); err != nil {
+			durable.Close()
+			return nil, fmt.Errorf("failed to rebuild index %q: %w", def.Name, err)
+		}
+	}
+	return db, nil
+}
+
+// removeDefinition returns defs without the definition of index name.
+func removeDefinition(defs []IndexDefinition, name string) []IndexDefinition {
+	kept := defs[:0]
+	for _, def := range defs {
+		if def.Name != name {
+			kept = append(kept, def)
+		}
+	}
+	return kept
+}
+
+// addDefinition creates and populates the index def declares.
+func (db *DurableIndexedBTree) addDefinition(def IndexDefinition) error {
+	idx, err := def.index()
+	if err != nil {
+		return err
+	}
+	if err := db.indexed.addIndex(idx, true); err != nil {
+		return err
+	}
+	db.defs = append(db.defs, def)
+	return nil
+}
+
+// CreateIndex creates the index def declares, populates it with existing
+// records and logs its definition. The index is built before it is logged,
+// so a definition that fails (say, on a unique violation) is never replayed.
+func (db *DurableIndexedBTree) CreateIndex(def IndexDefinition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.indexed.HasIndex(def.Name) {
+		return errors.New("index already exists")
+	}
+	def.Fields = append([]IndexField(nil), def.Fields...) // nil if empty
+	encoded, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	if err := db.addDefinition(def); err != nil {
+		return err
+	}
+	if _, err := db.durable.wal.Append(OpCreateIndex, []byte(def.Name), encoded); err != nil {
+		db.defs = removeDefinition(db.defs, def.Name)
+		db.indexed.DropIndex(def.Name)
+		return fmt.Errorf("WAL create index failed: %w", err)
+	}
+	return nil
+}
+
+// DropIndex removes an index and logs its removal.
+func (db *DurableIndexedBTree) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.indexed.HasIndex(name) {
+		return errors.New("index not found")
+	}
+	if _, err := db.durable.wal.Append(OpDropIndex, []byte(name), nil); err != nil {
+		return fmt.Errorf("WAL drop index failed: %w", err)
+	}
+	db.defs = removeDefinition(db.defs, name)
+	return db.indexed.DropIndex(name)
+}
+
+// Indexes returns the definitions of the indexes, in creation order.
+func (db *DurableIndexedBTree) Indexes() []IndexDefinition {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]IndexDefinition(nil), db.defs...)
+}
+
+// Insert adds or replaces a record, logging it and updating all indexes.
+// A unique violation fails the write before anything is logged.
+func (db *DurableIndexedBTree) Insert(key Keytype, value Valuetype) error {
+	return db.put(key, value, false)
+}
+
+// Put is an alias for Insert.
+func (db *DurableIndexedBTree) Put(key Keytype, value Valuetype) error {
+	return db.Insert(key, value)
+}
+
+// Update replaces an existing record, logging it and maintaining all
+// indexes.
+func (db *DurableIndexedBTree) Update(key Keytype, newValue Valuetype) error {
+	return db.put(key, newValue, true)
+}
+
+// put writes a record, failing if it must exist and does not.
+func (db *DurableIndexedBTree) put(key Keytype, value Valuetype, mustExist bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err := db.indexed.tree.Find(key)
+	existed := err == nil
+	if !existed {
+		if mustExist {
+			return errors.New("key not found")
+		}
+		oldValue = nil
+	}
+	if err := uniqueViolation(db.indexes(), oldValue, value); err != nil {
+		return err
+	}
+
+	if _, err := db.durable.wal.AppendInsert(key, value); err != nil {
+		return fmt.Errorf("WAL insert failed: %w", err)
+	}
+	if existed {
+		err = db.indexed.Update(key, value)
+	} else {
+		err = db.indexed.Insert(key, value)
+	}
+	if err != nil {
+		// Undo the logged write too, so replay matches the tree
+		if existed {
+			db.durable.wal.AppendInsert(key, oldValue)
+		} else {
+			db.durable.wal.AppendDelete(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a record, logging it and updating all indexes.
+func (db *DurableIndexedBTree) Delete(key Keytype) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.indexed.tree.Find(key); err != nil {
+		return false, nil
+	}
+	if _, err := db.durable.wal.AppendDelete(key); err != nil {
+		return false, fmt.Errorf("WAL delete failed: %w", err)
+	}
+	return db.indexed.Delete(key)
+}
+
+// Clear removes all records, logging it. Indexes stay defined, and empty.
+func (db *DurableIndexedBTree) Clear() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.durable.wal.AppendClear(); err != nil {
+		return fmt.Errorf("WAL clear failed: %w", err)
+	}
+	db.indexed.Clear()
+	return nil
+}
+
+// indexes returns the current indexes.
+func (db *DurableIndexedBTree) indexes() []*SecondaryIndex {
+	db.indexed.mu.RLock()
+	defer db.indexed.mu.RUnlock()
+
+	indexes := make([]*SecondaryIndex, 0, len(db.indexed.indexes))
+	for _, idx := range db.indexed.indexes {
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
+
+// Find searches for a key in the primary tree.
+func (db *DurableIndexedBTree) Find(key Keytype) (Valuetype, error) {
+	return db.indexed.Find(key)
+}
+
+// Get is an alias for Find.
+func (db *DurableIndexedBTree) Get(key Keytype) (Valuetype, error) {
+	return db.Find(key)
+}
+
+// FindByIndex finds the primary key for a key of a unique index.
+func (db *DurableIndexedBTree) FindByIndex(indexName string, indexKey []byte) (Keytype, error) {
+	return db.indexed.FindByIndex(indexName, indexKey)
+}
+
+// FindAllByIndex finds the primary keys of all records with an index key.
+func (db *DurableIndexedBTree) FindAllByIndex(indexName string, indexKey []byte) ([]Keytype, error) {
+	return db.indexed.FindAllByIndex(indexName, indexKey)
+}
+
+// FindRangeByIndex finds the primary keys of all records with index keys
+// in [startKey, endKey].
+func (db *DurableIndexedBTree) FindRangeByIndex(indexName string, startKey, endKey []byte) ([]Keytype, error) {
+	return db.indexed.FindRangeByIndex(indexName, startKey, endKey)
+}
+
+// FindPrefixByIndex finds the primary keys of all records whose index key
+// starts with prefix.
+func (db *DurableIndexedBTree) FindPrefixByIndex(indexName string, prefix []byte) ([]Keytype, error) {
+	return db.indexed.FindPrefixByIndex(indexName, prefix)
+}
+
+// FindByComposite queries a composite index by its leading field values.
+func (db *DurableIndexedBTree) FindByComposite(name string, values ...any) ([]Keytype, error) {
+	return db.indexed.FindByComposite(name, values...)
+}
+
+// Query returns the records matching every filter. See IndexedBTree.Query.
+func (db *DurableIndexedBTree) Query(filters []Filter, opts QueryOptions) (QueryResult, error) {
+	return db.indexed.Query(filters, opts)
+}
+
+// Count returns the number of records.
+func (db *DurableIndexedBTree) Count() int64 {
+	return db.indexed.Count()
+}
+
+// ForEach iterates over all records.
+func (db *DurableIndexedBTree) ForEach(fn func(key Keytype, value Valuetype) bool) {
+	db.indexed.ForEach(fn)
+}
+
+// Stats returns statistics for the tree and its indexes.
+func (db *DurableIndexedBTree) Stats() IndexedStats {
+	return db.indexed.Stats()
+}
+
+// Checkpoint truncates the WAL, starting the new file with the index
+// definitions so they outlive it. See DurableBTree.Checkpoint.
+func (db *DurableIndexedBTree) Checkpoint() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	carry := make([]LogEntry, len(db.defs))
+	for i, def := range db.defs {
+		encoded, err := json.Marshal(def)
+		if err != nil {
+			return err
+		}
+		carry[i] = LogEntry{Op: OpCreateIndex, Key: []byte(def.Name), Value: encoded}
+	}
+	return db.durable.checkpoint(carry)
+}
+
+// Sync forces a sync of the WAL to disk.
+func (db *DurableIndexedBTree) Sync() error {
+	return db.durable.Sync()
+}
+
+// Close closes the WAL.
+func (db *DurableIndexedBTree) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.durable.Close()
+}
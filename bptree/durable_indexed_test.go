@@ -0,0 +1,166 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurableIndexedBTreeRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "indexed.wal")
+
+	db, err := NewDurableIndexedBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create DurableIndexedBTree: %v", err)
+	}
+	if err := db.CreateIndex(IndexDefinition{Name: "email", Path: "email", Unique: true, CaseInsensitive: true}); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if err := db.CreateIndex(IndexDefinition{Name: "city_age", Fields: []IndexField{{Name: "city"}, {Name: "age", Type: IntField}}}); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if err := db.CreateIndex(IndexDefinition{Name: "temp", Path: "city"}); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		value := fmt.Sprintf(`{"email":"User%d@Example.com","city":"NYC","age":%d}`, i, 20+i)
+		if err := db.Insert([]byte(fmt.Sprintf("user:%d", i)), []byte(value)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	db.Update([]byte("user:1"), []byte(`{"email":"moved@example.com","city":"LA","age":21}`))
+	db.Delete([]byte("user:2"))
+	db.DropIndex("temp")
+
+	// A unique violation is neither applied nor logged
+	err = db.Insert([]byte("user:99"), []byte(`{"email":"user3@example.com","city":"SF","age":1}`))
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("Expected unique violation, got %v", err)
+	}
+	db.Close()
+
+	db, err = NewDurableIndexedBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen DurableIndexedBTree: %v", err)
+	}
+	defer db.Close()
+
+	if defs := db.Indexes(); len(defs) != 2 || defs[0].Name != "email" || defs[1].Name != "city_age" {
+		t.Fatalf("Expected indexes email and city_age, got %+v", defs)
+	}
+	if db.Count() != 9 {
+		t.Errorf("Expected 9 records, got %d", db.Count())
+	}
+	if pk, err := db.FindByIndex("email", []byte("USER3@example.com")); err != nil || string(pk) != "user:3" {
+		t.Errorf("Expected user:3 by email, got %q, %v", pk, err)
+	}
+	if pk, err := db.FindByIndex("email", []byte("moved@example.com")); err != nil || string(pk) != "user:1" {
+		t.Errorf("Expected user:1 by new email, got %q, %v", pk, err)
+	}
+	if _, err := db.FindByIndex("email", []byte("user1@example.com")); err == nil {
+		t.Error("Old email should not be indexed after recovery")
+	}
+	if pks, _ := db.FindByComposite("city_age", "NYC"); len(pks) != 8 {
+		t.Errorf("Expected 8 NYC records, got %d", len(pks))
+	}
+	if _, err := db.FindAllByIndex("temp", []byte("NYC")); err == nil {
+		t.Error("Dropped index should stay dropped after recovery")
+	}
+	err = db.Insert([]byte("user:99"), []byte(`{"email":"user4@example.com"}`))
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("Expected unique violation after recovery, got %v", err)
+	}
+}
+
+func TestDurableIndexedBTreeCheckpointKeepsIndexes(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "indexed.wal")
+
+	db, err := NewDurableIndexedBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create DurableIndexedBTree: %v", err)
+	}
+	db.CreateIndex(IndexDefinition{Name: "city", Path: "city"})
+	db.Insert([]byte("user:1"), []byte(`{"city":"NYC"}`))
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	db.Insert([]byte("user:2"), []byte(`{"city":"NYC"}`))
+	db.Close()
+
+	db, err = NewDurableIndexedBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen DurableIndexedBTree: %v", err)
+	}
+	defer db.Close()
+
+	pks, err := db.FindAllByIndex("city", []byte("NYC"))
+	if err != nil || len(pks) != 1 || string(pks[0]) != "user:2" {
+		t.Errorf("Expected user:2 under the checkpointed index, got %q, %v", pks, err)
+	}
+}
+
+func TestDurableIndexedBTreeCheckpointSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "indexed.wal")
+
+	db, err := NewDurableIndexedBTree(DurableConfig{WALPath: walPath, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create DurableIndexedBTree: %v", err)
+	}
+	defer db.Close()
+	db.CreateIndex(IndexDefinition{Name: "city", Path: "city"})
+	db.Insert([]byte("user:1"), []byte(`{"city":"NYC"}`))
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+
+	// Crash right after the checkpoint: only what reached the file survives
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashPath := filepath.Join(dir, "crashed.wal")
+	if err := os.WriteFile(crashPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(walPath + ".checkpoint"); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint left its temporary file behind: %v", err)
+	}
+
+	crashed, err := NewDurableIndexedBTree(DurableConfig{WALPath: crashPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen after crash: %v", err)
+	}
+	defer crashed.Close()
+	if defs := crashed.Indexes(); len(defs) != 1 || defs[0].Name != "city" {
+		t.Fatalf("Indexes after crash = %+v, want city", defs)
+	}
+	crashed.Insert([]byte("user:2"), []byte(`{"city":"NYC"}`))
+	if pks, err := crashed.FindAllByIndex("city", []byte("NYC")); err != nil || len(pks) != 1 {
+		t.Errorf("FindAllByIndex after crash = %q, %v", pks, err)
+	}
+}
+
+func TestDurableIndexedBTreeInvalidDefinition(t *testing.T) {
+	db, err := NewDurableIndexedBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "indexed.wal")})
+	if err != nil {
+		t.Fatalf("Failed to create DurableIndexedBTree: %v", err)
+	}
+	defer db.Close()
+
+	for _, def := range []IndexDefinition{
+		{Path: "email"},
+		{Name: "none"},
+		{Name: "both", Path: "a", Fields: []IndexField{{Name: "b"}}},
+		{Name: "bad", Path: "a[x]"},
+	} {
+		if err := db.CreateIndex(def); err == nil {
+			t.Errorf("Expected error for definition %+v", def)
+		}
+	}
+	if len(db.Indexes()) != 0 {
+		t.Errorf("Expected no indexes, got %+v", db.Indexes())
+	}
+}
@@ -0,0 +1,296 @@
+package bptree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DurableReader serves reads from a WAL that another process is writing.
+//
+// DESIGN:
+// - Opens the WAL read-only and never writes, locks or truncates it
+// - Replays the log once, then tails it: Refresh applies entries appended since
+// - A truncated (Checkpoint) or replaced (RotateLog) WAL triggers a full reload
+// - Reads see the state as of the last refresh, so they may lag the writer
+// - TTL entries keep their logged expiry, so expired keys read as missing
+//
+// LIMITATIONS:
+// - The store has no snapshot file yet: after a Checkpoint only newer entries are visible
+// - Entries the writer still holds in its buffer are not visible until flushed
+//
+// USAGE:
+//
+//	reader, err := OpenDurableReader(ReaderConfig{
+//	    WALPath:         "/data/stundb.wal",
+//	    RefreshInterval: time.Second,
+//	})
+//	defer reader.Close()
+//
+//	value, err := reader.Find(key)
+type DurableReader struct {
+	config ReaderConfig
+
+	mu   sync.RWMutex // Guards tree against reloads
+	tree *ShardedBTree
+
+	refreshMu sync.Mutex // Serializes Refresh
+	pos       walPosition
+	fileInfo  os.FileInfo
+	sequence  uint64 // pos.sequence, readable without refreshMu
+
+	// Statistics
+	reloads       uint64
+	refreshErrors uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ReaderConfig configures a read-only WAL reader.
+type ReaderConfig struct {
+	// WALPath is the path to the WAL file (required)
+	WALPath string
+
+	// NumShards for the in-memory tree (default: NumCPU)
+	NumShards int
+
+	// RefreshInterval is how often new WAL entries are applied
+	// (default: 0, only on explicit Refresh)
+	RefreshInterval time.Duration
+}
+
+// ReaderStats provides statistics for a DurableReader.
+type ReaderStats struct {
+	TreeStats     ShardStats
+	Sequence      uint64 // Last applied WAL sequence
+	Offset        int64  // WAL bytes applied
+	Reloads       uint64 // Full reloads after checkpoint or rotation
+	RefreshErrors uint64 // Failed background refreshes
+}
+
+// OpenDurableReader opens a WAL read-only and replays it.
+func OpenDurableReader(config ReaderConfig) (*DurableReader, error) {
+	if config.WALPath == "" {
+		return nil, fmt.Errorf("WAL path is required")
+	}
+
+	r := &DurableReader{
+		config: config,
+		tree:   NewShardedBTree(ShardConfig{NumShards: config.NumShards, EnableTTL: true}),
+	}
+	if err := r.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+
+	if config.RefreshInterval > 0 {
+		r.stop = make(chan struct{})
+		r.done = make(chan struct{})
+		go r.refreshLoop()
+	}
+
+	return r, nil
+}
+
+// refreshLoop applies the WAL tail every RefreshInterval until Close.
+func (r *DurableReader) refreshLoop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil {
+				atomic.AddUint64(&r.refreshErrors, 1)
+			}
+		}
+	}
+}
+
+// Refresh applies WAL entries appended since the last refresh.
+// If the WAL was truncated or replaced, the tree is rebuilt from scratch.
+func (r *DurableReader) Refresh() error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	file, err := os.Open(r.config.WALPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if r.fileInfo == nil || !os.SameFile(r.fileInfo, info) || info.Size() < r.pos.offset || !r.anchorIntact(file) {
+		return r.reload(file, info)
+	}
+
+	pos, err := r.applyTail(file, r.tree, r.pos)
+	r.setPosition(pos)
+	return err
+}
+
+// setPosition records how far the WAL has been applied. Called under refreshMu.
+func (r *DurableReader) setPosition(pos walPosition) {
+	r.pos = pos
+	atomic.StoreUint64(&r.sequence, pos.sequence)
+}
+
+// walPosition is how far a DurableReader has applied the WAL.
+type walPosition struct {
+	offset   int64  // End of the last applied entry
+	anchor   int64  // Start of the last applied entry, 0 if none
+	sequence uint64 // Sequence of the last applied entry
+	checksum uint32 // Checksum of the last applied entry
+}
+
+// anchorIntact reports whether the last applied entry is still where it was.
+// A WAL truncated by Checkpoint and refilled past our offset fails this check.
+func (r *DurableReader) anchorIntact(file *os.File) bool {
+	if r.pos.anchor == 0 {
+		return true // Nothing applied: any entries after the header are new
+	}
+	if _, err := file.Seek(r.pos.anchor, io.SeekStart); err != nil {
+		return false
+	}
+	entry, err := readEntry(bufio.NewReader(file))
+	return err == nil && entry.Sequence == r.pos.sequence && entry.Checksum == r.pos.checksum
+}
+
+// reload replays the whole WAL into a fresh tree and swaps it in.
+func (r *DurableReader) reload(file *os.File, info os.FileInfo) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, headerSize, err := readWALHeader(file)
+	if err != nil {
+		return err
+	}
+
+	tree := NewShardedBTree(ShardConfig{NumShards: r.config.NumShards, EnableTTL: true})
+	pos, err := r.applyTail(file, tree, walPosition{offset: headerSize})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.tree = tree
+	r.mu.Unlock()
+
+	if r.fileInfo != nil {
+		atomic.AddUint64(&r.reloads, 1)
+	}
+	r.setPosition(pos)
+	r.fileInfo = info
+	return nil
+}
+
+// applyTail applies complete entries after pos to tree and returns the new
+// position. A partially written or corrupt tail is left for the next refresh,
+// as WAL.Replay stops at the last good entry.
+func (r *DurableReader) applyTail(file *os.File, tree *ShardedBTree, pos walPosition) (walPosition, error) {
+	if _, err := file.Seek(pos.offset, io.SeekStart); err != nil {
+		return pos, err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		entry, err := readEntry(reader)
+		if err != nil {
+			return pos, nil
+		}
+
+		entries, err := unbatch(entry)
+		if err != nil {
+			return pos, err
+		}
+		for _, entry := range entries {
+			applyEntry(tree, entry)
+		}
+
+		pos.anchor = pos.offset
+		pos.offset += entryFrameSize(entry)
+		pos.sequence = entry.Sequence
+		pos.checksum = entry.Checksum
+	}
+}
+
+// current returns the tree reads should use.
+func (r *DurableReader) current() *ShardedBTree {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tree
+}
+
+// Find searches for a key as of the last refresh.
+func (r *DurableReader) Find(key Keytype) (Valuetype, error) {
+	return r.current().Find(key)
+}
+
+// Get is an alias for Find.
+func (r *DurableReader) Get(key Keytype) (Valuetype, error) {
+	return r.Find(key)
+}
+
+// GetRange returns all key-value pairs in the range as of the last refresh.
+func (r *DurableReader) GetRange(startKey, endKey Keytype) ([]Keytype, []Valuetype, error) {
+	return r.current().GetRange(startKey, endKey)
+}
+
+// GetRangeLimit returns the first limit pairs in the range as of the last
+// refresh.
+func (r *DurableReader) GetRangeLimit(startKey, endKey Keytype, limit int) ([]Keytype, []Valuetype, error) {
+	return r.current().GetRangeLimit(startKey, endKey, limit)
+}
+
+// Count returns the number of keys as of the last refresh.
+func (r *DurableReader) Count() int64 {
+	return r.current().Count()
+}
+
+// ForEach iterates over all key-value pairs as of the last refresh.
+func (r *DurableReader) ForEach(fn func(key Keytype, value Valuetype) bool) {
+	r.current().ForEach(fn)
+}
+
+// Sequence returns the sequence number of the last applied WAL entry.
+func (r *DurableReader) Sequence() uint64 {
+	return atomic.LoadUint64(&r.sequence)
+}
+
+// Stats returns reader statistics.
+func (r *DurableReader) Stats() ReaderStats {
+	r.refreshMu.Lock()
+	offset := r.pos.offset
+	r.refreshMu.Unlock()
+
+	return ReaderStats{
+		TreeStats:     r.current().Stats(false),
+		Sequence:      r.Sequence(),
+		Offset:        offset,
+		Reloads:       atomic.LoadUint64(&r.reloads),
+		RefreshErrors: atomic.LoadUint64(&r.refreshErrors),
+	}
+}
+
+// Close stops background refreshes. Safe to call more than once.
+func (r *DurableReader) Close() error {
+	if r.stop == nil {
+		return nil
+	}
+	r.closeOnce.Do(func() { close(r.stop) })
+	<-r.done
+	return nil
+}
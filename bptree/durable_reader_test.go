@@ -0,0 +1,134 @@
+package bptree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDurableReaderTailsWriter(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		db.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	reader, err := OpenDurableReader(ReaderConfig{WALPath: walPath, NumShards: 2})
+	if err != nil {
+		t.Fatalf("OpenDurableReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	if reader.Count() != 50 {
+		t.Errorf("Expected 50 keys after open, got %d", reader.Count())
+	}
+
+	// Writes after open are invisible until Refresh
+	db.Insert([]byte("key:new"), []byte("fresh"))
+	db.Delete([]byte("key:000"))
+	if _, err := reader.Find([]byte("key:new")); err == nil {
+		t.Error("Reader saw a write before Refresh")
+	}
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if value, err := reader.Find([]byte("key:new")); err != nil || string(value) != "fresh" {
+		t.Errorf("Find(key:new) after Refresh = %q, %v", value, err)
+	}
+	if _, err := reader.Find([]byte("key:000")); err == nil {
+		t.Error("Deleted key still visible after Refresh")
+	}
+	keys, _, _ := reader.GetRange([]byte("key:010"), []byte("key:019"))
+	if len(keys) != 10 {
+		t.Errorf("Expected 10 keys in range, got %d", len(keys))
+	}
+	if reader.Sequence() != db.WALSequence() {
+		t.Errorf("Reader sequence %d, writer sequence %d", reader.Sequence(), db.WALSequence())
+	}
+}
+
+func TestDurableReaderReloadsAfterCheckpoint(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		db.Insert([]byte(fmt.Sprintf("old:%02d", i)), []byte("v"))
+	}
+
+	reader, err := OpenDurableReader(ReaderConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("OpenDurableReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	// Refill past the reader's offset so only the content check can tell
+	db.Checkpoint()
+	for i := 0; i < 40; i++ {
+		db.Insert([]byte(fmt.Sprintf("new:%02d", i)), []byte("value-after-checkpoint"))
+	}
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if reader.Count() != 40 {
+		t.Errorf("Expected 40 keys after reload, got %d", reader.Count())
+	}
+	if _, err := reader.Find([]byte("new:39")); err != nil {
+		t.Errorf("Find(new:39) after reload: %v", err)
+	}
+	if stats := reader.Stats(); stats.Reloads != 1 {
+		t.Errorf("Expected 1 reload, got %d", stats.Reloads)
+	}
+}
+
+func TestDurableReaderBackgroundRefresh(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	reader, err := OpenDurableReader(ReaderConfig{WALPath: walPath, RefreshInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenDurableReader failed: %v", err)
+	}
+
+	db.Insert([]byte("key"), []byte("value"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := reader.Find([]byte("key")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Background refresh never applied the write")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	reader.Close()
+}
+
+func TestDurableReaderRequiresWALPath(t *testing.T) {
+	if _, err := OpenDurableReader(ReaderConfig{}); err == nil {
+		t.Error("Expected error when WAL path is empty")
+	}
+}
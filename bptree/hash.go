@@ -0,0 +1,25 @@
+package bptree
+
+import "hash/maphash"
+
+// HashFunc maps a key to the 32-bit hash that places it on a shard. It must
+// be deterministic for the life of the tree and spread keys evenly.
+type HashFunc func(key []byte) uint32
+
+// FNV1a is the default HashFunc: fast and well spread for ordinary keys,
+// but unseeded, so keys can be crafted to pile onto one shard.
+func FNV1a(key []byte) uint32 {
+	return fnv32a(key)
+}
+
+// NewMaphash returns a HashFunc using hash/maphash with a random seed.
+// Placement then differs between processes, which defeats crafted keys
+// (hash flooding); use it only for trees whose placement is never persisted
+// or compared across processes.
+func NewMaphash() HashFunc {
+	seed := maphash.MakeSeed()
+	return func(key []byte) uint32 {
+		h := maphash.Bytes(seed, key)
+		return uint32(h) ^ uint32(h>>32)
+	}
+}
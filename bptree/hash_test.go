@@ -0,0 +1,103 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardHashFunc(t *testing.T) {
+	// A custom HashFunc decides placement
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, HashFunc: func(key []byte) uint32 { return uint32(key[0]) }})
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("%c-%d", 'a'+i%4, i)), []byte("v"))
+	}
+	for idx, count := range tree.Stats(true).KeysPerShard {
+		if count != 25 {
+			t.Errorf("shard %d holds %d keys, want 25", idx, count)
+		}
+	}
+	if got := tree.GetShard(int('b') % 4).Len(); got != 25 {
+		t.Errorf("shard of 'b' keys holds %d keys", got)
+	}
+
+	// A seeded hash works through every placement-dependent path
+	for _, config := range []ShardConfig{
+		{NumShards: 4, HashFunc: NewMaphash()},
+		{NumShards: 2, HashFunc: NewMaphash(), ConsistentHashing: true},
+	} {
+		tree := NewShardedBTree(config)
+		for i := 0; i < 2000; i++ {
+			tree.Insert([]byte(fmt.Sprintf("key-%d", i)), []byte("v"))
+		}
+		if tree.resizable {
+			if err := tree.Resize(5); err != nil {
+				t.Fatal(err)
+			}
+			tree.WaitMigration()
+		}
+		snap := tree.Snapshot()
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("key-%d", i))
+			if _, err := tree.Find(key); err != nil {
+				t.Fatalf("%+v: %s lost", config, key)
+			}
+			if _, err := snap.Find(key); err != nil {
+				t.Fatalf("%+v: %s missing from the snapshot", config, key)
+			}
+		}
+		snap.Release()
+		if skew := tree.Stats(true).Skew; skew > 0.5 {
+			t.Errorf("%+v: skew %.2f", config, skew)
+		}
+	}
+}
+
+func TestAffinityDelimiter(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8, AffinityDelimiter: ':'})
+	for tenant := 0; tenant < 20; tenant++ {
+		for i := 0; i < 50; i++ {
+			tree.Insert([]byte(fmt.Sprintf("t%02d:%03d", tenant, i)), []byte("v"))
+		}
+	}
+	tree.Insert([]byte("plain"), []byte("v"))
+
+	// Every key of a tenant lands on one shard
+	for tenant := 0; tenant < 20; tenant++ {
+		want := tree.getShardIndex([]byte(fmt.Sprintf("t%02d", tenant)))
+		for i := 0; i < 50; i++ {
+			if idx := tree.getShardIndex([]byte(fmt.Sprintf("t%02d:%03d", tenant, i))); idx != want {
+				t.Fatalf("t%02d:%03d on shard %d, tenant on %d", tenant, i, idx, want)
+			}
+		}
+	}
+	if tree.rangeShard([]byte("t03:"), []byte("t03:\xff")) < 0 {
+		t.Error("a range within one prefix reads every shard")
+	}
+	if tree.rangeShard([]byte("t03:"), []byte("t04:")) >= 0 || tree.rangeShard([]byte("t03"), []byte("t03:9")) >= 0 {
+		t.Error("a range across prefixes reads one shard")
+	}
+
+	// Range reads within and across prefixes return the same as without affinity
+	keys, _, err := tree.GetRange([]byte("t03:010"), []byte("t03:019"))
+	if err != nil || len(keys) != 10 || string(keys[0]) != "t03:010" {
+		t.Errorf("GetRange within a prefix = %q, %v", keys, err)
+	}
+	if keys, _, _ := tree.GetRange([]byte("t03:040"), []byte("t04:009")); len(keys) != 20 {
+		t.Errorf("GetRange across prefixes returned %d keys, want 20", len(keys))
+	}
+	n := 0
+	for range tree.Range([]byte("t05:"), []byte("t05:\xff")) {
+		n++
+	}
+	if n != 50 {
+		t.Errorf("Range over one prefix yielded %d keys, want 50", n)
+	}
+
+	// Keys of one tenant can be updated atomically together
+	err = tree.AtomicUpdate([]Keytype{[]byte("t07:000"), []byte("t07:049")}, func(tx ShardTxn) error {
+		return tx.Put([]byte("t07:new"), []byte("v"))
+	})
+	if err != nil {
+		t.Errorf("AtomicUpdate within a tenant: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package bptree
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram records a distribution of non-negative values (latencies in
+// nanoseconds, batch sizes) in fixed memory.
+//
+// DESIGN:
+// - HDR-style buckets: values below histSubBuckets are exact, larger values are grouped by power of two
+// - Each power of two is split into histSubBuckets linear sub-buckets, so a bucket is within ~3% of its values
+// - Record is a handful of atomic adds: no locks, safe from any goroutine
+// - Readers see a near-consistent view while recording continues
+//
+// USAGE:
+//
+//	var h Histogram
+//	start := time.Now()
+//	doWork()
+//	h.RecordSince(start)
+//	p99 := h.PercentileDuration(99)
+type Histogram struct {
+	buckets [histBuckets]uint64
+	count   uint64
+	sum     uint64
+	max     uint64
+}
+
+const (
+	histSubBits    = 5
+	histSubBuckets = 1 << histSubBits
+	// One row of sub-buckets for the exact range plus one per power of two above it
+	histBuckets = (64 - histSubBits + 1) * histSubBuckets
+)
+
+// histIndex returns the bucket holding v.
+func histIndex(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	return (shift+1)*histSubBuckets + int(v>>shift) - histSubBuckets
+}
+
+// histUpper returns the largest value that falls in bucket idx.
+func histUpper(idx int) uint64 {
+	if idx < histSubBuckets {
+		return uint64(idx)
+	}
+	shift := idx/histSubBuckets - 1
+	sub := uint64(idx%histSubBuckets + histSubBuckets)
+	return (sub+1)<<shift - 1
+}
+
+// Record adds a value to the histogram. Thread-safe.
+func (h *Histogram) Record(v uint64) {
+	atomic.AddUint64(&h.buckets[histIndex(v)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, v)
+	h.raiseMax(v)
+}
+
+// raiseMax sets max to v if v is larger.
+func (h *Histogram) raiseMax(v uint64) {
+	for {
+		cur := atomic.LoadUint64(&h.max)
+		if v <= cur || atomic.CompareAndSwapUint64(&h.max, cur, v) {
+			return
+		}
+	}
+}
+
+// RecordSince records the time elapsed since start in nanoseconds. Thread-safe.
+func (h *Histogram) RecordSince(start time.Time) {
+	if elapsed := time.Since(start); elapsed > 0 {
+		h.Record(uint64(elapsed))
+	} else {
+		h.Record(0)
+	}
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Max returns the largest recorded value.
+func (h *Histogram) Max() uint64 {
+	return atomic.LoadUint64(&h.max)
+}
+
+// Mean returns the average recorded value, or 0 if nothing was recorded.
+func (h *Histogram) Mean() float64 {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&h.sum)) / float64(count)
+}
+
+// Percentile returns the value at percentile p (0-100): at least p% of
+// recorded values are less than or equal to it, within the bucket precision.
+// Returns 0 if nothing was recorded.
+func (h *Histogram) Percentile(p float64) uint64 {
+	var counts [histBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.buckets[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(p / 100 * float64(total))
+	if float64(rank) < p/100*float64(total) {
+		rank++ // Round up so p=50 of 3 values is the 2nd
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > total {
+		rank = total
+	}
+
+	largest := h.Max()
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			return min(histUpper(i), largest)
+		}
+	}
+	return largest
+}
+
+// PercentileDuration returns Percentile(p) as a duration, for histograms
+// recording nanoseconds.
+func (h *Histogram) PercentileDuration(p float64) time.Duration {
+	return time.Duration(h.Percentile(p))
+}
+
+// Merge adds other's recorded values into h. Thread-safe.
+func (h *Histogram) Merge(other *Histogram) {
+	for i := range other.buckets {
+		if c := atomic.LoadUint64(&other.buckets[i]); c > 0 {
+			atomic.AddUint64(&h.buckets[i], c)
+		}
+	}
+	atomic.AddUint64(&h.count, atomic.LoadUint64(&other.count))
+	atomic.AddUint64(&h.sum, atomic.LoadUint64(&other.sum))
+	h.raiseMax(atomic.LoadUint64(&other.max))
+}
+
+// Reset discards all recorded values. Values recorded concurrently with
+// Reset may be partially kept.
+func (h *Histogram) Reset() {
+	for i := range h.buckets {
+		atomic.StoreUint64(&h.buckets[i], 0)
+	}
+	atomic.StoreUint64(&h.count, 0)
+	atomic.StoreUint64(&h.sum, 0)
+	atomic.StoreUint64(&h.max, 0)
+}
+
+// LatencyOp identifies an operation whose latency ShardedBTree records.
+type LatencyOp int
+
+const (
+	LatencyInsert LatencyOp = iota
+	LatencyDelete
+	LatencyFind
+	LatencyGetRange // Time spent scanning one shard
+	numLatencyOps
+)
+
+// String returns the operation name.
+func (op LatencyOp) String() string {
+	switch op {
+	case LatencyInsert:
+		return "Insert"
+	case LatencyDelete:
+		return "Delete"
+	case LatencyFind:
+		return "Find"
+	case LatencyGetRange:
+		return "GetRange"
+	default:
+		return "Unknown"
+	}
+}
+
+// shardMetrics holds one shard's histograms.
+type shardMetrics struct {
+	latency    [numLatencyOps]Histogram
+	batchSizes Histogram // Keys per shard in each BulkInsert
+}
+
+// startTimer counts op on shard idx and returns the start time for observe,
+// or the zero time if this operation is not timed. Called pinned.
+func (s *ShardedBTree) startTimer(idx int, op LatencyOp) time.Time {
+	n := s.counters[idx].ops[op].Add(1)
+	if s.metrics == nil {
+		if rate := s.sampleRate.Load(); rate == 0 || n%uint64(rate) != 0 {
+			return time.Time{}
+		}
+	}
+	return time.Now()
+}
+
+// observe records the latency of op on shard idx since start, if timed.
+// Called pinned.
+func (s *ShardedBTree) observe(idx int, op LatencyOp, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	s.record(idx, op, time.Since(start))
+}
+
+// record adds a latency sample of op on shard idx. Called pinned.
+func (s *ShardedBTree) record(idx int, op LatencyOp, elapsed time.Duration) {
+	s.counters[idx].average(op, elapsed)
+	if s.metrics != nil {
+		s.metrics[idx].latency[op].Record(uint64(elapsed))
+	}
+}
+
+// Latency returns the latency histogram of op across all shards, in
+// nanoseconds. The result is a copy; it is empty unless
+// ShardConfig.RecordHistograms is set.
+func (s *ShardedBTree) Latency(op LatencyOp) *Histogram {
+	s.pin()
+	defer s.unpin()
+	merged := &Histogram{}
+	if s.metrics == nil || op < 0 || op >= numLatencyOps {
+		return merged
+	}
+	for _, m := range s.metrics {
+		merged.Merge(&m.latency[op])
+	}
+	return merged
+}
+
+// ShardLatency returns a copy of the latency histogram of op on one shard.
+func (s *ShardedBTree) ShardLatency(shard int, op LatencyOp) *Histogram {
+	s.pin()
+	defer s.unpin()
+	h := &Histogram{}
+	if s.metrics == nil || shard < 0 || shard >= len(s.metrics) || op < 0 || op >= numLatencyOps {
+		return h
+	}
+	h.Merge(&s.metrics[shard].latency[op])
+	return h
+}
+
+// LatencyPercentile returns the latency of op at percentile p (0-100)
+// across all shards.
+func (s *ShardedBTree) LatencyPercentile(op LatencyOp, p float64) time.Duration {
+	return s.Latency(op).PercentileDuration(p)
+}
+
+// BatchSizes returns the histogram of keys each shard received per
+// BulkInsert, across all shards. The result is a copy.
+func (s *ShardedBTree) BatchSizes() *Histogram {
+	s.pin()
+	defer s.unpin()
+	merged := &Histogram{}
+	for _, m := range s.metrics {
+		merged.Merge(&m.batchSizes)
+	}
+	return merged
+}
+
+// ResetHistograms discards all recorded latencies and batch sizes.
+func (s *ShardedBTree) ResetHistograms() {
+	s.pin()
+	defer s.unpin()
+	for _, m := range s.metrics {
+		for op := range m.latency {
+			m.latency[op].Reset()
+		}
+		m.batchSizes.Reset()
+	}
+}
@@ -0,0 +1,174 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	prevUpper := uint64(0)
+	for idx := 0; idx < histBuckets; idx++ {
+		upper := histUpper(idx)
+		if idx > 0 && upper <= prevUpper {
+			t.Fatalf("Bucket %d upper %d not above bucket %d upper %d", idx, upper, idx-1, prevUpper)
+		}
+		if got := histIndex(upper); got != idx {
+			t.Fatalf("histIndex(%d) = %d, want %d", upper, got, idx)
+		}
+		prevUpper = upper
+	}
+	if histUpper(histBuckets-1) != ^uint64(0) {
+		t.Errorf("Last bucket tops out at %d, want max uint64", histUpper(histBuckets-1))
+	}
+}
+
+func TestHistogramPercentileAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var h Histogram
+	values := make([]uint64, 100000)
+	for i := range values {
+		values[i] = uint64(rng.ExpFloat64() * 50000) // Long-tailed, like latencies
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, p := range []float64{1, 50, 90, 99, 99.9} {
+		exact := values[int(p/100*float64(len(values)))-1]
+		got := h.Percentile(p)
+		if got < exact || float64(got) > float64(exact)*1.04+1 {
+			t.Errorf("p%v = %d, exact %d", p, got, exact)
+		}
+	}
+	if h.Percentile(100) != values[len(values)-1] {
+		t.Errorf("p100 = %d, want max %d", h.Percentile(100), values[len(values)-1])
+	}
+	if h.Count() != uint64(len(values)) {
+		t.Errorf("Count = %d, want %d", h.Count(), len(values))
+	}
+}
+
+func TestHistogramSmallValuesExact(t *testing.T) {
+	var h Histogram
+	for _, v := range []uint64{1, 2, 3} {
+		h.Record(v)
+	}
+	if got := h.Percentile(50); got != 2 {
+		t.Errorf("p50 of {1,2,3} = %d, want 2", got)
+	}
+	if got := h.Percentile(0); got != 1 {
+		t.Errorf("p0 of {1,2,3} = %d, want 1", got)
+	}
+	if h.Mean() != 2 {
+		t.Errorf("Mean = %v, want 2", h.Mean())
+	}
+
+	var empty Histogram
+	if empty.Percentile(99) != 0 || empty.Mean() != 0 {
+		t.Error("Empty histogram should report zeros")
+	}
+}
+
+func TestHistogramMergeAndReset(t *testing.T) {
+	var a, b Histogram
+	for i := uint64(1); i <= 20; i++ {
+		a.Record(i)
+		b.Record(i + 1000)
+	}
+	a.Merge(&b)
+	if a.Count() != 40 || a.Max() != 1020 {
+		t.Errorf("Merged Count=%d Max=%d, want 40 and 1020", a.Count(), a.Max())
+	}
+	if p := a.Percentile(50); p != 20 {
+		t.Errorf("Merged p50 = %d, want 20", p)
+	}
+
+	a.Reset()
+	if a.Count() != 0 || a.Percentile(50) != 0 {
+		t.Error("Reset histogram still has values")
+	}
+}
+
+func TestHistogramConcurrentRecord(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.Record(uint64(g*1000 + i))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if h.Count() != 8000 || h.Max() != 7999 {
+		t.Errorf("Count=%d Max=%d, want 8000 and 7999", h.Count(), h.Max())
+	}
+}
+
+func TestShardedBTreeHistograms(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, RecordHistograms: true})
+
+	for i := 0; i < 200; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	for i := 0; i < 50; i++ {
+		tree.Find([]byte(fmt.Sprintf("key:%04d", i)))
+		tree.Delete([]byte(fmt.Sprintf("key:%04d", i)))
+	}
+	tree.GetRange([]byte("key:0000"), []byte("key:9999"))
+
+	keys := make([]Keytype, 100)
+	values := make([]Valuetype, 100)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bulk:%04d", i))
+		values[i] = []byte("v")
+	}
+	tree.BulkInsert(keys, values)
+
+	want := map[LatencyOp]uint64{LatencyInsert: 200, LatencyFind: 50, LatencyDelete: 50, LatencyGetRange: 4}
+	for op, count := range want {
+		h := tree.Latency(op)
+		if h.Count() != count {
+			t.Errorf("%v latency count = %d, want %d", op, h.Count(), count)
+		}
+		if tree.LatencyPercentile(op, 50) > tree.LatencyPercentile(op, 100) {
+			t.Errorf("%v p50 above p100", op)
+		}
+	}
+
+	var perShard uint64
+	for i := 0; i < tree.NumShards(); i++ {
+		perShard += tree.ShardLatency(i, LatencyInsert).Count()
+	}
+	if perShard != 200 {
+		t.Errorf("Per-shard insert counts sum to %d, want 200", perShard)
+	}
+
+	batches := tree.BatchSizes()
+	if batches.Mean()*float64(batches.Count()) != 100 {
+		t.Errorf("Batch sizes sum to %v, want 100", batches.Mean()*float64(batches.Count()))
+	}
+
+	tree.ResetHistograms()
+	if tree.Latency(LatencyInsert).Count() != 0 {
+		t.Error("ResetHistograms left insert latencies")
+	}
+}
+
+func TestShardedBTreeHistogramsDisabled(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2})
+	tree.Insert([]byte("key"), []byte("value"))
+	tree.Find([]byte("key"))
+
+	if tree.Latency(LatencyInsert).Count() != 0 || tree.BatchSizes().Count() != 0 {
+		t.Error("Histograms recorded without RecordHistograms")
+	}
+	if tree.LatencyPercentile(LatencyFind, 99) != 0 {
+		t.Error("Expected zero percentile when histograms are disabled")
+	}
+}
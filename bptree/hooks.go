@@ -0,0 +1,98 @@
+package bptree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MutationHook observes a change to a key. oldValue is nil for an insert of
+// a new key and newValue is nil for a delete. The slices are the stored
+// ones: a hook must not modify them.
+type MutationHook func(key Keytype, oldValue, newValue Valuetype)
+
+// mutationHooks is an immutable set of registered hooks, replaced whole on
+// each registration so firing needs no lock.
+type mutationHooks struct {
+	insert, update, delete []MutationHook
+}
+
+// hookRegistry holds a ShardedBTree's hooks.
+type hookRegistry struct {
+	mu    sync.Mutex // Serializes registrations
+	hooks atomic.Pointer[mutationHooks]
+}
+
+// OnInsert registers a hook called after a key that was absent is inserted.
+//
+// DESIGN:
+// - Hooks run synchronously in the goroutine that made the change, after its latches are released
+// - Writes to different shards run hooks concurrently: hooks must be safe for concurrent use
+// - With no hook registered, mutations pay a single atomic load
+//
+// LIMITATIONS:
+// - Hooks cannot be unregistered
+// - Clear fires no hooks, and neither do writes made directly on a shard (GetShard)
+// - Hooks run after the change is visible: another goroutine may read the new value before a hook runs
+//
+// USAGE:
+//
+//	tree.OnUpdate(func(key Keytype, _, _ Valuetype) { cache.Invalidate(key) })
+//	tree.OnDelete(func(key Keytype, _, _ Valuetype) { cache.Invalidate(key) })
+func (s *ShardedBTree) OnInsert(hook MutationHook) {
+	s.registerHook(func(h *mutationHooks) { h.insert = append(h.insert, hook) })
+}
+
+// OnUpdate registers a hook called after an existing key's value is
+// replaced. See OnInsert.
+func (s *ShardedBTree) OnUpdate(hook MutationHook) {
+	s.registerHook(func(h *mutationHooks) { h.update = append(h.update, hook) })
+}
+
+// OnDelete registers a hook called after a key is deleted. See OnInsert.
+func (s *ShardedBTree) OnDelete(hook MutationHook) {
+	s.registerHook(func(h *mutationHooks) { h.delete = append(h.delete, hook) })
+}
+
+// registerHook publishes a copy of the hooks with add applied.
+func (s *ShardedBTree) registerHook(add func(h *mutationHooks)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+
+	next := &mutationHooks{}
+	if current := s.hooks.hooks.Load(); current != nil {
+		next.insert = append([]MutationHook(nil), current.insert...)
+		next.update = append([]MutationHook(nil), current.update...)
+		next.delete = append([]MutationHook(nil), current.delete...)
+	}
+	add(next)
+	s.hooks.hooks.Store(next)
+}
+
+// hooked returns the registered hooks, or nil if there are none.
+func (s *ShardedBTree) hooked() *mutationHooks {
+	return s.hooks.hooks.Load()
+}
+
+// fireWrite runs the insert or update hooks for a write of key.
+func (h *mutationHooks) fireWrite(key Keytype, old Valuetype, existed bool, value Valuetype) {
+	if h == nil {
+		return
+	}
+	hooks := h.insert
+	if existed {
+		hooks = h.update
+	}
+	for _, hook := range hooks {
+		hook(key, old, value)
+	}
+}
+
+// fireDelete runs the delete hooks for a delete of key.
+func (h *mutationHooks) fireDelete(key Keytype, old Valuetype) {
+	if h == nil {
+		return
+	}
+	for _, hook := range h.delete {
+		hook(key, old, nil)
+	}
+}
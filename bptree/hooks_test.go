@@ -0,0 +1,78 @@
+package bptree
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMutationHooks(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+
+	var mu sync.Mutex
+	var events []string
+	record := func(kind string) MutationHook {
+		return func(key Keytype, oldValue, newValue Valuetype) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("%s %s %q→%q", kind, key, oldValue, newValue))
+		}
+	}
+	tree.OnInsert(record("insert"))
+	tree.OnUpdate(record("update"))
+	tree.OnDelete(record("delete"))
+
+	tree.Insert([]byte("a"), []byte("1"))
+	tree.Insert([]byte("a"), []byte("2"))
+	tree.CompareAndSwap([]byte("a"), []byte("2"), []byte("3"))
+	tree.CompareAndSwap([]byte("a"), []byte("wrong"), []byte("4")) // No event
+	tree.Modify([]byte("b"), func(Valuetype, bool) (Valuetype, bool) { return []byte("x"), true })
+	tree.Modify([]byte("b"), func(Valuetype, bool) (Valuetype, bool) { return nil, false }) // No event
+	tree.Delete([]byte("a"))
+	tree.Delete([]byte("missing")) // No event
+	tree.CompareAndDelete([]byte("b"), []byte("x"))
+
+	want := []string{
+		`insert a ""→"1"`,
+		`update a "1"→"2"`,
+		`update a "2"→"3"`,
+		`insert b ""→"x"`,
+		`delete a "3"→""`,
+		`delete b "x"→""`,
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Events:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMutationHooksBatch(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	var mu sync.Mutex
+	counts := map[string]int{}
+	count := func(kind string) MutationHook {
+		return func(Keytype, Valuetype, Valuetype) {
+			mu.Lock()
+			counts[kind]++
+			mu.Unlock()
+		}
+	}
+	tree.OnInsert(count("insert"))
+	tree.OnUpdate(count("update"))
+	tree.OnDelete(count("delete"))
+
+	keys := make([]Keytype, 100)
+	values := make([]Valuetype, 100)
+	for i := range keys {
+		keys[i], values[i] = Keytype(fmt.Sprintf("k%03d", i)), Valuetype("v")
+	}
+	tree.BulkInsert(keys, values)           // Bulk loads the empty shards
+	tree.BulkInsert(keys[:10], values[:10]) // Overwrites one by one
+	if n, err := tree.DeleteRange([]byte("k050"), []byte("k099")); n != 50 || err != nil {
+		t.Fatalf("DeleteRange = %d, %v", n, err)
+	}
+
+	if counts["insert"] != 100 || counts["update"] != 10 || counts["delete"] != 50 {
+		t.Errorf("Hook counts = %v", counts)
+	}
+}
@@ -0,0 +1,95 @@
+package bptree
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultHotKeys is the top-K reported per shard when
+// ShardConfig.HotKeySampleRate is set and HotKeys is not.
+const defaultHotKeys = 10
+
+// hotKeySlots is how many candidates a tracker keeps per reported key. The
+// extra slots absorb the churn of the long tail.
+const hotKeySlots = 4
+
+// HotKey is a frequently accessed key and its estimated number of accesses.
+type HotKey struct {
+	Key      string
+	Accesses uint64
+}
+
+// hotKeyTracker estimates one shard's most accessed keys from a sample of
+// accesses, with the Space-Saving algorithm.
+//
+// DESIGN:
+// - One access in rate is sampled; the rest cost one atomic add
+// - At most slots keys are counted; a new key evicts the least counted one and inherits its count
+// - Counts therefore overestimate, by at most the evicted count, but a key accessed more often than 1/slots of the time is never missed
+type hotKeyTracker struct {
+	rate     uint64
+	accesses atomic.Uint64
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	slots  int
+}
+
+func newHotKeyTracker(rate, k int) *hotKeyTracker {
+	return &hotKeyTracker{rate: uint64(rate), counts: make(map[string]uint64), slots: k * hotKeySlots}
+}
+
+// touch records an access to key, if sampled. A nil tracker records nothing.
+func (h *hotKeyTracker) touch(key []byte) {
+	if h == nil || h.accesses.Add(1)%h.rate != 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if count, ok := h.counts[string(key)]; ok || len(h.counts) < h.slots {
+		h.counts[string(key)] = count + 1
+		return
+	}
+
+	// Evict the least counted key
+	var minKey string
+	minCount := ^uint64(0)
+	for k, count := range h.counts {
+		if count < minCount {
+			minKey, minCount = k, count
+		}
+	}
+	delete(h.counts, minKey)
+	h.counts[string(key)] = minCount + 1
+}
+
+// top returns up to k keys by estimated accesses, most accessed first.
+func (h *hotKeyTracker) top(k int) []HotKey {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	hot := make([]HotKey, 0, len(h.counts))
+	for key, count := range h.counts {
+		hot = append(hot, HotKey{Key: key, Accesses: count * h.rate})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Accesses != hot[j].Accesses {
+			return hot[i].Accesses > hot[j].Accesses
+		}
+		return hot[i].Key < hot[j].Key
+	})
+	return hot[:min(k, len(hot))]
+}
+
+// hotKeys returns the tracker of shard idx, nil unless
+// ShardConfig.HotKeySampleRate. Called pinned.
+func (s *ShardedBTree) hotKeys(idx int) *hotKeyTracker {
+	if s.hot == nil {
+		return nil
+	}
+	return s.hot[idx]
+}
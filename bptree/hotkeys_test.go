@@ -0,0 +1,58 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHotKeyTracker(t *testing.T) {
+	h := newHotKeyTracker(1, 2) // 8 slots
+	for i := 0; i < 1000; i++ {
+		h.touch([]byte("hot"))
+		if i%2 == 0 {
+			h.touch([]byte("warm"))
+		}
+		h.touch([]byte(fmt.Sprintf("cold-%d", i))) // Long tail churning the slots
+	}
+
+	top := h.top(2)
+	if len(top) != 2 || top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Fatalf("top(2) = %v, want hot then warm", top)
+	}
+	// Space-Saving overestimates by at most the count it inherited
+	if top[0].Accesses < 1000 || top[1].Accesses < 500 {
+		t.Errorf("top(2) = %v underestimates", top)
+	}
+	if (*hotKeyTracker)(nil).top(3) != nil {
+		t.Error("a nil tracker reported hot keys")
+	}
+}
+
+func TestShardedBTreeHotKeys(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, HotKeySampleRate: 4, HotKeys: 3})
+	for i := 0; i < 2000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%d", i)), []byte("v"))
+	}
+	for i := 0; i < 4000; i++ {
+		tree.Find([]byte("key-7"))
+		tree.Find([]byte(fmt.Sprintf("key-%d", i%2000)))
+	}
+
+	stats := tree.Stats(true)
+	hotShard := tree.getShardIndex([]byte("key-7"))
+	for idx, a := range stats.Shards {
+		if len(a.HotKeys) > 3 {
+			t.Errorf("shard %d reports %d hot keys, want at most 3", idx, len(a.HotKeys))
+		}
+		if idx == hotShard && (len(a.HotKeys) == 0 || a.HotKeys[0].Key != "key-7") {
+			t.Errorf("shard %d hot keys = %v, want key-7 first", idx, a.HotKeys)
+		}
+	}
+	if got := stats.Shards[hotShard].HotKeys[0].Accesses; got < 3000 || got > 6000 {
+		t.Errorf("key-7 estimated at %d accesses, made about 4000", got)
+	}
+
+	if a := NewShardedBTree(ShardConfig{NumShards: 1}).Stats(true).Shards[0]; a.HotKeys != nil {
+		t.Errorf("hot keys tracked without HotKeySampleRate: %v", a.HotKeys)
+	}
+}
@@ -0,0 +1,173 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// IndexProblemKind is the kind of inconsistency VerifyIndexes found.
+type IndexProblemKind uint8
+
+const (
+	MissingPosting  IndexProblemKind = iota // A record is not in the index under its key
+	OrphanedPosting                         // The index holds a primary key under a key its record (if any) does not have
+	UniqueViolation                         // A unique index key belongs to more than one record
+	StaleCovered                            // A covering index stores an out-of-date copy of the record
+)
+
+// String returns the name of the kind.
+func (k IndexProblemKind) String() string {
+	switch k {
+	case MissingPosting:
+		return "missing posting"
+	case OrphanedPosting:
+		return "orphaned posting"
+	case UniqueViolation:
+		return "unique violation"
+	default:
+		return "stale covered record"
+	}
+}
+
+// IndexProblem is one inconsistency between the primary tree and an index.
+type IndexProblem struct {
+	Index      string
+	Kind       IndexProblemKind
+	IndexKey   []byte
+	PrimaryKey Keytype
+}
+
+func (p IndexProblem) String() string {
+	return fmt.Sprintf("index %s: %s: %q -> %q", p.Index, p.Kind, p.IndexKey, p.PrimaryKey)
+}
+
+// VerifyIndexes checks every index against the primary tree, for operators
+// after a crash or a suspected bug: index corruption is otherwise silent.
+// It returns the problems found, ordered by index, then index key, then
+// primary key; none means the indexes are consistent.
+//
+// CHECKS:
+// - Each record is posted under every key its index extracts (else MissingPosting)
+// - Each posting's record exists and extracts that key (else OrphanedPosting)
+// - No unique index key belongs to two records, in the data or in an entry (else UniqueViolation)
+// - Covering indexes store the current record or projection (else StaleCovered)
+//
+// LIMITATIONS:
+// - Blocks writes for the whole check, after applying deferred index updates
+// - Holds every expected posting of one index in memory at a time
+// - Indexes still being built online are skipped
+func (db *IndexedBTree) VerifyIndexes() ([]IndexProblem, error) {
+	db.writers.Lock()
+	defer db.writers.Unlock()
+	db.SyncIndexes()
+
+	db.mu.RLock()
+	indexes := make([]*SecondaryIndex, 0, len(db.indexes))
+	for _, idx := range db.indexes {
+		if idx.building() == nil {
+			indexes = append(indexes, idx)
+		}
+	}
+	db.mu.RUnlock()
+	slices.SortFunc(indexes, func(a, b *SecondaryIndex) int { return strings.Compare(a.name, b.name) })
+
+	var problems []IndexProblem
+	for _, idx := range indexes {
+		found, err := db.verifyIndex(idx)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", idx.name, err)
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// verifyIndex compares one index with the primary tree. Called with writes
+// blocked.
+func (db *IndexedBTree) verifyIndex(idx *SecondaryIndex) ([]IndexProblem, error) {
+	if err := db.tree.Err(); err != nil {
+		return nil, err
+	}
+	if err := idx.tree.Err(); err != nil {
+		return nil, err
+	}
+
+	// What the index should hold: index key -> primary key -> stored record
+	expected := make(map[string]map[string][]byte)
+	for pk, value := range db.tree.All() {
+		for _, indexKey := range idx.indexKeys(value) {
+			postings := expected[string(indexKey)]
+			if postings == nil {
+				postings = make(map[string][]byte)
+				expected[string(indexKey)] = postings
+			}
+			postings[string(pk)] = idx.stored(value)
+		}
+	}
+
+	var problems []IndexProblem
+	report := func(kind IndexProblemKind, indexKey, pk []byte) {
+		problems = append(problems, IndexProblem{Index: idx.name, Kind: kind, IndexKey: indexKey, PrimaryKey: pk})
+	}
+
+	// What it does hold, an index key's postings at a time
+	check := func(indexKey []byte, pks, records [][]byte) {
+		if idx.unique && len(pks) > 1 {
+			report(UniqueViolation, indexKey, pks[1])
+		}
+		postings := expected[string(indexKey)]
+		posted := false
+		for i, pk := range pks {
+			record, ok := postings[string(pk)]
+			switch {
+			case !ok:
+				report(OrphanedPosting, indexKey, pk)
+				continue
+			case idx.covering && !bytes.Equal(records[i], record):
+				report(StaleCovered, indexKey, pk)
+			}
+			delete(postings, string(pk)) // A repeat is then orphaned
+			posted = true
+		}
+		if idx.unique && posted {
+			// The entry rightly belongs to a record; any other with the key clashes
+			for pk := range postings {
+				report(UniqueViolation, indexKey, Keytype(pk))
+				delete(postings, pk)
+			}
+		}
+	}
+	var current []byte
+	var pks, records [][]byte
+	idx.postings(nil, nil, false, func(indexKey, pk, record []byte) bool {
+		if pks != nil && !bytes.Equal(indexKey, current) {
+			check(current, pks, records)
+			pks, records = nil, nil
+		}
+		current, pks, records = indexKey, append(pks, pk), append(records, record)
+		return true
+	})
+	if pks != nil {
+		check(current, pks, records)
+	}
+
+	for indexKey, postings := range expected {
+		kind := MissingPosting
+		if idx.unique && len(postings) > 1 {
+			kind = UniqueViolation
+		}
+		for pk := range postings {
+			report(kind, []byte(indexKey), Keytype(pk))
+		}
+	}
+
+	slices.SortFunc(problems, func(a, b IndexProblem) int {
+		if c := bytes.Compare(a.IndexKey, b.IndexKey); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.PrimaryKey, b.PrimaryKey)
+	})
+	return problems, nil
+}
@@ -0,0 +1,58 @@
+package bptree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVerifyIndexes(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndexWithConfig(IndexConfig{Name: "covered", Extractor: JSONFieldExtractor("email"), Unique: true, Covering: true})
+	db.CreateInvertedIndex(InvertedIndexConfig{Name: "bio", Field: JSONFieldExtractor("bio")})
+	for i := 0; i < 50; i++ {
+		db.Insert(Keytype(fmt.Sprintf("user:%02d", i)), Valuetype(fmt.Sprintf(`{"city":"c%d","email":"u%d@x.com","bio":"likes %d things"}`, i%5, i, i%3)))
+	}
+
+	problems, err := db.VerifyIndexes()
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Consistent indexes: %v, %v", problems, err)
+	}
+
+	// Corrupt each index behind the tree's back
+	db.mu.RLock()
+	city, email, covered, bio := db.indexes["city"], db.indexes["email"], db.indexes["covered"], db.indexes["bio"]
+	db.mu.RUnlock()
+	city.Remove(Keytype("user:07"), Valuetype(`{"city":"c2"}`))           // Missing
+	city.Index(Keytype("ghost"), Valuetype(`{"city":"c0"}`))              // Orphaned: no such record
+	city.Index(Keytype("user:01"), Valuetype(`{"city":"c4"}`))            // Orphaned: wrong key
+	email.tree.Insert([]byte("u3@x.com"), []byte("user:04"))              // Orphaned and missing
+	db.tree.Insert(Keytype("user:99"), Valuetype(`{"email":"u9@x.com"}`)) // Unique clash, not indexed
+	covered.tree.Insert([]byte("u5@x.com"), covered.encodeEntry([][]byte{[]byte("user:05")}, [][]byte{[]byte(`{}`)}))
+	bio.Remove(Keytype("user:02"), Valuetype(`{"bio":"things"}`)) // Missing one term
+
+	problems, err = db.VerifyIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`index bio: missing posting: "things" -> "user:02"`,
+		`index city: orphaned posting: "c0" -> "ghost"`,
+		`index city: missing posting: "c2" -> "user:07"`,
+		`index city: orphaned posting: "c4" -> "user:01"`,
+		`index covered: stale covered record: "u5@x.com" -> "user:05"`,
+		`index covered: unique violation: "u9@x.com" -> "user:99"`,
+		`index email: missing posting: "u3@x.com" -> "user:03"`,
+		`index email: orphaned posting: "u3@x.com" -> "user:04"`,
+		`index email: unique violation: "u9@x.com" -> "user:99"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
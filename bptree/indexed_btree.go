@@ -2,7 +2,10 @@ package bptree
 
 import (
 	"errors"
+	"fmt"
+	"iter"
 	"sync"
+	"sync/atomic"
 )
 
 // IndexedBTree wraps a ShardedBTree with automatic secondary index maintenance.
@@ -26,30 +29,108 @@ import (
 //	// Query by index
 //	pk, _ := db.FindByIndex("email", []byte("a@b.com"))
 //	record, _ := db.Find(pk)
+//
+// GRACEFUL DEGRADATION (opt-in via AsyncIndexThreshold):
+// - When more writes are in flight than the threshold, non-unique index updates are queued
+// - A catch-up worker applies queued updates in order; IndexLag reports how far behind it is
+// - Unique indexes are always updated inline, since they enforce constraints
+// - While lagging, index queries may miss recent writes; SyncIndexes waits for catch-up
 type IndexedBTree struct {
 	tree    *ShardedBTree
 	indexes map[string]*SecondaryIndex
 	mu      sync.RWMutex
+	writers sync.RWMutex                    // Read-held by each write; see IndexBuild.run
+	schema  atomic.Pointer[[]compiledField] // Checked by every write, nil if none
+
+	// Graceful degradation state, unused unless AsyncIndexThreshold > 0
+	asyncThreshold int64
+	inflight       int64 // Primary writes in progress
+	lag            int64 // Queued index updates not yet applied
+	deferred       uint64
+	pending        chan indexUpdate
+	workerDone     chan struct{}
+	asyncMu        sync.RWMutex // Guards closing pending against senders
+	closed         bool
+}
+
+// ErrUniqueViolation is matched by errors.Is for every UniqueViolationError.
+var ErrUniqueViolation = errors.New("unique constraint violation")
+
+// UniqueViolationError reports a write that would give two records the same
+// key in a unique index. errors.As extracts it from the error of Insert,
+// Update, BulkInsert and DocumentStore writes, for conflict messages or
+// upsert-on-conflict logic:
+//
+//	var conflict *UniqueViolationError
+//	if errors.As(err, &conflict) {
+//		db.Update(conflict.ExistingPrimaryKey, value)
+//	}
+type UniqueViolationError struct {
+	Index              string  // Name of the unique index
+	IndexKey           []byte  // Key both records have, as the index stores it
+	ExistingPrimaryKey Keytype // Record that already has the key
+}
+
+func (e *UniqueViolationError) Error() string {
+	return fmt.Sprintf("%v on index: %s (key %q belongs to %q)", ErrUniqueViolation, e.Index, e.IndexKey, e.ExistingPrimaryKey)
+}
+
+func (e *UniqueViolationError) Unwrap() error {
+	return ErrUniqueViolation
 }
 
 // IndexedConfig configures the indexed B-Tree.
 type IndexedConfig struct {
 	// NumShards for the primary tree (default: runtime.NumCPU())
 	NumShards int
+
+	// AsyncIndexThreshold is the number of in-flight writes above which
+	// non-unique index updates are deferred to a background worker
+	// (default: 0, always update indexes inline)
+	AsyncIndexThreshold int
+
+	// AsyncIndexQueue bounds the deferred updates; writers block when it is full (default: 10000)
+	AsyncIndexQueue int
 }
 
 // IndexedStats provides statistics about the indexed tree.
 type IndexedStats struct {
 	PrimaryStats ShardStats
 	IndexStats   map[string]IndexStats
+
+	// IndexLag is the number of deferred index updates not yet applied
+	IndexLag int64
+	// DeferredIndexUpdates counts index updates ever deferred under load
+	DeferredIndexUpdates uint64
 }
 
+const defaultAsyncIndexQueue = 10000
+
 // NewIndexedBTree creates a new indexed B-Tree.
+// If AsyncIndexThreshold is set, call Close to stop the catch-up worker.
 func NewIndexedBTree(config IndexedConfig) *IndexedBTree {
-	return &IndexedBTree{
-		tree:    NewShardedBTree(ShardConfig{NumShards: config.NumShards}),
+	return newIndexedBTreeOn(NewShardedBTree(ShardConfig{NumShards: config.NumShards}), config)
+}
+
+// newIndexedBTreeOn creates an indexed B-Tree whose primary tree is tree.
+func newIndexedBTreeOn(tree *ShardedBTree, config IndexedConfig) *IndexedBTree {
+	db := &IndexedBTree{
+		tree:    tree,
 		indexes: make(map[string]*SecondaryIndex),
 	}
+
+	if config.AsyncIndexThreshold > 0 {
+		queueSize := config.AsyncIndexQueue
+		if queueSize <= 0 {
+			queueSize = defaultAsyncIndexQueue
+		}
+		db.asyncThreshold = int64(config.AsyncIndexThreshold)
+		db.pending = make(chan indexUpdate, queueSize)
+		db.workerDone = make(chan struct{})
+		go db.catchUp()
+	}
+
+	return db
 }
 
 // NewIndexedBTreeDefault creates an indexed B-Tree with default settings.
@@ -60,35 +141,57 @@ func NewIndexedBTreeDefault() *IndexedBTree {
 // CreateIndex creates a new secondary index on the tree.
 // If rebuild is true, indexes all existing records.
 func (db *IndexedBTree) CreateIndex(name string, extractor KeyExtractor, unique bool) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.addIndex(newIndex(name, extractor, unique), false)
+}
 
-	if _, exists := db.indexes[name]; exists {
-		return errors.New("index already exists")
+// CreateIndexWithRebuild creates an index and populates it with existing
+// data, blocking writes meanwhile; CreateIndexOnline builds without blocking
+// them.
+func (db *IndexedBTree) CreateIndexWithRebuild(name string, extractor KeyExtractor, unique bool) error {
+	return db.addIndex(newIndex(name, extractor, unique), true)
+}
+
+// CreateIndexWithConfig creates an index with the options of config (such
+// as a Normalizer) and populates it with existing data.
+func (db *IndexedBTree) CreateIndexWithConfig(config IndexConfig) error {
+	if config.Extractor == nil {
+		return errors.New("index needs an extractor")
 	}
+	return db.addIndex(NewSecondaryIndex(config), true)
+}
 
-	idx := NewSecondaryIndex(IndexConfig{
+// newIndex returns an empty index for an IndexedBTree.
+func newIndex(name string, extractor KeyExtractor, unique bool) *SecondaryIndex {
+	return NewSecondaryIndex(IndexConfig{
 		Name:      name,
 		Extractor: extractor,
 		Unique:    unique,
 		NumShards: 4,
 	})
+}
 
+// addIndex registers idx, first populating it with existing data if rebuild
+// is set. A rebuild holds db.writers, so no write lands during the scan and
+// every later write finds the index registered.
+func (db *IndexedBTree) addIndex(idx *SecondaryIndex, rebuild bool) error {
+	if rebuild {
+		db.writers.Lock()
+		defer db.writers.Unlock()
+	}
+	name := idx.name
+	db.mu.Lock()
+	if _, exists := db.indexes[name]; exists {
+		db.mu.Unlock()
+		return errors.New("index already exists")
+	}
 	db.indexes[name] = idx
-	return nil
-}
+	db.mu.Unlock()
 
-// CreateIndexWithRebuild creates an index and populates it with existing data.
-func (db *IndexedBTree) CreateIndexWithRebuild(name string, extractor KeyExtractor, unique bool) error {
-	if err := db.CreateIndex(name, extractor, unique); err != nil {
-		return err
+	if !rebuild {
+		return nil
 	}
 
 	// Rebuild index from existing data
-	db.mu.RLock()
-	idx := db.indexes[name]
-	db.mu.RUnlock()
-
 	var indexErr error
 	db.tree.ForEach(func(key Keytype, value Valuetype) bool {
 		if err := idx.Index(key, value); err != nil {
@@ -109,16 +212,23 @@ func (db *IndexedBTree) CreateIndexWithRebuild(name string, extractor KeyExtract
 	return nil
 }
 
-// DropIndex removes a secondary index.
+// DropIndex removes a secondary index. It waits for writes in flight, which
+// finish against the index; later writes never see it, and index updates
+// still queued for it are discarded. Queries that already hold the index
+// finish against it.
 func (db *IndexedBTree) DropIndex(name string) error {
+	db.writers.Lock()
+	defer db.writers.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if _, exists := db.indexes[name]; !exists {
+	idx, exists := db.indexes[name]
+	if !exists {
 		return errors.New("index not found")
 	}
 
 	delete(db.indexes, name)
+	idx.dropped.Store(true)
 	return nil
 }
 
@@ -142,8 +252,18 @@ func (db *IndexedBTree) ListIndexes() []string {
 	return names
 }
 
-// Insert adds a record and updates all secondary indexes.
+// Insert adds a record and updates all secondary indexes. The record's keys
+// in unique indexes are claimed before it is written, so of two concurrent
+// inserts of one key exactly one succeeds; meanwhile, a lookup may find
+// the key before the record is readable.
 func (db *IndexedBTree) Insert(key Keytype, value Valuetype) error {
+	db.writers.RLock()
+	defer db.writers.RUnlock()
+
+	if err := db.checkSchema(value); err != nil {
+		return err
+	}
+
 	db.mu.RLock()
 	indexes := make([]*SecondaryIndex, 0, len(db.indexes))
 	for _, idx := range db.indexes {
@@ -152,25 +272,30 @@ func (db *IndexedBTree) Insert(key Keytype, value Valuetype) error {
 	db.mu.RUnlock()
 
 	// Check unique constraints first
-	for _, idx := range indexes {
-		if idx.unique {
-			indexKey := idx.extractor(value)
-			if indexKey != nil {
-				if _, err := idx.FindOne(indexKey); err == nil {
-					return errors.New("unique constraint violation on index: " + idx.name)
-				}
-			}
-		}
+	claimed, err := claimUnique(indexes, key, value)
+	if err != nil {
+		return err
 	}
 
+	atomic.AddInt64(&db.inflight, 1)
+	defer atomic.AddInt64(&db.inflight, -1)
+
 	// Insert into primary tree
-	db.tree.Insert(key, value)
+	if err := db.tree.TryInsert(key, value); err != nil {
+		releaseUnique(claimed, key, value)
+		return err
+	}
 
 	// Update all indexes
-	for _, idx := range indexes {
-		if err := idx.Index(key, value); err != nil {
-			// Rollback: remove from primary tree
+	for i, idx := range indexes {
+		if err := db.updateIndex(indexUpdate{op: indexInsert, idx: idx, primaryKey: key, newValue: value}); err != nil {
+			// Rollback: remove from the primary tree, the indexes updated so
+			// far (the failed one may be half updated) and the claims
 			db.tree.Delete(key)
+			for _, done := range indexes[:i+1] {
+				db.updateIndex(indexUpdate{op: indexRemove, idx: done, primaryKey: key, oldValue: value})
+			}
+			releaseUnique(claimed, key, value)
 			return err
 		}
 	}
@@ -185,6 +310,9 @@ func (db *IndexedBTree) Put(key Keytype, value Valuetype) error {
 
 // Update updates a record and maintains all secondary indexes.
 func (db *IndexedBTree) Update(key Keytype, newValue Valuetype) error {
+	db.writers.RLock()
+	defer db.writers.RUnlock()
+
 	// Get old value for index update
 	oldValue, err := db.tree.Find(key)
 	if err != nil {
@@ -198,37 +326,109 @@ func (db *IndexedBTree) Update(key Keytype, newValue Valuetype) error {
 	}
 	db.mu.RUnlock()
 
+	return db.replace(key, oldValue, newValue, indexes)
+}
+
+// replace overwrites the record at key, maintaining only the given indexes.
+// The caller knows the other indexes are unaffected by the change, and
+// read-holds db.writers.
+func (db *IndexedBTree) replace(key Keytype, oldValue, newValue Valuetype, indexes []*SecondaryIndex) error {
+	if err := db.checkSchema(newValue); err != nil {
+		return err
+	}
+
 	// Check unique constraints for new value
-	for _, idx := range indexes {
-		if idx.unique {
-			oldIndexKey := idx.extractor(oldValue)
-			newIndexKey := idx.extractor(newValue)
-
-			// Only check if index key changed
-			if newIndexKey != nil && !bytesEqual(oldIndexKey, newIndexKey) {
-				if _, err := idx.FindOne(newIndexKey); err == nil {
-					return errors.New("unique constraint violation on index: " + idx.name)
-				}
+	claimed, err := claimUnique(indexes, key, newValue)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&db.inflight, 1)
+	defer atomic.AddInt64(&db.inflight, -1)
+
+	// Update primary tree
+	if err := db.tree.TryInsert(key, newValue); err != nil {
+		releaseUnique(claimed, key, newValue)
+		return err
+	}
+
+	// Update the given indexes
+	for i, idx := range indexes {
+		update := indexUpdate{op: indexUpdateValue, idx: idx, primaryKey: key, oldValue: oldValue, newValue: newValue}
+		if err := db.updateIndex(update); err != nil {
+			// Rollback: restore the old record in the primary tree and the
+			// indexes updated so far (the failed one may be half updated),
+			// then drop the claims
+			db.tree.TryInsert(key, oldValue) // Stored before, so within the limits
+			for _, done := range indexes[:i+1] {
+				db.updateIndex(indexUpdate{op: indexUpdateValue, idx: done, primaryKey: key, oldValue: newValue, newValue: oldValue})
 			}
+			releaseUnique(claimed, key, newValue)
+			return err
 		}
 	}
 
-	// Update primary tree
-	db.tree.Insert(key, newValue)
+	return nil
+}
 
-	// Update all indexes
+// claimUnique adds value's keys to the ready unique indexes ahead of a
+// write of the record at key, which then finds them already indexed. Each
+// index checks and inserts a key in one step under its lock, so of two
+// writers racing for a key exactly one claims it. Returns the indexes whose
+// key was added; on a violation those are released and the error returned.
+func claimUnique(indexes []*SecondaryIndex, key Keytype, value Valuetype) ([]*SecondaryIndex, error) {
+	var claimed []*SecondaryIndex
 	for _, idx := range indexes {
-		if err := idx.Update(key, oldValue, newValue); err != nil {
-			// This shouldn't fail after constraint check, but handle it
-			return err
+		if !idx.unique || idx.building() != nil {
+			continue
+		}
+		added, err := idx.claim(key, value)
+		if err != nil {
+			releaseUnique(claimed, key, value)
+			return nil, err
+		}
+		if added {
+			claimed = append(claimed, idx)
 		}
 	}
+	return claimed, nil
+}
 
+// releaseUnique removes keys claimUnique added.
+func releaseUnique(claimed []*SecondaryIndex, key Keytype, value Valuetype) {
+	for _, idx := range claimed {
+		idx.Remove(key, value)
+	}
+}
+
+// uniqueViolation returns the error for the first unique index in which
+// replacing oldValue (nil for a new record) with newValue would take a key
+// another record has, or nil.
+func uniqueViolation(indexes []*SecondaryIndex, oldValue, newValue Valuetype) error {
+	for _, idx := range indexes {
+		if !idx.unique || idx.building() != nil {
+			continue
+		}
+		newIndexKey := idx.extractor(newValue)
+		if newIndexKey == nil {
+			continue
+		}
+		// Only check if index key changed
+		if oldValue != nil && bytesEqual(idx.extractor(oldValue), newIndexKey) {
+			continue
+		}
+		if existing, err := idx.FindOne(newIndexKey); err == nil {
+			return &UniqueViolationError{Index: idx.name, IndexKey: newIndexKey, ExistingPrimaryKey: existing}
+		}
+	}
 	return nil
 }
 
 // Delete removes a record and updates all secondary indexes.
 func (db *IndexedBTree) Delete(key Keytype) (bool, error) {
+	db.writers.RLock()
+	defer db.writers.RUnlock()
+
 	// Get value for index removal
 	value, err := db.tree.Find(key)
 	if err != nil {
@@ -242,6 +442,9 @@ func (db *IndexedBTree) Delete(key Keytype) (bool, error) {
 	}
 	db.mu.RUnlock()
 
+	atomic.AddInt64(&db.inflight, 1)
+	defer atomic.AddInt64(&db.inflight, -1)
+
 	// Delete from primary tree
 	deleted := db.tree.Delete(key)
 	if !deleted {
@@ -250,7 +453,7 @@ func (db *IndexedBTree) Delete(key Keytype) (bool, error) {
 
 	// Remove from all indexes
 	for _, idx := range indexes {
-		idx.Remove(key, value)
+		db.updateIndex(indexUpdate{op: indexRemove, idx: idx, primaryKey: key, oldValue: value})
 	}
 
 	return true, nil
@@ -269,50 +472,283 @@ func (db *IndexedBTree) Get(key Keytype) (Valuetype, error) {
 // FindByIndex finds records by secondary index (unique index).
 // Returns the primary key for the given index key.
 func (db *IndexedBTree) FindByIndex(indexName string, indexKey []byte) (Keytype, error) {
-	db.mu.RLock()
-	idx, exists := db.indexes[indexName]
-	db.mu.RUnlock()
-
-	if !exists {
-		return nil, errors.New("index not found")
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
 	}
 
 	return idx.FindOne(indexKey)
 }
 
+// FindRecordByIndex finds a record by unique secondary index, returning its
+// primary key and value. A covering index answers in one lookup, returning
+// what it stores (the projection, if it has one); any other index costs a
+// second lookup in the primary tree.
+func (db *IndexedBTree) FindRecordByIndex(indexName string, indexKey []byte) (Keytype, Valuetype, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !idx.unique {
+		return nil, nil, errors.New("FindRecordByIndex only works on unique indexes")
+	}
+
+	if idx.covering {
+		keys, values, err := idx.FindCovered(indexKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return keys[0], values[0], nil
+	}
+	pk, err := idx.FindOne(indexKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := db.tree.Find(pk)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pk, value, nil
+}
+
 // FindAllByIndex finds all records matching an index key.
 // Returns all primary keys for the given index key.
 func (db *IndexedBTree) FindAllByIndex(indexName string, indexKey []byte) ([]Keytype, error) {
-	db.mu.RLock()
-	idx, exists := db.indexes[indexName]
-	db.mu.RUnlock()
-
-	if !exists {
-		return nil, errors.New("index not found")
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
 	}
 
 	return idx.FindAll(indexKey)
 }
 
+// FindAllByIndexPage is FindAllByIndex a page at a time, for index keys with
+// too many records to return at once. It returns up to limit primary keys
+// after cursor (nil for the first page) and the cursor of the next page,
+// nil after the last:
+//
+//	var cursor []byte
+//	for {
+//		keys, next, err := db.FindAllByIndexPage("city", []byte("NYC"), 1000, cursor)
+//		if err != nil {
+//			return err
+//		}
+//		process(keys)
+//		if next == nil {
+//			break
+//		}
+//		cursor = next
+//	}
+//
+// The cursor is the last primary key returned, so paging stays in place
+// while records are written: each record present throughout is returned
+// exactly once.
+func (db *IndexedBTree) FindAllByIndexPage(indexName string, indexKey []byte, limit int, cursor []byte) ([]Keytype, []byte, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return idx.FindAllPage(indexKey, limit, cursor)
+}
+
+// CountByIndex returns the number of records with an index key, from the
+// index alone: the primary tree is not read. 0 if there are none.
+func (db *IndexedBTree) CountByIndex(indexName string, indexKey []byte) (uint64, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return 0, err
+	}
+
+	return idx.CountKey(indexKey)
+}
+
+// CountRangeByIndex returns the number of records with index keys in
+// [startKey, endKey], from the index alone. A record under several keys in
+// the range (an InvertedIndex's terms) counts once per key.
+func (db *IndexedBTree) CountRangeByIndex(indexName string, startKey, endKey []byte) (uint64, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return 0, err
+	}
+
+	return idx.CountRange(startKey, endKey)
+}
+
+// FindValueByIndex finds a record by unique secondary index and returns its
+// value, saving the caller the primary lookup. A covering index without a
+// projection answers on its own.
+func (db *IndexedBTree) FindValueByIndex(indexName string, indexKey []byte) (Valuetype, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.unique {
+		return nil, errors.New("FindValueByIndex only works on unique indexes")
+	}
+
+	if idx.covering && idx.project == nil {
+		_, values, err := idx.FindCovered(indexKey)
+		if err != nil {
+			return nil, err
+		}
+		return values[0], nil
+	}
+	pk, err := idx.FindOne(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	return db.tree.Find(pk)
+}
+
+// FindAllValuesByIndex finds all records matching an index key, returning
+// their primary keys and values. The records are fetched with one MultiGet,
+// so each shard is searched once for the whole batch; a covering index
+// without a projection answers on its own. Records deleted since the index
+// was read are left out.
+func (db *IndexedBTree) FindAllValuesByIndex(indexName string, indexKey []byte) ([]Keytype, []Valuetype, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if idx.covering && idx.project == nil {
+		return idx.FindCovered(indexKey)
+	}
+
+	pks, err := idx.FindAll(indexKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, errs := db.tree.MultiGet(pks)
+	kept := 0
+	for i, err := range errs {
+		if errors.Is(err, ErrInvariant) {
+			return nil, nil, err
+		}
+		if err == nil {
+			pks[kept], values[kept] = pks[i], values[i]
+			kept++
+		}
+	}
+	return pks[:kept], values[:kept], nil
+}
+
 // FindRangeByIndex finds all records with index keys in a range.
 // Returns all primary keys where startKey <= indexKey <= endKey.
 func (db *IndexedBTree) FindRangeByIndex(indexName string, startKey, endKey []byte) ([]Keytype, error) {
-	db.mu.RLock()
-	idx, exists := db.indexes[indexName]
-	db.mu.RUnlock()
-
-	if !exists {
-		return nil, errors.New("index not found")
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
 	}
 
 	return idx.FindRange(startKey, endKey)
 }
 
+// FindRangeByIndexReverse finds up to limit records with index keys in
+// [startKey, endKey], largest index key first, reading the index only as far
+// as the limit needs: the 10 most recent signups are
+//
+//	db.FindRangeByIndexReverse("created_at", from, to, 10)
+//
+// A limit of 0 or less returns the whole range.
+func (db *IndexedBTree) FindRangeByIndexReverse(indexName string, startKey, endKey []byte, limit int) ([]Keytype, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx.FindRangeReverse(startKey, endKey, limit)
+}
+
+// FindPrefixByIndex finds all records whose index key starts with prefix
+// (after the index's Normalizer), in index key order. With a
+// ReversedDomainExtractor, prefix "com.example@" finds every address at
+// example.com.
+func (db *IndexedBTree) FindPrefixByIndex(indexName string, prefix []byte) ([]Keytype, error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx.FindPrefix(prefix)
+}
+
+// RangeByIndex returns an iterator over (primaryKey, record) pairs whose
+// index keys fall in [startKey, endKey], in index key order.
+// Records deleted between the index read and the primary fetch are skipped.
+func (db *IndexedBTree) RangeByIndex(indexName string, startKey, endKey []byte) (iter.Seq2[[]byte, []byte], error) {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func([]byte, []byte) bool) {
+		for _, pk := range idx.Range(startKey, endKey) {
+			value, err := db.tree.Find(pk)
+			if err != nil {
+				continue
+			}
+			if !yield(pk, value) {
+				return
+			}
+		}
+	}, nil
+}
+
+// ForEachByIndex calls fn for every record the index holds, in index key
+// order (then primary key order within a key), until fn returns false: an
+// "ORDER BY field" traversal without sorting. Records the index does not
+// hold, such as those missing the field, are not visited; a record indexed
+// under several keys (an InvertedIndex's terms) is visited once per key.
+// Records are fetched a batch at a time; ones deleted before their batch is
+// fetched are skipped.
+func (db *IndexedBTree) ForEachByIndex(indexName string, fn func(key Keytype, value Valuetype) bool) error {
+	idx, err := db.index(indexName)
+	if err != nil {
+		return err
+	}
+	if err := idx.tree.Err(); err != nil {
+		return err
+	}
+
+	var batch []Keytype
+	stopped := false
+	flush := func() bool {
+		values, errs := db.tree.MultiGet(batch)
+		for i, pk := range batch {
+			if errs[i] == nil && !fn(pk, values[i]) {
+				stopped = true
+				break
+			}
+		}
+		batch = batch[:0]
+		return !stopped
+	}
+	idx.postings(nil, nil, false, func(_, pk, _ []byte) bool {
+		batch = append(batch, Keytype(pk))
+		return len(batch) < rangeBatchSize || flush()
+	})
+	if !stopped && len(batch) > 0 {
+		flush()
+	}
+	return nil
+}
+
+// All returns an iterator over all records. Order is not guaranteed.
+func (db *IndexedBTree) All() iter.Seq2[[]byte, []byte] {
+	return db.tree.All()
+}
+
 // GetRange returns all key-value pairs in the primary key range.
 func (db *IndexedBTree) GetRange(startKey, endKey Keytype) ([]Keytype, []Valuetype, error) {
 	return db.tree.GetRange(startKey, endKey)
 }
 
+// GetRangeLimit returns the first limit records in the primary key range.
+func (db *IndexedBTree) GetRangeLimit(startKey, endKey Keytype, limit int) ([]Keytype, []Valuetype, error) {
+	return db.tree.GetRangeLimit(startKey, endKey, limit)
+}
+
 // Count returns the number of records in the primary tree.
 func (db *IndexedBTree) Count() int64 {
 	return db.tree.Count()
@@ -324,7 +760,10 @@ func (db *IndexedBTree) ForEach(fn func(key Keytype, value Valuetype) bool) {
 }
 
 // Clear removes all records and clears all indexes.
+// Deferred index updates are applied first so none land after the clear.
 func (db *IndexedBTree) Clear() {
+	db.SyncIndexes()
+
 	db.mu.RLock()
 	indexes := make([]*SecondaryIndex, 0, len(db.indexes))
 	for _, idx := range db.indexes {
@@ -352,9 +791,124 @@ func (db *IndexedBTree) Stats() IndexedStats {
 	}
 
 	return IndexedStats{
-		PrimaryStats: db.tree.Stats(),
-		IndexStats:   indexStats,
+		PrimaryStats:         db.tree.Stats(false),
+		IndexStats:           indexStats,
+		IndexLag:             db.IndexLag(),
+		DeferredIndexUpdates: atomic.LoadUint64(&db.deferred),
+	}
+}
+
+// indexOp identifies the index maintenance a write needs.
+type indexOp uint8
+
+const (
+	indexInsert indexOp = iota
+	indexUpdateValue
+	indexRemove
+)
+
+// indexUpdate is one index maintenance step, applied inline or by catchUp.
+type indexUpdate struct {
+	op         indexOp
+	idx        *SecondaryIndex
+	primaryKey Keytype
+	oldValue   Valuetype
+	newValue   Valuetype
+
+	flushed chan struct{} // Set on SyncIndexes markers instead of an update
+}
+
+func (u indexUpdate) apply() error {
+	if u.idx.dropped.Load() {
+		return nil // Queued before DropIndex
+	}
+	switch u.op {
+	case indexInsert:
+		return u.idx.Index(u.primaryKey, u.newValue)
+	case indexUpdateValue:
+		return u.idx.Update(u.primaryKey, u.oldValue, u.newValue)
+	default:
+		return u.idx.Remove(u.primaryKey, u.oldValue)
+	}
+}
+
+// updateIndex applies u inline, or queues it when the tree is degraded.
+// Once anything is queued, later updates queue behind it to keep them ordered.
+// Updates to an index being built online are left to the build.
+func (db *IndexedBTree) updateIndex(u indexUpdate) error {
+	if b := u.idx.building(); b != nil && b.buffer(u) {
+		return nil
+	}
+	if db.pending == nil || u.idx.unique {
+		return u.apply()
 	}
+	if atomic.LoadInt64(&db.inflight) <= db.asyncThreshold && atomic.LoadInt64(&db.lag) == 0 {
+		return u.apply()
+	}
+
+	db.asyncMu.RLock()
+	defer db.asyncMu.RUnlock()
+	if db.closed {
+		return u.apply()
+	}
+	atomic.AddInt64(&db.lag, 1)
+	atomic.AddUint64(&db.deferred, 1)
+	db.pending <- u
+	return nil
+}
+
+// catchUp applies deferred index updates in queue order until Close.
+func (db *IndexedBTree) catchUp() {
+	defer close(db.workerDone)
+	for u := range db.pending {
+		if u.flushed != nil {
+			close(u.flushed)
+			continue
+		}
+		// Only non-unique updates are deferred, and those cannot fail
+		u.apply()
+		atomic.AddInt64(&db.lag, -1)
+	}
+}
+
+// IndexLag returns the number of deferred index updates not yet applied.
+func (db *IndexedBTree) IndexLag() int64 {
+	return atomic.LoadInt64(&db.lag)
+}
+
+// SyncIndexes blocks until every index update deferred before the call has
+// been applied. No-op unless graceful degradation is enabled.
+func (db *IndexedBTree) SyncIndexes() {
+	if db.pending == nil {
+		return
+	}
+
+	flushed := make(chan struct{})
+	db.asyncMu.RLock()
+	if db.closed {
+		db.asyncMu.RUnlock()
+		return
+	}
+	db.pending <- indexUpdate{flushed: flushed}
+	db.asyncMu.RUnlock()
+	<-flushed
+}
+
+// Close applies any deferred index updates and stops the catch-up worker.
+// Later writes update indexes inline. Safe to call more than once.
+func (db *IndexedBTree) Close() error {
+	if db.pending == nil {
+		return nil
+	}
+
+	db.asyncMu.Lock()
+	if !db.closed {
+		db.closed = true
+		close(db.pending)
+	}
+	db.asyncMu.Unlock()
+	<-db.workerDone
+	return nil
 }
 
 // bytesEqual compares two byte slices, handling nil.
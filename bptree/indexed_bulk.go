@@ -0,0 +1,254 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// BulkOptions configures IndexedBTree.BulkInsert.
+type BulkOptions struct {
+	// DeferUnique validates unique indexes for the whole batch in one pass
+	// before anything is written, instead of checking each pair as it is
+	// inserted (default: false)
+	DeferUnique bool
+}
+
+// BulkInsert inserts many records and updates every secondary index. The
+// result is nil or a *BulkError giving each pair's outcome; errors.Is sees
+// ErrUniqueViolation through it for the pairs rejected by a unique index.
+//
+// By default each pair is inserted as Insert would insert it, so a pair
+// that breaks a unique index fails alone and the rest are inserted. With
+// DeferUnique the batch is all or nothing as far as unique indexes go:
+//
+// DESIGN:
+// - Unique keys are extracted for the whole batch, checked against each other, then looked up with one MultiGet per index
+// - Any violation fails the batch before it writes anything, marking each offending pair
+// - Otherwise the records go in with one ShardedBTree.BulkInsert, and each unique index is loaded the same way
+// - Non-unique indexes and indexes still building are maintained as for Insert
+//
+// LIMITATIONS:
+// - Holds every unique index's lock from validation to load, stalling other writers to those indexes
+// - As with Insert, a pair overwriting an existing key does not remove the old record's index entries
+//
+// USAGE:
+//
+//	err := db.BulkInsert(keys, values, BulkOptions{DeferUnique: true})
+//	var bulkErr *BulkError
+//	if errors.As(err, &bulkErr) { ... bulkErr.Errs[i] ... }
+func (db *IndexedBTree) BulkInsert(keys []Keytype, values []Valuetype, opts BulkOptions) error {
+	if len(keys) != len(values) {
+		return errors.New("keys and values must have the same length")
+	}
+	if !opts.DeferUnique {
+		return db.insertEach(keys, values)
+	}
+
+	db.writers.RLock()
+	defer db.writers.RUnlock()
+
+	db.mu.RLock()
+	var unique, others []*SecondaryIndex
+	for _, idx := range db.indexes {
+		if idx.unique && idx.building() == nil {
+			unique = append(unique, idx)
+		} else {
+			others = append(others, idx)
+		}
+	}
+	db.mu.RUnlock()
+
+	// Lock in name order, so concurrent bulk inserts cannot deadlock
+	slices.SortFunc(unique, func(a, b *SecondaryIndex) int { return strings.Compare(a.name, b.name) })
+	for _, idx := range unique {
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+	}
+
+	indexKeys := make([][][]byte, len(unique))
+	errs := make([]error, len(keys))
+	failed := 0
+	for i, value := range values {
+		if err := db.checkSchema(value); err != nil {
+			errs[i] = err
+			failed++
+		}
+	}
+	for i, idx := range unique {
+		indexKeys[i] = idx.validateBatch(keys, values, errs, &failed)
+	}
+	if failed > 0 {
+		return &BulkError{Errs: errs, Failed: failed}
+	}
+
+	atomic.AddInt64(&db.inflight, int64(len(keys)))
+	defer atomic.AddInt64(&db.inflight, -int64(len(keys)))
+
+	var bulkErr *BulkError
+	if err := db.tree.BulkInsert(keys, values); err != nil && !errors.As(err, &bulkErr) {
+		return err
+	}
+	inserted := func(i int) bool {
+		return bulkErr == nil || bulkErr.Errs[i] == nil
+	}
+
+	for i, idx := range unique {
+		var entryKeys []Keytype
+		var entries []Valuetype
+		for j, indexKey := range indexKeys[i] {
+			if indexKey != nil && inserted(j) {
+				entryKeys = append(entryKeys, indexKey)
+				entries = append(entries, idx.encodeEntry([][]byte{keys[j]}, [][]byte{idx.stored(values[j])}))
+			}
+		}
+		if err := idx.tree.BulkInsert(entryKeys, entries); err != nil {
+			return err // Primary records are in; the index is not
+		}
+		idx.entries += uint64(len(entryKeys))
+	}
+
+	for _, idx := range others {
+		for j := range keys {
+			if inserted(j) {
+				db.updateIndex(indexUpdate{op: indexInsert, idx: idx, primaryKey: keys[j], newValue: values[j]})
+			}
+		}
+	}
+
+	if bulkErr != nil {
+		return bulkErr
+	}
+	return nil
+}
+
+// InsertMany inserts or replaces the records, keyed by primary key, as one
+// unit: if any record breaks a unique index, none is written and the error
+// names it. Unlike Insert, a record replacing an existing one moves its
+// index entries.
+//
+// DESIGN:
+// - Every record's unique index keys are claimed first (see Insert), in key order
+// - A failed claim releases all the batch's claims, so nothing was written to undo
+// - Then the records are written and the remaining index updates applied, none of which can fail
+//
+// LIMITATIONS:
+// - A record cannot take a unique key another record in the batch gives up: the old key is held until the batch is claimed
+// - Readers may see part of the batch while it is written
+func (db *IndexedBTree) InsertMany(records map[string][]byte) error {
+	db.writers.RLock()
+	defer db.writers.RUnlock()
+
+	db.mu.RLock()
+	indexes := make([]*SecondaryIndex, 0, len(db.indexes))
+	for _, idx := range db.indexes {
+		indexes = append(indexes, idx)
+	}
+	db.mu.RUnlock()
+
+	for key, value := range records {
+		if err := db.checkSchema(value); err != nil {
+			return fmt.Errorf("record %q: %w", key, err)
+		}
+		if err := db.tree.checkSizes([]byte(key), value); err != nil {
+			return fmt.Errorf("record %q: %w", key, err)
+		}
+	}
+
+	type claimedRecord struct {
+		key     Keytype
+		value   Valuetype
+		claimed []*SecondaryIndex
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	batch := make([]claimedRecord, 0, len(keys))
+	for _, k := range keys {
+		key, value := Keytype(k), Valuetype(records[k])
+		claimed, err := claimUnique(indexes, key, value)
+		if err != nil {
+			for _, r := range batch {
+				releaseUnique(r.claimed, r.key, r.value)
+			}
+			return fmt.Errorf("record %q: %w", key, err)
+		}
+		batch = append(batch, claimedRecord{key: key, value: value, claimed: claimed})
+	}
+
+	atomic.AddInt64(&db.inflight, int64(len(batch)))
+	defer atomic.AddInt64(&db.inflight, -int64(len(batch)))
+
+	for _, r := range batch {
+		oldValue, err := db.tree.Find(r.key)
+		db.tree.TryInsert(r.key, r.value) // Sizes checked above
+		for _, idx := range indexes {
+			u := indexUpdate{op: indexInsert, idx: idx, primaryKey: r.key, newValue: r.value}
+			if err == nil {
+				u.op, u.oldValue = indexUpdateValue, oldValue
+			}
+			db.updateIndex(u) // Claimed or non-unique, so it cannot fail
+		}
+	}
+	return nil
+}
+
+// insertEach inserts the pairs one at a time with Insert.
+func (db *IndexedBTree) insertEach(keys []Keytype, values []Valuetype) error {
+	errs := make([]error, len(keys))
+	failed := 0
+	for i := range keys {
+		if err := db.Insert(keys[i], values[i]); err != nil {
+			errs[i] = err
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &BulkError{Errs: errs, Failed: failed}
+	}
+	return nil
+}
+
+// validateBatch returns the key the index stores for each of values, marking
+// in errs the pairs whose key repeats an earlier pair's or is already in the
+// index. Called with idx.mu held.
+func (idx *SecondaryIndex) validateBatch(keys []Keytype, values []Valuetype, errs []error, failed *int) [][]byte {
+	indexKeys := make([][]byte, len(values))
+	var probes []Keytype
+	var probed []int
+	first := make(map[string]int, len(values)) // Index key -> first pair with it
+	reject := func(i int, existing Keytype) {
+		if errs[i] == nil {
+			errs[i] = &UniqueViolationError{Index: idx.name, IndexKey: indexKeys[i], ExistingPrimaryKey: existing}
+			*failed++
+		}
+	}
+
+	for i, value := range values {
+		indexKey := idx.extractor(value)
+		if indexKey == nil {
+			continue
+		}
+		indexKeys[i] = indexKey
+		if j, seen := first[string(indexKey)]; seen {
+			reject(i, keys[j])
+			continue
+		}
+		first[string(indexKey)] = i
+		probes, probed = append(probes, indexKey), append(probed, i)
+	}
+
+	entries, findErrs := idx.tree.MultiGet(probes)
+	for j, err := range findErrs {
+		if err == nil {
+			existing, _ := idx.decodeEntry(entries[j])
+			reject(probed[j], existing[0])
+		}
+	}
+	return indexKeys
+}
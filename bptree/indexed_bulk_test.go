@@ -0,0 +1,148 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func bulkTestDB(t *testing.T) *IndexedBTree {
+	t.Helper()
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+	db.Insert(Keytype("existing"), Valuetype(`{"email":"taken@x.com","city":"LA"}`))
+	return db
+}
+
+func bulkRecords(n int) ([]Keytype, []Valuetype) {
+	keys := make([]Keytype, n)
+	values := make([]Valuetype, n)
+	for i := range keys {
+		keys[i] = Keytype(fmt.Sprintf("user:%04d", i))
+		values[i] = Valuetype(fmt.Sprintf(`{"email":"u%d@x.com","city":"c%d"}`, i, i%7))
+	}
+	return keys, values
+}
+
+func TestIndexedBulkInsert(t *testing.T) {
+	for _, opts := range []BulkOptions{{}, {DeferUnique: true}} {
+		db := bulkTestDB(t)
+		keys, values := bulkRecords(1000)
+		if err := db.BulkInsert(keys, values, opts); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+
+		if n := db.Count(); n != 1001 {
+			t.Errorf("%+v: Count = %d, want 1001", opts, n)
+		}
+		if pk, err := db.FindByIndex("email", []byte("u123@x.com")); err != nil || string(pk) != "user:0123" {
+			t.Errorf("%+v: FindByIndex = %s, %v", opts, pk, err)
+		}
+		if pks, _ := db.FindAllByIndex("city", []byte("c3")); len(pks) != 143 {
+			t.Errorf("%+v: city c3 has %d records, want 143", opts, len(pks))
+		}
+		if stats := db.Stats().IndexStats["email"]; stats.Entries != 1001 {
+			t.Errorf("%+v: email index has %d entries, want 1001", opts, stats.Entries)
+		}
+		if err := db.Insert(Keytype("late"), Valuetype(`{"email":"u5@x.com"}`)); !errors.Is(err, ErrUniqueViolation) {
+			t.Errorf("%+v: Insert of a loaded email = %v, want ErrUniqueViolation", opts, err)
+		}
+	}
+}
+
+func TestIndexedBulkInsertViolations(t *testing.T) {
+	keys, values := bulkRecords(100)
+	values[10] = Valuetype(`{"email":"taken@x.com"}`) // Clashes with the index
+	values[20] = Valuetype(`{"email":"u5@x.com"}`)    // Clashes within the batch
+
+	// Checked per pair: the clashing pairs fail alone
+	db := bulkTestDB(t)
+	err := db.BulkInsert(keys, values, BulkOptions{})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 2 {
+		t.Fatalf("BulkInsert = %v, want 2 failed pairs", err)
+	}
+	for _, i := range []int{10, 20} {
+		if !errors.Is(bulkErr.Errs[i], ErrUniqueViolation) {
+			t.Errorf("Errs[%d] = %v, want ErrUniqueViolation", i, bulkErr.Errs[i])
+		}
+	}
+	if n := db.Count(); n != 99 {
+		t.Errorf("Count = %d, want 99", n)
+	}
+
+	// Deferred: the same pairs are marked and nothing is written
+	db = bulkTestDB(t)
+	err = db.BulkInsert(keys, values, BulkOptions{DeferUnique: true})
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 2 {
+		t.Fatalf("Deferred BulkInsert = %v, want 2 failed pairs", err)
+	}
+	if !errors.Is(err, ErrUniqueViolation) || bulkErr.Errs[10] == nil || bulkErr.Errs[20] == nil {
+		t.Errorf("Deferred BulkInsert marked %v", bulkErr.Errs)
+	}
+	if n := db.Count(); n != 1 {
+		t.Errorf("Count = %d after a rejected batch, want 1", n)
+	}
+	if pks, _ := db.FindAllByIndex("city", []byte("c1")); len(pks) != 0 {
+		t.Errorf("Rejected batch left %d city entries", len(pks))
+	}
+
+	if err := db.BulkInsert(keys, values[:1], BulkOptions{DeferUnique: true}); err == nil {
+		t.Error("BulkInsert with mismatched lengths succeeded")
+	}
+}
+
+func TestIndexedInsertMany(t *testing.T) {
+	db := bulkTestDB(t)
+	err := db.InsertMany(map[string][]byte{
+		"user:1":   []byte(`{"email":"a@x.com","city":"NYC"}`),
+		"user:2":   []byte(`{"email":"b@x.com","city":"NYC"}`),
+		"existing": []byte(`{"email":"moved@x.com","city":"SF"}`),
+	})
+	if err != nil {
+		t.Fatalf("InsertMany: %v", err)
+	}
+	if n := db.Count(); n != 3 {
+		t.Errorf("Count = %d, want 3", n)
+	}
+	if pk, err := db.FindByIndex("email", []byte("moved@x.com")); err != nil || string(pk) != "existing" {
+		t.Errorf("FindByIndex(moved) = %s, %v", pk, err)
+	}
+	if _, err := db.FindByIndex("email", []byte("taken@x.com")); err == nil {
+		t.Error("Replaced record's old email is still indexed")
+	}
+	if pks, _ := db.FindAllByIndex("city", []byte("LA")); len(pks) != 0 {
+		t.Errorf("Replaced record's old city is still indexed: %s", pks)
+	}
+}
+
+func TestIndexedInsertManyRollsBack(t *testing.T) {
+	for name, records := range map[string]map[string][]byte{
+		"existing key": {
+			"user:1": []byte(`{"email":"a@x.com","city":"NYC"}`),
+			"user:9": []byte(`{"email":"taken@x.com","city":"NYC"}`),
+		},
+		"within batch": {
+			"user:1": []byte(`{"email":"a@x.com","city":"NYC"}`),
+			"user:2": []byte(`{"email":"a@x.com","city":"NYC"}`),
+		},
+	} {
+		db := bulkTestDB(t)
+		err := db.InsertMany(records)
+		var conflict *UniqueViolationError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("%s: err = %v, want a UniqueViolationError", name, err)
+		}
+
+		if n := db.Count(); n != 1 {
+			t.Errorf("%s: Count = %d, want 1", name, n)
+		}
+		if stats := db.Stats().IndexStats; stats["email"].Entries != 1 || stats["city"].Entries != 1 {
+			t.Errorf("%s: index entries = %d, %d, want 1, 1", name, stats["email"].Entries, stats["city"].Entries)
+		}
+		if pk, err := db.FindByIndex("email", []byte("taken@x.com")); err != nil || string(pk) != "existing" {
+			t.Errorf("%s: FindByIndex(taken) = %s, %v", name, pk, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ErrInvariant is matched by errors.Is for every InvariantError.
+var ErrInvariant = errors.New("tree invariant violated")
+
+// InvariantError reports a broken tree invariant: a missing child, an index
+// past the end of a node, or any other panic inside the tree.
+//
+// PANIC-FREE MODE (Btree.SetPanicFree, ShardConfig.PanicFree):
+// - Operations recover from panics and return an InvariantError instead
+// - The first violation fails the tree: later operations return it without touching nodes
+// - Failing is deliberate: a panic can leave node latches held or a node half-rewritten
+// - Insert and Delete have no error result; TryInsert and TryDelete report it, Err always does
+// - Iterators (All, Range) are not guarded, since a panic in the loop body is the caller's
+//
+// Without panic-free mode, detected violations still panic with an
+// InvariantError, so the crash carries the same diagnostics.
+type InvariantError struct {
+	Op     string  // Operation that hit the violation
+	Key    Keytype // Key it was working on, nil for range operations
+	Detail string  // What was wrong, with the offending node's shape
+	Stack  []byte  // Stack of the failing goroutine
+}
+
+func (e *InvariantError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("%v: %s", ErrInvariant, e.Detail)
+	}
+	return fmt.Sprintf("%v in %s(%q): %s", ErrInvariant, e.Op, e.Key, e.Detail)
+}
+
+func (e *InvariantError) Unwrap() error {
+	return ErrInvariant
+}
+
+// invariantf returns an InvariantError describing a malformed node.
+func invariantf(n *Node, format string, args ...any) *InvariantError {
+	return &InvariantError{Detail: fmt.Sprintf(format, args...) + "; " + n.describe()}
+}
+
+// describe summarizes a node's shape for diagnostics. The caller holds n latched.
+func (n *Node) describe() string {
+	return fmt.Sprintf("node{leaf=%v keys=%d values=%d children=%d dead=%v}",
+		n.isleaf, len(n.keys), len(n.values), len(n.children), n.dead)
+}
+
+// SetPanicFree turns panic-free mode on or off. See InvariantError.
+func (t *Btree) SetPanicFree(enabled bool) {
+	t.panicFree.Store(enabled)
+}
+
+// Err returns the invariant violation that failed the tree, or nil.
+func (t *Btree) Err() error {
+	if failure := t.failure.Load(); failure != nil {
+		return failure
+	}
+	return nil
+}
+
+// guard is deferred first by every guarded operation, so it runs after the
+// operation's own deferred unlatching. In panic-free mode it turns a panic
+// into an InvariantError stored in *err and fails the tree; otherwise the
+// panic carries on.
+func (t *Btree) guard(op string, key []byte, err *error) {
+	if !t.panicFree.Load() {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	violation, ok := r.(*InvariantError)
+	if !ok {
+		violation = &InvariantError{Detail: fmt.Sprint(r)}
+	}
+	violation.Op = op
+	if key != nil {
+		violation.Key = append(Keytype(nil), key...)
+	}
+	violation.Stack = debug.Stack()
+
+	if !t.failure.CompareAndSwap(nil, violation) {
+		violation = t.failure.Load() // Report the first violation consistently
+	}
+	*err = violation
+}
+
+// CheckInvariants verifies the structure of the whole tree, for operators
+// after a crash or a suspected bug, and for fuzzers. It returns an
+// *InvariantError describing the first violation found, or nil.
+//
+// CHECKS:
+// - Keys strictly ascending within each node, and within the bounds set by the separators above
+// - Every node but the root holds MinKeys to MaxKeys keys, with one value per key
+// - Internal nodes have one more child than keys, none nil; all leaves are at the same depth
+// - Right-sibling links chain each level in key order, no reachable node is dead, and leaf fences match
+// - The key count matches Len, and the node and byte counts match MemoryUsage's
+//
+// It holds treeLock exclusively for an O(n) walk, blocking other operations
+// meanwhile. A violation does not fail the tree; panics while checking a
+// malformed node are reported as violations.
+func (t *Btree) CheckInvariants() (err error) {
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = &InvariantError{Op: "CheckInvariants", Detail: fmt.Sprint(r), Stack: debug.Stack()}
+		}
+	}()
+
+	c := invariantChecker{leafDepth: -1}
+	if t.root != nil {
+		if violation := c.check(t.root, nil, nil, 0, true); violation != nil {
+			violation.Op = "CheckInvariants"
+			return violation
+		}
+		if violation := checkLinks(t.root); violation != nil {
+			violation.Op = "CheckInvariants"
+			return violation
+		}
+	}
+	if size := atomic.LoadInt64(&t.size); size != c.keys {
+		return &InvariantError{Op: "CheckInvariants", Detail: fmt.Sprintf("Len is %d but the tree holds %d keys", size, c.keys)}
+	}
+	if nodes := t.nodes.Load(); nodes != c.nodes {
+		return &InvariantError{Op: "CheckInvariants", Detail: fmt.Sprintf("node count is %d but the tree holds %d nodes", nodes, c.nodes)}
+	}
+	if dataBytes := t.dataBytes.Load(); dataBytes != c.dataBytes {
+		return &InvariantError{Op: "CheckInvariants", Detail: fmt.Sprintf("byte count is %d but the tree holds %d bytes", dataBytes, c.dataBytes)}
+	}
+	return nil
+}
+
+// invariantChecker carries what CheckInvariants learns across subtrees.
+type invariantChecker struct {
+	leafDepth int // Depth of the first leaf reached, -1 before that
+	keys      int64
+	nodes     int64
+	dataBytes int64
+}
+
+// check verifies n and its subtree, whose keys must lie strictly between
+// low and high (nil is unbounded).
+func (c *invariantChecker) check(n *Node, low, high Keytype, depth int, isRoot bool) *InvariantError {
+	if !isRoot && len(n.keys) < MinKeys {
+		return invariantf(n, "underfull node at depth %d", depth)
+	}
+	if len(n.keys) > MaxKeys {
+		return invariantf(n, "overfull node at depth %d", depth)
+	}
+	if len(n.values) != len(n.keys) {
+		return invariantf(n, "key and value counts differ")
+	}
+	c.keys += int64(len(n.keys))
+	c.nodes++
+	for i := range n.keys {
+		c.dataBytes += n.entryBytes(i)
+	}
+
+	for i := range n.keys {
+		if i > 0 && n.compareKey(i, n.key(i-1)) <= 0 {
+			return invariantf(n, "key %d is not above key %d", i, i-1)
+		}
+		if (low != nil && n.compareKey(i, low) <= 0) || (high != nil && n.compareKey(i, high) >= 0) {
+			return invariantf(n, "key %d lies outside its separators %q..%q", i, low, high)
+		}
+	}
+
+	if n.isleaf {
+		if len(n.children) != 0 {
+			return invariantf(n, "leaf has children")
+		}
+		if c.leafDepth == -1 {
+			c.leafDepth = depth
+		} else if depth != c.leafDepth {
+			return invariantf(n, "leaf at depth %d, others at %d", depth, c.leafDepth)
+		}
+		return nil
+	}
+
+	if len(n.children) != len(n.keys)+1 {
+		return invariantf(n, "internal node needs %d children", len(n.keys)+1)
+	}
+	for i, child := range n.children {
+		if child == nil {
+			return invariantf(n, "child %d is nil", i)
+		}
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = n.key(i - 1)
+		}
+		if i < len(n.keys) {
+			childHigh = n.key(i)
+		}
+		if violation := c.check(child, childLow, childHigh, depth+1, false); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+// checkLinks verifies the B-Link structure: each level's right-sibling chain
+// visits that level's nodes in order, and leaf fences match the separators
+// above them. check has already verified child counts.
+func checkLinks(root *Node) *InvariantError {
+	for level := []*Node{root}; len(level) > 0; {
+		var next []*Node
+		for i, n := range level {
+			var want *Node
+			if i+1 < len(level) {
+				want = level[i+1]
+			}
+			if n.rightSibling != want {
+				return invariantf(n, "right-sibling link %d of %d is wrong", i, len(level))
+			}
+			if n.dead {
+				return invariantf(n, "reachable node is marked dead")
+			}
+			if !n.isleaf {
+				next = append(next, n.children...)
+			}
+		}
+		level = next
+	}
+	return checkLeafFences(root, nil, nil)
+}
+
+// checkLeafFences verifies that each leaf's fences are the separators
+// bounding it.
+func checkLeafFences(n *Node, low, high Keytype) *InvariantError {
+	if n.isleaf {
+		if n.hasLowKey != (low != nil) || (low != nil && !bytes.Equal(n.lowKey, low)) {
+			return invariantf(n, "leaf low fence %q, want %q", n.lowKey, low)
+		}
+		if n.hasHighKey != (high != nil) || (high != nil && !bytes.Equal(n.highKey, high)) {
+			return invariantf(n, "leaf high fence %q, want %q", n.highKey, high)
+		}
+		return nil
+	}
+	for i, child := range n.children {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = n.key(i - 1)
+		}
+		if i < len(n.keys) {
+			childHigh = n.key(i)
+		}
+		if violation := checkLeafFences(child, childLow, childHigh); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
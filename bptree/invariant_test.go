@@ -0,0 +1,236 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// corruptedTree returns a multi-level tree whose root has lost all but its
+// first child, and the largest key inserted.
+func corruptedTree(t *testing.T, panicFree bool) (*Btree, Keytype) {
+	t.Helper()
+	tree := &Btree{}
+	tree.SetPanicFree(panicFree)
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+	}
+	if tree.root.isleaf {
+		t.Fatal("Expected a multi-level tree")
+	}
+	tree.root.children = tree.root.children[:1]
+	return tree, []byte("key:099")
+}
+
+func TestPanicFreeReturnsInvariantError(t *testing.T) {
+	tree, lastKey := corruptedTree(t, true)
+
+	err := tree.TryInsert(lastKey, []byte("new"))
+	if !errors.Is(err, ErrInvariant) {
+		t.Fatalf("TryInsert on corrupted tree = %v, want ErrInvariant", err)
+	}
+	var violation *InvariantError
+	if !errors.As(err, &violation) {
+		t.Fatalf("Expected *InvariantError, got %T", err)
+	}
+	if violation.Op != "Insert" || string(violation.Key) != string(lastKey) || len(violation.Stack) == 0 {
+		t.Errorf("Incomplete diagnostics: op=%q key=%q stack=%d bytes", violation.Op, violation.Key, len(violation.Stack))
+	}
+
+	// The tree is failed: every operation reports the first violation
+	if tree.Err() != err {
+		t.Errorf("Err() = %v, want %v", tree.Err(), err)
+	}
+	if _, err := tree.Find([]byte("key:000")); err != violation {
+		t.Errorf("Find on failed tree = %v, want the first violation", err)
+	}
+	if _, err := tree.TryDelete([]byte("key:000")); err != violation {
+		t.Errorf("TryDelete on failed tree = %v, want the first violation", err)
+	}
+	if _, _, err := tree.GetRange([]byte("a"), []byte("z")); err != violation {
+		t.Errorf("GetRange on failed tree = %v, want the first violation", err)
+	}
+	if tree.Delete([]byte("key:000")) || tree.CompareAndSwap([]byte("key:000"), []byte("v"), []byte("w")) {
+		t.Error("Writes succeeded on a failed tree")
+	}
+	if _, err := tree.TryCompareAndSwap([]byte("key:000"), []byte("v"), []byte("w")); err != violation {
+		t.Errorf("TryCompareAndSwap on failed tree = %v, want the first violation", err)
+	}
+	if _, err := tree.TryModify([]byte("key:000"), func(Valuetype, bool) (Valuetype, bool) { return nil, true }); err != violation {
+		t.Errorf("TryModify on failed tree = %v, want the first violation", err)
+	}
+}
+
+func TestPanicFreeRecoversRuntimePanic(t *testing.T) {
+	tree := &Btree{}
+	tree.SetPanicFree(true)
+	tree.Insert([]byte("key"), []byte("value"))
+	tree.root.values = nil // Keys without values: Find indexes past the end
+
+	_, err := tree.Find([]byte("key"))
+	if !errors.Is(err, ErrInvariant) {
+		t.Fatalf("Find = %v, want ErrInvariant", err)
+	}
+
+	// The panic left the root read-latched; the failed tree must not touch it
+	if err := tree.TryInsert([]byte("other"), []byte("value")); err == nil {
+		t.Error("TryInsert succeeded on a failed tree")
+	}
+}
+
+func TestInvariantViolationPanicsByDefault(t *testing.T) {
+	tree, lastKey := corruptedTree(t, false)
+
+	defer func() {
+		r := recover()
+		violation, ok := r.(*InvariantError)
+		if !ok {
+			t.Fatalf("Expected panic with *InvariantError, got %v", r)
+		}
+		if violation.Detail == "" {
+			t.Error("Violation has no detail")
+		}
+		if tree.Err() != nil {
+			t.Error("Tree marked failed outside panic-free mode")
+		}
+	}()
+	tree.Insert(lastKey, []byte("new"))
+	t.Fatal("Insert on corrupted tree did not panic")
+}
+
+func TestFindReportsMissingChild(t *testing.T) {
+	tree, lastKey := corruptedTree(t, false)
+
+	if _, err := tree.Find(lastKey); !errors.Is(err, ErrInvariant) {
+		t.Errorf("Find past a missing child = %v, want ErrInvariant", err)
+	}
+	if _, err := tree.Find([]byte("key:000")); err != nil {
+		t.Errorf("Find in the intact subtree: %v", err)
+	}
+}
+
+func TestShardedBTreePanicFree(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 1, PanicFree: true})
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+	}
+	shard := tree.GetShard(0)
+	shard.root.children = shard.root.children[:1]
+
+	err := tree.BulkInsert([]Keytype{[]byte("key:099")}, []Valuetype{[]byte("new")})
+	if !errors.Is(err, ErrInvariant) {
+		t.Fatalf("BulkInsert into corrupted shard = %v, want ErrInvariant", err)
+	}
+	if !errors.Is(tree.Err(), ErrInvariant) {
+		t.Errorf("Err() = %v, want ErrInvariant", tree.Err())
+	}
+
+	// Clear replaces the failed shard with a fresh one in the same mode
+	tree.Clear()
+	if tree.Err() != nil {
+		t.Errorf("Err() after Clear = %v", tree.Err())
+	}
+	if err := tree.TryInsert([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("TryInsert after Clear: %v", err)
+	}
+	if !tree.GetShard(0).panicFree.Load() {
+		t.Error("Clear dropped panic-free mode")
+	}
+}
+
+func TestDurableBTreePanicFree(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 1, SyncMode: SyncNone, PanicFree: true})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		db.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+	}
+	shard := db.tree.GetShard(0)
+	shard.root.children = shard.root.children[:1]
+
+	if err := db.Insert([]byte("key:099"), []byte("new")); !errors.Is(err, ErrInvariant) {
+		t.Errorf("Insert into corrupted tree = %v, want ErrInvariant", err)
+	}
+	if _, err := db.Delete([]byte("key:000")); !errors.Is(err, ErrInvariant) {
+		t.Errorf("Delete from failed tree = %v, want ErrInvariant", err)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	healthy := func() *Btree {
+		tree := &Btree{}
+		for i := 0; i < 100; i++ {
+			tree.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("Healthy tree failed the check: %v", err)
+		}
+		return tree
+	}
+	leftmostLeaf := func(tree *Btree) *Node {
+		n := tree.root
+		for !n.isleaf {
+			n = n.children[0]
+		}
+		return n
+	}
+
+	corruptions := map[string]func(tree *Btree){
+		"missing child": func(tree *Btree) { tree.root.children = tree.root.children[:1] },
+		"key order": func(tree *Btree) {
+			leaf := leftmostLeaf(tree)
+			first, second := leaf.key(0), leaf.key(1)
+			leaf.setKey(0, second)
+			leaf.setKey(1, first)
+		},
+		"underfull": func(tree *Btree) {
+			leaf := leftmostLeaf(tree)
+			leaf.removeAt(0)
+			leaf.removeAt(0)
+		},
+		"uneven depth": func(tree *Btree) {
+			leaf := leftmostLeaf(tree)
+			tree.root.children[len(tree.root.children)-1] = leaf
+		},
+		"size":        func(tree *Btree) { tree.size++ },
+		"dead":        func(tree *Btree) { leftmostLeaf(tree).dead = true },
+		"broken link": func(tree *Btree) { leftmostLeaf(tree).rightSibling = nil },
+	}
+	for name, corrupt := range corruptions {
+		tree := healthy()
+		corrupt(tree)
+		err := tree.CheckInvariants()
+		if !errors.Is(err, ErrInvariant) {
+			t.Errorf("%s: CheckInvariants = %v, want ErrInvariant", name, err)
+		}
+		if tree.Err() != nil {
+			t.Errorf("%s: CheckInvariants failed the tree", name)
+		}
+	}
+
+	if err := (&Btree{}).CheckInvariants(); err != nil {
+		t.Errorf("Empty tree: %v", err)
+	}
+}
+
+func TestShardedBTreeCheckInvariants(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 200; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatalf("Healthy tree failed the check: %v", err)
+	}
+
+	// A key inserted directly into the wrong shard
+	key := []byte("misplaced")
+	tree.GetShard((tree.getShardIndex(key)+1)%4).Insert(key, []byte("v"))
+	if err := tree.CheckInvariants(); !errors.Is(err, ErrInvariant) {
+		t.Errorf("CheckInvariants = %v, want ErrInvariant", err)
+	}
+}
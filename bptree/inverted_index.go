@@ -0,0 +1,183 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"unicode"
+)
+
+// Analyzer splits text into the terms an InvertedIndex stores it under.
+// It must return the same terms for the same text.
+type Analyzer func(text []byte) [][]byte
+
+// SimpleAnalyzer splits text on white space, lower-cases each word and trims
+// the punctuation around it, returning each term once: "Hello, hello world!"
+// gives "hello" and "world".
+func SimpleAnalyzer(text []byte) [][]byte {
+	var terms [][]byte
+	seen := make(map[string]bool)
+	for _, word := range bytes.Fields(text) {
+		word = bytes.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(word) == 0 {
+			continue
+		}
+		term := bytes.ToLower(word)
+		if !seen[string(term)] {
+			seen[string(term)] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// TermMatch is how FindAllTerms combines terms.
+type TermMatch uint8
+
+const (
+	MatchAllTerms TermMatch = iota // Records containing every term (AND)
+	MatchAnyTerm                   // Records containing some term (OR)
+)
+
+// InvertedIndexConfig configures an inverted index.
+type InvertedIndexConfig struct {
+	// Name of the index
+	Name string
+	// Field extracts the text to index from a record
+	Field KeyExtractor
+	// Analyzer splits the text into terms (default: SimpleAnalyzer)
+	Analyzer Analyzer
+	// NumShards for the underlying tree (default: 4)
+	NumShards int
+}
+
+// InvertedIndex is a full-text index: it maps each term of a text field to
+// the primary keys of the records containing it.
+//
+// DESIGN:
+// - A secondary index whose records have one key per term, maintained by IndexedBTree like any other
+// - Postings are the non-unique entry format: one tree key per term and primary key
+// - Updates touch only the terms that were added or removed
+// - Query terms go through the same Analyzer as the text, so "Hello" finds "hello"
+//
+// LIMITATIONS:
+// - No positions, so no phrase queries; no ranking
+//
+// USAGE:
+//
+//	posts, _ := db.CreateInvertedIndex(InvertedIndexConfig{Name: "body", Field: JSONFieldExtractor("body")})
+//	keys, _ := posts.FindAllTerms([]string{"btree", "latch"}, MatchAllTerms)
+type InvertedIndex struct {
+	idx *SecondaryIndex
+}
+
+// CreateInvertedIndex creates an inverted index and populates it with
+// existing data.
+func (db *IndexedBTree) CreateInvertedIndex(config InvertedIndexConfig) (*InvertedIndex, error) {
+	if config.Field == nil {
+		return nil, errors.New("inverted index needs a field extractor")
+	}
+	idx := NewSecondaryIndex(IndexConfig{Name: config.Name, Extractor: config.Field, NumShards: config.NumShards})
+	idx.analyzer = config.Analyzer
+	if idx.analyzer == nil {
+		idx.analyzer = SimpleAnalyzer
+	}
+	if err := db.addIndex(idx, true); err != nil {
+		return nil, err
+	}
+	return &InvertedIndex{idx: idx}, nil
+}
+
+// InvertedIndex returns the named inverted index.
+func (db *IndexedBTree) InvertedIndex(name string) (*InvertedIndex, error) {
+	idx, err := db.index(name)
+	if err != nil {
+		return nil, err
+	}
+	if idx.analyzer == nil {
+		return nil, errors.New("index " + name + " is not an inverted index")
+	}
+	return &InvertedIndex{idx: idx}, nil
+}
+
+// Name returns the index name.
+func (ii *InvertedIndex) Name() string {
+	return ii.idx.name
+}
+
+// Stats returns statistics about the index; Entries counts postings.
+func (ii *InvertedIndex) Stats() IndexStats {
+	return ii.idx.Stats()
+}
+
+// FindTerm returns the primary keys of records containing term, in key
+// order. A term the Analyzer splits into several must match all of them.
+func (ii *InvertedIndex) FindTerm(term string) ([]Keytype, error) {
+	return ii.FindAllTerms([]string{term}, MatchAllTerms)
+}
+
+// FindAllTerms returns the primary keys of records containing all of terms,
+// or any of them, in key order.
+func (ii *InvertedIndex) FindAllTerms(terms []string, match TermMatch) ([]Keytype, error) {
+	var queries []IndexQuery
+	for _, term := range terms {
+		for _, t := range ii.idx.analyzer([]byte(term)) {
+			queries = append(queries, Match(ii.idx.name, t))
+		}
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	q := And(queries...)
+	if match == MatchAnyTerm {
+		q = Or(queries...)
+	}
+	return q.eval(map[string]*SecondaryIndex{ii.idx.name: ii.idx})
+}
+
+// indexKeys returns the keys the index stores value under: the extracted
+// key, or the terms of the extracted text for an inverted index.
+func (idx *SecondaryIndex) indexKeys(value Valuetype) [][]byte {
+	key := idx.extractor(value)
+	switch {
+	case key == nil:
+		return nil
+	case idx.analyzer != nil:
+		return idx.analyzer(key)
+	}
+	return [][]byte{key}
+}
+
+// updateTerms moves primaryKey from the postings of the terms only in
+// oldValue to those of the terms only in newValue. Either may be nil.
+func (idx *SecondaryIndex) updateTerms(primaryKey Keytype, oldValue, newValue Valuetype) error {
+	var oldTerms, newTerms [][]byte
+	if oldValue != nil {
+		oldTerms = idx.indexKeys(oldValue)
+	}
+	if newValue != nil {
+		newTerms = idx.indexKeys(newValue)
+	}
+	kept := make(map[string]bool, len(newTerms))
+	for _, term := range newTerms {
+		kept[string(term)] = true
+	}
+
+	had := make(map[string]bool, len(oldTerms))
+	for _, term := range oldTerms {
+		had[string(term)] = true
+		if !kept[string(term)] {
+			idx.removeKey(primaryKey, term)
+		}
+	}
+	for _, term := range newTerms {
+		if !had[string(term)] {
+			if _, err := idx.addKey(primaryKey, term, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
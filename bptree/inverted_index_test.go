@@ -0,0 +1,115 @@
+package bptree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSimpleAnalyzer(t *testing.T) {
+	cases := map[string]string{
+		"Hello, hello world!":     "[hello world]",
+		"  B+trees:   latch-free": "[b+trees latch-free]",
+		"Ünïcode ÄND 42":          "[ünïcode änd 42]",
+		"... -- !!":               "[]",
+	}
+	for text, want := range cases {
+		if got := fmt.Sprintf("%s", SimpleAnalyzer([]byte(text))); got != want {
+			t.Errorf("SimpleAnalyzer(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestInvertedIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.Insert([]byte("post:1"), []byte(`{"body":"B-trees keep keys sorted"}`))
+
+	posts, err := db.CreateInvertedIndex(InvertedIndexConfig{Name: "body", Field: JSONFieldExtractor("body")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Insert([]byte("post:2"), []byte(`{"body":"Sharded trees, sorted merges"}`))
+	db.Insert([]byte("post:3"), []byte(`{"body":"Latches protect the trees"}`))
+	db.Insert([]byte("post:4"), []byte(`{"title":"no body"}`))
+
+	check := func(what string, keys []Keytype, err error, want string) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", what, err)
+		} else if got := fmt.Sprintf("%s", keys); got != want {
+			t.Errorf("%s = %s, want %s", what, got, want)
+		}
+	}
+	keys, err := posts.FindTerm("trees")
+	check("FindTerm(trees)", keys, err, "[post:2 post:3]")
+	keys, err = posts.FindTerm("SORTED")
+	check("FindTerm(SORTED)", keys, err, "[post:1 post:2]")
+	keys, err = posts.FindTerm("sorted trees")
+	check("FindTerm(sorted trees)", keys, err, "[post:2]")
+	keys, err = posts.FindTerm("missing")
+	check("FindTerm(missing)", keys, err, "[]")
+	keys, err = posts.FindAllTerms([]string{"latches", "b-trees"}, MatchAnyTerm)
+	check("Any(latches, b-trees)", keys, err, "[post:1 post:3]")
+	keys, err = posts.FindAllTerms([]string{"trees", "latches"}, MatchAllTerms)
+	check("All(trees, latches)", keys, err, "[post:3]")
+
+	// Updates move only the changed terms; deletes drop every posting
+	db.Update([]byte("post:3"), []byte(`{"body":"Latches protect sorted leaves"}`))
+	keys, err = posts.FindTerm("trees")
+	check("FindTerm(trees) after update", keys, err, "[post:2]")
+	keys, err = posts.FindTerm("sorted")
+	check("FindTerm(sorted) after update", keys, err, "[post:1 post:2 post:3]")
+	db.Delete([]byte("post:2"))
+	keys, err = posts.FindAllTerms([]string{"sorted", "sharded"}, MatchAnyTerm)
+	check("Any(sorted, sharded) after delete", keys, err, "[post:1 post:3]")
+
+	// 4 terms in post:1, 4 in post:3
+	if n := posts.Stats().Entries; n != 8 {
+		t.Errorf("Entries = %d, want 8", n)
+	}
+
+	// The index is reachable by name, and through the generic lookups
+	again, err := db.InvertedIndex("body")
+	if err != nil || again.Name() != "body" {
+		t.Fatalf("InvertedIndex(body) = %v, %v", again, err)
+	}
+	pks, err := db.FindAllByIndex("body", []byte("leaves"))
+	check("FindAllByIndex(leaves)", pks, err, "[post:3]")
+	result, err := db.Query([]Filter{Equals("body", []byte("protect")), Equals("body", []byte("sorted"))}, QueryOptions{})
+	check("Query(protect, sorted)", result.Keys, err, "[post:3]")
+	result, err = db.Query([]Filter{Equals("body", []byte("keys"))}, QueryOptions{NoIndex: true})
+	check("Query(keys) scanning", result.Keys, err, "[post:1]")
+
+	db.CreateIndex("title", JSONFieldExtractor("title"), false)
+	if _, err := db.InvertedIndex("title"); err == nil {
+		t.Error("InvertedIndex of a plain index succeeded")
+	}
+}
+
+func TestInvertedIndexCustomAnalyzer(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	tags, err := db.CreateInvertedIndex(InvertedIndexConfig{
+		Name:  "tags",
+		Field: JSONFieldExtractor("tags"),
+		Analyzer: func(text []byte) [][]byte {
+			var terms [][]byte
+			for _, tag := range strings.Split(string(text), ",") {
+				terms = append(terms, []byte(strings.TrimSpace(tag)))
+			}
+			return terms
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Insert([]byte("a"), []byte(`{"tags":"go, New York"}`))
+	db.Insert([]byte("b"), []byte(`{"tags":"New York,rust"}`))
+
+	keys, err := tags.FindTerm("New York")
+	if got := fmt.Sprintf("%s", keys); err != nil || got != "[a b]" {
+		t.Errorf("FindTerm(New York) = %s, %v, want [a b]", got, err)
+	}
+	if keys, _ := tags.FindTerm("new york"); len(keys) != 0 {
+		t.Errorf("Custom analyzer was bypassed: FindTerm(new york) = %s", keys)
+	}
+}
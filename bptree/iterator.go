@@ -3,39 +3,106 @@ package bptree
 import (
 	"bytes"
 	"errors"
+	"iter"
 )
 
+// rangeBatchSize is the number of pairs Range copies out per lock acquisition.
+const rangeBatchSize = 128
+
 // GetRange returns all key-value pairs in the range [startKey, endKey].
-// Thread-safe: acquires read lock on tree.
-func (t *Btree) GetRange(startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
+// Thread-safe: read-latches the path it traverses.
+func (t *Btree) GetRange(startKey, endKey []byte) (keys []Keytype, values []Valuetype, err error) {
+	defer t.guard("GetRange", nil, &err)
+	if err := t.Err(); err != nil {
+		return nil, nil, err
+	}
+
 	t.treeLock.RLock()
 	defer t.treeLock.RUnlock()
 
-	if t.root == nil {
+	root := t.rlockRoot()
+	if root == nil {
 		return nil, nil, nil
 	}
+	defer root.mu.RUnlock()
 	if bytes.Compare(startKey, endKey) > 0 {
 		return nil, nil, errors.New("invalid range: startKey is greater than endKey")
 	}
 
+	keys = make([]Keytype, 0)
+	values = make([]Valuetype, 0)
+	root.getRange(startKey, endKey, &keys, &values)
+	return keys, values, nil
+}
+
+// KeysInRange returns copies of the keys in the range [startKey, endKey] in
+// ascending order, without copying values.
+func (t *Btree) KeysInRange(startKey, endKey []byte) ([]Keytype, error) {
 	keys := make([]Keytype, 0)
+	err := t.ScanRange(startKey, endKey, func(key Keytype, _ Valuetype) bool {
+		keys = append(keys, append(Keytype(nil), key...))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ValuesInRange returns copies of the values of the keys in the range
+// [startKey, endKey] in key order, without copying keys.
+func (t *Btree) ValuesInRange(startKey, endKey []byte) ([]Valuetype, error) {
 	values := make([]Valuetype, 0)
-	t.root.getRange(startKey, endKey, &keys, &values)
-	return keys, values, nil
+	err := t.ScanRange(startKey, endKey, func(_ Keytype, value Valuetype) bool {
+		values = append(values, append(Valuetype{}, value...))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ScanRange streams key-value pairs in [startKey, endKey] to fn in ascending
+// key order, stopping early if fn returns false. Unlike GetRange it never
+// materializes the whole range.
+// Thread-safe: fn runs while the scanned path is read-latched, so it must not
+// call back into the tree. The slices passed to fn are the stored ones: copy
+// them to retain them. In panic-free mode a panic in fn fails the tree too,
+// as it leaves the scanned path latched.
+func (t *Btree) ScanRange(startKey, endKey []byte, fn func(key Keytype, value Valuetype) bool) (err error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return errors.New("invalid range: startKey is greater than endKey")
+	}
+	defer t.guard("ScanRange", nil, &err)
+	if err := t.Err(); err != nil {
+		return err
+	}
+
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	root := t.rlockRoot()
+	if root == nil {
+		return nil
+	}
+	defer root.mu.RUnlock()
+	root.scan(startKey, endKey, true, fn)
+	return nil
 }
 
-// getRange collects key-value pairs in the range. Called under treeLock.
+// getRange collects key-value pairs in the range.
+// The caller holds n read-latched; children are latched on the way down.
 func (n *Node) getRange(startKey, endKey []byte, keys *[]Keytype, values *[]Valuetype) {
 	pos := 0
-	for pos < len(n.keys) && bytes.Compare(n.keys[pos], startKey) < 0 {
+	for pos < len(n.keys) && n.compareKey(pos, startKey) < 0 {
 		pos++
 	}
 
 	if n.isleaf {
-		for i := pos; i < len(n.keys) && bytes.Compare(n.keys[i], endKey) <= 0; i++ {
+		for i := pos; i < len(n.keys) && n.compareKey(i, endKey) <= 0; i++ {
 			// Make copies
-			keyCopy := make([]byte, len(n.keys[i]))
-			copy(keyCopy, n.keys[i])
+			keyCopy := n.copyKey(i)
 			valueCopy := make([]byte, len(n.values[i]))
 			copy(valueCopy, n.values[i])
 			*keys = append(*keys, keyCopy)
@@ -46,41 +113,231 @@ func (n *Node) getRange(startKey, endKey []byte, keys *[]Keytype, values *[]Valu
 
 	// Internal node
 	if pos < len(n.children) {
-		n.children[pos].getRange(startKey, endKey, keys, values)
+		n.children[pos].rlockedGetRange(startKey, endKey, keys, values)
 	}
 
 	for i := pos; i < len(n.keys); i++ {
-		if bytes.Compare(n.keys[i], endKey) > 0 {
+		if n.compareKey(i, endKey) > 0 {
 			break
 		}
-		keyCopy := make([]byte, len(n.keys[i]))
-		copy(keyCopy, n.keys[i])
+		keyCopy := n.copyKey(i)
 		valueCopy := make([]byte, len(n.values[i]))
 		copy(valueCopy, n.values[i])
 		*keys = append(*keys, keyCopy)
 		*values = append(*values, valueCopy)
 
 		if i+1 < len(n.children) {
-			n.children[i+1].getRange(startKey, endKey, keys, values)
+			n.children[i+1].rlockedGetRange(startKey, endKey, keys, values)
 		}
 	}
 }
 
-// DeleteRange deletes all keys in the range [startKey, endKey].
-// Thread-safe: uses GetRange for snapshot, then Delete for each key.
+// rlockedGetRange read-latches n for the duration of getRange.
+func (n *Node) rlockedGetRange(startKey, endKey []byte, keys *[]Keytype, values *[]Valuetype) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	n.getRange(startKey, endKey, keys, values)
+}
+
+// DeleteRange deletes all keys in the range [startKey, endKey] and returns
+// how many it deleted. Atomic: it holds treeLock exclusively, so no other
+// operation sees the range partly deleted or inserts into it meanwhile.
 func (t *Btree) DeleteRange(startKey, endKey []byte) (int, error) {
-	// First, get a snapshot of keys to delete
-	keys, _, err := t.GetRange(startKey, endKey)
-	if err != nil {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return 0, errors.New("invalid range: startKey is greater than endKey")
+	}
+
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+	return t.deleteRangeLocked(startKey, endKey, nil)
+}
+
+// deleteRangeLocked deletes [startKey, endKey], passing copies of each
+// deleted pair to onDelete unless it is nil. Called with treeLock held
+// exclusively; latches are still taken so the usual delete path applies.
+func (t *Btree) deleteRangeLocked(startKey, endKey []byte, onDelete func(key Keytype, value Valuetype)) (deleted int, err error) {
+	defer t.guard("DeleteRange", nil, &err)
+	if err := t.Err(); err != nil {
 		return 0, err
 	}
 
-	deletedCount := 0
-	for _, key := range keys {
-		if t.Delete(key) {
-			deletedCount++
+	root := t.rlockRoot()
+	if root == nil {
+		return 0, nil
+	}
+	var keys []Keytype
+	var values []Valuetype
+	root.getRange(startKey, endKey, &keys, &values)
+	root.mu.RUnlock()
+
+	for i, key := range keys {
+		p := t.latchForDelete(key)
+		removed := p.remove()
+		p.release()
+		if removed {
+			deleted++
+			if onDelete != nil {
+				onDelete(key, values[i])
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// All returns an iterator over every key-value pair in ascending key order.
+// See Range for the locking contract.
+func (t *Btree) All() iter.Seq2[[]byte, []byte] {
+	return t.iterate(nil, nil, false)
+}
+
+// Range returns an iterator over key-value pairs in [startKey, endKey] in
+// ascending key order. An inverted range yields nothing.
+//
+// Pairs are copied out in batches under the read lock, which is released
+// before yielding, so the loop body may safely modify the tree. Such writes
+// may or may not be observed by the remainder of the iteration.
+func (t *Btree) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return t.iterate(startKey, endKey, true)
+}
+
+// iterate yields pairs from startKey onwards, stopping after endKey when bounded.
+func (t *Btree) iterate(startKey, endKey []byte, bounded bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		if bounded && bytes.Compare(startKey, endKey) > 0 {
+			return
+		}
+
+		next := startKey
+		for {
+			keys, values := t.collectBatch(next, endKey, bounded, rangeBatchSize)
+			for i := range keys {
+				if !yield(keys[i], values[i]) {
+					return
+				}
+			}
+			if len(keys) < rangeBatchSize {
+				return
+			}
+			// Resume at the smallest key strictly greater than the last one seen
+			last := keys[len(keys)-1]
+			next = append(last[:len(last):len(last)], 0)
+		}
+	}
+}
+
+// collectBatch copies up to limit pairs starting at startKey, up to endKey
+// when bounded. A bounded batch is streamed through ScanRange, which the
+// ShardedBTree range reads use this way, a batch per shard at a time.
+// Thread-safe: read-latches the path it traverses.
+func (t *Btree) collectBatch(startKey, endKey []byte, bounded bool, limit int) ([]Keytype, []Valuetype) {
+	keys := make([]Keytype, 0, limit)
+	values := make([]Valuetype, 0, limit)
+	collect := func(key Keytype, value Valuetype) bool {
+		keyCopy := make([]byte, len(key))
+		copy(keyCopy, key)
+		valueCopy := make([]byte, len(value))
+		copy(valueCopy, value)
+		keys = append(keys, keyCopy)
+		values = append(values, valueCopy)
+		return len(keys) < limit
+	}
+	if bounded {
+		// An error (a resumed start past endKey, a failed tree) ends the range
+		t.ScanRange(startKey, endKey, collect)
+		return keys, values
+	}
+
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	root := t.rlockRoot()
+	if root == nil {
+		return nil, nil
+	}
+	defer root.mu.RUnlock()
+	root.scan(startKey, endKey, false, collect)
+	return keys, values
+}
+
+// collectBatchReverse copies up to limit pairs at or below endKey (strictly
+// below it if exclusive) and at or above startKey, in descending order.
+// Thread-safe: read-latches the path it traverses.
+func (t *Btree) collectBatchReverse(startKey, endKey []byte, exclusive bool, limit int) ([]Keytype, []Valuetype) {
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	root := t.rlockRoot()
+	if root == nil {
+		return nil, nil
+	}
+	defer root.mu.RUnlock()
+
+	keys := make([]Keytype, 0, limit)
+	values := make([]Valuetype, 0, limit)
+	root.scanReverse(startKey, endKey, exclusive, func(key Keytype, value Valuetype) bool {
+		keys = append(keys, append(Keytype(nil), key...))
+		values = append(values, append(Valuetype{}, value...))
+		return len(keys) < limit
+	})
+	return keys, values
+}
+
+// scanReverse is scan in descending order: it visits pairs with key <=
+// endKey (< endKey if exclusive) and >= startKey until fn returns false.
+func (n *Node) scanReverse(startKey, endKey []byte, exclusive bool, fn func(key Keytype, value Valuetype) bool) bool {
+	i := n.findindex(endKey)
+	if i < len(n.keys) && !exclusive && n.compareKey(i, endKey) == 0 {
+		if !fn(n.key(i), n.values[i]) {
+			return false
 		}
 	}
+	for ; i >= 0; i-- {
+		if !n.isleaf && i < len(n.children) {
+			child := n.children[i]
+			child.mu.RLock()
+			more := child.scanReverse(startKey, endKey, exclusive, fn)
+			child.mu.RUnlock()
+			if !more {
+				return false
+			}
+		}
+		if i == 0 {
+			break
+		}
+		if n.compareKey(i-1, startKey) < 0 {
+			return false
+		}
+		if !fn(n.key(i-1), n.values[i-1]) {
+			return false
+		}
+	}
+	return true
+}
 
-	return deletedCount, nil
+// scan visits pairs with key >= startKey (and <= endKey when bounded) in
+// ascending order until fn returns false. Returns false if the scan stopped
+// early. The caller holds n read-latched; children are latched on the way
+// down. fn receives the stored slices, not copies.
+func (n *Node) scan(startKey, endKey []byte, bounded bool, fn func(key Keytype, value Valuetype) bool) bool {
+	for i := n.findindex(startKey); i <= len(n.keys); i++ {
+		if !n.isleaf && i < len(n.children) {
+			child := n.children[i]
+			child.mu.RLock()
+			more := child.scan(startKey, endKey, bounded, fn)
+			child.mu.RUnlock()
+			if !more {
+				return false
+			}
+		}
+		if i == len(n.keys) {
+			break
+		}
+		if bounded && n.compareKey(i, endKey) > 0 {
+			return false
+		}
+		if !fn(n.key(i), n.values[i]) {
+			return false
+		}
+	}
+	return true
 }
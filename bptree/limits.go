@@ -0,0 +1,67 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooLarge is matched by errors.Is for every SizeLimitError.
+var ErrTooLarge = errors.New("key or value too large")
+
+// SizeLimitError reports a key or value over a configured size limit
+// (Btree.SetSizeLimits, ShardConfig, WALConfig, DurableConfig).
+type SizeLimitError struct {
+	Field string // "key" or "value"
+	Size  int
+	Limit int
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("%v: %s is %d bytes, limit %d", ErrTooLarge, e.Field, e.Size, e.Limit)
+}
+
+func (e *SizeLimitError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// checkSizes returns a SizeLimitError if key or value exceeds its limit.
+// A limit of 0 or less is no limit.
+func checkSizes(key, value []byte, maxKeySize, maxValueSize int) error {
+	if maxKeySize > 0 && len(key) > maxKeySize {
+		return &SizeLimitError{Field: "key", Size: len(key), Limit: maxKeySize}
+	}
+	if maxValueSize > 0 && len(value) > maxValueSize {
+		return &SizeLimitError{Field: "value", Size: len(value), Limit: maxValueSize}
+	}
+	return nil
+}
+
+// SetSizeLimits bounds the keys and values the tree accepts, in bytes; 0
+// removes a limit. TryInsert and BulkLoad report a SizeLimitError for an
+// oversized entry, Insert panics with it, and Modify and CompareAndSwap do
+// not write it. Existing entries are unaffected.
+func (t *Btree) SetSizeLimits(maxKeySize, maxValueSize int) {
+	t.maxKeySize.Store(int64(maxKeySize))
+	t.maxValueSize.Store(int64(maxValueSize))
+}
+
+// checkSizes applies the tree's size limits.
+func (t *Btree) checkSizes(key, value []byte) error {
+	return checkSizes(key, value, int(t.maxKeySize.Load()), int(t.maxValueSize.Load()))
+}
+
+// checkSizes applies the tree's size limits, which every shard shares.
+func (s *ShardedBTree) checkSizes(key, value []byte) error {
+	s.pin()
+	defer s.unpin()
+	return s.shards[0].checkSizes(key, value)
+}
+
+// SetSizeLimits sets the size limits of every shard. See Btree.SetSizeLimits.
+func (s *ShardedBTree) SetSizeLimits(maxKeySize, maxValueSize int) {
+	s.pin()
+	defer s.unpin()
+	for _, shard := range s.shards {
+		shard.SetSizeLimits(maxKeySize, maxValueSize)
+	}
+}
@@ -0,0 +1,152 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBtreeSizeLimits(t *testing.T) {
+	tree := &Btree{}
+	tree.SetSizeLimits(8, 16)
+	big := bytes.Repeat([]byte("x"), 17)
+
+	err := tree.TryInsert([]byte("key"), big)
+	var limitErr *SizeLimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrTooLarge) || limitErr.Field != "value" || limitErr.Size != 17 || limitErr.Limit != 16 {
+		t.Fatalf("TryInsert of oversized value = %v", err)
+	}
+	if err := tree.TryInsert([]byte("much-too-long-key"), nil); !errors.As(err, &limitErr) || limitErr.Field != "key" {
+		t.Errorf("TryInsert of oversized key = %v", err)
+	}
+
+	tree.Insert([]byte("a"), []byte("small"))
+	if tree.Modify([]byte("a"), func(Valuetype, bool) (Valuetype, bool) { return big, true }) {
+		t.Error("Modify wrote an oversized value")
+	}
+	if tree.CompareAndSwap([]byte("a"), []byte("small"), big) {
+		t.Error("CompareAndSwap wrote an oversized value")
+	}
+	if written, err := tree.TryModify([]byte("a"), func(Valuetype, bool) (Valuetype, bool) { return big, true }); written || !errors.As(err, &limitErr) || limitErr.Field != "value" {
+		t.Errorf("TryModify of oversized value = %v, %v", written, err)
+	}
+	if written, err := tree.TryModify([]byte("b"), func(Valuetype, bool) (Valuetype, bool) { return big, true }); written || !errors.Is(err, ErrTooLarge) {
+		t.Errorf("TryModify inserting oversized value = %v, %v", written, err)
+	}
+	if written, err := tree.TryModify([]byte("a"), func(Valuetype, bool) (Valuetype, bool) { return nil, false }); written || err != nil {
+		t.Errorf("TryModify declined = %v, %v", written, err)
+	}
+	if swapped, err := tree.TryCompareAndSwap([]byte("a"), []byte("small"), big); swapped || !errors.As(err, &limitErr) || limitErr.Field != "value" {
+		t.Errorf("TryCompareAndSwap of oversized value = %v, %v", swapped, err)
+	}
+	if swapped, err := tree.TryCompareAndSwap([]byte("a"), []byte("other"), []byte("new")); swapped || err != nil {
+		t.Errorf("TryCompareAndSwap mismatch = %v, %v", swapped, err)
+	}
+	if value, _ := tree.Find([]byte("a")); string(value) != "small" || tree.Len() != 1 {
+		t.Errorf("Tree changed: a = %q, Len %d", value, tree.Len())
+	}
+
+	if err := (&Btree{}).BulkLoad([]Keytype{[]byte("a")}, []Valuetype{big}); err != nil {
+		t.Errorf("BulkLoad without limits = %v", err)
+	}
+	limited := &Btree{}
+	limited.SetSizeLimits(0, 16)
+	if err := limited.BulkLoad([]Keytype{[]byte("a"), []byte("b")}, []Valuetype{nil, big}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("BulkLoad of oversized value = %v", err)
+	}
+}
+
+func TestInsertOversizedPanics(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 17)
+	expectPanic := func(name string, insert func()) {
+		t.Helper()
+		defer func() {
+			err, _ := recover().(error)
+			var limitErr *SizeLimitError
+			if !errors.As(err, &limitErr) || limitErr.Field != "value" {
+				t.Errorf("%s of an oversized value panicked with %v, want a SizeLimitError", name, err)
+			}
+		}()
+		insert()
+	}
+
+	tree := &Btree{}
+	tree.SetSizeLimits(0, 16)
+	expectPanic("Btree.Insert", func() { tree.Insert([]byte("a"), big) })
+	sharded := NewShardedBTree(ShardConfig{NumShards: 2, MaxValueSize: 16})
+	expectPanic("ShardedBTree.Insert", func() { sharded.Insert([]byte("a"), big) })
+	if tree.Len() != 0 || sharded.Count() != 0 {
+		t.Error("Oversized entry was written")
+	}
+}
+
+func TestIndexedSizeLimits(t *testing.T) {
+	db := newIndexedBTreeOn(NewShardedBTree(ShardConfig{NumShards: 2, MaxValueSize: 32}), IndexedConfig{})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.Insert([]byte("user:1"), []byte(`{"email":"a@example.com"}`))
+	padded := []byte(`{"email":"b@example.com","pad":"xxxxxxxxxx"}`)
+
+	if err := db.Insert([]byte("user:2"), padded); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Insert of an oversized record = %v", err)
+	}
+	if err := db.Update([]byte("user:1"), padded); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Update to an oversized record = %v", err)
+	}
+	if err := db.InsertMany(map[string][]byte{"user:3": padded}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("InsertMany of an oversized record = %v", err)
+	}
+
+	// The failed writes released their claim on the email
+	if err := db.Insert([]byte("user:4"), []byte(`{"email":"b@example.com"}`)); err != nil {
+		t.Errorf("Insert of the email the failed writes claimed = %v", err)
+	}
+	if value, _ := db.Find([]byte("user:1")); string(value) != `{"email":"a@example.com"}` {
+		t.Errorf("Failed update changed the record to %s", value)
+	}
+}
+
+func TestDurableSizeLimits(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Insert([]byte("old"), bytes.Repeat([]byte("x"), 100)) // Logged before any limit
+	db.Close()
+
+	db, err = NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone, MaxValueSize: 64})
+	if err != nil {
+		t.Fatalf("Replay under a tighter limit failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Find([]byte("old")); err != nil {
+		t.Error("Entry logged before the limit was lost")
+	}
+
+	sequence := db.WALSequence()
+	if err := db.Insert([]byte("new"), make([]byte, 65)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Insert of oversized value = %v", err)
+	}
+	err = db.BulkInsert([]Keytype{[]byte("a"), []byte("b")}, []Valuetype{[]byte("ok"), make([]byte, 65)})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("BulkInsert with an oversized value = %v", err)
+	}
+	if db.WALSequence() != sequence || db.Count() != 1 {
+		t.Errorf("Rejected writes reached the WAL (sequence %d → %d) or tree (%d keys)", sequence, db.WALSequence(), db.Count())
+	}
+}
+
+func TestWALSizeLimits(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), MaxKeySize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	if _, err := wal.AppendInsert([]byte("toolong"), nil); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("AppendInsert of oversized key = %v", err)
+	}
+	if _, err := wal.AppendDelete([]byte("ok")); err != nil {
+		t.Errorf("AppendDelete = %v", err)
+	}
+}
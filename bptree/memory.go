@@ -0,0 +1,37 @@
+package bptree
+
+import "unsafe"
+
+// nodeBytes is the size of a node with full key, value and child slices,
+// not counting the bytes the keys and values point to.
+const nodeBytes = int64(unsafe.Sizeof(Node{})) +
+	MaxKeys*int64(unsafe.Sizeof(Keytype(nil))+unsafe.Sizeof(Valuetype(nil))) +
+	(MaxKeys+1)*int64(unsafe.Sizeof((*Node)(nil)))
+
+// MemoryUsage estimates the bytes the tree holds in nodes, keys and values,
+// for enforcing memory budgets. O(1): node and byte counts are kept as the
+// tree changes.
+//
+// LIMITATIONS:
+// - Keys count at full length, though prefix compression stores less of them
+// - Node versions kept for live snapshots, and pooled nodes, are not counted
+// - Allocator and garbage collector overhead is not counted
+func (t *Btree) MemoryUsage() int64 {
+	return t.nodes.Load()*nodeBytes + t.dataBytes.Load()
+}
+
+// entryBytes returns the bytes of the key and value at position i.
+func (n *Node) entryBytes(i int) int64 {
+	return int64(len(n.prefix) + len(n.keys[i]) + len(n.values[i]))
+}
+
+// MemoryUsage sums the estimates of every shard. See Btree.MemoryUsage.
+func (s *ShardedBTree) MemoryUsage() int64 {
+	s.pin()
+	defer s.unpin()
+	var total int64
+	for _, shard := range s.shards {
+		total += shard.MemoryUsage()
+	}
+	return total
+}
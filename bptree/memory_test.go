@@ -0,0 +1,48 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	tree := &Btree{}
+	if usage := tree.MemoryUsage(); usage != 0 {
+		t.Fatalf("Empty tree uses %d bytes", usage)
+	}
+
+	for i := 0; i < 500; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 10))
+	}
+	filled := tree.MemoryUsage()
+	if filled < 500*16 {
+		t.Errorf("MemoryUsage = %d, below the %d bytes of keys and values", filled, 500*16)
+	}
+
+	// Growing a value grows the estimate by exactly the difference
+	tree.Insert([]byte("key000"), make([]byte, 110))
+	if grown := tree.MemoryUsage(); grown != filled+100 {
+		t.Errorf("After growing a value MemoryUsage = %d, want %d", grown, filled+100)
+	}
+
+	for i := 0; i < 500; i += 2 {
+		tree.Delete([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if usage := tree.MemoryUsage(); usage >= filled {
+		t.Errorf("MemoryUsage did not shrink on delete: %d, was %d", usage, filled)
+	}
+
+	tree.Clear()
+	if usage := tree.MemoryUsage(); usage != 0 {
+		t.Errorf("After Clear MemoryUsage = %d", usage)
+	}
+
+	sharded := NewShardedBTree(ShardConfig{NumShards: 4})
+	sharded.Insert([]byte("a"), []byte("b"))
+	if usage := sharded.MemoryUsage(); usage != nodeBytes+2 {
+		t.Errorf("ShardedBTree.MemoryUsage = %d, want %d", usage, nodeBytes+2)
+	}
+}
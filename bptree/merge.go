@@ -0,0 +1,335 @@
+package bptree
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"iter"
+	"time"
+)
+
+// mergeCursor walks one shard's pairs in ascending key order, a batch at a
+// time.
+type mergeCursor struct {
+	keys   []Keytype
+	values []Valuetype
+	pos    int
+	fill   func() ([]Keytype, []Valuetype) // Next batch, empty once exhausted
+}
+
+// load replaces the batch with the next one and reports whether it has pairs.
+func (c *mergeCursor) load() bool {
+	c.keys, c.values = c.fill()
+	c.pos = 0
+	return len(c.keys) > 0
+}
+
+// advance moves to the next pair and reports whether there is one.
+func (c *mergeCursor) advance() bool {
+	c.pos++
+	return c.pos < len(c.keys) || c.load()
+}
+
+// mergeHeap orders cursors by their current key.
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].keys[h[i].pos], h[j].keys[h[j].pos]) < 0
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// descMergeHeap orders cursors by their current key, largest first.
+type descMergeHeap struct{ *mergeHeap }
+
+func (h descMergeHeap) Less(i, j int) bool { return h.mergeHeap.Less(j, i) }
+
+// mergeCursors yields the pairs of all cursors in ascending key order, until
+// yield returns false. Each cursor's pairs must be ascending and keys must
+// not repeat across cursors. O(log cursors) per pair.
+func mergeCursors(cursors []*mergeCursor, yield func(Keytype, Valuetype) bool) {
+	mergeOrdered(cursors, false, yield)
+}
+
+// mergeCursorsReverse is mergeCursors for cursors whose pairs are
+// descending, yielding in descending key order.
+func mergeCursorsReverse(cursors []*mergeCursor, yield func(Keytype, Valuetype) bool) {
+	mergeOrdered(cursors, true, yield)
+}
+
+// mergeOrdered merges cursors ascending, or descending if desc.
+func mergeOrdered(cursors []*mergeCursor, desc bool, yield func(Keytype, Valuetype) bool) {
+	h := make(mergeHeap, 0, len(cursors))
+	for _, c := range cursors {
+		if c.load() {
+			h = append(h, c)
+		}
+	}
+	var ordered heap.Interface = &h
+	if desc {
+		ordered = descMergeHeap{&h}
+	}
+	heap.Init(ordered)
+
+	for len(h) > 0 {
+		c := h[0]
+		if !yield(c.keys[c.pos], c.values[c.pos]) {
+			return
+		}
+		if c.advance() {
+			heap.Fix(ordered, 0)
+		} else {
+			heap.Pop(ordered)
+		}
+	}
+}
+
+// sliceCursor returns a cursor over pairs already in memory.
+func sliceCursor(keys []Keytype, values []Valuetype) *mergeCursor {
+	used := false
+	return &mergeCursor{fill: func() ([]Keytype, []Valuetype) {
+		if used {
+			return nil, nil
+		}
+		used = true
+		return keys, values
+	}}
+}
+
+// shardCursor returns a cursor copying pairs from startKey onwards (up to
+// endKey when bounded) out of shard idx in batches of up to batchSize pairs,
+// skipping expired keys. Called pinned; the cursor itself reads without the
+// pin.
+func (s *ShardedBTree) shardCursor(idx int, startKey, endKey []byte, bounded bool, batchSize int) *mergeCursor {
+	return newShardCursor(s.shards[idx], s.ttlShard(idx), startKey, endKey, bounded, batchSize)
+}
+
+// newShardCursor is shardCursor over shard, hiding the keys ts says have
+// expired (ts may be nil).
+func newShardCursor(shard *Btree, ts *ttlShard, startKey, endKey []byte, bounded bool, batchSize int) *mergeCursor {
+	next, done := startKey, false
+	return &mergeCursor{fill: func() ([]Keytype, []Valuetype) {
+		for !done {
+			keys, values := shard.collectBatch(next, endKey, bounded, batchSize)
+			if len(keys) < batchSize {
+				done = true
+			} else {
+				// Resume at the smallest key strictly greater than the last one seen
+				last := keys[len(keys)-1]
+				next = append(last[:len(last):len(last)], 0)
+			}
+			if ts != nil {
+				keys, values = ts.unexpired(keys, values)
+			}
+			if len(keys) > 0 {
+				return keys, values
+			}
+		}
+		return nil, nil
+	}}
+}
+
+// newShardCursorReverse is newShardCursor for pairs in [startKey, endKey]
+// in descending order.
+func newShardCursorReverse(shard *Btree, ts *ttlShard, startKey, endKey []byte, batchSize int) *mergeCursor {
+	next, exclusive, done := endKey, false, false
+	return &mergeCursor{fill: func() ([]Keytype, []Valuetype) {
+		for !done {
+			keys, values := shard.collectBatchReverse(startKey, next, exclusive, batchSize)
+			if len(keys) < batchSize {
+				done = true
+			} else {
+				// Resume strictly below the last key seen
+				next, exclusive = keys[len(keys)-1], true
+			}
+			if ts != nil {
+				keys, values = ts.unexpired(keys, values)
+			}
+			if len(keys) > 0 {
+				return keys, values
+			}
+		}
+		return nil, nil
+	}}
+}
+
+// unexpired filters out the pairs whose key has expired.
+func (ts *ttlShard) unexpired(keys []Keytype, values []Valuetype) ([]Keytype, []Valuetype) {
+	ts.rlock()
+	defer ts.runlock()
+	cutoff := ttlNow()
+	kept := 0
+	for i, key := range keys {
+		if !ts.expired(key, cutoff) {
+			keys[kept], values[kept] = key, values[i]
+			kept++
+		}
+	}
+	return keys[:kept], values[:kept]
+}
+
+// rangeCursors returns a cursor from startKey onwards (up to endKey when
+// bounded) for every shard.
+func (s *ShardedBTree) rangeCursors(startKey, endKey []byte, bounded bool, batchSize int) []*mergeCursor {
+	s.pin()
+	defer s.unpin()
+	return s.pinnedRangeCursors(startKey, endKey, bounded, batchSize)
+}
+
+// pinnedRangeCursors is rangeCursors called pinned. Merging the cursors
+// before unpinning keeps a migration from moving keys between them.
+func (s *ShardedBTree) pinnedRangeCursors(startKey, endKey []byte, bounded bool, batchSize int) []*mergeCursor {
+	if bounded {
+		if only := s.rangeShard(startKey, endKey); only >= 0 {
+			return []*mergeCursor{s.shardCursor(only, startKey, endKey, bounded, batchSize)}
+		}
+	}
+	cursors := make([]*mergeCursor, len(s.shards))
+	for i := range s.shards {
+		cursors[i] = s.shardCursor(i, startKey, endKey, bounded, batchSize)
+	}
+	return cursors
+}
+
+// rangeCursorsReverse returns a cursor over [startKey, endKey] in
+// descending order for every shard, or for the one shard holding the range.
+func (s *ShardedBTree) rangeCursorsReverse(startKey, endKey []byte, batchSize int) []*mergeCursor {
+	s.pin()
+	defer s.unpin()
+	shards := s.rangeShards(startKey, endKey)
+	cursors := make([]*mergeCursor, len(shards))
+	for i, idx := range shards {
+		cursors[i] = newShardCursorReverse(s.shards[idx], s.ttlShard(idx), startKey, endKey, batchSize)
+	}
+	return cursors
+}
+
+// timedRangeCursors is pinnedRangeCursors over [startKey, endKey] counting
+// op on every shard read. The time a timed shard's cursor spends copying is
+// recorded as one sample once the cursor is exhausted. The cursors must be
+// merged before unpinning.
+func (s *ShardedBTree) timedRangeCursors(startKey, endKey []byte, op LatencyOp, batchSize int) []*mergeCursor {
+	shards := s.rangeShards(startKey, endKey)
+	cursors := make([]*mergeCursor, len(shards))
+	for i, idx := range shards {
+		c := s.shardCursor(idx, startKey, endKey, true, batchSize)
+		if start := s.startTimer(idx, op); !start.IsZero() {
+			c.fill = s.timedFill(idx, op, c.fill)
+		}
+		cursors[i] = c
+	}
+	return cursors
+}
+
+// timedFill wraps fill to record the time spent in it on shard idx once it
+// returns an empty batch. The returned fill is called pinned.
+func (s *ShardedBTree) timedFill(idx int, op LatencyOp, fill func() ([]Keytype, []Valuetype)) func() ([]Keytype, []Valuetype) {
+	var elapsed time.Duration
+	return func() ([]Keytype, []Valuetype) {
+		start := time.Now()
+		keys, values := fill()
+		elapsed += time.Since(start)
+		if len(keys) == 0 {
+			s.record(idx, op, elapsed)
+		}
+		return keys, values
+	}
+}
+
+// rangeShards returns the indexes of the shards that may hold keys in
+// [startKey, endKey]: the one the range maps to (see rangeShard), or all.
+// Called pinned.
+func (s *ShardedBTree) rangeShards(startKey, endKey []byte) []int {
+	if only := s.rangeShard(startKey, endKey); only >= 0 {
+		return []int{only}
+	}
+	shards := make([]int, len(s.shards))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+// affinityCursors is rangeCursors for a bounded range the caller knows lies
+// within startKey's affinity prefix, though the bounds need not show it:
+// one cursor over that prefix's shard, or one per shard during a Resize.
+func (s *ShardedBTree) affinityCursors(startKey, endKey []byte, batchSize int) []*mergeCursor {
+	s.pin()
+	if s.affinity == 0 || s.migration != nil {
+		s.unpin()
+		return s.rangeCursors(startKey, endKey, true, batchSize)
+	}
+	defer s.unpin()
+	return []*mergeCursor{s.shardCursor(s.getShardIndex(startKey), startKey, endKey, true, batchSize)}
+}
+
+// GetRangeLimit returns the first limit key-value pairs in the range
+// [startKey, endKey], in ascending key order. A limit of 0 or less returns
+// the whole range, as GetRange does.
+//
+// DESIGN:
+// - Merges per-shard cursors through a heap, so shards are read only as far as the result needs
+// - Each shard copies out at most about limit pairs, in batches each streamed by one ScanRange call
+//
+// - Holds the layout pinned for the whole merge, so a Resize or Rebalance cannot move keys under it
+//
+// LIMITATIONS:
+// - Not a snapshot: writes made while it runs may or may not be included
+// - A migration batch waits for the merge to finish
+func (s *ShardedBTree) GetRangeLimit(startKey, endKey []byte, limit int) ([]Keytype, []Valuetype, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	if limit <= 0 {
+		return s.GetRange(startKey, endKey)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	batchSize := min(limit, rangeBatchSize)
+	keys := make([]Keytype, 0, batchSize)
+	values := make([]Valuetype, 0, batchSize)
+	s.pin()
+	defer s.unpin()
+	mergeCursors(s.pinnedRangeCursors(startKey, endKey, true, batchSize), func(key Keytype, value Valuetype) bool {
+		keys, values = append(keys, key), append(values, value)
+		return len(keys) < limit
+	})
+	return keys, values, nil
+}
+
+// Range returns an iterator over key-value pairs in [startKey, endKey]
+// in ascending key order across all shards. An inverted range yields nothing.
+//
+// Shards are merged lazily, a batch at a time, and no lock is held while the
+// loop body runs. As with All, keys migrating during a Resize may be missed
+// or repeated.
+func (s *ShardedBTree) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		if bytes.Compare(startKey, endKey) > 0 {
+			return
+		}
+		mergeCursors(s.rangeCursors(startKey, endKey, true, rangeBatchSize), func(key Keytype, value Valuetype) bool {
+			return yield(key, value)
+		})
+	}
+}
+
+// ForEachOrdered iterates over all key-value pairs in ascending key order
+// across all shards, for exports, backups and verification tools that need a
+// canonical ordering.
+//
+// Shards are merged lazily, a batch at a time, and no lock is held while
+// callback runs, so callback may write to the tree. Not a snapshot: writes
+// made during the walk may or may not be seen.
+func (s *ShardedBTree) ForEachOrdered(callback func(key Keytype, value Valuetype) bool) {
+	mergeCursors(s.rangeCursors(nil, nil, false, rangeBatchSize), callback)
+}
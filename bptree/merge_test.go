@@ -0,0 +1,169 @@
+package bptree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetRangeLimit(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	const n = 1000
+	for i := 0; i < n; i++ {
+		tree.Insert([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	for _, limit := range []int{1, 7, 128, 129, 500, n + 10} {
+		keys, values, err := tree.GetRangeLimit([]byte("0100"), []byte("0899"), limit)
+		if err != nil {
+			t.Fatalf("GetRangeLimit(%d): %v", limit, err)
+		}
+		want := min(limit, 800)
+		if len(keys) != want || len(values) != want {
+			t.Fatalf("GetRangeLimit(%d) returned %d pairs, want %d", limit, len(keys), want)
+		}
+		for i := range keys {
+			if string(keys[i]) != fmt.Sprintf("%04d", 100+i) || string(values[i]) != fmt.Sprintf("v%d", 100+i) {
+				t.Fatalf("GetRangeLimit(%d)[%d] = %q, %q", limit, i, keys[i], values[i])
+			}
+		}
+	}
+
+	if keys, _, _ := tree.GetRangeLimit([]byte("0000"), []byte("9999"), 0); len(keys) != n {
+		t.Errorf("GetRangeLimit with no limit returned %d pairs, want %d", len(keys), n)
+	}
+	if _, _, err := tree.GetRangeLimit([]byte("b"), []byte("a"), 10); err == nil {
+		t.Error("GetRangeLimit accepted an inverted range")
+	}
+}
+
+func TestRangeCursorsReverse(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	const n = 1000
+	for i := 0; i < n; i++ {
+		tree.Insert([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	for _, bounds := range [][2]string{{"0100", "0899"}, {"0100x", "0899x"}, {"", "9999"}, {"0500", "0500"}, {"2000", "3000"}} {
+		want := 0
+		got := 0
+		for i := n - 1; i >= 0; i-- {
+			if key := fmt.Sprintf("%04d", i); key >= bounds[0] && key <= bounds[1] {
+				want++
+			}
+		}
+		var last string
+		mergeCursorsReverse(tree.rangeCursorsReverse([]byte(bounds[0]), []byte(bounds[1]), 7), func(key Keytype, value Valuetype) bool {
+			if last != "" && string(key) >= last {
+				t.Fatalf("%v: yielded %q after %q", bounds, key, last)
+			}
+			if string(key) < bounds[0] || string(key) > bounds[1] || string(value) != fmt.Sprintf("v%d", atoiKey(key)) {
+				t.Fatalf("%v: yielded %q = %q", bounds, key, value)
+			}
+			last = string(key)
+			got++
+			return true
+		})
+		if got != want {
+			t.Errorf("%v: yielded %d pairs, want %d", bounds, got, want)
+		}
+	}
+}
+
+// atoiKey parses a zero-padded decimal key.
+func atoiKey(key []byte) int {
+	n := 0
+	for _, c := range key {
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+func TestRangeMergesLazily(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8, EnableTTL: true})
+	const n = 2000
+	for i := 0; i < n; i++ {
+		tree.Insert([]byte(fmt.Sprintf("%05d", i)), []byte("v"))
+	}
+	tree.InsertWithTTL([]byte("00500x"), []byte("gone"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// Keys come back in order across shards, without expired ones
+	seen := 0
+	var last string
+	for key := range tree.Range([]byte("00000"), []byte("99999")) {
+		if string(key) <= last {
+			t.Fatalf("Range yielded %q after %q", key, last)
+		}
+		if string(key) == "00500x" {
+			t.Fatal("Range yielded an expired key")
+		}
+		last = string(key)
+		seen++
+	}
+	if seen != n {
+		t.Fatalf("Range yielded %d pairs, want %d", seen, n)
+	}
+
+	// Breaking early stops the merge
+	seen = 0
+	for range tree.Range([]byte("00000"), []byte("99999")) {
+		if seen++; seen == 10 {
+			break
+		}
+	}
+	if seen != 10 {
+		t.Errorf("Range continued after break: %d", seen)
+	}
+	for range tree.Range([]byte("b"), []byte("a")) {
+		t.Fatal("Range over an inverted range yielded a pair")
+	}
+}
+
+func TestForEachOrdered(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8})
+	const n = 1500
+	for i := n - 1; i >= 0; i-- {
+		tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	i := 0
+	tree.ForEachOrdered(func(key Keytype, value Valuetype) bool {
+		if string(key) != fmt.Sprintf("key-%05d", i) || string(value) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("pair %d = %q, %q", i, key, value)
+		}
+		i++
+		return true
+	})
+	if i != n {
+		t.Fatalf("ForEachOrdered visited %d pairs, want %d", i, n)
+	}
+
+	// The callback may write to the tree, and returning false stops the walk
+	i = 0
+	tree.ForEachOrdered(func(key Keytype, value Valuetype) bool {
+		tree.Delete(key)
+		i++
+		return i < 100
+	})
+	if i != 100 || tree.Count() != n-100 {
+		t.Errorf("visited %d pairs, %d left, want 100 and %d", i, tree.Count(), n-100)
+	}
+}
+
+func TestDurableGetRangeLimit(t *testing.T) {
+	db, err := NewDurableBTree(DurableConfig{WALPath: filepath.Join(t.TempDir(), "test.wal"), NumShards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 300; i++ {
+		db.Insert([]byte(fmt.Sprintf("%03d", i)), []byte("v"))
+	}
+
+	keys, _, err := db.GetRangeLimit([]byte("050"), []byte("299"), 20)
+	if err != nil || len(keys) != 20 || string(keys[0]) != "050" || string(keys[19]) != "069" {
+		t.Errorf("GetRangeLimit = %q, %v", keys, err)
+	}
+}
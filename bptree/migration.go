@@ -0,0 +1,219 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Migration is a versioned, one-time transformation of stored data.
+//
+// DESIGN:
+// - Migrate applies migrations in Version order, each at most once
+// - Progress is recorded in a reserved keyspace of the tree itself, so it is as durable as the data
+// - A migration is marked running before Apply and applied after it, with the WAL synced each time
+// - A migration found still running was interrupted: Migrate re-runs it only if it is Idempotent
+//
+// LIMITATIONS:
+// - Migrate must run before the tree serves other writers
+// - Scans of the whole tree (ForEach, InRange) also see the reserved keys
+//
+// USAGE:
+//
+//	applied, err := db.Migrate([]Migration{
+//		{Version: 1, Description: "rename users", Apply: RenamePrefix([]byte("user:"), []byte("users/")), Idempotent: true},
+//		{Version: 2, Description: "upper-case names", Apply: ReencodeValues([]byte("users/"), upper)},
+//	})
+type Migration struct {
+	Version     uint64 // Unique and non-zero; order of application
+	Description string
+	Apply       func(db *DurableBTree) error
+
+	// Idempotent lets Migrate re-run the migration after a crash
+	// interrupted it. Leave unset unless running Apply twice is harmless.
+	Idempotent bool
+}
+
+// ErrMigrationInterrupted is returned by Migrate for a migration that did
+// not finish and cannot be safely re-run.
+var ErrMigrationInterrupted = errors.New("migration interrupted")
+
+// reservedKeyPrefix starts every key the store keeps for its own
+// bookkeeping, out of the way of user keys.
+const reservedKeyPrefix = "\x00stundb/"
+
+// migrationKeyPrefix is the reserved keyspace holding migration records:
+// prefix + big-endian version → state byte + description.
+const migrationKeyPrefix = reservedKeyPrefix + "migrations/"
+
+const (
+	migrationRunning byte = 'r'
+	migrationApplied byte = 'a'
+)
+
+// Migrate applies, in Version order, every migration not yet applied.
+// Returns the versions it applied, which is nothing when the data is current.
+func (db *DurableBTree) Migrate(migrations []Migration) ([]uint64, error) {
+	pending := make([]Migration, len(migrations))
+	copy(pending, migrations)
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	for i, m := range pending {
+		if m.Version == 0 || m.Apply == nil {
+			return nil, fmt.Errorf("migration %q needs a non-zero Version and an Apply function", m.Description)
+		}
+		if i > 0 && pending[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+	}
+
+	states := db.migrationStates()
+	var latest uint64
+	for version, state := range states {
+		if state == migrationApplied && version > latest {
+			latest = version
+		}
+	}
+
+	var applied []uint64
+	for _, m := range pending {
+		switch states[m.Version] {
+		case migrationApplied:
+			continue
+		case migrationRunning:
+			if !m.Idempotent {
+				return applied, fmt.Errorf("%w: version %d (%s)", ErrMigrationInterrupted, m.Version, m.Description)
+			}
+		default:
+			if m.Version < latest {
+				return applied, fmt.Errorf("migration %d is older than applied migration %d", m.Version, latest)
+			}
+		}
+
+		if err := db.recordMigration(m, migrationRunning); err != nil {
+			return applied, err
+		}
+		if err := m.Apply(db); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		if err := db.recordMigration(m, migrationApplied); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+// MigrationVersion returns the highest applied migration version, or 0.
+func (db *DurableBTree) MigrationVersion() uint64 {
+	var latest uint64
+	for version, state := range db.migrationStates() {
+		if state == migrationApplied && version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// migrationStates reads every migration record.
+func (db *DurableBTree) migrationStates() map[uint64]byte {
+	states := make(map[uint64]byte)
+	for key, value := range db.InRange(prefixRange([]byte(migrationKeyPrefix))) {
+		suffix := key[len(migrationKeyPrefix):]
+		if len(suffix) != 8 || len(value) == 0 {
+			continue
+		}
+		states[binary.BigEndian.Uint64(suffix)] = value[0]
+	}
+	return states
+}
+
+// recordMigration durably stores m's state.
+func (db *DurableBTree) recordMigration(m Migration, state byte) error {
+	key := binary.BigEndian.AppendUint64([]byte(migrationKeyPrefix), m.Version)
+	value := append([]byte{state}, m.Description...)
+	if err := db.Insert(key, value); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+	if err := db.Sync(); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// prefixRange returns the range of keys starting with prefix.
+func prefixRange(prefix []byte) KeyRange {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return KeyRange{Start: prefix, End: end[:i+1]}
+		}
+	}
+	return KeyRange{Start: prefix} // All 0xff: unbounded above
+}
+
+// isReservedKey reports whether key belongs to the store's bookkeeping.
+func isReservedKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(reservedKeyPrefix))
+}
+
+// ============================================================================
+// Common Migrations
+// ============================================================================
+
+// ReencodeValues returns an Apply function that rewrites the value of every
+// key starting with prefix to fn(key, value). An empty prefix covers all
+// user keys. Not idempotent unless fn is.
+func ReencodeValues(prefix []byte, fn func(key, value []byte) ([]byte, error)) func(*DurableBTree) error {
+	return func(db *DurableBTree) error {
+		for key, value := range db.InRange(prefixRange(prefix)) {
+			if isReservedKey(key) {
+				continue
+			}
+			encoded, err := fn(key, value)
+			if err != nil {
+				return fmt.Errorf("failed to re-encode %q: %w", key, err)
+			}
+			if err := db.Insert(key, encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RenamePrefix returns an Apply function that moves every key starting with
+// oldPrefix to the same key starting with newPrefix. Idempotent: after a
+// crash, re-running moves whatever was not yet moved.
+func RenamePrefix(oldPrefix, newPrefix []byte) func(*DurableBTree) error {
+	return func(db *DurableBTree) error {
+		if len(oldPrefix) == 0 || bytes.HasPrefix(newPrefix, oldPrefix) {
+			return fmt.Errorf("cannot rename prefix %q to %q", oldPrefix, newPrefix)
+		}
+
+		// Collect first: renamed keys may sort into the scanned range
+		var keys []Keytype
+		var values []Valuetype
+		for key, value := range db.InRange(prefixRange(oldPrefix)) {
+			if isReservedKey(key) {
+				continue
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+
+		for i, key := range keys {
+			renamed := append(bytes.Clone(newPrefix), key[len(oldPrefix):]...)
+			// Insert before deleting, so a crash never loses the record
+			if err := db.Insert(renamed, values[i]); err != nil {
+				return err
+			}
+			if _, err := db.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func openMigrationTestDB(t *testing.T, walPath string) *DurableBTree {
+	t.Helper()
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	return db
+}
+
+func TestMigrateAppliesOnceAcrossRestarts(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db := openMigrationTestDB(t, walPath)
+	for i := 0; i < 20; i++ {
+		db.Insert([]byte(fmt.Sprintf("user:%02d", i)), []byte("ada"))
+	}
+
+	runs := 0
+	migrations := []Migration{
+		{Version: 2, Description: "upper-case", Apply: ReencodeValues([]byte("users/"), func(_, value []byte) ([]byte, error) {
+			runs++
+			return bytes.ToUpper(value), nil
+		})},
+		{Version: 1, Description: "rename users", Apply: RenamePrefix([]byte("user:"), []byte("users/")), Idempotent: true},
+	}
+
+	applied, err := db.Migrate(migrations)
+	if err != nil || fmt.Sprint(applied) != "[1 2]" {
+		t.Fatalf("Migrate = %v, %v; want [1 2]", applied, err)
+	}
+	if value, err := db.Find([]byte("users/07")); err != nil || string(value) != "ADA" {
+		t.Errorf("users/07 = %q, %v", value, err)
+	}
+	if _, err := db.Find([]byte("user:07")); err == nil {
+		t.Error("Old key survived the rename")
+	}
+	db.Close()
+
+	// Reopening replays the WAL, migration records included
+	db = openMigrationTestDB(t, walPath)
+	defer db.Close()
+	if db.MigrationVersion() != 2 {
+		t.Errorf("MigrationVersion after restart = %d, want 2", db.MigrationVersion())
+	}
+	applied, err = db.Migrate(migrations)
+	if err != nil || len(applied) != 0 || runs != 20 {
+		t.Errorf("Second Migrate = %v, %v with %d re-encodes; want nothing new", applied, err, runs)
+	}
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	db := openMigrationTestDB(t, filepath.Join(t.TempDir(), "test.wal"))
+	defer db.Close()
+
+	// A crash between the running and applied records
+	m := Migration{Version: 1, Description: "risky", Apply: func(*DurableBTree) error { return nil }}
+	if err := db.recordMigration(m, migrationRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Migrate([]Migration{m}); !errors.Is(err, ErrMigrationInterrupted) {
+		t.Fatalf("Migrate = %v, want ErrMigrationInterrupted", err)
+	}
+
+	m.Idempotent = true
+	if applied, err := db.Migrate([]Migration{m}); err != nil || len(applied) != 1 {
+		t.Errorf("Idempotent re-run = %v, %v", applied, err)
+	}
+}
+
+func TestMigrateStopsAtFailure(t *testing.T) {
+	db := openMigrationTestDB(t, filepath.Join(t.TempDir(), "test.wal"))
+	defer db.Close()
+
+	ran := false
+	applied, err := db.Migrate([]Migration{
+		{Version: 1, Apply: func(*DurableBTree) error { return nil }},
+		{Version: 2, Apply: func(*DurableBTree) error { return errors.New("boom") }},
+		{Version: 3, Apply: func(*DurableBTree) error { ran = true; return nil }},
+	})
+	if err == nil || fmt.Sprint(applied) != "[1]" || ran {
+		t.Errorf("Migrate = %v, %v (version 3 ran: %v); want [1] and an error", applied, err, ran)
+	}
+	if db.MigrationVersion() != 1 {
+		t.Errorf("MigrationVersion = %d, want 1", db.MigrationVersion())
+	}
+}
+
+func TestMigrateRejectsBadMigrations(t *testing.T) {
+	db := openMigrationTestDB(t, filepath.Join(t.TempDir(), "test.wal"))
+	defer db.Close()
+	noop := func(*DurableBTree) error { return nil }
+
+	if _, err := db.Migrate([]Migration{{Version: 0, Apply: noop}}); err == nil {
+		t.Error("Expected error for version 0")
+	}
+	if _, err := db.Migrate([]Migration{{Version: 1, Apply: noop}, {Version: 1, Apply: noop}}); err == nil {
+		t.Error("Expected error for duplicate versions")
+	}
+
+	db.Migrate([]Migration{{Version: 5, Apply: noop}})
+	if _, err := db.Migrate([]Migration{{Version: 3, Apply: noop}, {Version: 5, Apply: noop}}); err == nil {
+		t.Error("Expected error for a migration older than an applied one")
+	}
+	if err := RenamePrefix([]byte("a"), []byte("ab"))(db); err == nil {
+		t.Error("Expected error renaming into an extension of the old prefix")
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	r := prefixRange([]byte("ab\xff"))
+	if !r.Contains([]byte("ab\xff\x00")) || r.Contains([]byte("ac")) || string(r.End) != "ac" {
+		t.Errorf("prefixRange(ab\\xff) = %q..%q", r.Start, r.End)
+	}
+	if r := prefixRange([]byte("\xff")); r.End != nil {
+		t.Errorf("prefixRange(\\xff) should be unbounded, got End %q", r.End)
+	}
+}
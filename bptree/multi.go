@@ -0,0 +1,164 @@
+package bptree
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// findMany looks each of keys up under a single treeLock acquisition,
+// passing found the index, a copy of the value and the error of each lookup.
+func (t *Btree) findMany(keys []Keytype, found func(i int, value Valuetype, err error)) {
+	if err := t.Err(); err != nil {
+		for i := range keys {
+			found(i, nil, err)
+		}
+		return
+	}
+
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
+	for i, key := range keys {
+		value, err := func() (value []byte, err error) {
+			defer t.guard("MultiGet", key, &err)
+			return t.lookup(key, func(stored []byte) []byte {
+				return append([]byte{}, stored...)
+			})
+		}()
+		found(i, value, err)
+	}
+}
+
+// MultiGet looks up many keys at once. values[i] and errs[i] are what Find
+// would return for keys[i].
+//
+// Keys are grouped by shard and the shards searched in parallel, each taking
+// its locks once for the whole group rather than once per key, which pays
+// off for callers fetching hundreds of keys.
+func (s *ShardedBTree) MultiGet(keys []Keytype) ([]Valuetype, []error) {
+	values := make([]Valuetype, len(keys))
+	errs := make([]error, len(keys))
+
+	// Group by shard
+	s.pin()
+	defer s.unpin()
+	shardGroups := make(map[int][]int) // shard index -> key indices
+	for i, key := range keys {
+		shardIdx := s.getShardIndex(key)
+		shardGroups[shardIdx] = append(shardGroups[shardIdx], i)
+	}
+	atomic.AddUint64(&s.totalFinds, uint64(len(keys)))
+
+	var wg sync.WaitGroup
+	for shardIdx, keyIndices := range shardGroups {
+		wg.Add(1)
+		go func(idx int, indices []int) {
+			defer wg.Done()
+			s.counters[idx].ops[LatencyFind].Add(uint64(len(indices)))
+			groupKeys := make([]Keytype, len(indices))
+			for i, keyIdx := range indices {
+				groupKeys[i] = keys[keyIdx]
+			}
+
+			ts := s.ttlShard(idx)
+			ts.rlock()
+			defer ts.runlock()
+			cutoff := ttlNow()
+			s.shards[idx].findMany(groupKeys, func(i int, value Valuetype, err error) {
+				if err == nil && ts.expired(groupKeys[i], cutoff) {
+					value, err = nil, errors.New("key not found")
+				}
+				values[indices[i]], errs[indices[i]] = value, err
+			})
+		}(shardIdx, keyIndices)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// extractMany deletes each of keys under a single treeLock acquisition,
+// passing removed the index and old value (the stored slice) of each key it
+// deleted. Stops at the first invariant violation.
+func (t *Btree) extractMany(keys []Keytype, removed func(i int, old Valuetype)) error {
+	if err := t.Err(); err != nil {
+		return err
+	}
+
+	t.waitRLock(&t.treeLock)
+	defer t.treeLock.RUnlock()
+	for i, key := range keys {
+		old, deleted, err := func() (old Valuetype, deleted bool, err error) {
+			defer t.guard("MultiDelete", key, &err)
+			old, deleted = t.remove(key)
+			return old, deleted, nil
+		}()
+		if err != nil {
+			return err
+		}
+		if deleted {
+			removed(i, old)
+		}
+	}
+	return nil
+}
+
+// MultiDelete deletes many keys at once and returns how many were present.
+// An expired key counts as absent.
+//
+// Like BulkInsert, keys are grouped by shard and each group deleted in
+// parallel under one acquisition of its shard's locks. Delete hooks fire
+// once every shard is done. In panic-free mode a shard stops at its first
+// invariant violation; Err reports it.
+func (s *ShardedBTree) MultiDelete(keys []Keytype) (deletedCount int) {
+	// Group by shard
+	s.pin()
+	shardGroups := make(map[int][]int) // shard index -> key indices
+	for i, key := range keys {
+		shardIdx := s.getShardIndex(key)
+		shardGroups[shardIdx] = append(shardGroups[shardIdx], i)
+	}
+
+	// Delete from each shard in parallel, keeping the hook events to fire
+	// once unpinned
+	hooks := s.hooked()
+	var wg sync.WaitGroup
+	var deleted atomic.Int64
+	events := make([][]keyValuePair, len(s.shards))
+
+	for shardIdx, keyIndices := range shardGroups {
+		wg.Add(1)
+		go func(idx int, indices []int) {
+			defer wg.Done()
+			s.counters[idx].ops[LatencyDelete].Add(uint64(len(indices)))
+			groupKeys := make([]Keytype, len(indices))
+			for i, keyIdx := range indices {
+				groupKeys[i] = keys[keyIdx]
+			}
+
+			ts := s.ttlShard(idx)
+			ts.lock()
+			cutoff := ttlNow()
+			s.shards[idx].extractMany(groupKeys, func(i int, old Valuetype) {
+				key := groupKeys[i]
+				if !ts.expired(key, cutoff) {
+					deleted.Add(1)
+				}
+				ts.forget(key)
+				atomic.AddUint64(&s.totalDeletes, 1)
+				if hooks != nil {
+					events[idx] = append(events[idx], keyValuePair{key: key, value: old})
+				}
+			})
+			ts.unlock()
+		}(shardIdx, keyIndices)
+	}
+
+	wg.Wait()
+	s.unpin()
+	for _, shardEvents := range events {
+		for _, e := range shardEvents {
+			hooks.fireDelete(e.key, e.value)
+		}
+	}
+	return int(deleted.Load())
+}
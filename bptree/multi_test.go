@@ -0,0 +1,80 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMultiGet(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	for i := 0; i < 500; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+	tree.InsertWithTTL([]byte("expiring"), []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	keys := []Keytype{[]byte("missing"), []byte("expiring")}
+	for i := 499; i >= 0; i -= 3 {
+		keys = append(keys, []byte(fmt.Sprintf("key-%d", i)))
+	}
+	keys = append(keys, []byte("key-7")) // Duplicates are looked up twice
+
+	values, errs := tree.MultiGet(keys)
+	if len(values) != len(keys) || len(errs) != len(keys) {
+		t.Fatalf("MultiGet returned %d values and %d errors for %d keys", len(values), len(errs), len(keys))
+	}
+	for i, key := range keys {
+		want, wantErr := tree.Find(key)
+		if (errs[i] == nil) != (wantErr == nil) || string(values[i]) != string(want) {
+			t.Errorf("MultiGet(%q) = %q, %v; Find = %q, %v", key, values[i], errs[i], want, wantErr)
+		}
+	}
+	if errs[0] == nil || errs[1] == nil {
+		t.Error("MultiGet found a missing or expired key")
+	}
+
+	// Values are copies
+	values[2][0] = 'X'
+	if v, _ := tree.Find(keys[2]); v[0] == 'X' {
+		t.Error("MultiGet returned the stored value")
+	}
+
+	if values, errs := tree.MultiGet(nil); len(values) != 0 || len(errs) != 0 {
+		t.Errorf("MultiGet(nil) = %v, %v", values, errs)
+	}
+}
+
+func TestMultiDelete(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	for i := 0; i < 300; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%d", i)), []byte("v"))
+	}
+	tree.InsertWithTTL([]byte("expiring"), []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	var hooked int
+	tree.OnDelete(func(Keytype, Valuetype, Valuetype) { hooked++ })
+
+	keys := []Keytype{[]byte("missing"), []byte("expiring"), []byte("key-0")}
+	for i := 0; i < 300; i += 2 {
+		keys = append(keys, []byte(fmt.Sprintf("key-%d", i)))
+	}
+	if n := tree.MultiDelete(keys); n != 150 {
+		t.Errorf("MultiDelete = %d, want 150", n)
+	}
+	if hooked != 151 {
+		t.Errorf("delete hooks fired %d times, want 151", hooked)
+	}
+	if tree.Count() != 150 {
+		t.Errorf("Count = %d after MultiDelete, want 150", tree.Count())
+	}
+	for i := 0; i < 300; i++ {
+		_, err := tree.Find([]byte(fmt.Sprintf("key-%d", i)))
+		if (err == nil) != (i%2 == 1) {
+			t.Fatalf("key-%d: Find error %v after MultiDelete", i, err)
+		}
+	}
+	if n := tree.MultiDelete(nil); n != 0 {
+		t.Errorf("MultiDelete(nil) = %d", n)
+	}
+}
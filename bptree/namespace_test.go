@@ -0,0 +1,86 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestNamespaceExportRestore(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	for _, tenant := range []string{"acme", "globex"} {
+		for i := 0; i < 50; i++ {
+			db.Insert([]byte(fmt.Sprintf("%s/user:%02d", tenant, i)), []byte(fmt.Sprintf(`{"email":"%d@%s.com"}`, i, tenant)))
+		}
+	}
+
+	var backup bytes.Buffer
+	if n, err := db.ExportNamespace([]byte("acme/"), &backup); err != nil || n != 50 {
+		t.Fatalf("ExportNamespace = %d, %v", n, err)
+	}
+
+	// Damage acme, and change globex, which the restore must leave alone
+	for i := 0; i < 50; i += 2 {
+		db.Delete([]byte(fmt.Sprintf("acme/user:%02d", i)))
+	}
+	db.Insert([]byte("acme/intruder"), []byte(`{"email":"x@acme.com"}`))
+	db.Update([]byte("globex/user:07"), []byte(`{"email":"new@globex.com"}`))
+
+	// Restore into a fresh database too, which needs the extractor
+	fresh := NewIndexedBTree(IndexedConfig{NumShards: 2})
+	if _, err := fresh.RestoreNamespace(bytes.NewReader(backup.Bytes()), nil); err == nil {
+		t.Error("Expected error restoring without the email extractor")
+	}
+	extractors := map[string]KeyExtractor{"email": JSONFieldExtractor("email")}
+	if n, err := fresh.RestoreNamespace(bytes.NewReader(backup.Bytes()), extractors); err != nil || n != 50 || fresh.Count() != 50 {
+		t.Errorf("Restore into fresh db = %d, %v with %d records", n, err, fresh.Count())
+	}
+
+	if n, err := db.RestoreNamespace(bytes.NewReader(backup.Bytes()), nil); err != nil || n != 50 {
+		t.Fatalf("RestoreNamespace = %d, %v", n, err)
+	}
+	if db.Count() != 100 {
+		t.Errorf("Count = %d, want 100", db.Count())
+	}
+	if _, err := db.Find([]byte("acme/intruder")); err == nil {
+		t.Error("Restore kept a key not in the backup")
+	}
+	if pk, err := db.FindByIndex("email", []byte("4@acme.com")); err != nil || string(pk) != "acme/user:04" {
+		t.Errorf("FindByIndex after restore = %q, %v", pk, err)
+	}
+	if _, err := db.FindByIndex("email", []byte("x@acme.com")); err == nil {
+		t.Error("Index still holds the deleted intruder")
+	}
+	if value, _ := db.Find([]byte("globex/user:07")); string(value) != `{"email":"new@globex.com"}` {
+		t.Errorf("Restore touched another namespace: globex/user:07 = %s", value)
+	}
+}
+
+func TestNamespaceRestoreRejectsDamage(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 2})
+	db.Insert([]byte("ns/a"), []byte("1"))
+	var backup bytes.Buffer
+	if _, err := db.ExportNamespace([]byte("ns/"), &backup); err != nil {
+		t.Fatal(err)
+	}
+	data := backup.Bytes()
+
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-6] ^= 0xff
+	for name, damaged := range map[string][]byte{
+		"truncated": data[:len(data)-3],
+		"corrupt":   corrupt,
+		"garbage":   []byte("not a backup"),
+	} {
+		if _, err := db.RestoreNamespace(bytes.NewReader(damaged), nil); err == nil {
+			t.Errorf("%s backup: expected error", name)
+		}
+	}
+	if value, _ := db.Find([]byte("ns/a")); string(value) != "1" {
+		t.Error("Failed restore changed the namespace")
+	}
+	if _, err := db.ExportNamespace(nil, &backup); err == nil {
+		t.Error("Expected error exporting an empty prefix")
+	}
+}
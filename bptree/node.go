@@ -2,19 +2,40 @@ package bptree
 
 import (
 	"bytes"
+	"sort"
 	"sync"
 )
 
 type Keytype []byte
 type Valuetype []byte
 
+// Node is a B-tree node.
+//
+// PREFIX COMPRESSION:
+// - A node stores the longest common prefix of its keys once, and only suffixes in keys
+// - Adding a key outside the prefix shrinks it, moving the dropped bytes back onto every suffix
+// - Removing keys never grows the prefix; splits and merges recompute it
+// - Suffixes are copies, so a stored key does not pin the caller's longer buffer
+// - With no shared prefix, keys are stored as given
 type Node struct {
-	keys         []Keytype
+	keys         []Keytype // Suffixes after prefix: use key and compareKey for full keys
+	prefix       Keytype   // Shared by every key in the node
 	values       []Valuetype
 	children     []*Node
 	isleaf       bool
 	mu           sync.RWMutex
 	rightSibling *Node
+
+	// B-Link fences: the node covers keys strictly between lowKey and
+	// highKey. A missing fence is unbounded.
+	lowKey, highKey       Keytype
+	hasLowKey, hasHighKey bool
+	dead                  bool // Merged away or dropped as root
+
+	// Copy-on-write for snapshots: contents are visible to snapshots of
+	// generation >= gen; versions holds earlier contents, newest first.
+	gen      uint64
+	versions []*Node
 }
 
 const (
@@ -22,26 +43,134 @@ const (
 	MinKeys = MaxKeys / 2
 )
 
+// findindex returns the position of the first key >= key, by binary search.
+// Keys outside the node's prefix sort before or after all of its keys.
 func (node *Node) findindex(key []byte) int {
-	length := len(node.keys)
-	for i := 0; i < length; i++ {
-		if bytes.Compare(node.keys[i], key) >= 0 {
-			return i
+	if p := len(node.prefix); p > 0 {
+		switch bytes.Compare(node.prefix, key[:min(p, len(key))]) {
+		case 1:
+			return 0
+		case -1:
+			return len(node.keys)
 		}
+		key = key[p:]
 	}
-	return length
+	return sort.Search(len(node.keys), func(i int) bool {
+		return bytes.Compare(node.keys[i], key) >= 0
+	})
 }
 
 func (node *Node) alreadyExists(key []byte) bool {
-	length := len(node.keys)
-	for i := 0; i < length; i++ {
-		if bytes.Equal(node.keys[i], key) {
+	for i := range node.keys {
+		if node.compareKey(i, key) == 0 {
 			return true
 		}
 	}
 	return false
 }
 
+// key returns the full key at i. It is the stored slice when the node has no
+// prefix, and a fresh one otherwise.
+func (node *Node) key(i int) Keytype {
+	if len(node.prefix) == 0 {
+		return node.keys[i]
+	}
+	return node.copyKey(i)
+}
+
+// copyKey returns a fresh copy of the full key at i.
+func (node *Node) copyKey(i int) Keytype {
+	full := make(Keytype, 0, len(node.prefix)+len(node.keys[i]))
+	full = append(full, node.prefix...)
+	return append(full, node.keys[i]...)
+}
+
+// appendKeys appends every full key in the node to dst.
+func (node *Node) appendKeys(dst []Keytype) []Keytype {
+	for i := range node.keys {
+		dst = append(dst, node.key(i))
+	}
+	return dst
+}
+
+// compareKey compares the full key at i with key, without rebuilding it.
+func (node *Node) compareKey(i int, key []byte) int {
+	p := len(node.prefix)
+	if p == 0 {
+		return bytes.Compare(node.keys[i], key)
+	}
+	if c := bytes.Compare(node.prefix, key[:min(p, len(key))]); c != 0 {
+		return c
+	}
+	return bytes.Compare(node.keys[i], key[p:])
+}
+
+// hasKeyAt reports whether the key at pos, if any, equals key.
+func (node *Node) hasKeyAt(pos int, key []byte) bool {
+	return pos < len(node.keys) && node.compareKey(pos, key) == 0
+}
+
+// suffix returns what to store for a full key that has the node's prefix.
+func (node *Node) suffix(full Keytype) Keytype {
+	if len(node.prefix) == 0 {
+		return full
+	}
+	return append(Keytype(nil), full[len(node.prefix):]...)
+}
+
+// fitPrefix shrinks the prefix until it is shared by full.
+func (node *Node) fitPrefix(full Keytype) {
+	if bytes.HasPrefix(full, node.prefix) {
+		return
+	}
+	keep := commonPrefixLen(node.prefix, full)
+	dropped := node.prefix[keep:]
+	for i, suffix := range node.keys {
+		widened := make(Keytype, 0, len(dropped)+len(suffix))
+		widened = append(widened, dropped...)
+		node.keys[i] = append(widened, suffix...)
+	}
+	if keep == 0 {
+		node.prefix = nil
+	} else {
+		node.prefix = append(Keytype(nil), node.prefix[:keep]...)
+	}
+}
+
+// setKey replaces the key at i with a full key.
+func (node *Node) setKey(i int, full Keytype) {
+	node.fitPrefix(full)
+	node.keys[i] = node.suffix(full)
+}
+
+// setKeys replaces all keys with the given sorted full keys, recomputing
+// the prefix.
+func (node *Node) setKeys(full []Keytype) {
+	node.prefix = nil
+	if len(full) > 1 {
+		// Sorted keys: the first and last share what all of them share
+		if keep := commonPrefixLen(full[0], full[len(full)-1]); keep > 0 {
+			node.prefix = append(Keytype(nil), full[0][:keep]...)
+		}
+	}
+	clear(node.keys)
+	node.keys = node.keys[:0]
+	for _, key := range full {
+		node.keys = append(node.keys, node.suffix(key))
+	}
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
 func NewNode(isleaf bool) *Node {
 	return &Node{
 		keys:     make([]Keytype, 0, MaxKeys),
@@ -51,7 +180,20 @@ func NewNode(isleaf bool) *Node {
 	}
 }
 
+// splitFences links a node just split off to the right of node and moves
+// node's high fence to midKey.
+func (node *Node) splitFences(newNode *Node, midKey Keytype) {
+	newNode.lowKey, newNode.hasLowKey = midKey, true
+	newNode.highKey, newNode.hasHighKey = node.highKey, node.hasHighKey
+	newNode.rightSibling = node.rightSibling
+	node.highKey, node.hasHighKey = midKey, true
+	node.rightSibling = newNode
+}
+
 func (node *Node) insertAt(index int, key Keytype, value Valuetype) {
+	node.fitPrefix(key)
+	key = node.suffix(key)
+
 	// Grow slices by appending a zero value first
 	node.keys = append(node.keys, nil)
 	node.values = append(node.values, nil)
@@ -73,7 +215,7 @@ func (node *Node) insertChildAt(index int, child *Node) {
 }
 
 func (node *Node) removeAt(index int) (Keytype, Valuetype) {
-	removedKey := node.keys[index]
+	removedKey := node.key(index)
 	removedValue := node.values[index]
 	node.keys = append(node.keys[:index], node.keys[index+1:]...)
 	node.values = append(node.values[:index], node.values[index+1:]...)
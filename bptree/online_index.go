@@ -0,0 +1,297 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrIndexBuilding is returned by queries on an index CreateIndexOnline is
+// still building.
+var ErrIndexBuilding = errors.New("index is still being built")
+
+// errIndexDropped ends an online build whose index was dropped.
+var errIndexDropped = errors.New("index was dropped during the build")
+
+// catchUpTail is the number of changed records an online build replays
+// while holding off writes to the index, once it has caught up that far.
+const catchUpTail = 64
+
+// changeSet maps each changed primary key to the index keys it may be
+// indexed under.
+type changeSet map[string][][]byte
+
+// add records that key may be indexed under indexKeys.
+func (c changeSet) add(key string, indexKeys ...[]byte) {
+	known := c[key]
+next:
+	for _, indexKey := range indexKeys {
+		for _, k := range known {
+			if bytes.Equal(k, indexKey) {
+				continue next
+			}
+		}
+		known = append(known, indexKey)
+	}
+	c[key] = known
+}
+
+// BuildPhase is the stage an online index build is in.
+type BuildPhase int
+
+const (
+	BuildScanning   BuildPhase = iota // Indexing a snapshot of the records
+	BuildCatchingUp                   // Replaying changes made since the snapshot
+	BuildReady                        // Maintained inline and open to queries
+	BuildFailed                       // Abandoned; see IndexBuild.Wait
+)
+
+// String returns the name of the phase.
+func (p BuildPhase) String() string {
+	switch p {
+	case BuildScanning:
+		return "scanning"
+	case BuildCatchingUp:
+		return "catching up"
+	case BuildReady:
+		return "ready"
+	default:
+		return "failed"
+	}
+}
+
+// IndexBuildProgress reports on an online index build.
+type IndexBuildProgress struct {
+	Phase    BuildPhase
+	Total    int64 // Records in the snapshot
+	Scanned  int64 // Snapshot records indexed so far
+	Buffered int   // Changed records waiting to be replayed
+}
+
+// Fraction returns the share of the snapshot indexed, from 0 to 1.
+func (p IndexBuildProgress) Fraction() float64 {
+	if p.Total == 0 {
+		return 1
+	}
+	return float64(p.Scanned) / float64(p.Total)
+}
+
+// IndexBuild is an index being built by CreateIndexOnline.
+type IndexBuild struct {
+	db  *IndexedBTree
+	idx *SecondaryIndex
+
+	mu      sync.Mutex
+	pending changeSet // Records changed since the build began, not yet replayed
+	phase   BuildPhase
+	err     error
+
+	total   atomic.Int64
+	scanned atomic.Int64
+	done    chan struct{}
+}
+
+// CreateIndexOnline creates an index and populates it in the background
+// without stalling writers. The index is registered at once but answers
+// queries (with ErrIndexBuilding until then) only once Wait returns nil.
+//
+// DESIGN:
+// - Writes made once the build starts record the changed key for it instead of updating the index
+// - A snapshot of the records is indexed, then changed keys are reindexed from the records' current values
+// - Each key is buffered once however often it changes, so catch-up converges on hot keys
+// - The last changed keys are reindexed holding off writes to the index, which then switches to ready atomically
+//
+// LIMITATIONS:
+// - Unique constraints are not enforced on writes during the build; a duplicate found at the end fails it and drops the index
+// - The buffer grows with the number of keys written for as long as the scan takes
+// - If writers touch new keys as fast as they are reindexed, the final pass holds them off for a whole batch
+//
+// USAGE:
+//
+//	build, _ := db.CreateIndexOnline(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true})
+//	fmt.Printf("%.0f%%\n", 100*build.Progress().Fraction())
+//	if err := build.Wait(); err != nil { ... }
+func (db *IndexedBTree) CreateIndexOnline(config IndexConfig) (*IndexBuild, error) {
+	if config.Extractor == nil {
+		return nil, errors.New("index needs an extractor")
+	}
+	idx := NewSecondaryIndex(config)
+	build := &IndexBuild{db: db, idx: idx, pending: changeSet{}, done: make(chan struct{})}
+	idx.build.Store(build)
+	if err := db.addIndex(idx, false); err != nil {
+		return nil, err
+	}
+
+	go build.run()
+	return build, nil
+}
+
+// Progress reports how far the build has got.
+func (b *IndexBuild) Progress() IndexBuildProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return IndexBuildProgress{
+		Phase:    b.phase,
+		Total:    b.total.Load(),
+		Scanned:  b.scanned.Load(),
+		Buffered: len(b.pending),
+	}
+}
+
+// Wait blocks until the index is ready, or returns why the build failed.
+func (b *IndexBuild) Wait() error {
+	<-b.done
+	return b.err
+}
+
+// building returns the index's running build, nil once it is ready.
+func (idx *SecondaryIndex) building() *IndexBuild {
+	return idx.build.Load()
+}
+
+// index returns the named index, if it exists and answers queries.
+func (db *IndexedBTree) index(name string) (*SecondaryIndex, error) {
+	db.mu.RLock()
+	idx, exists := db.indexes[name]
+	db.mu.RUnlock()
+
+	if !exists {
+		return nil, errors.New("index not found")
+	}
+	if idx.building() != nil {
+		return nil, ErrIndexBuilding
+	}
+	return idx, nil
+}
+
+// buffer records u for replay and reports true, or reports false once the
+// index is ready for u to be applied directly.
+func (b *IndexBuild) buffer(u indexUpdate) bool {
+	var indexKeys [][]byte
+	for _, value := range []Valuetype{u.oldValue, u.newValue} {
+		if value != nil {
+			indexKeys = append(indexKeys, b.idx.indexKeys(value)...)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.phase == BuildReady || b.phase == BuildFailed {
+		return false
+	}
+	b.pending.add(string(u.primaryKey), indexKeys...)
+	return true
+}
+
+// run scans a snapshot into the index, replays the changes buffered
+// meanwhile and switches the index to ready.
+func (b *IndexBuild) run() {
+	// Writers that began before the index was registered did not buffer
+	// their changes: let them finish, so the snapshot holds them
+	b.db.writers.Lock()
+	b.db.writers.Unlock()
+
+	err := b.catchUp(b.scan())
+
+	b.mu.Lock()
+	if err != nil {
+		b.phase, b.err = BuildFailed, err
+		b.pending = changeSet{}
+	}
+	b.mu.Unlock()
+	if err != nil {
+		b.db.mu.Lock()
+		if b.db.indexes[b.idx.name] == b.idx {
+			delete(b.db.indexes, b.idx.name)
+		}
+		b.db.mu.Unlock()
+	}
+	close(b.done)
+}
+
+// scan indexes a snapshot of the records. As in replay, records whose
+// unique key clashes are returned for a later pass.
+func (b *IndexBuild) scan() changeSet {
+	snap := b.db.tree.Snapshot()
+	defer snap.Release()
+	b.total.Store(snap.Len())
+
+	retry := changeSet{}
+	snap.ForEach(func(key Keytype, value Valuetype) bool {
+		if err := b.idx.Index(key, value); err != nil {
+			retry.add(string(key))
+		}
+		b.scanned.Add(1)
+		return !b.idx.dropped.Load()
+	})
+	return retry
+}
+
+// catchUp replays retry and the buffered changes until few remain, or until
+// they stop shrinking, then replays those holding off further updates to the
+// index and marks it ready.
+func (b *IndexBuild) catchUp(retry changeSet) error {
+	b.mu.Lock()
+	b.phase = BuildCatchingUp
+	b.mu.Unlock()
+
+	last := -1
+	for {
+		if b.idx.dropped.Load() {
+			return errIndexDropped
+		}
+		b.mu.Lock()
+		batch := b.pending
+		quiet := len(batch) == 0 // Retrying alone would not resolve anything
+		for key, indexKeys := range retry {
+			batch.add(key, indexKeys...)
+		}
+		b.pending = changeSet{}
+		if quiet || len(batch) <= catchUpTail || (last >= 0 && len(batch) >= last) {
+			defer b.mu.Unlock()
+			if _, err := b.replay(batch, true); err != nil {
+				return err
+			}
+			b.phase = BuildReady
+			b.idx.build.Store(nil)
+			return nil
+		}
+		b.mu.Unlock()
+
+		last = len(batch)
+		var err error
+		if retry, err = b.replay(batch, false); err != nil {
+			return err
+		}
+	}
+}
+
+// replay brings the index entries of the records changed in batch up to
+// date with their current values. A unique conflict may be with a record
+// whose own change is still buffered, so it is returned for a later pass;
+// on the final pass it fails the build.
+func (b *IndexBuild) replay(batch changeSet, final bool) (changeSet, error) {
+	// Drop whatever the index may hold for the changed records...
+	for key, indexKeys := range batch {
+		for _, indexKey := range indexKeys {
+			b.idx.removeKey(Keytype(key), indexKey)
+		}
+	}
+
+	// ...then index their current values
+	retry := changeSet{}
+	for key := range batch {
+		value, err := b.db.tree.Find(Keytype(key))
+		if err != nil {
+			continue // Deleted
+		}
+		if err := b.idx.Index(Keytype(key), value); err != nil {
+			if final {
+				return nil, err
+			}
+			retry.add(key)
+		}
+	}
+	return retry, nil
+}
@@ -0,0 +1,138 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCreateIndexOnline(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4})
+	record := func(i, group int) Valuetype {
+		return Valuetype(fmt.Sprintf(`{"id":%d,"group":"g%d"}`, i, group))
+	}
+	for i := 0; i < 10000; i++ {
+		db.Insert(Keytype(fmt.Sprintf("k%05d", i)), record(i, i%100))
+	}
+
+	// Writers keep inserting, updating and deleting throughout the build.
+	// IndexedBTree does not order writes to the same key, so each writer
+	// owns the keys congruent to it mod 4.
+	stop := make(chan struct{})
+	var writes atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				i := rng.Intn(12000)/4*4 + int(seed)
+				key := Keytype(fmt.Sprintf("k%05d", i))
+				if rng.Intn(3) == 0 {
+					db.Delete(key)
+				} else if db.Update(key, record(i, rng.Intn(100))) != nil {
+					db.Insert(key, record(i, rng.Intn(100)))
+				}
+				writes.Add(1)
+			}
+		}(int64(w))
+	}
+
+	build, err := db.CreateIndexOnline(IndexConfig{Name: "group", Extractor: JSONFieldExtractor("group")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateIndexOnline(IndexConfig{Name: "group", Extractor: JSONFieldExtractor("group")}); err == nil {
+		t.Error("Second CreateIndexOnline of the same name succeeded")
+	}
+	if p := build.Progress(); p.Phase != BuildReady {
+		if _, err := db.FindAllByIndex("group", []byte("g1")); !errors.Is(err, ErrIndexBuilding) {
+			t.Errorf("FindAllByIndex during the build = %v, want ErrIndexBuilding", err)
+		}
+	}
+	if err := build.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if p := build.Progress(); p.Phase != BuildReady || p.Fraction() != 1 || p.Buffered != 0 {
+		t.Errorf("Progress after Wait = %+v", p)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	// Writes after the build maintain the index inline
+	for i := 0; i < 1000; i++ {
+		db.Update(Keytype(fmt.Sprintf("k%05d", i)), record(i, 3))
+	}
+	if writes.Load() == 0 {
+		t.Fatal("No writes ran during the build")
+	}
+
+	want := make(map[string]int)
+	db.ForEach(func(key Keytype, value Valuetype) bool {
+		want[string(JSONFieldExtractor("group")(value))]++
+		return true
+	})
+	for group, n := range want {
+		keys, err := db.FindAllByIndex("group", []byte(group))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != n {
+			t.Errorf("Group %s: index has %d keys, records have %d", group, len(keys), n)
+		}
+		for _, key := range keys {
+			value, err := db.Find(key)
+			if err != nil || string(JSONFieldExtractor("group")(value)) != group {
+				t.Errorf("Index maps %s to %s, record is %q (%v)", group, key, value, err)
+			}
+		}
+	}
+}
+
+func TestCreateIndexOnlineUniqueViolation(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4})
+	for i := 0; i < 1000; i++ {
+		db.Insert(Keytype(fmt.Sprintf("k%04d", i)), Valuetype(fmt.Sprintf(`{"email":"u%d@example.com"}`, i)))
+	}
+	db.Insert(Keytype("dup"), Valuetype(`{"email":"u7@example.com"}`))
+
+	build, err := db.CreateIndexOnline(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build.Wait(); err == nil {
+		t.Fatal("Build over duplicate emails succeeded")
+	}
+	if p := build.Progress(); p.Phase != BuildFailed {
+		t.Errorf("Phase = %v, want %v", p.Phase, BuildFailed)
+	}
+	if db.HasIndex("email") {
+		t.Error("Failed index is still registered")
+	}
+
+	// The name is free again once the duplicate is gone
+	db.Delete(Keytype("dup"))
+	build, err = db.CreateIndexOnline(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if pk, err := db.FindByIndex("email", []byte("u7@example.com")); err != nil || string(pk) != "k0007" {
+		t.Errorf("FindByIndex = %q, %v, want k0007", pk, err)
+	}
+	if err := db.Insert(Keytype("dup"), Valuetype(`{"email":"u7@example.com"}`)); err == nil {
+		t.Error("Unique constraint not enforced after the build")
+	}
+}
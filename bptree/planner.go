@@ -0,0 +1,276 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Filter is one condition of a Query: a field equal to a value, or within
+// an inclusive range of values.
+type Filter struct {
+	// Field names the index answering the filter. Without a ready index of
+	// that name, it is read from each record as a JSON path.
+	Field string
+	// Extractor reads the field from a record when no index answers the
+	// filter (default: JSONPathExtractor(Field))
+	Extractor KeyExtractor
+
+	value      []byte
+	start, end []byte
+	ranged     bool
+}
+
+// Equals matches records whose field equals value.
+func Equals(field string, value []byte) Filter {
+	return Filter{Field: field, value: value}
+}
+
+// Between matches records whose field is in [start, end], compared bytewise
+// as index keys are.
+func Between(field string, start, end []byte) Filter {
+	return Filter{Field: field, start: start, end: end, ranged: true}
+}
+
+// QueryOptions tunes a Query.
+type QueryOptions struct {
+	// Limit caps the records returned, in primary key order (default: 0, all)
+	Limit int
+	// NoIndex forces a scan, for comparing plans
+	NoIndex bool
+	// ForceIndex names the index to read candidates from, overriding the
+	// planner's choice; the query fails unless a filter is on that index
+	ForceIndex string
+	// IgnoreIndexes are not used, as if their filters were unindexed
+	IgnoreIndexes []string
+}
+
+// QueryPlan reports how a Query was answered.
+type QueryPlan struct {
+	// Indexes whose primary keys were intersected into candidates, in the
+	// order they were read; empty for a scan
+	Indexes []string
+	// Residual is the number of filters checked against each candidate
+	Residual int
+	// Examined is the number of records fetched and checked
+	Examined int
+}
+
+// Scan reports whether the query scanned every record.
+func (p QueryPlan) Scan() bool {
+	return len(p.Indexes) == 0
+}
+
+// String describes the plan, e.g. "index email, filter 1, examined 1".
+func (p QueryPlan) String() string {
+	access := "scan"
+	if !p.Scan() {
+		access = "index " + strings.Join(p.Indexes, " & ")
+	}
+	return fmt.Sprintf("%s, filter %d, examined %d", access, p.Residual, p.Examined)
+}
+
+// QueryResult is the records a Query matched, in primary key order.
+type QueryResult struct {
+	Keys   []Keytype
+	Values []Valuetype
+	Plan   QueryPlan
+}
+
+// plannedFilter is a filter resolved against the indexes.
+type plannedFilter struct {
+	Filter
+	idx     *SecondaryIndex          // nil if no ready index answers it
+	extract func(Valuetype) [][]byte // Field of a record, as the index stores it
+	key     []byte                   // value, start and end as the index stores them
+	lo, hi  []byte
+}
+
+// tier ranks how selective an indexed filter is expected to be; lower is
+// better, and 0 means not indexed.
+func (f plannedFilter) tier() int {
+	switch {
+	case f.idx == nil:
+		return 0
+	case !f.ranged && f.idx.unique:
+		return 1
+	case !f.ranged:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// matches reports whether the record value satisfies the filter.
+func (f plannedFilter) matches(value Valuetype) bool {
+	for _, field := range f.extract(value) {
+		if !f.ranged && bytes.Equal(field, f.key) {
+			return true
+		}
+		if f.ranged && bytes.Compare(field, f.lo) >= 0 && bytes.Compare(field, f.hi) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the records matching every filter, choosing how to find
+// them from the indexes available.
+//
+// DESIGN:
+// - Equality on a unique index wins outright, since it yields at most one record
+// - Otherwise every equality on a non-unique index is used, intersecting their primary keys, else every indexed range
+// - Candidates are fetched and checked against the remaining filters
+// - With no usable index, every record is scanned in key order
+// - QueryOptions.ForceIndex and IgnoreIndexes override the choice, to work around a poor plan
+//
+// LIMITATIONS:
+// - Tiers are fixed, not costed: a wide equality is preferred over a narrow range
+// - Indexed filters are compared as the index stores keys, so a Normalizer applies to the filter's values too
+//
+// USAGE:
+//
+//	result, _ := db.Query([]Filter{
+//		Equals("city", []byte("NYC")),
+//		Between("age", []byte("30"), []byte("39")),
+//	}, QueryOptions{Limit: 20})
+//	fmt.Println(result.Plan) // index city, filter 1, examined 312
+func (db *IndexedBTree) Query(filters []Filter, opts QueryOptions) (QueryResult, error) {
+	if opts.NoIndex && opts.ForceIndex != "" {
+		return QueryResult{}, errors.New("NoIndex and ForceIndex are exclusive")
+	}
+	planned, err := db.planFilters(filters, opts)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	best := 0
+	for _, f := range planned {
+		if t := f.tier(); t != 0 && (best == 0 || t < best) {
+			best = t
+		}
+	}
+	use := func(f plannedFilter) bool {
+		return f.idx != nil && f.tier() == best
+	}
+	if opts.ForceIndex != "" {
+		use = func(f plannedFilter) bool {
+			return f.idx != nil && f.idx.name == opts.ForceIndex
+		}
+		if !slices.ContainsFunc(planned, use) {
+			return QueryResult{}, fmt.Errorf("no filter can use forced index %q", opts.ForceIndex)
+		}
+	}
+
+	var result QueryResult
+	var residual []plannedFilter
+	var sets [][]Keytype
+	for _, f := range planned {
+		if !use(f) || (f.tier() == 1 && len(sets) > 0) {
+			residual = append(residual, f)
+			continue
+		}
+		keys, err := f.candidates()
+		if err != nil {
+			return QueryResult{}, err
+		}
+		result.Plan.Indexes = append(result.Plan.Indexes, f.idx.name)
+		sets = append(sets, keys)
+	}
+	result.Plan.Residual = len(residual)
+
+	keep := func(key Keytype, value Valuetype) bool {
+		result.Plan.Examined++
+		for _, f := range residual {
+			if !f.matches(value) {
+				return true
+			}
+		}
+		result.Keys = append(result.Keys, key)
+		result.Values = append(result.Values, value)
+		return opts.Limit <= 0 || len(result.Keys) < opts.Limit
+	}
+
+	if len(sets) == 0 {
+		for key, value := range db.tree.All() {
+			if !keep(key, value) {
+				break
+			}
+		}
+		return result, nil
+	}
+
+	candidates := sets[0]
+	for _, keys := range sets[1:] {
+		candidates = intersectSorted(candidates, keys)
+	}
+	for _, key := range candidates {
+		value, err := db.tree.Find(key)
+		if err != nil {
+			continue // Deleted since the index was read
+		}
+		if !keep(key, value) {
+			break
+		}
+	}
+	return result, nil
+}
+
+// planFilters resolves each filter's index, or how to read its field when
+// it has none.
+func (db *IndexedBTree) planFilters(filters []Filter, opts QueryOptions) ([]plannedFilter, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("query needs at least one filter")
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	planned := make([]plannedFilter, len(filters))
+	for i, filter := range filters {
+		f := plannedFilter{Filter: filter, key: filter.value, lo: filter.start, hi: filter.end}
+		if f.ranged && bytes.Compare(f.start, f.end) > 0 {
+			return nil, fmt.Errorf("filter on %q: start is greater than end", f.Field)
+		}
+		if idx, exists := db.indexes[f.Field]; exists && idx.building() == nil {
+			// Compare as the index does, whether or not it is used
+			f.extract = idx.indexKeys
+			f.key, f.lo, f.hi = idx.lookupKey(f.value), idx.lookupKey(f.start), idx.lookupKey(f.end)
+			if !opts.NoIndex && !slices.Contains(opts.IgnoreIndexes, idx.name) {
+				f.idx = idx
+			}
+		} else {
+			extract := f.Extractor
+			if extract == nil {
+				if _, err := parseJSONPath(f.Field); err != nil {
+					return nil, fmt.Errorf("filter on %q: no such index, and %w", f.Field, err)
+				}
+				extract = JSONPathExtractor(f.Field)
+			}
+			f.extract = func(value Valuetype) [][]byte {
+				if field := extract(value); field != nil {
+					return [][]byte{field}
+				}
+				return nil
+			}
+		}
+		planned[i] = f
+	}
+	return planned, nil
+}
+
+// candidates returns the sorted, duplicate-free primary keys the filter's
+// index holds for it.
+func (f plannedFilter) candidates() ([]Keytype, error) {
+	indexes := map[string]*SecondaryIndex{f.Field: f.idx}
+	if !f.ranged {
+		return Match(f.Field, f.value).eval(indexes)
+	}
+
+	keys, err := f.idx.FindRange(f.start, f.end)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(keys), nil
+}
@@ -0,0 +1,122 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQueryPlanner(t *testing.T) {
+	db := queryTestDB(t)
+
+	cases := []struct {
+		name    string
+		filters []Filter
+		want    string
+		indexes string
+	}{
+		{"unique wins", []Filter{Equals("city", []byte("NYC")), Equals("email", []byte("u3@x.com"))}, "[user:3]", "[email]"},
+		{"equalities intersect", []Filter{Equals("city", []byte("NYC")), Equals("plan", []byte("pro"))}, "[user:0 user:3]", "[city plan]"},
+		{"equality over range", []Filter{Between("city", []byte("A"), []byte("M")), Equals("plan", []byte("pro"))}, "[user:2]", "[plan]"},
+		{"range", []Filter{Between("city", []byte("NYC"), []byte("SF"))}, "[user:0 user:1 user:3 user:4 user:5]", "[city]"},
+		{"unindexed field", []Filter{Equals("plan", []byte("team")), Equals("id", []byte("x"))}, "[]", "[plan]"},
+		{"no match", []Filter{Equals("city", []byte("Paris"))}, "[]", "[city]"},
+	}
+	for _, tc := range cases {
+		result, err := db.Query(tc.filters, QueryOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := fmt.Sprintf("%s", result.Keys); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+		if got := fmt.Sprint(result.Plan.Indexes); got != tc.indexes {
+			t.Errorf("%s: plan %v, want indexes %s", tc.name, result.Plan, tc.indexes)
+		}
+		if result.Plan.Residual != len(tc.filters)-len(result.Plan.Indexes) {
+			t.Errorf("%s: plan %v has the wrong residual count", tc.name, result.Plan)
+		}
+
+		scan, err := db.Query(tc.filters, QueryOptions{NoIndex: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !scan.Plan.Scan() || scan.Plan.Examined != 6 {
+			t.Errorf("%s: NoIndex plan %v", tc.name, scan.Plan)
+		}
+		if got := fmt.Sprintf("%s", scan.Keys); got != tc.want {
+			t.Errorf("%s: scan got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestQueryPlannerScanAndLimit(t *testing.T) {
+	db := queryTestDB(t)
+	db.DropIndex("plan")
+
+	result, err := db.Query([]Filter{Equals("plan", []byte("pro"))}, QueryOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%s", result.Keys); got != "[user:0 user:2]" {
+		t.Errorf("Keys = %s, want [user:0 user:2]", got)
+	}
+	if !result.Plan.Scan() || result.Plan.Examined != 3 {
+		t.Errorf("Plan = %v, want a scan stopping after 3 records", result.Plan)
+	}
+	if string(result.Values[1]) != `{"city":"LA","plan":"pro","email":"u2@x.com"}` {
+		t.Errorf("Values[1] = %s", result.Values[1])
+	}
+
+	if _, err := db.Query(nil, QueryOptions{}); err == nil {
+		t.Error("Query without filters succeeded")
+	}
+	if _, err := db.Query([]Filter{Between("city", []byte("b"), []byte("a"))}, QueryOptions{}); err == nil {
+		t.Error("Query with an inverted range succeeded")
+	}
+	if _, err := db.Query([]Filter{Equals("a..b", []byte("x"))}, QueryOptions{}); err == nil {
+		t.Error("Query on an invalid path succeeded")
+	}
+}
+
+func TestQueryIndexHints(t *testing.T) {
+	db := queryTestDB(t)
+	filters := []Filter{Equals("city", []byte("NYC")), Equals("email", []byte("u3@x.com"))}
+
+	forced, err := db.Query(filters, QueryOptions{ForceIndex: "city"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%s", forced.Keys); got != "[user:3]" {
+		t.Errorf("ForceIndex: got %s, want [user:3]", got)
+	}
+	if fmt.Sprint(forced.Plan.Indexes) != "[city]" || forced.Plan.Residual != 1 {
+		t.Errorf("ForceIndex: plan %v, want city with 1 residual filter", forced.Plan)
+	}
+
+	ignored, err := db.Query(filters, QueryOptions{IgnoreIndexes: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ignored.Plan.Indexes) != "[city]" || fmt.Sprintf("%s", ignored.Keys) != "[user:3]" {
+		t.Errorf("IgnoreIndexes: got %s with plan %v", ignored.Keys, ignored.Plan)
+	}
+
+	ranged, err := db.Query([]Filter{Between("city", []byte("NYC"), []byte("SF")), Equals("plan", []byte("pro"))}, QueryOptions{ForceIndex: "city"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ranged.Plan.Indexes) != "[city]" || fmt.Sprintf("%s", ranged.Keys) != "[user:0 user:3]" {
+		t.Errorf("ForceIndex on a range: got %s with plan %v", ranged.Keys, ranged.Plan)
+	}
+
+	if _, err := db.Query(filters, QueryOptions{ForceIndex: "plan"}); err == nil {
+		t.Error("Forcing an index no filter uses succeeded")
+	}
+	if _, err := db.Query(filters, QueryOptions{ForceIndex: "city", IgnoreIndexes: []string{"city"}}); err == nil {
+		t.Error("Forcing an ignored index succeeded")
+	}
+	if _, err := db.Query(filters, QueryOptions{ForceIndex: "city", NoIndex: true}); err == nil {
+		t.Error("ForceIndex with NoIndex succeeded")
+	}
+}
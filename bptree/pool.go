@@ -0,0 +1,142 @@
+package bptree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// nodePool recycles the nodes of one tree, with their key, value and child
+// slices, to cut allocation in write-heavy workloads.
+//
+// DESIGN:
+// - Merges and root collapses retire the node they unlink instead of dropping it
+// - A retired node is not reused at once: a B-Link Find may still hold a pointer to it
+// - Only with treeLock held exclusively, when no operation is in flight, do retired nodes move to the free pool
+// - That happens in Clear, and in Insert when enough nodes are retired and the tree is idle
+// - The pool is per tree, so a stale pointer can never lead into another tree
+// - A failed tree (see InvariantError) recycles nothing: its nodes may still be latched
+// - Nodes a live Snapshot may read are never recycled
+type nodePool struct {
+	free sync.Pool // *Node, reset and ready for reuse
+
+	mu      sync.Mutex
+	retired []*Node
+	pending int32 // len(retired), readable without mu
+}
+
+const (
+	// reclaimThreshold is how many retired nodes Insert waits for before
+	// trying to recycle them.
+	reclaimThreshold = 64
+
+	// maxRetired bounds the retired list while the tree is never idle;
+	// beyond it unlinked nodes are left to the garbage collector.
+	maxRetired = 1024
+)
+
+// newNode returns an empty node, recycled if one is free.
+func (t *Btree) newNode(isleaf bool) *Node {
+	n, ok := t.pool.free.Get().(*Node)
+	if ok {
+		n.isleaf = isleaf
+	} else {
+		n = NewNode(isleaf)
+	}
+	n.gen = t.snapGen.Load() + 1 // No existing snapshot can reach it
+	t.nodes.Add(1)
+	return n
+}
+
+// retire hands a node that is no longer reachable from the root to the pool.
+// Nodes a live snapshot may still read are left to the garbage collector.
+func (t *Btree) retire(n *Node) {
+	t.nodes.Add(-1)
+	if t.snapshots.Load() > 0 && (n.gen <= t.snapGen.Load() || len(n.versions) > 0) {
+		return
+	}
+	t.pool.mu.Lock()
+	if len(t.pool.retired) < maxRetired {
+		t.pool.retired = append(t.pool.retired, n)
+		atomic.StoreInt32(&t.pool.pending, int32(len(t.pool.retired)))
+	}
+	t.pool.mu.Unlock()
+}
+
+// maybeReclaim recycles retired nodes if there are enough of them and no
+// operation is in flight. It never waits. Called without treeLock.
+func (t *Btree) maybeReclaim() {
+	if atomic.LoadInt32(&t.pool.pending) < reclaimThreshold || !t.treeLock.TryLock() {
+		return
+	}
+	t.reclaim()
+	t.treeLock.Unlock()
+}
+
+// reclaim moves retired nodes to the free pool. Called with treeLock held
+// exclusively.
+func (t *Btree) reclaim() {
+	t.pool.mu.Lock()
+	retired := t.pool.retired
+	t.pool.retired = nil
+	atomic.StoreInt32(&t.pool.pending, 0)
+	t.pool.mu.Unlock()
+
+	if t.Err() != nil {
+		return
+	}
+	for _, n := range retired {
+		n.reset()
+		t.pool.free.Put(n)
+	}
+}
+
+// reset empties a node for reuse, keeping its slices' capacity.
+func (n *Node) reset() {
+	clear(n.keys[:cap(n.keys)])
+	clear(n.values[:cap(n.values)])
+	clear(n.children[:cap(n.children)])
+	n.keys = n.keys[:0]
+	n.values = n.values[:0]
+	n.children = n.children[:0]
+	n.prefix = nil
+	n.rightSibling = nil
+	n.lowKey, n.highKey = nil, nil
+	n.hasLowKey, n.hasHighKey = false, false
+	n.dead = false
+	n.versions = nil
+}
+
+// Clear removes every key, recycling the tree's nodes. Clear also resets a
+// failed tree, whose nodes are dropped rather than recycled.
+func (t *Btree) Clear() {
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+	t.clearLocked()
+}
+
+// clearLocked is Clear. Called with treeLock held exclusively.
+func (t *Btree) clearLocked() {
+	if t.Err() == nil && t.root != nil {
+		t.retireSubtree(t.root)
+	}
+	t.reclaim()
+
+	t.rootLock.Lock()
+	t.root = nil
+	t.rootLock.Unlock()
+	atomic.StoreInt64(&t.size, 0)
+	t.nodes.Store(0)
+	t.dataBytes.Store(0)
+	t.failure.Store(nil)
+}
+
+// retireSubtree retires n and every node below it. Called with treeLock
+// held exclusively.
+func (t *Btree) retireSubtree(n *Node) {
+	for _, child := range n.children {
+		if child != nil {
+			t.retireSubtree(child)
+		}
+	}
+	t.retire(n)
+}
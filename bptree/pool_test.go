@@ -0,0 +1,131 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestNodePoolReusesRetiredNodes(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 200; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	for i := 0; i < 200; i++ {
+		tree.Delete([]byte(fmt.Sprintf("key:%04d", i)))
+	}
+	if tree.pool.pending == 0 {
+		t.Fatal("Deletes retired no nodes")
+	}
+
+	// Retired nodes are recycled once the tree is idle
+	tree.Insert([]byte("trigger"), []byte("v"))
+	if tree.pool.pending != 0 {
+		t.Errorf("Idle insert left %d nodes retired", tree.pool.pending)
+	}
+
+	n := tree.newNode(false)
+	if len(n.keys) != 0 || len(n.children) != 0 || n.dead || n.hasLowKey || n.hasHighKey || n.rightSibling != nil {
+		t.Errorf("Recycled node not reset: %s", n.describe())
+	}
+	for _, key := range n.keys[:cap(n.keys)] {
+		if key != nil {
+			t.Fatal("Recycled node still references old keys")
+		}
+	}
+}
+
+func TestNodePoolChurnKeepsTreeValid(t *testing.T) {
+	tree := &Btree{}
+	rng := rand.New(rand.NewSource(7))
+	present := make(map[string]bool)
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key:%04d", rng.Intn(1000))
+			if rng.Intn(2) == 0 {
+				tree.Insert([]byte(key), []byte(key))
+				present[key] = true
+			} else {
+				tree.Delete([]byte(key))
+				delete(present, key)
+			}
+		}
+		if err := validateBTreeProperties(tree); err != nil {
+			t.Fatalf("Round %d: %v", round, err)
+		}
+		if round%5 == 4 {
+			tree.Clear()
+			present = make(map[string]bool)
+		}
+	}
+
+	for key := range present {
+		if value, err := tree.Find([]byte(key)); err != nil || string(value) != key {
+			t.Errorf("Find(%s) = %q, %v", key, value, err)
+		}
+	}
+	if int(tree.Len()) != len(present) {
+		t.Errorf("Len = %d, want %d", tree.Len(), len(present))
+	}
+}
+
+func TestNodePoolConcurrentChurn(t *testing.T) {
+	tree := &Btree{}
+	const stable = 100
+	for i := 0; i < stable; i++ {
+		key := []byte(fmt.Sprintf("stable-%04d", i))
+		tree.Insert(key, key)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := []byte(fmt.Sprintf("churn-%d-%03d", w, i%100))
+				if i%200 < 100 {
+					tree.Insert(key, key)
+				} else {
+					tree.Delete(key)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 4000; i++ {
+				key := []byte(fmt.Sprintf("stable-%04d", i%stable))
+				if value, err := tree.Find(key); err != nil || string(value) != string(key) {
+					t.Errorf("Find(%s) = %q, %v", key, value, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearResetsFailedTree(t *testing.T) {
+	tree, _ := corruptedTree(t, true)
+	tree.TryInsert([]byte("key:099"), []byte("new"))
+	if tree.Err() == nil {
+		t.Fatal("Expected the corrupted tree to fail")
+	}
+
+	tree.Clear()
+	if tree.Err() != nil || tree.Len() != 0 {
+		t.Errorf("After Clear: Err=%v Len=%d", tree.Err(), tree.Len())
+	}
+	if err := tree.TryInsert([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("TryInsert after Clear: %v", err)
+	}
+}
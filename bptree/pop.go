@@ -0,0 +1,93 @@
+package bptree
+
+// PopMin atomically removes and returns the smallest entry. ok is false if
+// the tree is empty.
+//
+// DESIGN:
+// - Reads the smallest key, then latches it for delete as TryDelete does
+// - The key is still the smallest if it sits first in the leftmost leaf, which has no low fence: a smaller key could only go into that leaf, and it is latched
+// - Otherwise (popped by another caller, or a smaller key arrived) it retries
+//
+// USAGE:
+//
+//	for {
+//		key, job, ok := queue.PopMin() // Keys ordered by priority
+//		if !ok {
+//			break
+//		}
+//		run(key, job)
+//	}
+func (t *Btree) PopMin() (key Keytype, value Valuetype, ok bool) {
+	return t.pop("PopMin", false)
+}
+
+// PopMax atomically removes and returns the largest entry. ok is false if
+// the tree is empty. See PopMin.
+func (t *Btree) PopMax() (key Keytype, value Valuetype, ok bool) {
+	return t.pop("PopMax", true)
+}
+
+// pop removes the smallest entry, or the largest if last is set.
+func (t *Btree) pop(op string, last bool) (key Keytype, value Valuetype, ok bool) {
+	var err error
+	defer t.guard(op, nil, &err)
+	if t.Err() != nil {
+		return nil, nil, false
+	}
+
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	for {
+		edge, found := t.edgeKey(last)
+		if !found {
+			return nil, nil, false
+		}
+
+		p := t.latchForDelete(edge)
+		if n := p.found; n != nil && n.isleaf && atEdge(n, p.foundPos, last) {
+			value = append(Valuetype(nil), n.values[p.foundPos]...)
+			p.remove()
+			p.release()
+			return edge, value, true
+		}
+		p.release()
+	}
+}
+
+// atEdge reports whether position i of leaf n holds the tree's smallest key,
+// or its largest if last is set.
+func atEdge(n *Node, i int, last bool) bool {
+	if last {
+		return i == len(n.keys)-1 && !n.hasHighKey
+	}
+	return i == 0 && !n.hasLowKey
+}
+
+// edgeKey returns a copy of the smallest key, or the largest if last is set,
+// read-latching down the leftmost or rightmost path. Called with treeLock
+// held shared.
+func (t *Btree) edgeKey(last bool) (Keytype, bool) {
+	n := t.rlockRoot()
+	if n == nil {
+		return nil, false
+	}
+	for !n.isleaf && len(n.children) > 0 {
+		child := n.children[0]
+		if last {
+			child = n.children[len(n.children)-1]
+		}
+		child.mu.RLock()
+		n.mu.RUnlock()
+		n = child
+	}
+	defer n.mu.RUnlock()
+
+	if len(n.keys) == 0 {
+		return nil, false
+	}
+	if last {
+		return n.copyKey(len(n.keys) - 1), true
+	}
+	return n.copyKey(0), true
+}
@@ -0,0 +1,78 @@
+package bptree
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestPopMinMax(t *testing.T) {
+	tree := &Btree{}
+	if _, _, ok := tree.PopMin(); ok {
+		t.Fatal("PopMin on an empty tree succeeded")
+	}
+
+	for _, i := range []int{5, 1, 9, 3, 7, 2, 8, 4, 6, 0} {
+		tree.Insert([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	if key, value, ok := tree.PopMin(); !ok || string(key) != "k0" || string(value) != "v0" {
+		t.Errorf("PopMin = %q, %q, %v", key, value, ok)
+	}
+	if key, value, ok := tree.PopMax(); !ok || string(key) != "k9" || string(value) != "v9" {
+		t.Errorf("PopMax = %q, %q, %v", key, value, ok)
+	}
+	for want := 1; want <= 8; want++ {
+		key, _, _ := tree.PopMin()
+		if string(key) != fmt.Sprintf("k%d", want) {
+			t.Fatalf("PopMin = %q, want k%d", key, want)
+		}
+	}
+	if _, _, ok := tree.PopMax(); ok || tree.Len() != 0 {
+		t.Errorf("PopMax on a drained tree = %v, Len %d", ok, tree.Len())
+	}
+}
+
+func TestPopMinConcurrent(t *testing.T) {
+	tree := &Btree{}
+	const n = 2000
+	for i := 0; i < n; i++ {
+		tree.Insert([]byte(fmt.Sprintf("%05d", i)), []byte("job"))
+	}
+
+	// Every key is popped exactly once, and each popper sees ascending keys
+	var mu sync.Mutex
+	var popped []string
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last string
+			for {
+				key, _, ok := tree.PopMin()
+				if !ok {
+					return
+				}
+				if string(key) <= last {
+					t.Errorf("PopMin returned %q after %q", key, last)
+				}
+				last = string(key)
+				mu.Lock()
+				popped = append(popped, last)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(popped)
+	if len(popped) != n {
+		t.Fatalf("Popped %d keys, want %d", len(popped), n)
+	}
+	for i, key := range popped {
+		if key != fmt.Sprintf("%05d", i) {
+			t.Fatalf("Popped keys[%d] = %q", i, key)
+		}
+	}
+}
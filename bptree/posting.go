@@ -0,0 +1,195 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Non-unique index entries are stored one posting per tree key, so adding or
+// removing a record is one B-Tree insert or delete however many records
+// share its index key.
+//
+// DESIGN:
+// - Posting key: the index key with each 0x00 escaped as 0x00 0xff, a 0x00 0x01 terminator, then the primary key
+// - The escaping (as for composite string fields) keeps index key order, and no index key's postings interleave with another's
+// - Postings of one index key are contiguous and sorted by primary key
+// - The value is the stored record for a covering index, empty otherwise
+// - Keys are placed by their part before the first 0x01 (postingAffinity), so an index key's postings share a shard
+// - Unique indexes keep one entry per index key: index_key → primary_key
+//
+// LIMITATIONS:
+// - The index key is stored once per posting, so long keys with many records cost more space than a shared list
+// - Each 0x00 byte in an index key takes two bytes
+// - An index key with most of the records puts most of the postings on one shard
+// - Reading an index key's postings walks them in the tree rather than decoding one value, so lookups of very common keys are slower
+
+// postingAffinity is the AffinityDelimiter of a non-unique index tree: the
+// first 0x01 of a posting key is in its index key or ends its terminator.
+const postingAffinity = 0x01
+
+// appendEscaped appends key to dst with each 0x00 escaped as 0x00 0xff.
+func appendEscaped(dst, key []byte) []byte {
+	for _, c := range key {
+		dst = append(dst, c)
+		if c == 0 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return dst
+}
+
+// postingPrefix returns the prefix shared by the posting keys of indexKey.
+func postingPrefix(indexKey []byte) []byte {
+	return append(appendEscaped(make([]byte, 0, len(indexKey)+2), indexKey), 0, 1)
+}
+
+// postingKey returns the tree key of the posting of primaryKey under indexKey.
+func postingKey(indexKey, primaryKey []byte) []byte {
+	key := appendEscaped(make([]byte, 0, len(indexKey)+2+len(primaryKey)), indexKey)
+	return append(append(key, 0, 1), primaryKey...)
+}
+
+// postingEnd returns a tree key above every posting of indexKey and below
+// those of any greater index key (0x00 0x02 is never part of a posting key).
+func postingEnd(indexKey []byte) []byte {
+	return append(appendEscaped(make([]byte, 0, len(indexKey)+2), indexKey), 0, 2)
+}
+
+// splitPostingKey splits a posting key into its index key and primary key.
+// The results may alias key.
+func splitPostingKey(key []byte) (indexKey, primaryKey []byte, ok bool) {
+	i := bytes.IndexByte(key, 0)
+	if i < 0 || i+1 == len(key) {
+		return nil, nil, false
+	}
+	if key[i+1] == 1 {
+		return key[:i:i], key[i+2:], true // No escapes: the common case
+	}
+
+	indexKey = append(make([]byte, 0, len(key)), key[:i]...)
+	for ; i+1 < len(key); i++ {
+		c := key[i]
+		if c != 0 {
+			indexKey = append(indexKey, c)
+			continue
+		}
+		switch key[i+1] {
+		case 0xff:
+			indexKey = append(indexKey, 0)
+			i++
+		case 1:
+			return indexKey, key[i+2:], true
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
+
+// postings calls yield for each posting with an index key in
+// [startKey, endKey] (or from startKey on, unless bounded), in index key
+// then primary key order, until yield returns false. A nil startKey starts
+// at the first posting; record is nil unless the index is covering.
+func (idx *SecondaryIndex) postings(startKey, endKey []byte, bounded bool, yield func(indexKey, primaryKey, record []byte) bool) {
+	if idx.unique {
+		mergeCursors(idx.tree.rangeCursors(startKey, endKey, bounded, rangeBatchSize), func(indexKey Keytype, value Valuetype) bool {
+			pks, records := idx.decodeEntry(value)
+			for i, pk := range pks {
+				var record []byte
+				if idx.covering {
+					record = records[i]
+				}
+				if !yield(indexKey, pk, record) {
+					return false
+				}
+			}
+			return true
+		})
+		return
+	}
+
+	var cursors []*mergeCursor
+	switch {
+	case bounded && startKey != nil && bytes.Equal(startKey, endKey):
+		cursors = idx.tree.affinityCursors(postingPrefix(startKey), postingEnd(endKey), rangeBatchSize)
+	case bounded:
+		cursors = idx.tree.rangeCursors(postingPrefix(startKey), postingEnd(endKey), true, rangeBatchSize)
+	case startKey != nil:
+		cursors = idx.tree.rangeCursors(postingPrefix(startKey), nil, false, rangeBatchSize)
+	default:
+		cursors = idx.tree.rangeCursors(nil, nil, false, rangeBatchSize)
+	}
+	mergeCursors(cursors, func(key Keytype, value Valuetype) bool {
+		indexKey, pk, ok := splitPostingKey(key)
+		if !ok {
+			return true // Not a posting
+		}
+		var record []byte
+		if idx.covering {
+			record = value
+		}
+		return yield(indexKey, pk, record)
+	})
+}
+
+// postingsReverse is postings over [startKey, endKey] in descending order:
+// index keys descending, then primary keys descending within each.
+func (idx *SecondaryIndex) postingsReverse(startKey, endKey []byte, yield func(indexKey, primaryKey, record []byte) bool) {
+	if idx.unique {
+		mergeCursorsReverse(idx.tree.rangeCursorsReverse(startKey, endKey, rangeBatchSize), func(indexKey Keytype, value Valuetype) bool {
+			pks, records := idx.decodeEntry(value)
+			for i := len(pks) - 1; i >= 0; i-- {
+				var record []byte
+				if idx.covering {
+					record = records[i]
+				}
+				if !yield(indexKey, pks[i], record) {
+					return false
+				}
+			}
+			return true
+		})
+		return
+	}
+
+	cursors := idx.tree.rangeCursorsReverse(postingPrefix(startKey), postingEnd(endKey), rangeBatchSize)
+	mergeCursorsReverse(cursors, func(key Keytype, value Valuetype) bool {
+		indexKey, pk, ok := splitPostingKey(key)
+		if !ok {
+			return true // Not a posting
+		}
+		var record []byte
+		if idx.covering {
+			record = value
+		}
+		return yield(indexKey, pk, record)
+	})
+}
+
+// lookup returns the primary keys, and records if covering, posted under
+// indexKey.
+func (idx *SecondaryIndex) lookup(indexKey []byte) (primaryKeys, records [][]byte, err error) {
+	if idx.unique {
+		value, err := idx.tree.Find(indexKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		primaryKeys, records = idx.decodeEntry(value)
+		return primaryKeys, records, nil
+	}
+
+	if err := idx.tree.Err(); err != nil {
+		return nil, nil, err
+	}
+	idx.postings(indexKey, indexKey, true, func(_, pk, record []byte) bool {
+		primaryKeys = append(primaryKeys, pk)
+		if idx.covering {
+			records = append(records, record)
+		}
+		return true
+	})
+	if len(primaryKeys) == 0 {
+		return nil, nil, errors.New("key not found")
+	}
+	return primaryKeys, records, nil
+}
@@ -0,0 +1,89 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestPostingKey(t *testing.T) {
+	indexKeys := [][]byte{{}, {0}, {0, 0}, {0, 1}, {0, 0xff}, []byte("a"), []byte("a\x00"), []byte("a\x00b"), []byte("ab"), {0xff}}
+	primaryKeys := [][]byte{{}, []byte("k"), {0, 1}, {0xff, 0}}
+
+	var postings [][]byte
+	for _, indexKey := range indexKeys {
+		for _, pk := range primaryKeys {
+			key := postingKey(indexKey, pk)
+			gotIndexKey, gotPK, ok := splitPostingKey(key)
+			if !ok || !bytes.Equal(gotIndexKey, indexKey) || !bytes.Equal(gotPK, pk) {
+				t.Errorf("splitPostingKey(postingKey(%q, %q)) = %q, %q, %v", indexKey, pk, gotIndexKey, gotPK, ok)
+			}
+			if !bytes.HasPrefix(key, postingPrefix(indexKey)) || bytes.Compare(key, postingEnd(indexKey)) >= 0 {
+				t.Errorf("postingKey(%q, %q) is outside its index key's range", indexKey, pk)
+			}
+			postings = append(postings, key)
+		}
+	}
+
+	// Sorted postings group by index key in index key order
+	slices.SortFunc(postings, bytes.Compare)
+	var order [][]byte
+	for _, key := range postings {
+		indexKey, _, _ := splitPostingKey(key)
+		if len(order) == 0 || !bytes.Equal(order[len(order)-1], indexKey) {
+			order = append(order, indexKey)
+		}
+	}
+	want := slices.Clone(indexKeys)
+	slices.SortFunc(want, bytes.Compare)
+	if fmt.Sprintf("%q", order) != fmt.Sprintf("%q", want) {
+		t.Errorf("Posting order = %q, want %q", order, want)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("abc"), {'a', 0}, {'a', 0, 2, 'k'}, {0, 0xff}} {
+		if _, _, ok := splitPostingKey(bad); ok {
+			t.Errorf("splitPostingKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSecondaryIndexPostings(t *testing.T) {
+	idx := NewSecondaryIndex(IndexConfig{Name: "tag", Extractor: func(v Valuetype) []byte { return v }, Covering: true})
+	for i := 9; i >= 0; i-- {
+		idx.Index(Keytype(fmt.Sprintf("k%d", i)), Valuetype([]byte{'t', 0, byte('0' + i%3)}))
+	}
+	idx.Index(Keytype("z"), Valuetype("t"))
+	idx.Index(Keytype("y"), Valuetype("t\x01"))
+
+	check := func(what string, keys []Keytype, err error, want string) {
+		t.Helper()
+		if got := fmt.Sprintf("%s", keys); err != nil || got != want {
+			t.Errorf("%s = %s, %v, want %s", what, got, err, want)
+		}
+	}
+	keys, err := idx.FindAll([]byte("t\x001"))
+	check("FindAll(t 0 1)", keys, err, "[k1 k4 k7]")
+	keys, err = idx.FindRange([]byte("t"), []byte("t\x001"))
+	check("FindRange(t, t 0 1)", keys, err, "[z k0 k3 k6 k9 k1 k4 k7]")
+	keys, err = idx.FindPrefix([]byte("t\x00"))
+	check("FindPrefix(t 0)", keys, err, "[k0 k3 k6 k9 k1 k4 k7 k2 k5 k8]")
+	keys, values, err := idx.FindCovered([]byte("t\x002"))
+	check("FindCovered(t 0 2)", keys, err, "[k2 k5 k8]")
+	if string(values[0]) != "t\x002" {
+		t.Errorf("FindCovered record = %q", values[0])
+	}
+	if _, err := idx.FindAll([]byte("t\x00")); err == nil {
+		t.Error("FindAll of a key with no postings succeeded")
+	}
+
+	// Adding and removing touch one posting each
+	idx.Index(Keytype("k1"), Valuetype("t\x001"))
+	idx.Remove(Keytype("k4"), Valuetype("t\x001"))
+	idx.Remove(Keytype("k4"), Valuetype("t\x001"))
+	keys, err = idx.FindAll([]byte("t\x001"))
+	check("FindAll(t 0 1) after remove", keys, err, "[k1 k7]")
+	if n := idx.Count(); n != 11 {
+		t.Errorf("Count = %d, want 11", n)
+	}
+}
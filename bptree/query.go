@@ -0,0 +1,179 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// IndexQuery is a boolean combination of secondary index lookups, evaluated
+// by IndexedBTree.FindByIndexes.
+//
+// DESIGN:
+// - A leaf matches the primary keys stored under one (index, key) pair
+// - And and Or combine sub-queries; they nest freely
+// - Each leaf's primary keys are sorted once, then combined by sorted-set merge
+// - And intersects smallest set first and stops as soon as the result is empty
+//
+// USAGE:
+//
+//	q := And(Match("city", []byte("NYC")), Or(Match("plan", []byte("pro")), Match("plan", []byte("team"))))
+//	keys, err := db.FindByIndexes(q)
+type IndexQuery struct {
+	op       queryOp
+	index    string
+	key      []byte
+	children []IndexQuery
+}
+
+type queryOp uint8
+
+const (
+	queryMatch queryOp = iota
+	queryAnd
+	queryOr
+)
+
+// Match matches records whose indexed value in index equals key.
+func Match(index string, key []byte) IndexQuery {
+	return IndexQuery{op: queryMatch, index: index, key: key}
+}
+
+// And matches records matched by every query.
+func And(queries ...IndexQuery) IndexQuery {
+	return IndexQuery{op: queryAnd, children: queries}
+}
+
+// Or matches records matched by any query.
+func Or(queries ...IndexQuery) IndexQuery {
+	return IndexQuery{op: queryOr, children: queries}
+}
+
+// FindByIndexes returns the primary keys matched by q, in key order.
+// Only primary keys are combined; no record is fetched.
+func (db *IndexedBTree) FindByIndexes(q IndexQuery) ([]Keytype, error) {
+	db.mu.RLock()
+	indexes := make(map[string]*SecondaryIndex)
+	err := q.resolve(db.indexes, indexes)
+	db.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return q.eval(indexes)
+}
+
+// resolve looks up every index q refers to, so evaluation does not race with
+// DropIndex.
+func (q IndexQuery) resolve(all, into map[string]*SecondaryIndex) error {
+	if q.op == queryMatch {
+		idx, exists := all[q.index]
+		if !exists {
+			return fmt.Errorf("index %q not found", q.index)
+		}
+		if idx.building() != nil {
+			return fmt.Errorf("index %q: %w", q.index, ErrIndexBuilding)
+		}
+		into[q.index] = idx
+		return nil
+	}
+	if len(q.children) == 0 {
+		return errors.New("empty And/Or query")
+	}
+	for _, child := range q.children {
+		if err := child.resolve(all, into); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eval returns the sorted, duplicate-free primary keys matched by q.
+func (q IndexQuery) eval(indexes map[string]*SecondaryIndex) ([]Keytype, error) {
+	if q.op == queryMatch {
+		keys, err := indexes[q.index].FindAll(q.key)
+		if err != nil {
+			if errors.Is(err, ErrInvariant) {
+				return nil, err
+			}
+			return nil, nil // No record has this value
+		}
+		return sortedKeys(keys), nil
+	}
+
+	sets := make([][]Keytype, 0, len(q.children))
+	for _, child := range q.children {
+		keys, err := child.eval(indexes)
+		if err != nil {
+			return nil, err
+		}
+		if q.op == queryAnd && len(keys) == 0 {
+			return nil, nil
+		}
+		sets = append(sets, keys)
+	}
+
+	if q.op == queryOr {
+		result := sets[0]
+		for _, keys := range sets[1:] {
+			result = unionSorted(result, keys)
+		}
+		return result, nil
+	}
+
+	// Intersect smallest first: the running result only shrinks
+	slices.SortFunc(sets, func(a, b []Keytype) int { return len(a) - len(b) })
+	result := sets[0]
+	for _, keys := range sets[1:] {
+		if result = intersectSorted(result, keys); len(result) == 0 {
+			return nil, nil
+		}
+	}
+	return result, nil
+}
+
+// sortedKeys sorts keys in place and drops duplicates.
+func sortedKeys(keys []Keytype) []Keytype {
+	slices.SortFunc(keys, func(a, b Keytype) int { return bytes.Compare(a, b) })
+	return slices.CompactFunc(keys, func(a, b Keytype) bool { return bytes.Equal(a, b) })
+}
+
+// intersectSorted returns the keys present in both sorted sets.
+func intersectSorted(a, b []Keytype) []Keytype {
+	var result []Keytype
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch c := bytes.Compare(a[i], b[j]); {
+		case c < 0:
+			i++
+		case c > 0:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// unionSorted returns the keys present in either sorted set.
+func unionSorted(a, b []Keytype) []Keytype {
+	result := make([]Keytype, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch c := bytes.Compare(a[i], b[j]); {
+		case c < 0:
+			result = append(result, a[i])
+			i++
+		case c > 0:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
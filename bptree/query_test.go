@@ -0,0 +1,81 @@
+package bptree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func queryTestDB(t *testing.T) *IndexedBTree {
+	t.Helper()
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+	db.CreateIndex("plan", JSONFieldExtractor("plan"), false)
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+
+	users := []struct{ city, plan string }{
+		{"NYC", "pro"}, {"NYC", "free"}, {"LA", "pro"}, {"NYC", "pro"}, {"SF", "team"}, {"NYC", "team"},
+	}
+	// Insert in reverse so index entries are not already in key order
+	for i := len(users) - 1; i >= 0; i-- {
+		record := fmt.Sprintf(`{"city":"%s","plan":"%s","email":"u%d@x.com"}`, users[i].city, users[i].plan, i)
+		if err := db.Insert([]byte(fmt.Sprintf("user:%d", i)), []byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestFindByIndexes(t *testing.T) {
+	db := queryTestDB(t)
+
+	cases := []struct {
+		name  string
+		query IndexQuery
+		want  string
+	}{
+		{"match", Match("city", []byte("SF")), "[user:4]"},
+		{"and", And(Match("city", []byte("NYC")), Match("plan", []byte("pro"))), "[user:0 user:3]"},
+		{"or", Or(Match("plan", []byte("team")), Match("city", []byte("LA"))), "[user:2 user:4 user:5]"},
+		{"or overlapping", Or(Match("city", []byte("NYC")), Match("plan", []byte("pro"))), "[user:0 user:1 user:2 user:3 user:5]"},
+		{"nested", And(Match("city", []byte("NYC")), Or(Match("plan", []byte("pro")), Match("plan", []byte("team")))), "[user:0 user:3 user:5]"},
+		{"unique index", And(Match("email", []byte("u3@x.com")), Match("plan", []byte("pro"))), "[user:3]"},
+		{"no match", And(Match("city", []byte("LA")), Match("plan", []byte("free"))), "[]"},
+		{"missing value", Or(Match("city", []byte("Paris")), Match("city", []byte("SF"))), "[user:4]"},
+	}
+	for _, tc := range cases {
+		keys, err := db.FindByIndexes(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := fmt.Sprintf("%s", keys); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFindByIndexesErrors(t *testing.T) {
+	db := queryTestDB(t)
+
+	if _, err := db.FindByIndexes(And(Match("city", []byte("NYC")), Match("missing", []byte("x")))); err == nil {
+		t.Error("Expected error for unknown index")
+	}
+	if _, err := db.FindByIndexes(Or()); err == nil {
+		t.Error("Expected error for empty Or")
+	}
+}
+
+func TestSortedSetMerge(t *testing.T) {
+	a := []Keytype{[]byte("a"), []byte("c"), []byte("e")}
+	b := []Keytype{[]byte("b"), []byte("c"), []byte("f")}
+
+	if got := fmt.Sprintf("%s", intersectSorted(a, b)); got != "[c]" {
+		t.Errorf("intersectSorted = %s, want [c]", got)
+	}
+	if got := fmt.Sprintf("%s", unionSorted(a, b)); got != "[a b c e f]" {
+		t.Errorf("unionSorted = %s, want [a b c e f]", got)
+	}
+	if got := intersectSorted(a, nil); len(got) != 0 {
+		t.Errorf("intersectSorted with empty set = %s", got)
+	}
+}
@@ -0,0 +1,115 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// ErrReadOnly is returned by the mutating methods of a ShardView.
+var ErrReadOnly = errors.New("shard view is read-only")
+
+// ShardView is a read-only view of one shard, for backup and snapshot tools
+// that walk shards independently. Reads go straight to the shard, taking
+// only its own latches, and its mutating methods return ErrReadOnly.
+//
+// DESIGN:
+// - Holds the shard itself, so it stays valid across Resize and Clear
+// - Expired keys are hidden, as for the tree's own reads
+// - Reads are not counted in Stats or hot keys
+//
+// LIMITATIONS:
+// - Not a snapshot: writes to the tree may or may not be seen by a walk in progress (use Snapshot for that)
+// - During a Resize, keys migrating off the shard disappear from the view, and keys migrating on appear
+//
+// USAGE:
+//
+//	view, _ := tree.ReadOnlyShard(i)
+//	for key, value := range view.All() {
+//		backup.Write(key, value)
+//	}
+type ShardView struct {
+	index int
+	shard *Btree
+	ts    *ttlShard // nil without TTL
+}
+
+// ReadOnlyShard returns a read-only view of shard i.
+func (s *ShardedBTree) ReadOnlyShard(i int) (*ShardView, error) {
+	s.pin()
+	defer s.unpin()
+	if i < 0 || i >= len(s.shards) {
+		return nil, fmt.Errorf("shard %d out of range [0, %d)", i, len(s.shards))
+	}
+	return &ShardView{index: i, shard: s.shards[i], ts: s.ttlShard(i)}, nil
+}
+
+// Index returns the shard's index in the tree.
+func (v *ShardView) Index() int {
+	return v.index
+}
+
+// Len returns the number of keys in the shard, including expired keys not
+// yet swept.
+func (v *ShardView) Len() int64 {
+	return v.shard.Len()
+}
+
+// Find returns the value stored under key, if the shard holds it.
+func (v *ShardView) Find(key Keytype) (Valuetype, error) {
+	v.ts.rlock()
+	defer v.ts.runlock()
+	value, err := v.shard.Find(key)
+	if err == nil && v.ts.expired(key, ttlNow()) {
+		return nil, errors.New("key not found")
+	}
+	return value, err
+}
+
+// All returns an iterator over the shard's pairs in ascending key order.
+// Pairs are copied out in batches, so the loop body may write to the tree.
+func (v *ShardView) All() iter.Seq2[[]byte, []byte] {
+	return v.iterate(nil, nil, false)
+}
+
+// Range returns an iterator over the shard's pairs in [startKey, endKey] in
+// ascending key order.
+func (v *ShardView) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return v.iterate(startKey, endKey, true)
+}
+
+// Cursor returns a Cursor over the shard, positioned before its first pair.
+func (v *ShardView) Cursor() *Cursor {
+	return &Cursor{c: newShardCursor(v.shard, v.ts, nil, nil, false, rangeBatchSize)}
+}
+
+func (v *ShardView) iterate(startKey, endKey []byte, bounded bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		c := newShardCursor(v.shard, v.ts, startKey, endKey, bounded, rangeBatchSize)
+		for ok := c.load(); ok; ok = c.advance() {
+			if !yield(c.keys[c.pos], c.values[c.pos]) {
+				return
+			}
+		}
+	}
+}
+
+// TryInsert returns ErrReadOnly.
+func (v *ShardView) TryInsert(key Keytype, value Valuetype) error {
+	return ErrReadOnly
+}
+
+// TryDelete returns ErrReadOnly.
+func (v *ShardView) TryDelete(key Keytype) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// DeleteRange returns ErrReadOnly.
+func (v *ShardView) DeleteRange(startKey, endKey []byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// Clear returns ErrReadOnly.
+func (v *ShardView) Clear() error {
+	return ErrReadOnly
+}
@@ -0,0 +1,80 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyShard(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	for i := 0; i < 400; i++ {
+		tree.Insert(Keytype(fmt.Sprintf("key-%03d", i)), Valuetype(fmt.Sprintf("v%d", i)))
+	}
+	tree.InsertWithTTL(Keytype("expired"), Valuetype("x"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if _, err := tree.ReadOnlyShard(4); err == nil {
+		t.Error("ReadOnlyShard(4) of 4 shards succeeded")
+	}
+
+	total := 0
+	for i := 0; i < tree.NumShards(); i++ {
+		view, err := tree.ReadOnlyShard(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var last []byte
+		for key, value := range view.All() {
+			if string(key) == "expired" {
+				t.Error("All yielded an expired key")
+			}
+			if last != nil && string(key) <= string(last) {
+				t.Fatalf("Shard %d: %q after %q", i, key, last)
+			}
+			if got, err := view.Find(key); err != nil || string(got) != string(value) {
+				t.Errorf("Find(%q) = %q, %v, want %q", key, got, err, value)
+			}
+			last = key
+			total++
+		}
+
+		if err := view.TryInsert(Keytype("new"), Valuetype("v")); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("TryInsert = %v, want ErrReadOnly", err)
+		}
+		if _, err := view.TryDelete(last); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("TryDelete = %v, want ErrReadOnly", err)
+		}
+		if _, err := view.DeleteRange(nil, []byte{0xff}); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("DeleteRange = %v, want ErrReadOnly", err)
+		}
+		if err := view.Clear(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Clear = %v, want ErrReadOnly", err)
+		}
+	}
+	if total != 400 {
+		t.Errorf("Views yielded %d pairs, want 400", total)
+	}
+	if n := tree.Count(); n != 401 {
+		t.Errorf("Count = %d after read-only views, want 401", n)
+	}
+
+	view, _ := tree.ReadOnlyShard(0)
+	if _, err := view.Find(Keytype("expired")); err == nil {
+		t.Error("Find returned an expired key")
+	}
+	n := 0
+	for range view.Range([]byte("key-100"), []byte("key-199")) {
+		n++
+	}
+	c, counted := view.Cursor(), 0
+	for c.Next() {
+		if string(c.Key()) >= "key-100" && string(c.Key()) <= "key-199" {
+			counted++
+		}
+	}
+	if n != counted {
+		t.Errorf("Range yielded %d pairs, Cursor saw %d in range", n, counted)
+	}
+}
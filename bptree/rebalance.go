@@ -0,0 +1,133 @@
+package bptree
+
+import "errors"
+
+// ErrNoRing is returned by Rebalance under rendezvous placement, which has
+// no arcs to move.
+var ErrNoRing = errors.New("rebalancing moves hash ring arcs: it needs ShardConfig.ConsistentHashing, not Rendezvous")
+
+// defaultRebalanceSkew is the shard skew Rebalance tolerates by default.
+const defaultRebalanceSkew = 0.1
+
+// Rebalance evens out shards whose key counts have drifted apart, and
+// reports whether it started moving keys. It does nothing while Stats().Skew
+// is within ShardConfig.RebalanceSkew.
+//
+// DESIGN:
+// - Counts the keys on every arc of the hash ring, then hands arcs from the fullest shard to the emptiest while that narrows their gap
+// - The new ring keeps the same points, so only the keys of reassigned arcs move
+// - Keys migrate in the background as for Resize: reads and writes continue, and MigrationProgress reports how far it got
+//
+// LIMITATIONS:
+// - Needs ShardConfig.ConsistentHashing (ErrNoRing with Rendezvous); returns ErrMigrating while a resize or rebalance runs
+// - Moves whole arcs, so a few very hot arcs bound how even shards can get: raise VirtualNodes for finer arcs
+// - Counting arcs walks every key
+//
+// USAGE:
+//
+//	if started, _ := tree.Rebalance(); started {
+//		tree.WaitMigration()
+//	}
+func (s *ShardedBTree) Rebalance() (bool, error) {
+	if !s.resizable {
+		return false, ErrNotResizable
+	}
+	if s.Stats(true).Skew <= s.rebalanceSkew {
+		return false, nil
+	}
+
+	s.pin()
+	ring, ok := s.place.(*hashRing)
+	busy := s.migration != nil
+	var arcKeys []int64
+	if ok && !busy {
+		arcKeys = s.countArcs(ring)
+	}
+	numShards := len(s.shards)
+	s.unpin()
+	if !ok {
+		return false, ErrNoRing
+	}
+	if busy {
+		return false, ErrMigrating
+	}
+
+	owners := balanceArcs(ring.owners, arcKeys, numShards)
+	if owners == nil {
+		return false, nil // No arc can move without widening a gap
+	}
+
+	s.layoutMu.Lock()
+	defer s.layoutMu.Unlock()
+	if s.migration != nil || s.place != placement(ring) {
+		return false, ErrMigrating // A resize started while counting
+	}
+	s.startMigration(&hashRing{points: ring.points, owners: owners, vnodes: ring.vnodes}, len(s.shards))
+	return true, nil
+}
+
+// countArcs returns the number of keys on each arc of ring. Called pinned.
+func (s *ShardedBTree) countArcs(ring *hashRing) []int64 {
+	arcKeys := make([]int64, len(ring.points))
+	for _, shard := range s.shards {
+		for key := range shard.All() {
+			arcKeys[ring.arc(s.hash(key))]++
+		}
+	}
+	return arcKeys
+}
+
+// balanceArcs returns new arc owners that even out shard loads, or nil if
+// no arc should move. Each step moves the arc of the fullest shard that best
+// halves its gap to the emptiest shard.
+func balanceArcs(owners []int, arcKeys []int64, numShards int) []int {
+	load := make([]int64, numShards)
+	for i, owner := range owners {
+		load[owner] += arcKeys[i]
+	}
+
+	next := append([]int(nil), owners...)
+	changed := false
+	for range owners { // Each step lowers the load variance; the bound is a safeguard
+		heavy, light := 0, 0
+		for shard := range load {
+			if load[shard] > load[heavy] {
+				heavy = shard
+			}
+			if load[shard] < load[light] {
+				light = shard
+			}
+		}
+		gap := load[heavy] - load[light]
+
+		best := -1
+		for i, owner := range next {
+			if owner != heavy || arcKeys[i] == 0 || arcKeys[i] >= gap {
+				continue // Moving it would not narrow the gap
+			}
+			if best < 0 || abs64(2*arcKeys[i]-gap) < abs64(2*arcKeys[best]-gap) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		next[best] = light
+		load[heavy] -= arcKeys[best]
+		load[light] += arcKeys[best]
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return next
+}
+
+// abs64 returns the absolute value of x.
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
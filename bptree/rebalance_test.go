@@ -0,0 +1,78 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRebalance(t *testing.T) {
+	if _, err := NewShardedBTree(ShardConfig{NumShards: 4}).Rebalance(); !errors.Is(err, ErrNotResizable) {
+		t.Errorf("Rebalance of a modulo tree = %v, want ErrNotResizable", err)
+	}
+
+	// Few virtual nodes leave the ring lopsided
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, ConsistentHashing: true, VirtualNodes: 8})
+	const keys = 8000
+	for i := 0; i < keys; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	before := tree.Stats(false).Skew
+	if before <= defaultRebalanceSkew {
+		t.Fatalf("Skew before rebalancing = %.3f, too even to test", before)
+	}
+
+	var stop atomic.Bool
+	var failures atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; !stop.Load(); i = (i + 1) % keys {
+			if _, err := tree.Find([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+				failures.Add(1)
+			}
+		}
+	}()
+
+	started, err := tree.Rebalance()
+	if err != nil || !started {
+		t.Fatalf("Rebalance = %v, %v", started, err)
+	}
+	if status := tree.MigrationProgress(); status.Running && status.Total != keys {
+		t.Errorf("MigrationProgress = %+v, want Total %d", status, keys)
+	}
+	tree.WaitMigration()
+	stop.Store(true)
+	wg.Wait()
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("%d lookups missed during the rebalance", n)
+	}
+	if after := tree.Stats(false).Skew; after >= before {
+		t.Errorf("Skew after rebalancing = %.3f, was %.3f", after, before)
+	}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		if value, err := tree.Find([]byte(key)); err != nil || string(value) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Find(%s) = %q, %v", key, value, err)
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalanceArcs(t *testing.T) {
+	// Shard 0 owns arcs of 50, 30 and 20 keys; shard 1 owns one of 10. Moving
+	// the 50 best halves the 90-key gap, leaving 50 and 60
+	owners := balanceArcs([]int{0, 0, 0, 1}, []int64{50, 30, 20, 10}, 2)
+	if want := []int{1, 0, 0, 1}; fmt.Sprint(owners) != fmt.Sprint(want) {
+		t.Errorf("balanceArcs = %v, want %v", owners, want)
+	}
+	if owners := balanceArcs([]int{0, 1}, []int64{10, 10}, 2); owners != nil {
+		t.Errorf("balanceArcs of even shards = %v, want nil", owners)
+	}
+}
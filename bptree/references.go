@@ -0,0 +1,254 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ReferentialBTree enforces references between records of a DurableBTree:
+// a record may declare the primary keys it refers to, and deleting or
+// renaming a referenced key is restricted or cascaded.
+//
+// DESIGN:
+// - References are by primary key, extracted from each record's value by RefConfig.Extract
+// - A reverse entry (target, referrer) per reference lives in the reserved keyspace, so Delete finds referrers without a scan
+// - Every write, with its reverse-entry changes and cascades, is one WAL-logged batch (see DurableBTree.BulkInsert)
+// - Writes are serialized; reads go straight to the underlying tree
+//
+// LIMITATIONS:
+// - Writes made directly to the DurableBTree bypass the checks and the reverse entries
+// - Like BulkInsert, a batch torn by a crash before its WAL sync may be partly replayed
+//
+// USAGE:
+//
+//	orders, _ := NewReferentialBTree(db, RefConfig{
+//		Extract:  func(key, value []byte) []Keytype { return []Keytype{customerOf(value)} },
+//		OnDelete: Cascade,
+//	})
+//	orders.Insert([]byte("order:1"), order) // Fails unless the customer exists
+//	orders.Delete([]byte("customer:7"))     // Also deletes customer 7's orders
+type ReferentialBTree struct {
+	db     *DurableBTree
+	config RefConfig
+	mu     sync.Mutex // Serializes writes, so checks and batches do not interleave
+}
+
+// OnDelete is what deleting a referenced key does to its referrers.
+type OnDelete int
+
+const (
+	// Restrict refuses to delete a key that is still referenced
+	Restrict OnDelete = iota
+	// Cascade deletes the referrers too, and theirs in turn
+	Cascade
+)
+
+// RefConfig configures a ReferentialBTree.
+type RefConfig struct {
+	// Extract returns the primary keys a record refers to (required)
+	Extract func(key, value []byte) []Keytype
+
+	// OnDelete applies to Delete and, without Rewrite, to Rename (default: Restrict)
+	OnDelete OnDelete
+
+	// Rewrite returns a referrer's value with references to oldKey changed
+	// to newKey. Without it, renaming a referenced key fails.
+	Rewrite func(value []byte, oldKey, newKey []byte) []byte
+}
+
+var (
+	// ErrReferenced is returned for a write that would leave a reference
+	// to a deleted or renamed key.
+	ErrReferenced = errors.New("key is referenced")
+
+	// ErrDanglingReference is returned for a record referring to a key
+	// that does not exist.
+	ErrDanglingReference = errors.New("reference to missing key")
+)
+
+// refKeyPrefix is the reserved keyspace holding reverse references:
+// prefix + length-prefixed target + referrer → empty.
+const refKeyPrefix = reservedKeyPrefix + "refs/"
+
+// NewReferentialBTree enforces config's references on db.
+func NewReferentialBTree(db *DurableBTree, config RefConfig) (*ReferentialBTree, error) {
+	if config.Extract == nil {
+		return nil, errors.New("RefConfig.Extract is required")
+	}
+	return &ReferentialBTree{db: db, config: config}, nil
+}
+
+// Insert adds or replaces a record. Fails with ErrDanglingReference if it
+// refers to a key that does not exist.
+func (r *ReferentialBTree) Insert(key Keytype, value Valuetype) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.commit(map[string]refChange{string(key): {value: value}})
+}
+
+// Delete removes a record. A referenced key fails with ErrReferenced under
+// Restrict, and takes its referrers with it under Cascade.
+func (r *ReferentialBTree) Delete(key Keytype) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.db.Find(key); err != nil {
+		return false, nil
+	}
+	changes := map[string]refChange{string(key): {deleted: true}}
+	if r.config.OnDelete == Cascade {
+		for queue := []Keytype{key}; len(queue) > 0; queue = queue[1:] {
+			for _, referrer := range r.referrers(queue[0]) {
+				if _, seen := changes[string(referrer)]; !seen {
+					changes[string(referrer)] = refChange{deleted: true}
+					queue = append(queue, referrer)
+				}
+			}
+		}
+	}
+	if err := r.commit(changes); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rename moves a record to newKey, which must not exist, rewriting its
+// referrers with RefConfig.Rewrite.
+func (r *ReferentialBTree) Rename(oldKey, newKey Keytype) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value, err := r.db.Find(oldKey)
+	if err != nil {
+		return fmt.Errorf("cannot rename %q: %w", oldKey, err)
+	}
+	if _, err := r.db.Find(newKey); err == nil {
+		return fmt.Errorf("cannot rename %q: %q already exists", oldKey, newKey)
+	}
+
+	changes := map[string]refChange{string(oldKey): {deleted: true}}
+	if r.config.Rewrite != nil {
+		for _, referrer := range r.referrers(oldKey) {
+			if bytes.Equal(referrer, oldKey) {
+				value = r.config.Rewrite(value, oldKey, newKey)
+				continue
+			}
+			referrerValue, err := r.db.Find(referrer)
+			if err != nil {
+				return fmt.Errorf("referrer %q is missing: %w", referrer, err)
+			}
+			changes[string(referrer)] = refChange{value: r.config.Rewrite(referrerValue, oldKey, newKey)}
+		}
+	}
+	changes[string(newKey)] = refChange{value: value}
+	return r.commit(changes)
+}
+
+// Find returns the record at key.
+func (r *ReferentialBTree) Find(key Keytype) (Valuetype, error) {
+	return r.db.Find(key)
+}
+
+// Referrers returns the keys of the records referring to key, in order.
+func (r *ReferentialBTree) Referrers(key Keytype) []Keytype {
+	return r.referrers(key)
+}
+
+// refChange is the new state of one record in a write.
+type refChange struct {
+	value   Valuetype
+	deleted bool
+}
+
+// commit checks that changes leave no reference dangling, then writes them
+// and their reverse entries as one batch. Called with r.mu held.
+func (r *ReferentialBTree) commit(changes map[string]refChange) error {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		if isReservedKey([]byte(key)) {
+			return fmt.Errorf("cannot write reserved key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Deterministic batches
+
+	existsAfter := func(key Keytype) bool {
+		if change, ok := changes[string(key)]; ok {
+			return !change.deleted
+		}
+		_, err := r.db.Find(key)
+		return err == nil
+	}
+
+	var ops []batchOp
+	for _, k := range keys {
+		key, change := Keytype(k), changes[k]
+		var oldRefs, newRefs map[string]bool
+		oldValue, err := r.db.Find(key)
+		existed := err == nil
+		if existed {
+			oldRefs = r.refsOf(key, oldValue)
+		}
+		if !change.deleted {
+			newRefs = r.refsOf(key, change.value)
+		}
+
+		for target := range oldRefs {
+			if !newRefs[target] {
+				ops = append(ops, batchOp{op: OpDelete, key: refKey(Keytype(target), key)})
+			}
+		}
+		for target := range newRefs {
+			if !existsAfter(Keytype(target)) {
+				return fmt.Errorf("%w: %q refers to %q", ErrDanglingReference, key, target)
+			}
+			if !oldRefs[target] {
+				ops = append(ops, batchOp{op: OpInsert, key: refKey(Keytype(target), key)})
+			}
+		}
+
+		if !change.deleted {
+			ops = append(ops, batchOp{op: OpInsert, key: key, value: change.value})
+			continue
+		}
+		for _, referrer := range r.referrers(key) {
+			if _, changed := changes[string(referrer)]; !changed {
+				return fmt.Errorf("%w: %q by %q", ErrReferenced, key, referrer)
+			}
+			// A changed referrer's new references were checked above
+		}
+		if existed {
+			ops = append(ops, batchOp{op: OpDelete, key: key})
+		}
+	}
+	return r.db.writeBatch(ops)
+}
+
+// refsOf returns the set of keys the record refers to.
+func (r *ReferentialBTree) refsOf(key Keytype, value Valuetype) map[string]bool {
+	refs := make(map[string]bool)
+	for _, target := range r.config.Extract(key, value) {
+		refs[string(target)] = true
+	}
+	return refs
+}
+
+// referrers returns the keys of the records referring to target.
+func (r *ReferentialBTree) referrers(target Keytype) []Keytype {
+	prefix := refKey(target, nil)
+	var referrers []Keytype
+	for key := range r.db.InRange(prefixRange(prefix)) {
+		referrers = append(referrers, key[len(prefix):])
+	}
+	sort.Slice(referrers, func(i, j int) bool { return bytes.Compare(referrers[i], referrers[j]) < 0 })
+	return referrers
+}
+
+// refKey returns the reverse entry recording that referrer refers to target.
+func refKey(target, referrer Keytype) Keytype {
+	key := appendBytes([]byte(refKeyPrefix), target)
+	return append(key, referrer...)
+}
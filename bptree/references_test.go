@@ -0,0 +1,126 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// refTestConfig: a value "ref:a,ref:b|payload" refers to keys a and b.
+func refTestConfig(onDelete OnDelete) RefConfig {
+	return RefConfig{
+		Extract: func(_, value []byte) []Keytype {
+			refs, _, _ := bytes.Cut(value, []byte("|"))
+			var keys []Keytype
+			for _, ref := range bytes.Split(refs, []byte(",")) {
+				if target, ok := bytes.CutPrefix(ref, []byte("ref:")); ok {
+					keys = append(keys, target)
+				}
+			}
+			return keys
+		},
+		OnDelete: onDelete,
+		Rewrite: func(value, oldKey, newKey []byte) []byte {
+			return bytes.ReplaceAll(value, append([]byte("ref:"), oldKey...), append([]byte("ref:"), newKey...))
+		},
+	}
+}
+
+func TestReferentialRestrict(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db := openMigrationTestDB(t, walPath)
+	refs, err := NewReferentialBTree(db, refTestConfig(Restrict))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := refs.Insert([]byte("order:1"), []byte("ref:customer:1|")); !errors.Is(err, ErrDanglingReference) {
+		t.Fatalf("Insert with missing target = %v, want ErrDanglingReference", err)
+	}
+	refs.Insert([]byte("customer:1"), []byte("|ada"))
+	refs.Insert([]byte("customer:2"), []byte("|bob"))
+	if err := refs.Insert([]byte("order:1"), []byte("ref:customer:1|")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := refs.Delete([]byte("customer:1")); !errors.Is(err, ErrReferenced) {
+		t.Errorf("Delete of referenced key = %v, want ErrReferenced", err)
+	}
+
+	// Repointing the order releases customer 1
+	refs.Insert([]byte("order:1"), []byte("ref:customer:2|"))
+	if deleted, err := refs.Delete([]byte("customer:1")); !deleted || err != nil {
+		t.Errorf("Delete of released key = %v, %v", deleted, err)
+	}
+	db.Close()
+
+	// Reverse entries are durable
+	db = openMigrationTestDB(t, walPath)
+	defer db.Close()
+	refs, _ = NewReferentialBTree(db, refTestConfig(Restrict))
+	if got := refs.Referrers([]byte("customer:2")); len(got) != 1 || string(got[0]) != "order:1" {
+		t.Errorf("Referrers after restart = %q", got)
+	}
+	if _, err := refs.Delete([]byte("customer:2")); !errors.Is(err, ErrReferenced) {
+		t.Errorf("Delete after restart = %v, want ErrReferenced", err)
+	}
+}
+
+func TestReferentialCascade(t *testing.T) {
+	db := openMigrationTestDB(t, filepath.Join(t.TempDir(), "test.wal"))
+	defer db.Close()
+	refs, _ := NewReferentialBTree(db, refTestConfig(Cascade))
+
+	refs.Insert([]byte("customer:1"), []byte("|"))
+	refs.Insert([]byte("order:1"), []byte("ref:customer:1|"))
+	refs.Insert([]byte("line:1"), []byte("ref:order:1|"))
+	refs.Insert([]byte("loop"), []byte("ref:loop,ref:customer:1|")) // Refers to itself too
+	refs.Insert([]byte("other"), []byte("|"))
+
+	if deleted, err := refs.Delete([]byte("customer:1")); !deleted || err != nil {
+		t.Fatalf("Cascading delete = %v, %v", deleted, err)
+	}
+	if db.Count() != 1 {
+		var left []string
+		db.ForEach(func(key Keytype, _ Valuetype) bool {
+			left = append(left, string(key))
+			return true
+		})
+		t.Errorf("After cascade %d keys remain: %q, want only other", db.Count(), left)
+	}
+}
+
+func TestReferentialRename(t *testing.T) {
+	db := openMigrationTestDB(t, filepath.Join(t.TempDir(), "test.wal"))
+	defer db.Close()
+	refs, _ := NewReferentialBTree(db, refTestConfig(Restrict))
+
+	refs.Insert([]byte("customer:1"), []byte("|ada"))
+	refs.Insert([]byte("order:1"), []byte("ref:customer:1|"))
+	refs.Insert([]byte("order:2"), []byte("ref:customer:1,ref:order:1|"))
+
+	if err := refs.Rename([]byte("customer:1"), []byte("customer:ada")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := refs.Find([]byte("order:2")); string(value) != "ref:customer:ada,ref:order:1|" {
+		t.Errorf("order:2 = %q", value)
+	}
+	if got := refs.Referrers([]byte("customer:ada")); len(got) != 2 {
+		t.Errorf("Referrers(customer:ada) = %q", got)
+	}
+	if got := refs.Referrers([]byte("customer:1")); len(got) != 0 {
+		t.Errorf("Stale reverse entries: %q", got)
+	}
+
+	// Without Rewrite, renaming a referenced key is refused
+	config := refTestConfig(Restrict)
+	config.Rewrite = nil
+	strict, _ := NewReferentialBTree(db, config)
+	if err := strict.Rename([]byte("customer:ada"), []byte("customer:2")); !errors.Is(err, ErrReferenced) {
+		t.Errorf("Rename without Rewrite = %v, want ErrReferenced", err)
+	}
+	if err := strict.Rename([]byte("order:2"), []byte("order:3")); err != nil {
+		t.Errorf("Rename of unreferenced key = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package bptree
+
+import "fmt"
+
+// placement maps key hashes to shards for a resizable tree: a hashRing with
+// ShardConfig.ConsistentHashing, a rendezvous with ShardConfig.Rendezvous.
+type placement interface {
+	// locate returns the shard owning hash.
+	locate(hash uint32) int
+
+	// grow returns a copy with shards [from, to) added. Only hashes the new
+	// shards take over change owner.
+	grow(from, to int) placement
+}
+
+// rendezvous places key hashes on shards by highest random weight: every
+// shard scores each hash, and the highest score owns it. Adding a shard moves
+// exactly the hashes it outscores everyone on, about 1 in the new count, and
+// removing one would move only its own.
+type rendezvous struct {
+	seeds []uint64 // seeds[i] scores hashes for shard i
+}
+
+// newRendezvous returns a placement over numShards shards.
+func newRendezvous(numShards int) *rendezvous {
+	return (&rendezvous{}).withShards(0, numShards)
+}
+
+// withShards returns a copy with shards [from, to) added. A shard's seed
+// depends only on its index, so existing shards keep their scores.
+func (r *rendezvous) withShards(from, to int) *rendezvous {
+	seeds := append(make([]uint64, 0, to), r.seeds...)
+	for shard := from; shard < to; shard++ {
+		seeds = append(seeds, uint64(fnv32a([]byte(fmt.Sprintf("shard-%d", shard))))<<32|uint64(shard))
+	}
+	return &rendezvous{seeds: seeds}
+}
+
+func (r *rendezvous) grow(from, to int) placement {
+	return r.withShards(from, to)
+}
+
+// locate returns the shard scoring hash highest, the lowest index on a tie.
+// Costs one score per shard.
+func (r *rendezvous) locate(hash uint32) int {
+	best, bestWeight := 0, uint64(0)
+	for shard, seed := range r.seeds {
+		if w := rendezvousWeight(hash, seed); w > bestWeight || shard == 0 {
+			best, bestWeight = shard, w
+		}
+	}
+	return best
+}
+
+// rendezvousWeight scores hash for the shard with seed: the splitmix64
+// finalizer over both, so scores for different shards are independent.
+func rendezvousWeight(hash uint32, seed uint64) uint64 {
+	x := seed ^ uint64(hash)*0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
@@ -0,0 +1,70 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRendezvousGrowth(t *testing.T) {
+	before := newRendezvous(4)
+	after := before.withShards(4, 5)
+
+	const keys = 10000
+	moved := 0
+	perShard := make([]int, 5)
+	for i := 0; i < keys; i++ {
+		hash := fnv32a([]byte(fmt.Sprintf("key%05d", i)))
+		from, to := before.locate(hash), after.locate(hash)
+		if from != to {
+			moved++
+			if to != 4 {
+				t.Fatalf("key%05d moved between old shards %d and %d", i, from, to)
+			}
+		}
+		perShard[to]++
+	}
+	// Only the new shard's fifth moves
+	if moved < keys/5*4/5 || moved > keys/5*6/5 {
+		t.Errorf("%d of %d keys moved, want about %d", moved, keys, keys/5)
+	}
+	for shard, n := range perShard {
+		if n < keys/5*4/5 || n > keys/5*6/5 {
+			t.Errorf("Shard %d holds %d keys, want about %d", shard, n, keys/5)
+		}
+	}
+}
+
+func TestRendezvousResize(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 3, Rendezvous: true})
+	const keys = 3000
+	for i := 0; i < keys; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	if err := tree.Resize(5); err != nil {
+		t.Fatal(err)
+	}
+	tree.WaitMigration()
+
+	if tree.Count() != keys {
+		t.Errorf("Count after resize = %d, want %d", tree.Count(), keys)
+	}
+	for i := 0; i < keys; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if v, err := tree.Find(key); err != nil || string(v) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Find(%s) = %q, %v", key, v, err)
+		}
+	}
+	for i, shard := range tree.shards {
+		if shard.Len() == 0 {
+			t.Errorf("Shard %d is empty after resize", i)
+		}
+	}
+
+	// There are no arcs to rebalance, even when skewed
+	tree.rebalanceSkew = 1e-9
+	if _, err := tree.Rebalance(); !errors.Is(err, ErrNoRing) {
+		t.Errorf("Rebalance = %v, want ErrNoRing", err)
+	}
+}
@@ -0,0 +1,297 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+)
+
+// Errors returned by Resize and Rebalance.
+var (
+	ErrNotResizable = errors.New("shard placement is fixed: resizing and rebalancing need ShardConfig.ConsistentHashing or Rendezvous")
+	ErrMigrating    = errors.New("a resize or rebalance is already in progress")
+)
+
+const (
+	// defaultVirtualNodes is the number of ring points per shard.
+	defaultVirtualNodes = 128
+
+	// migrateBatchSize is how many keys a resize scans per exclusive pause.
+	migrateBatchSize = 256
+)
+
+// hashRing places key hashes on shards by consistent hashing: each shard owns
+// the arcs ending at its virtual nodes' points.
+type hashRing struct {
+	points []uint32 // Ascending
+	owners []int    // owners[i] is the shard owning points[i]
+	vnodes int      // Points per added shard
+}
+
+// newHashRing returns a ring of numShards shards with vnodes points each.
+func newHashRing(numShards, vnodes int) *hashRing {
+	return (&hashRing{vnodes: vnodes}).withShards(0, numShards)
+}
+
+// withShards returns a copy of the ring with shards [from, to) added. Points
+// of existing shards stay put, so only keys on the new shards' arcs move.
+func (r *hashRing) withShards(from, to int) *hashRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	vnodes := r.vnodes
+	points := make([]point, 0, len(r.points)+(to-from)*vnodes)
+	for i := range r.points {
+		points = append(points, point{r.points[i], r.owners[i]})
+	}
+	for shard := from; shard < to; shard++ {
+		for v := 0; v < vnodes; v++ {
+			points = append(points, point{fnv32a([]byte(fmt.Sprintf("shard-%d-vnode-%d", shard, v))), shard})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	next := &hashRing{points: make([]uint32, len(points)), owners: make([]int, len(points)), vnodes: vnodes}
+	for i, p := range points {
+		next.points[i], next.owners[i] = p.hash, p.owner
+	}
+	return next
+}
+
+func (r *hashRing) grow(from, to int) placement {
+	return r.withShards(from, to)
+}
+
+// locate returns the shard owning hash: the owner of the first point at or
+// after it, wrapping around.
+func (r *hashRing) locate(hash uint32) int {
+	return r.owners[r.arc(hash)]
+}
+
+// arc returns the index of the point whose arc holds hash.
+func (r *hashRing) arc(hash uint32) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// shardMigration tracks the keys a Resize or Rebalance still has to move.
+// Each source shard is migrated in key order: its keys below cursors[i] that
+// move are already on their new shard.
+type shardMigration struct {
+	from    placement // Placement before the migration
+	cursors []Keytype // Next key to migrate, per source shard
+	done    []bool    // Source shards fully migrated
+	total   int64     // Keys when the migration started
+	scanned int64
+	moved   int64
+	finish  chan struct{} // Closed when the migration completes
+}
+
+// MigrationStatus reports the progress of a Resize or Rebalance.
+type MigrationStatus struct {
+	Running bool
+	Total   int64 // Keys when the migration started
+	Scanned int64 // Keys examined so far
+	Moved   int64 // Keys moved to another shard so far
+}
+
+// Fraction returns the share of keys examined, from 0 to 1. Keys written
+// during the migration may push it briefly past 1.
+func (st MigrationStatus) Fraction() float64 {
+	if st.Total == 0 {
+		return 1
+	}
+	return float64(st.Scanned) / float64(st.Total)
+}
+
+// migrated reports whether key, placed on source shard idx by the old ring,
+// has already been moved.
+func (m *shardMigration) migrated(idx int, key []byte) bool {
+	return m.done[idx] || (m.cursors[idx] != nil && bytes.Compare(key, m.cursors[idx]) < 0)
+}
+
+// pin holds the shard layout steady for an operation. A no-op unless the
+// tree is resizable. Operations must unpin before running hooks, which may
+// call back into the tree.
+func (s *ShardedBTree) pin() {
+	if s.resizable {
+		s.layoutMu.RLock()
+	}
+}
+
+// unpin releases pin.
+func (s *ShardedBTree) unpin() {
+	if s.resizable {
+		s.layoutMu.RUnlock()
+	}
+}
+
+// Resize grows the tree to newShards shards. The new shards are added at
+// once; the keys consistent hashing assigns them, about 1 in newShards,
+// migrate in the background. Resize returns without waiting: WaitMigration
+// blocks until the migration finishes and MigrationProgress reports on it.
+//
+// DESIGN:
+// - Each source shard is migrated in key order; a per-shard cursor tells whether a key has moved yet
+// - Operations read the layout under a shared lock, so a key is always found where the cursors say it is
+// - Batches of migrateBatchSize keys move under the exclusive lock, pausing other operations only briefly
+//
+// LIMITATIONS:
+// - Shards can only be added; Rebalance evens them out afterwards
+// - The All, InRange and Range iterators may miss or repeat keys that migrate while they run
+// - GetRange, GetRangeLimit, KeysInRange and ValuesInRange delay migration batches until they finish
+// - Writes made directly on a shard (GetShard) bypass placement and may be misplaced
+// - A ForEach callback must not write to the tree while a resize may start: it would deadlock
+//
+// USAGE:
+//
+//	tree := NewShardedBTree(ShardConfig{NumShards: 4, ConsistentHashing: true})
+//	tree.Resize(8) // Reads and writes continue meanwhile
+//	tree.WaitMigration()
+func (s *ShardedBTree) Resize(newShards int) error {
+	if !s.resizable {
+		return ErrNotResizable
+	}
+
+	s.layoutMu.Lock()
+	defer s.layoutMu.Unlock()
+
+	if s.migration != nil {
+		return ErrMigrating
+	}
+	oldShards := len(s.shards)
+	if newShards <= oldShards {
+		return fmt.Errorf("resize to %d shards: only growing is supported, from %d", newShards, oldShards)
+	}
+
+	for i := oldShards; i < newShards; i++ {
+		shard := &Btree{}
+		shard.SetPanicFree(s.shards[0].panicFree.Load())
+		shard.SetSizeLimits(int(s.shards[0].maxKeySize.Load()), int(s.shards[0].maxValueSize.Load()))
+		s.shards = append(s.shards, shard)
+		if s.ttl != nil {
+			s.ttl = append(s.ttl, &ttlShard{expires: make(map[string]int64)})
+		}
+		s.counters = append(s.counters, &shardCounters{})
+		if s.hot != nil {
+			s.hot = append(s.hot, newHotKeyTracker(int(s.hot[0].rate), s.hotTopK))
+		}
+		if s.metrics != nil {
+			s.metrics = append(s.metrics, &shardMetrics{})
+		}
+	}
+	s.numShards = uint32(newShards)
+
+	s.startMigration(s.place.grow(oldShards, newShards), oldShards)
+	return nil
+}
+
+// startMigration switches to place and starts moving the keys it reassigns
+// off shards [0, sources). Called with layoutMu held.
+func (s *ShardedBTree) startMigration(place placement, sources int) {
+	migration := &shardMigration{
+		from:    s.place,
+		cursors: make([]Keytype, sources),
+		done:    make([]bool, sources),
+		finish:  make(chan struct{}),
+	}
+	for _, shard := range s.shards[:sources] {
+		migration.total += shard.Len()
+	}
+	s.place = place
+	s.migration = migration
+
+	go s.migrate(migration)
+}
+
+// WaitMigration blocks until the running resize or rebalance, if any, has
+// migrated every key.
+func (s *ShardedBTree) WaitMigration() {
+	s.pin()
+	migration := s.migration
+	s.unpin()
+	if migration != nil {
+		<-migration.finish
+	}
+}
+
+// MigrationProgress reports on the running resize or rebalance.
+func (s *ShardedBTree) MigrationProgress() MigrationStatus {
+	s.pin()
+	defer s.unpin()
+	if s.migration == nil {
+		return MigrationStatus{}
+	}
+	m := s.migration
+	return MigrationStatus{Running: true, Total: m.total, Scanned: m.scanned, Moved: m.moved}
+}
+
+// migrate moves every key the new placement reassigned, one batch at a time.
+func (s *ShardedBTree) migrate(m *shardMigration) {
+	for idx := range m.done {
+		for !s.migrateBatch(m, idx) {
+			runtime.Gosched() // Let waiting operations in between batches
+		}
+	}
+
+	s.layoutMu.Lock()
+	s.migration = nil
+	s.layoutMu.Unlock()
+	close(m.finish)
+}
+
+// migrateBatch moves the reassigned keys among the next migrateBatchSize
+// keys of source shard idx. Returns true once the shard is done.
+func (s *ShardedBTree) migrateBatch(m *shardMigration, idx int) bool {
+	s.layoutMu.Lock()
+	defer s.layoutMu.Unlock()
+
+	source := s.shards[idx]
+	var keys []Keytype
+	var values []Valuetype
+	for k, v := range source.iterate(m.cursors[idx], nil, false) {
+		keys, values = append(keys, k), append(values, v)
+		if len(keys) == migrateBatchSize {
+			break
+		}
+	}
+
+	for i, key := range keys {
+		dest := s.place.locate(s.hash(key))
+		if dest == idx {
+			continue
+		}
+		if err := s.shards[dest].TryInsert(key, values[i]); err != nil {
+			continue // A failed shard keeps the key where it is
+		}
+		source.TryDelete(key)
+		if s.ttl != nil {
+			if expiry, ok := s.ttl[idx].expires[string(key)]; ok {
+				delete(s.ttl[idx].expires, string(key))
+				s.ttl[dest].expires[string(key)] = expiry
+			}
+		}
+		m.moved++
+	}
+
+	m.scanned += int64(len(keys))
+	if len(keys) < migrateBatchSize {
+		m.done[idx] = true
+		return true
+	}
+	// Resume at the smallest key strictly greater than the last one scanned
+	last := keys[len(keys)-1]
+	m.cursors[idx] = append(last[:len(last):len(last)], 0)
+	return false
+}
@@ -0,0 +1,190 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHashRingGrowth(t *testing.T) {
+	before := newHashRing(4, defaultVirtualNodes)
+	after := before.withShards(4, 8)
+
+	const keys = 10000
+	moved := 0
+	perShard := make([]int, 8)
+	for i := 0; i < keys; i++ {
+		hash := fnv32a([]byte(fmt.Sprintf("key%05d", i)))
+		from, to := before.locate(hash), after.locate(hash)
+		if from != to {
+			moved++
+			if to < 4 {
+				t.Fatalf("key%05d moved between old shards %d and %d", i, from, to)
+			}
+		}
+		perShard[to]++
+	}
+	// Half the keys belong on the new shards; modulo placement would move more
+	if moved < keys*2/5 || moved > keys*3/5 {
+		t.Errorf("%d of %d keys moved, want about half", moved, keys)
+	}
+	for shard, n := range perShard {
+		if n < keys/8/2 || n > keys/8*2 {
+			t.Errorf("Shard %d holds %d keys, want about %d", shard, n, keys/8)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, ConsistentHashing: true})
+	const keys = 5000
+	for i := 0; i < keys; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	if err := NewShardedBTree(ShardConfig{NumShards: 4}).Resize(8); !errors.Is(err, ErrNotResizable) {
+		t.Errorf("Resize of a modulo tree = %v, want ErrNotResizable", err)
+	}
+	if err := tree.Resize(4); err == nil {
+		t.Error("Resize to the same shard count succeeded")
+	}
+
+	// Readers and writers keep running through the migration
+	var stop atomic.Bool
+	var failures atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; !stop.Load(); i = (i + 4) % keys {
+				key := []byte(fmt.Sprintf("key%05d", i))
+				if _, err := tree.Find(key); err != nil {
+					failures.Add(1)
+				}
+				if w == 0 {
+					tree.Insert([]byte(fmt.Sprintf("new%05d", i)), []byte("n"))
+				}
+			}
+		}(w)
+	}
+
+	if err := tree.Resize(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Resize(16); !errors.Is(err, ErrMigrating) {
+		t.Errorf("Second Resize = %v, want ErrMigrating", err)
+	}
+	tree.WaitMigration()
+	stop.Store(true)
+	wg.Wait()
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("%d lookups missed during the resize", n)
+	}
+	if tree.NumShards() != 8 {
+		t.Errorf("NumShards = %d, want 8", tree.NumShards())
+	}
+	if status := tree.MigrationProgress(); status.Running {
+		t.Errorf("MigrationProgress after WaitMigration = %+v", status)
+	}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		if value, err := tree.Find([]byte(key)); err != nil || string(value) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Find(%s) = %q, %v", key, value, err)
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	stats := tree.Stats(false)
+	for shard := 4; shard < 8; shard++ {
+		if stats.KeysPerShard[shard] == 0 {
+			t.Errorf("New shard %d received no keys", shard)
+		}
+	}
+}
+
+func TestResizeTTL(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2, ConsistentHashing: true, EnableTTL: true})
+	for i := 0; i < 1000; i++ {
+		tree.InsertWithTTL([]byte(fmt.Sprintf("key%04d", i)), []byte("v"), -1) // Already expired
+	}
+	if err := tree.Resize(4); err != nil {
+		t.Fatal(err)
+	}
+	tree.WaitMigration()
+
+	// Expiries moved with their keys
+	if _, err := tree.Find([]byte("key0000")); err == nil {
+		t.Error("Expired key found after the resize")
+	}
+	if swept := tree.SweepExpired(); swept != 1000 {
+		t.Errorf("SweepExpired = %d, want 1000", swept)
+	}
+}
+
+// readDuringResize runs read against a tree of 20000 keys until its
+// migration from 2 to 8 shards finishes, failing on any result that misses
+// or repeats a key.
+func readDuringResize(t *testing.T, read func(tree *ShardedBTree) []Keytype) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2, ConsistentHashing: true})
+	const keys = 20000
+	for i := 0; i < keys; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte("v"))
+	}
+
+	if err := tree.Resize(8); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tree.WaitMigration()
+	}()
+	for reads := 0; ; reads++ {
+		got := read(tree)
+		if len(got) != keys {
+			t.Fatalf("Read %d returned %d keys, want %d", reads, len(got), keys)
+		}
+		for i, key := range got {
+			if want := fmt.Sprintf("key%05d", i); string(key) != want {
+				t.Fatalf("Read %d has %s at %d, want %s", reads, key, i, want)
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestGetRangeDuringResize(t *testing.T) {
+	readDuringResize(t, func(tree *ShardedBTree) []Keytype {
+		keys, _, err := tree.GetRange([]byte("key"), []byte("key~"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	})
+}
+
+func TestKeysInRangeDuringResize(t *testing.T) {
+	readDuringResize(t, func(tree *ShardedBTree) []Keytype {
+		keys, err := tree.KeysInRange([]byte("key"), []byte("key~"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := tree.ValuesInRange([]byte("key"), []byte("key~"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != len(keys) {
+			t.Fatalf("ValuesInRange returned %d values for %d keys", len(values), len(keys))
+		}
+		return keys
+	})
+}
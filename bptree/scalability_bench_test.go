@@ -3,7 +3,6 @@ package bptree
 import (
 	"fmt"
 	"math/rand"
-	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -106,7 +105,7 @@ func BenchmarkLatencyDistribution(b *testing.B) {
 	}
 
 	b.Run("ReadLatency", func(b *testing.B) {
-		latencies := make([]time.Duration, b.N)
+		var latencies Histogram
 		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 		b.ResetTimer()
@@ -114,38 +113,39 @@ func BenchmarkLatencyDistribution(b *testing.B) {
 			key := fmt.Sprintf("key-%08d", rng.Intn(100000))
 			start := time.Now()
 			tree.Find(Keytype(key))
-			latencies[i] = time.Since(start)
+			latencies.RecordSince(start)
 		}
 		b.StopTimer()
 
-		// Sort and report percentiles
-		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
-		if len(latencies) > 0 {
-			b.ReportMetric(float64(latencies[len(latencies)*50/100].Nanoseconds()), "p50_ns")
-			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99_ns")
-		}
+		reportPercentiles(b, &latencies)
 	})
 
 	b.Run("WriteLatency", func(b *testing.B) {
-		latencies := make([]time.Duration, b.N)
+		var latencies Histogram
 
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			key := fmt.Sprintf("bench-key-%08d", i)
 			start := time.Now()
 			tree.Insert(Keytype(key), Valuetype(fmt.Sprintf("val-%d", i)))
-			latencies[i] = time.Since(start)
+			latencies.RecordSince(start)
 		}
 		b.StopTimer()
 
-		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
-		if len(latencies) > 0 {
-			b.ReportMetric(float64(latencies[len(latencies)*50/100].Nanoseconds()), "p50_ns")
-			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99_ns")
-		}
+		reportPercentiles(b, &latencies)
 	})
 }
 
+// reportPercentiles reports p50/p99/p999 of a nanosecond latency histogram
+func reportPercentiles(b *testing.B, latencies *Histogram) {
+	if latencies.Count() == 0 {
+		return
+	}
+	b.ReportMetric(float64(latencies.Percentile(50)), "p50_ns")
+	b.ReportMetric(float64(latencies.Percentile(99)), "p99_ns")
+	b.ReportMetric(float64(latencies.Percentile(99.9)), "p999_ns")
+}
+
 // BenchmarkScalingLimits finds where performance degrades
 func BenchmarkScalingLimits(b *testing.B) {
 	treeSizes := []int{1000, 10000, 100000, 1000000}
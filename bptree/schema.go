@@ -0,0 +1,175 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Schema declares the types of fields of JSON records, for
+// IndexedBTree.SetSchema.
+//
+// DESIGN:
+// - Each field is a JSON path, as for JSONPathExtractor, with a FieldType
+// - Every write is checked: a field present with the wrong JSON type fails with a SchemaError
+// - CreateSchemaIndex takes field types from the schema, so each field gets its order-preserving encoding (see CreateCompositeIndex)
+//
+// LIMITATIONS:
+// - Fields not in the schema are not checked
+// - StringField requires a JSON string; IntField and FloatField a JSON number, not a numeric string
+//
+// USAGE:
+//
+//	db.SetSchema(Schema{Fields: []SchemaField{
+//		{Name: "email", Type: StringField, Required: true},
+//		{Name: "age", Type: IntField},
+//	}})
+//	db.CreateSchemaIndex("by_age", []string{"age"}, false) // Sorts numerically
+//
+//	err := db.Insert(key, []byte(`{"email":"a@b.com","age":"old"}`)) // *SchemaError
+type Schema struct {
+	Fields []SchemaField
+}
+
+// SchemaField is the declared type of one field of a Schema.
+type SchemaField struct {
+	Name     string // JSON path of the field
+	Type     FieldType
+	Required bool // Records must have the field, and not as null
+}
+
+// ErrSchemaViolation is matched by errors.Is for every SchemaError.
+var ErrSchemaViolation = errors.New("schema violation")
+
+// SchemaError reports a record whose field does not match its Schema type.
+type SchemaError struct {
+	Field string    // Path of the field
+	Type  FieldType // Type the schema declares
+	Value []byte    // JSON found in the record, nil if the field is missing
+}
+
+func (e *SchemaError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("%v: field %q is required", ErrSchemaViolation, e.Field)
+	}
+	return fmt.Sprintf("%v: field %q is %s, not %s", ErrSchemaViolation, e.Field, e.Value, e.Type)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return ErrSchemaViolation
+}
+
+// compiledField is a SchemaField with its path parsed.
+type compiledField struct {
+	SchemaField
+	steps []jsonStep
+}
+
+// SetSchema checks every write against schema from now on, after checking
+// the existing records, and fails with their first SchemaError if any does
+// not conform. Writers wait for the check. An empty schema removes it.
+func (db *IndexedBTree) SetSchema(schema Schema) error {
+	var fields []compiledField
+	for _, field := range schema.Fields {
+		steps, err := parseJSONPath(field.Name)
+		if err != nil {
+			return err
+		}
+		if field.Type > FloatField {
+			return fmt.Errorf("invalid type for field %q", field.Name)
+		}
+		fields = append(fields, compiledField{SchemaField: field, steps: steps})
+	}
+
+	db.writers.Lock()
+	defer db.writers.Unlock()
+
+	var schemaErr error
+	db.tree.ForEach(func(key Keytype, value Valuetype) bool {
+		if err := checkFields(fields, value); err != nil {
+			schemaErr = fmt.Errorf("record %q: %w", key, err)
+			return false
+		}
+		return true
+	})
+	if schemaErr != nil {
+		return schemaErr
+	}
+
+	if len(fields) == 0 {
+		db.schema.Store(nil)
+	} else {
+		db.schema.Store(&fields)
+	}
+	return nil
+}
+
+// Schema returns the schema writes are checked against, empty if none.
+func (db *IndexedBTree) Schema() Schema {
+	var schema Schema
+	if fields := db.schema.Load(); fields != nil {
+		for _, field := range *fields {
+			schema.Fields = append(schema.Fields, field.SchemaField)
+		}
+	}
+	return schema
+}
+
+// CreateSchemaIndex creates a composite index over schema fields, each
+// encoded as its declared type, and populates it with existing data.
+// FindByComposite queries it.
+func (db *IndexedBTree) CreateSchemaIndex(name string, fieldNames []string, unique bool) error {
+	schema := db.Schema()
+	fields := make([]IndexField, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		found := false
+		for _, field := range schema.Fields {
+			if field.Name == fieldName {
+				fields[i], found = IndexField{Name: fieldName, Type: field.Type}, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("field %q is not in the schema", fieldName)
+		}
+	}
+	return db.CreateCompositeIndex(name, fields, unique)
+}
+
+// checkSchema returns the SchemaError of value, if a schema is set.
+func (db *IndexedBTree) checkSchema(value Valuetype) error {
+	if fields := db.schema.Load(); fields != nil {
+		return checkFields(*fields, value)
+	}
+	return nil
+}
+
+// checkFields returns a SchemaError for the first of fields value breaks.
+func checkFields(fields []compiledField, value Valuetype) error {
+	for _, field := range fields {
+		raw, ok := resolveJSONPath(value, field.steps)
+		raw = bytes.TrimSpace(raw)
+		if !ok || len(raw) == 0 || string(raw) == "null" {
+			if field.Required {
+				return &SchemaError{Field: field.Name, Type: field.Type}
+			}
+			continue
+		}
+
+		var valid bool
+		switch field.Type {
+		case StringField:
+			valid = raw[0] == '"'
+		case IntField:
+			_, err := strconv.ParseInt(string(raw), 10, 64)
+			valid = err == nil
+		case FloatField:
+			valid = raw[0] == '-' || '0' <= raw[0] && raw[0] <= '9' // Any JSON number
+		}
+		if !valid {
+			return &SchemaError{Field: field.Name, Type: field.Type, Value: raw}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func schemaTestDB(t *testing.T) *IndexedBTree {
+	t.Helper()
+	db := NewIndexedBTreeDefault()
+	err := db.SetSchema(Schema{Fields: []SchemaField{
+		{Name: "email", Type: StringField, Required: true},
+		{Name: "age", Type: IntField},
+		{Name: "score", Type: FloatField},
+	}})
+	if err != nil {
+		t.Fatalf("SetSchema failed: %v", err)
+	}
+	return db
+}
+
+func TestSchemaValidatesWrites(t *testing.T) {
+	db := schemaTestDB(t)
+
+	valid := []string{
+		`{"email":"a@x.com"}`,
+		`{"email":"a@x.com","age":-3,"score":2.5e3}`,
+		`{"email":"a@x.com","age":null,"extra":[1,2]}`,
+	}
+	for i, value := range valid {
+		if err := db.Insert([]byte(fmt.Sprintf("ok:%d", i)), []byte(value)); err != nil {
+			t.Errorf("Insert(%s) failed: %v", value, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`{"age":3}`:                           "email",
+		`{"email":42}`:                        "email",
+		`{"email":"a@x.com","age":"3"}`:       "age",
+		`{"email":"a@x.com","age":3.5}`:       "age",
+		`{"email":"a@x.com","score":"fast"}`:  "score",
+		`not json`:                            "email",
+		`{"email":"a@x.com","score":[1.0]}`:   "score",
+		`{"email":"a@x.com","age":true}`:      "age",
+		`{"email":"a@x.com","score":{"v":1}}`: "score",
+	}
+	for value, field := range invalid {
+		err := db.Insert([]byte("bad"), []byte(value))
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) || schemaErr.Field != field {
+			t.Errorf("Insert(%s) = %v, want a SchemaError on %s", value, err, field)
+		}
+	}
+	if _, err := db.Find([]byte("bad")); err == nil {
+		t.Error("Invalid record was written")
+	}
+
+	err := db.Update([]byte("ok:0"), []byte(`{"email":"a@x.com","age":"old"}`))
+	if !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("Update = %v, want ErrSchemaViolation", err)
+	}
+	err = db.InsertMany(map[string][]byte{"m:1": []byte(`{"email":"b@x.com"}`), "m:2": []byte(`{}`)})
+	if !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("InsertMany = %v, want ErrSchemaViolation", err)
+	}
+	if db.Count() != int64(len(valid)) {
+		t.Errorf("Expected %d records, got %d", len(valid), db.Count())
+	}
+}
+
+func TestSchemaCheckedAgainstExistingRecords(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.Insert([]byte("user:1"), []byte(`{"age":"forty"}`))
+
+	err := db.SetSchema(Schema{Fields: []SchemaField{{Name: "age", Type: IntField}}})
+	if !errors.Is(err, ErrSchemaViolation) {
+		t.Fatalf("SetSchema = %v, want ErrSchemaViolation", err)
+	}
+	if len(db.Schema().Fields) != 0 {
+		t.Error("Rejected schema was set")
+	}
+	if err := db.Insert([]byte("user:2"), []byte(`{"age":"fifty"}`)); err != nil {
+		t.Errorf("Insert without a schema failed: %v", err)
+	}
+}
+
+func TestCreateSchemaIndex(t *testing.T) {
+	db := schemaTestDB(t)
+	for i, age := range []int{100, 9, -5, 42} {
+		db.Insert([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf(`{"email":"u%d@x.com","age":%d}`, i, age)))
+	}
+
+	if err := db.CreateSchemaIndex("by_age", []string{"age"}, false); err != nil {
+		t.Fatalf("CreateSchemaIndex failed: %v", err)
+	}
+	var order []string
+	db.ForEachByIndex("by_age", func(key Keytype, _ Valuetype) bool {
+		order = append(order, string(key))
+		return true
+	})
+	if fmt.Sprint(order) != "[user:2 user:1 user:3 user:0]" {
+		t.Errorf("Expected numeric age order, got %v", order)
+	}
+	if pks, err := db.FindByComposite("by_age", 42); err != nil || len(pks) != 1 || string(pks[0]) != "user:3" {
+		t.Errorf("FindByComposite(42) = %q, %v", pks, err)
+	}
+
+	if err := db.CreateSchemaIndex("by_name", []string{"name"}, false); err == nil {
+		t.Error("Expected error for a field not in the schema")
+	}
+}
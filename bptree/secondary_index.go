@@ -3,8 +3,17 @@ package bptree
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"iter"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"unicode"
 )
 
 // SecondaryIndex provides secondary indexing for a B-Tree.
@@ -25,12 +34,20 @@ import (
 //
 // IMPLEMENTATION:
 // - Unique index: field_value → primary_key (direct mapping)
-// - Non-unique index: field_value → [primary_key1, primary_key2, ...]
+// - Non-unique index: one posting per record, field_value+primary_key → "" (see posting.go)
+// - Covering index: each primary key's record is stored with it, in the entry or as the posting's value
 type SecondaryIndex struct {
 	name      string
 	tree      *ShardedBTree
 	extractor KeyExtractor
 	unique    bool
+	normalize func([]byte) []byte        // Applied to query keys, nil if none
+	fields    []IndexField               // Composite layout, nil unless CreateCompositeIndex
+	covering  bool                       // Entries store records (see IndexConfig.Covering)
+	project   KeyExtractor               // Stored part of a record, nil for all of it
+	analyzer  Analyzer                   // Splits extracted text into terms, nil unless an InvertedIndex
+	build     atomic.Pointer[IndexBuild] // Set until an online build is ready
+	dropped   atomic.Bool                // Set by DropIndex; updates are discarded
 	mu        sync.RWMutex
 
 	// Statistics
@@ -51,6 +68,16 @@ type IndexConfig struct {
 	Unique bool
 	// NumShards for the underlying tree (default: 4)
 	NumShards int
+	// Normalizer, if set, rewrites extracted keys before they are stored and
+	// query keys before lookup, e.g. LowercaseNormalizer for case-insensitive
+	// email lookups. It must be idempotent.
+	Normalizer func([]byte) []byte
+	// Covering stores each record alongside its primary key, so FindCovered
+	// (and IndexedBTree.FindRecordByIndex) answer without reading the
+	// primary tree. Projection, if set, stores only the part of the record
+	// it returns. Costs a copy of each record (or projection) in the index.
+	Covering   bool
+	Projection KeyExtractor
 }
 
 // IndexStats provides statistics about an index.
@@ -59,6 +86,29 @@ type IndexStats struct {
 	Entries   uint64
 	Unique    bool
 	TreeStats ShardStats
+
+	// DistinctKeys is the number of distinct index keys, and AvgPostings
+	// and MaxPostings the average and largest number of primary keys under
+	// one
+	DistinctKeys uint64
+	AvgPostings  float64
+	MaxPostings  uint64
+	// PostingsHistogram[i] counts the index keys with 2^i to 2^(i+1)-1
+	// primary keys; its length is that of MaxPostings in bits
+	//
+	// These distribution fields come from walking every entry of the
+	// index, so Stats costs O(index size), not O(1)
+	PostingsHistogram []uint64
+}
+
+// Selectivity returns the expected fraction of the indexed records an
+// equality lookup matches, 1/DistinctKeys: near 0 for a useful index, 1 for
+// an index on a field every record shares. Returns 0 for an empty index.
+func (s IndexStats) Selectivity() float64 {
+	if s.DistinctKeys == 0 {
+		return 0
+	}
+	return 1 / float64(s.DistinctKeys)
 }
 
 // NewSecondaryIndex creates a new secondary index.
@@ -68,12 +118,100 @@ func NewSecondaryIndex(config IndexConfig) *SecondaryIndex {
 		numShards = 4 // Smaller default for indexes
 	}
 
+	extractor := config.Extractor
+	if normalize := config.Normalizer; normalize != nil {
+		extractor = func(value Valuetype) []byte {
+			if key := config.Extractor(value); key != nil {
+				return normalize(key)
+			}
+			return nil
+		}
+	}
+
+	shardConfig := ShardConfig{NumShards: numShards}
+	if !config.Unique {
+		shardConfig.AffinityDelimiter = postingAffinity
+	}
+
 	return &SecondaryIndex{
 		name:      config.Name,
-		tree:      NewShardedBTree(ShardConfig{NumShards: numShards}),
-		extractor: config.Extractor,
+		tree:      NewShardedBTree(shardConfig),
+		extractor: extractor,
 		unique:    config.Unique,
+		normalize: config.Normalizer,
+		covering:  config.Covering,
+		project:   config.Projection,
+	}
+}
+
+// stored returns what a covering index stores for a record, nil otherwise.
+func (idx *SecondaryIndex) stored(value Valuetype) []byte {
+	switch {
+	case !idx.covering:
+		return nil
+	case idx.project == nil:
+		return value
 	}
+	return idx.project(value)
+}
+
+// encodeEntry encodes the value of a unique index entry holding primaryKeys
+// and, for a covering index, their stored records.
+func (idx *SecondaryIndex) encodeEntry(primaryKeys, records [][]byte) []byte {
+	if !idx.covering {
+		return primaryKeys[0]
+	}
+	pairs := make([][]byte, 0, 2*len(primaryKeys))
+	for i, pk := range primaryKeys {
+		pairs = append(pairs, pk, records[i])
+	}
+	return encodePrimaryKeys(pairs)
+}
+
+// decodeEntry returns the primary keys of a unique index entry and, for a
+// covering index, their stored records.
+func (idx *SecondaryIndex) decodeEntry(value []byte) (primaryKeys, records [][]byte) {
+	if !idx.covering {
+		return [][]byte{value}, nil
+	}
+	// Entries are fresh copies, so the results may alias value
+	if len(value) < 4 {
+		return nil, nil
+	}
+	n := int(binary.LittleEndian.Uint32(value) / 2)
+	primaryKeys, records = make([][]byte, 0, n), make([][]byte, 0, n)
+	for rest := value[4:]; len(primaryKeys) < n; {
+		pk, after, ok := cutLengthPrefixed(rest)
+		if !ok {
+			break
+		}
+		record, after, ok := cutLengthPrefixed(after)
+		if !ok {
+			break
+		}
+		primaryKeys, records, rest = append(primaryKeys, pk), append(records, record), after
+	}
+	return primaryKeys, records
+}
+
+// cutLengthPrefixed splits a 4-byte length-prefixed field off data.
+func cutLengthPrefixed(data []byte) (field, rest []byte, ok bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	n := binary.LittleEndian.Uint32(data)
+	if uint64(len(data)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return data[4 : 4+n : 4+n], data[4+n:], true
+}
+
+// lookupKey returns a query key as the index stores it.
+func (idx *SecondaryIndex) lookupKey(key []byte) []byte {
+	if idx.normalize == nil || key == nil {
+		return key
+	}
+	return idx.normalize(key)
 }
 
 // Name returns the index name.
@@ -87,102 +225,141 @@ func (idx *SecondaryIndex) IsUnique() bool {
 }
 
 // Index adds a primary key to the index based on record value.
-// For unique indexes, returns error if the indexed value already exists.
+// For unique indexes, returns error if the indexed value already belongs to
+// another record. Indexing a record again is a no-op.
 func (idx *SecondaryIndex) Index(primaryKey Keytype, value Valuetype) error {
+	if idx.analyzer != nil {
+		return idx.updateTerms(primaryKey, nil, value)
+	}
 	indexKey := idx.extractor(value)
 	if indexKey == nil {
 		// Field doesn't exist in record, skip indexing
 		return nil
 	}
+	_, err := idx.addKey(primaryKey, indexKey, idx.stored(value))
+	return err
+}
 
+// claim is Index for a unique index, also reporting whether the record's
+// key was added rather than already there. The uniqueness check and the
+// insert are one step under the index's lock.
+func (idx *SecondaryIndex) claim(primaryKey Keytype, value Valuetype) (bool, error) {
+	indexKey := idx.extractor(value)
+	if indexKey == nil {
+		return false, nil
+	}
+	return idx.addKey(primaryKey, indexKey, idx.stored(value))
+}
+
+// addKey adds primaryKey, and record if covering, to the entry for indexKey,
+// and reports whether it was not there before.
+func (idx *SecondaryIndex) addKey(primaryKey Keytype, indexKey, record []byte) (bool, error) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	if idx.unique {
-		// Check if already exists
-		_, err := idx.tree.Find(indexKey)
+	if !idx.unique {
+		// One posting per record: indexKey+primaryKey → record (or nothing)
+		key := postingKey(indexKey, primaryKey)
+		existing, err := idx.tree.Find(key)
 		if err == nil {
-			return errors.New("duplicate key in unique index")
+			if idx.covering && !bytes.Equal(existing, record) {
+				idx.tree.Insert(key, append([]byte{}, record...)) // Refresh the stored record
+			}
+			return false, nil
 		}
-		// Store: indexKey → primaryKey
-		idx.tree.Insert(indexKey, Valuetype(primaryKey))
-	} else {
-		// Non-unique: indexKey → list of primary keys
-		existing, err := idx.tree.Find(indexKey)
-		if err != nil {
-			// First entry for this index key
-			idx.tree.Insert(indexKey, encodePrimaryKeys([][]byte{primaryKey}))
-		} else {
-			// Append to existing list
-			keys := decodePrimaryKeys(existing)
-			// Check if already in list (idempotent)
-			for _, k := range keys {
-				if bytes.Equal(k, primaryKey) {
-					return nil // Already indexed
+		idx.tree.Insert(key, append([]byte{}, record...))
+		idx.entries++
+		return true, nil
+	}
+
+	// Unique: indexKey → primaryKey
+	if existing, err := idx.tree.Find(indexKey); err == nil {
+		keys, records := idx.decodeEntry(existing)
+		// Check if already indexed (idempotent)
+		for i, k := range keys {
+			if bytes.Equal(k, primaryKey) {
+				if idx.covering && !bytes.Equal(records[i], record) {
+					records[i] = record // Refresh the stored record
+					idx.tree.Insert(indexKey, idx.encodeEntry(keys, records))
 				}
+				return false, nil
 			}
-			keys = append(keys, primaryKey)
-			idx.tree.Insert(indexKey, encodePrimaryKeys(keys))
 		}
+		return false, &UniqueViolationError{Index: idx.name, IndexKey: indexKey, ExistingPrimaryKey: keys[0]}
 	}
+	idx.tree.Insert(indexKey, idx.encodeEntry([][]byte{primaryKey}, [][]byte{record}))
 
 	idx.entries++
-	return nil
+	return true, nil
 }
 
-// Remove removes a primary key from the index.
+// Remove removes a primary key from the index. It leaves the entry alone if
+// the indexed value belongs to other records only.
 func (idx *SecondaryIndex) Remove(primaryKey Keytype, value Valuetype) error {
+	if idx.analyzer != nil {
+		return idx.updateTerms(primaryKey, value, nil)
+	}
 	indexKey := idx.extractor(value)
 	if indexKey == nil {
 		return nil
 	}
+	idx.removeKey(primaryKey, indexKey)
+	return nil
+}
 
+// removeKey removes primaryKey from the entry for indexKey, if there.
+func (idx *SecondaryIndex) removeKey(primaryKey Keytype, indexKey []byte) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	if idx.unique {
-		// Simply delete the index entry
-		idx.tree.Delete(indexKey)
-		if idx.entries > 0 {
-			idx.entries--
-		}
-	} else {
-		// Remove from list
-		existing, err := idx.tree.Find(indexKey)
-		if err != nil {
-			return nil // Not in index
+	if !idx.unique {
+		if idx.tree.Delete(postingKey(indexKey, primaryKey)) {
+			idx.entries -= min(idx.entries, 1)
 		}
+		return
+	}
 
-		keys := decodePrimaryKeys(existing)
-		newKeys := make([][]byte, 0, len(keys))
-		for _, k := range keys {
-			if !bytes.Equal(k, primaryKey) {
-				newKeys = append(newKeys, k)
-			}
-		}
+	existing, err := idx.tree.Find(indexKey)
+	if err != nil {
+		return // Not in index
+	}
 
-		if len(newKeys) == 0 {
-			idx.tree.Delete(indexKey)
-		} else {
-			idx.tree.Insert(indexKey, encodePrimaryKeys(newKeys))
+	keys, records := idx.decodeEntry(existing)
+	newKeys := make([][]byte, 0, len(keys))
+	var newRecords [][]byte
+	for i, k := range keys {
+		if !bytes.Equal(k, primaryKey) {
+			newKeys = append(newKeys, k)
+			if idx.covering {
+				newRecords = append(newRecords, records[i])
+			}
 		}
+	}
+	if len(newKeys) == len(keys) {
+		return // Indexed under other values
+	}
 
-		if idx.entries > 0 {
-			idx.entries--
-		}
+	if len(newKeys) == 0 {
+		idx.tree.Delete(indexKey)
+	} else {
+		idx.tree.Insert(indexKey, idx.encodeEntry(newKeys, newRecords))
 	}
 
-	return nil
+	removed := uint64(len(keys) - len(newKeys))
+	idx.entries -= min(idx.entries, removed)
 }
 
 // Update updates an index entry when a record changes.
 // Removes old index entry and adds new one.
 func (idx *SecondaryIndex) Update(primaryKey Keytype, oldValue, newValue Valuetype) error {
+	if idx.analyzer != nil {
+		return idx.updateTerms(primaryKey, oldValue, newValue)
+	}
 	oldIndexKey := idx.extractor(oldValue)
 	newIndexKey := idx.extractor(newValue)
 
-	// If index key didn't change, nothing to do
-	if bytes.Equal(oldIndexKey, newIndexKey) {
+	// If index key (and any stored record) didn't change, nothing to do
+	if bytes.Equal(oldIndexKey, newIndexKey) && (!idx.covering || bytes.Equal(idx.stored(oldValue), idx.stored(newValue))) {
 		return nil
 	}
 
@@ -203,11 +380,12 @@ func (idx *SecondaryIndex) FindOne(indexKey []byte) (Keytype, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	val, err := idx.tree.Find(indexKey)
+	val, err := idx.tree.Find(idx.lookupKey(indexKey))
 	if err != nil {
 		return nil, err
 	}
-	return Keytype(val), nil
+	keys, _ := idx.decodeEntry(val)
+	return Keytype(keys[0]), nil
 }
 
 // FindAll finds all primary keys matching an index key.
@@ -216,16 +394,10 @@ func (idx *SecondaryIndex) FindAll(indexKey []byte) ([]Keytype, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	value, err := idx.tree.Find(indexKey)
+	pks, _, err := idx.lookup(idx.lookupKey(indexKey))
 	if err != nil {
 		return nil, err
 	}
-
-	if idx.unique {
-		return []Keytype{Keytype(value)}, nil
-	}
-
-	pks := decodePrimaryKeys(value)
 	result := make([]Keytype, len(pks))
 	for i, pk := range pks {
 		result[i] = Keytype(pk)
@@ -233,32 +405,196 @@ func (idx *SecondaryIndex) FindAll(indexKey []byte) ([]Keytype, error) {
 	return result, nil
 }
 
+// FindAllPage finds up to limit primary keys matching an index key, in
+// primary key order, starting after the primary key cursor (nil for the
+// first page). It also returns the cursor of the next page, nil after the
+// last. A limit of 0 or less returns the rest.
+func (idx *SecondaryIndex) FindAllPage(indexKey []byte, limit int, cursor []byte) ([]Keytype, []byte, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	indexKey = idx.lookupKey(indexKey)
+	if idx.unique {
+		pks, _, err := idx.lookup(indexKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cursor != nil && bytes.Compare(pks[0], cursor) <= 0 {
+			return nil, nil, nil
+		}
+		return []Keytype{Keytype(pks[0])}, nil, nil
+	}
+
+	if err := idx.tree.Err(); err != nil {
+		return nil, nil, err
+	}
+	start := postingPrefix(indexKey)
+	if cursor != nil {
+		start = append(postingKey(indexKey, cursor), 0) // Strictly after the cursor
+	}
+	batchSize := rangeBatchSize
+	if limit > 0 {
+		batchSize = min(limit+1, rangeBatchSize)
+	}
+	var result []Keytype
+	more := false
+	mergeCursors(idx.tree.affinityCursors(start, postingEnd(indexKey), batchSize), func(key Keytype, _ Valuetype) bool {
+		if _, pk, ok := splitPostingKey(key); ok {
+			if limit > 0 && len(result) == limit {
+				more = true
+				return false
+			}
+			result = append(result, Keytype(pk))
+		}
+		return true
+	})
+	if cursor == nil && len(result) == 0 {
+		return nil, nil, errors.New("key not found")
+	}
+	if !more {
+		return result, nil, nil
+	}
+	return result, result[len(result)-1], nil
+}
+
+// FindCovered finds all primary keys matching an index key, with the records
+// (or projections) a covering index stores for them, in one lookup.
+// The records are as of the last index update, which lags the primary tree
+// while IndexedBTree defers index updates.
+func (idx *SecondaryIndex) FindCovered(indexKey []byte) ([]Keytype, []Valuetype, error) {
+	if !idx.covering {
+		return nil, nil, errors.New("FindCovered only works on covering indexes")
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	pks, records, err := idx.lookup(idx.lookupKey(indexKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]Keytype, len(pks))
+	values := make([]Valuetype, len(pks))
+	for i := range pks {
+		keys[i], values[i] = Keytype(pks[i]), Valuetype(records[i])
+	}
+	return keys, values, nil
+}
+
 // FindRange finds all primary keys for index keys in a range.
 // Returns primary keys for all index keys where startKey <= indexKey <= endKey.
 func (idx *SecondaryIndex) FindRange(startKey, endKey []byte) ([]Keytype, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	keys, values, err := idx.tree.GetRange(startKey, endKey)
-	if err != nil {
+	startKey, endKey = idx.lookupKey(startKey), idx.lookupKey(endKey)
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	if err := idx.tree.Err(); err != nil {
 		return nil, err
 	}
 
 	var result []Keytype
-	for i := range keys {
-		if idx.unique {
-			result = append(result, Keytype(values[i]))
-		} else {
-			pks := decodePrimaryKeys(values[i])
-			for _, pk := range pks {
-				result = append(result, Keytype(pk))
-			}
-		}
+	idx.postings(startKey, endKey, true, func(_, pk, _ []byte) bool {
+		result = append(result, Keytype(pk))
+		return true
+	})
+	return result, nil
+}
+
+// FindRangeReverse finds up to limit primary keys for index keys in
+// [startKey, endKey], in descending index key order (primary keys descending
+// within a key). A limit of 0 or less returns them all.
+func (idx *SecondaryIndex) FindRangeReverse(startKey, endKey []byte, limit int) ([]Keytype, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	startKey, endKey = idx.lookupKey(startKey), idx.lookupKey(endKey)
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	if err := idx.tree.Err(); err != nil {
+		return nil, err
 	}
 
+	var result []Keytype
+	idx.postingsReverse(startKey, endKey, func(_, pk, _ []byte) bool {
+		result = append(result, Keytype(pk))
+		return limit <= 0 || len(result) < limit
+	})
 	return result, nil
 }
 
+// FindPrefix finds all primary keys for index keys starting with prefix, in
+// index key order.
+func (idx *SecondaryIndex) FindPrefix(prefix []byte) ([]Keytype, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if err := idx.tree.Err(); err != nil {
+		return nil, err
+	}
+	prefix = idx.lookupKey(prefix)
+	var result []Keytype
+	idx.postings(prefix, nil, false, func(indexKey, pk, _ []byte) bool {
+		if !bytes.HasPrefix(indexKey, prefix) {
+			return false
+		}
+		result = append(result, Keytype(pk))
+		return true
+	})
+	return result, nil
+}
+
+// Range returns an iterator over (indexKey, primaryKey) pairs for index keys
+// in [startKey, endKey], in index key order. Non-unique index keys yield one
+// pair per primary key, in primary key order.
+func (idx *SecondaryIndex) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		startKey, endKey := idx.lookupKey(startKey), idx.lookupKey(endKey)
+		if bytes.Compare(startKey, endKey) > 0 {
+			return
+		}
+		idx.postings(startKey, endKey, true, func(indexKey, pk, _ []byte) bool {
+			return yield(indexKey, pk)
+		})
+	}
+}
+
+// CountKey returns the number of primary keys matching an index key,
+// counted in the index alone: 0 if there are none.
+func (idx *SecondaryIndex) CountKey(indexKey []byte) (uint64, error) {
+	indexKey = idx.lookupKey(indexKey)
+	return idx.countPostings(indexKey, indexKey)
+}
+
+// CountRange returns the number of primary keys for index keys in
+// [startKey, endKey], counted in the index alone.
+func (idx *SecondaryIndex) CountRange(startKey, endKey []byte) (uint64, error) {
+	startKey, endKey = idx.lookupKey(startKey), idx.lookupKey(endKey)
+	if bytes.Compare(startKey, endKey) > 0 {
+		return 0, errors.New("invalid range: startKey is greater than endKey")
+	}
+	return idx.countPostings(startKey, endKey)
+}
+
+// countPostings counts the postings with index keys in [startKey, endKey].
+func (idx *SecondaryIndex) countPostings(startKey, endKey []byte) (uint64, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if err := idx.tree.Err(); err != nil {
+		return 0, err
+	}
+	var n uint64
+	idx.postings(startKey, endKey, true, func(_, _, _ []byte) bool {
+		n++
+		return true
+	})
+	return n, nil
+}
+
 // Count returns the number of entries in the index.
 func (idx *SecondaryIndex) Count() uint64 {
 	idx.mu.RLock()
@@ -266,17 +602,47 @@ func (idx *SecondaryIndex) Count() uint64 {
 	return idx.entries
 }
 
-// Stats returns index statistics.
+// Stats returns index statistics. Like the tree shape, the key
+// distribution comes from a walk of the index, in O(n).
 func (idx *SecondaryIndex) Stats() IndexStats {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	return IndexStats{
+	stats := IndexStats{
 		Name:      idx.name,
 		Entries:   idx.entries,
 		Unique:    idx.unique,
-		TreeStats: idx.tree.Stats(),
+		TreeStats: idx.tree.Stats(false),
+	}
+
+	var prev []byte
+	var postings, run uint64
+	addRun := func() {
+		if run == 0 {
+			return
+		}
+		stats.DistinctKeys++
+		stats.MaxPostings = max(stats.MaxPostings, run)
+		bucket := bits.Len64(run) - 1
+		for len(stats.PostingsHistogram) <= bucket {
+			stats.PostingsHistogram = append(stats.PostingsHistogram, 0)
+		}
+		stats.PostingsHistogram[bucket]++
+	}
+	idx.postings(nil, nil, false, func(indexKey, _, _ []byte) bool {
+		if run == 0 || !bytes.Equal(indexKey, prev) {
+			addRun()
+			prev, run = append(prev[:0], indexKey...), 0
+		}
+		run++
+		postings++
+		return true
+	})
+	addRun()
+	if stats.DistinctKeys > 0 {
+		stats.AvgPostings = float64(postings) / float64(stats.DistinctKeys)
 	}
+	return stats
 }
 
 // Clear removes all entries from the index.
@@ -348,54 +714,179 @@ func decodePrimaryKeys(data []byte) [][]byte {
 // Common Key Extractors
 // ============================================================================
 
-// JSONFieldExtractor creates an extractor for a JSON field.
-// This is a simple implementation that looks for "field": "value" patterns.
-// For production, use a proper JSON parser.
+// JSONFieldExtractor creates an extractor for a top-level field of a JSON
+// object. Strings are indexed by their unescaped contents, numbers and
+// booleans by their literal, objects and arrays by their compact JSON;
+// missing, null and empty-string fields and malformed records are not
+// indexed. With duplicate names the first occurrence wins.
 func JSONFieldExtractor(fieldName string) KeyExtractor {
-	searchPattern := []byte(`"` + fieldName + `":`)
-
 	return func(value Valuetype) []byte {
-		// Find field
-		idx := bytes.Index(value, searchPattern)
-		if idx == -1 {
+		raw, ok := jsonField(value, fieldName)
+		if !ok {
 			return nil
 		}
+		return jsonIndexKey(raw)
+	}
+}
 
-		// Skip to value
-		start := idx + len(searchPattern)
-		for start < len(value) && (value[start] == ' ' || value[start] == '\t') {
-			start++
-		}
+// JSONPathExtractor creates an extractor for a nested field of a JSON record,
+// addressed by a path of dotted field names and bracketed array indexes:
+// "address.city", "tags[0]", "orders[1].items[0].sku". Values are indexed as
+// for JSONFieldExtractor; a path that does not resolve is not indexed, nor
+// is anything under a malformed path. Field names containing '.' or '['
+// cannot be addressed.
+func JSONPathExtractor(path string) KeyExtractor {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return func(Valuetype) []byte { return nil }
+	}
 
-		if start >= len(value) {
+	return func(value Valuetype) []byte {
+		raw, ok := resolveJSONPath(value, steps)
+		if !ok {
 			return nil
 		}
+		return jsonIndexKey(raw)
+	}
+}
+
+// resolveJSONPath returns the raw JSON the parsed path addresses in data.
+func resolveJSONPath(data []byte, steps []jsonStep) (json.RawMessage, bool) {
+	raw, ok := json.RawMessage(data), true
+	for _, step := range steps {
+		if step.index < 0 {
+			raw, ok = jsonField(raw, step.name)
+		} else {
+			raw, ok = jsonElement(raw, step.index)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return raw, true
+}
+
+// jsonStep is one step of a JSON path: a field name, or an array index when
+// index is not negative.
+type jsonStep struct {
+	name  string
+	index int
+}
 
-		// Handle string value
-		if value[start] == '"' {
-			start++
-			end := start
-			for end < len(value) && value[end] != '"' {
-				end++
+// parseJSONPath splits a path like "orders[1].sku" into its steps. Only the
+// first segment may omit the name, to index a top-level array: "[0].sku".
+func parseJSONPath(path string) ([]jsonStep, error) {
+	var steps []jsonStep
+	for i, segment := range strings.Split(path, ".") {
+		name, indexes, hasIndex := strings.Cut(segment, "[")
+		if name == "" && (i > 0 || !hasIndex) {
+			return nil, fmt.Errorf("invalid JSON path %q", path)
+		}
+		if name != "" {
+			steps = append(steps, jsonStep{name: name, index: -1})
+		}
+		for hasIndex {
+			digits, rest, closed := strings.Cut(indexes, "]")
+			n, err := strconv.Atoi(digits)
+			if !closed || err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid array index in JSON path %q", path)
+			}
+			steps = append(steps, jsonStep{index: n})
+			if rest == "" {
+				break
 			}
-			// For string values, return nil if empty, otherwise return the content
-			if end == start {
-				return nil // empty string
+			if rest[0] != '[' {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
 			}
-			return value[start:end]
+			indexes = rest[1:]
 		}
+	}
+	return steps, nil
+}
 
-		// Handle number/boolean value
-		end := start
-		for end < len(value) && value[end] != ',' && value[end] != '}' && value[end] != ' ' {
-			end++
+// jsonElement returns the raw JSON of element index of the array in data.
+func jsonElement(data []byte, index int) (json.RawMessage, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+		return nil, false
+	}
+	for i := 0; decoder.More(); i++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, false
 		}
-		if end > start {
-			return value[start:end]
+		if i == index {
+			return raw, true
 		}
+	}
+	return nil, false
+}
 
+// jsonField returns the raw JSON of the top-level field name of the object
+// in data. Other fields' values are skipped token by token, not decoded.
+func jsonField(data []byte, name string) (json.RawMessage, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, false
+		}
+		if tok == name {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+// jsonIndexKey encodes a raw JSON value as an index key, or nil for null and
+// the empty string.
+func jsonIndexKey(raw json.RawMessage) []byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	switch raw[0] {
+	case 'n':
+		return nil
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil || s == "" {
+			return nil
+		}
+		return []byte(s)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
 		return nil
 	}
+	return compact.Bytes()
+}
+
+// LowercaseNormalizer maps keys to Unicode lower case, for case-insensitive
+// indexes (see IndexConfig.Normalizer).
+func LowercaseNormalizer(key []byte) []byte {
+	return bytes.ToLower(key)
+}
+
+// CaseFoldNormalizer maps each character of a key to a canonical member of
+// its Unicode simple case folding orbit, so that keys differing only in case
+// compare equal even where lower-casing alone disagrees (e.g. the Kelvin sign
+// and 'K', or final and medial sigma).
+func CaseFoldNormalizer(key []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folded = min(folded, f)
+		}
+		return folded
+	}, key)
 }
 
 // PrefixExtractor creates an extractor that takes the first N bytes.
@@ -418,6 +909,29 @@ func OffsetExtractor(offset, length int) KeyExtractor {
 	}
 }
 
+// ReversedDomainExtractor wraps an extractor of email addresses or host
+// names, reversing the labels of the domain and moving it to the front:
+// "alice@mail.example.com" becomes "com.example.mail@alice" and
+// "www.example.com" becomes "com.example.www". A prefix scan then finds
+// a whole domain: "com.example@" for its addresses, "com.example." for
+// everything under its subdomains.
+func ReversedDomainExtractor(inner KeyExtractor) KeyExtractor {
+	return func(value Valuetype) []byte {
+		field := inner(value)
+		if field == nil {
+			return nil
+		}
+		at := bytes.LastIndexByte(field, '@')
+		labels := bytes.Split(field[at+1:], []byte("."))
+		slices.Reverse(labels)
+		key := bytes.Join(labels, []byte("."))
+		if at >= 0 {
+			key = append(append(key, '@'), field[:at]...)
+		}
+		return key
+	}
+}
+
 // CompositeExtractor combines multiple extractors into a composite key.
 func CompositeExtractor(extractors ...KeyExtractor) KeyExtractor {
 	return func(value Valuetype) []byte {
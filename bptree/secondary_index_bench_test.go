@@ -81,6 +81,27 @@ func BenchmarkSecondaryIndexFindNonUnique(b *testing.B) {
 	}
 }
 
+func BenchmarkSecondaryIndexHighFanoutUpdate(b *testing.B) {
+	idx := NewSecondaryIndex(IndexConfig{
+		Name:      "status",
+		Extractor: JSONFieldExtractor("status"),
+		Unique:    false,
+	})
+
+	// One index key shared by 100000 records
+	for i := 0; i < 100000; i++ {
+		idx.Index([]byte(fmt.Sprintf("order:%d", i)), []byte(`{"status":"open"}`))
+	}
+	value := []byte(`{"status":"open"}`)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := []byte(fmt.Sprintf("new:%d", i))
+		idx.Index(key, value)
+		idx.Remove(key, value)
+	}
+}
+
 // ==================== IndexedBTree Benchmarks ====================
 
 func BenchmarkIndexedBTreeInsert(b *testing.B) {
@@ -170,6 +191,44 @@ func BenchmarkIndexedBTreeFindByIndexThenPrimary(b *testing.B) {
 	}
 }
 
+func BenchmarkIndexedBTreeFindRecordByCoveringIndex(b *testing.B) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 8})
+	db.CreateIndexWithConfig(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true, Covering: true})
+
+	// Pre-populate
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("user:%d", i))
+		value := []byte(fmt.Sprintf(`{"email":"user%d@example.com","name":"User %d"}`, i, i))
+		db.Insert(key, value)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// One lookup returns the full record
+		db.FindRecordByIndex("email", []byte(fmt.Sprintf("user%d@example.com", i%10000)))
+	}
+}
+
+func BenchmarkIndexedBTreeBulkInsert(b *testing.B) {
+	keys, values := bulkRecords(10000)
+	for _, mode := range []struct {
+		name string
+		opts BulkOptions
+	}{{"PerPair", BulkOptions{}}, {"DeferUnique", BulkOptions{DeferUnique: true}}} {
+		b.Run(mode.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db := NewIndexedBTree(IndexedConfig{NumShards: 8})
+				db.CreateIndex("email", JSONFieldExtractor("email"), true)
+				b.StartTimer()
+				if err := db.BulkInsert(keys, values, mode.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkIndexedBTreeUpdate(b *testing.B) {
 	db := NewIndexedBTree(IndexedConfig{NumShards: 8})
 	db.CreateIndex("email", JSONFieldExtractor("email"), true)
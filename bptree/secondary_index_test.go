@@ -2,9 +2,13 @@ package bptree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // ==================== SecondaryIndex Basic Tests ====================
@@ -232,8 +236,17 @@ func TestJSONFieldExtractor(t *testing.T) {
 		{`{"email":"test@example.com"}`, "test@example.com"},
 		{`{"name":"alice","email":"alice@example.com"}`, "alice@example.com"},
 		{`{"email": "spaced@example.com"}`, "spaced@example.com"},
-		{`{"name":"bob"}`, ""}, // missing field
-		{`{"email":""}`, ""},   // empty value
+		{`{"name":"bob"}`, ""},                              // missing field
+		{`{"email":""}`, ""},                                // empty value
+		{`{"email":null}`, ""},                              // null
+		{`{"email":"a\"b@example.com"}`, `a"b@example.com`}, // escaped quote
+		{`{"backup_email":"x@example.com","email":"y@example.com"}`, "y@example.com"}, // name contains field
+		{`{"contact":{"email":"nested@example.com"}}`, ""},                            // nested only
+		{`{"contact":{"email":"n@example.com"},"email":"top@example.com"}`, "top@example.com"},
+		{`{"note":"\"email\":\"fake\"","email":"real@example.com"}`, "real@example.com"},
+		{`{"email":["a","b"]}`, `["a","b"]`},
+		{`{"email":true}`, "true"},
+		{`not json "email":"x"`, ""},
 	}
 
 	for _, tc := range tests {
@@ -255,6 +268,160 @@ func TestJSONFieldExtractorNumeric(t *testing.T) {
 	}
 }
 
+func TestJSONPathExtractor(t *testing.T) {
+	record := []byte(`{"name":"alice","address":{"city":"London","geo":{"lat":51.5}},` +
+		`"tags":["admin","ops"],"orders":[{"sku":"a1"},{"sku":"b2","qty":3}],"grid":[[1,2],[3,4]]}`)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"name", "alice"},
+		{"address.city", "London"},
+		{"address.geo.lat", "51.5"},
+		{"address.geo", `{"lat":51.5}`},
+		{"tags[0]", "admin"},
+		{"tags[1]", "ops"},
+		{"tags[2]", ""}, // out of range
+		{"orders[1].sku", "b2"},
+		{"orders[1].qty", "3"},
+		{"grid[1][0]", "3"},
+		{"address.zip", ""},   // missing
+		{"name.first", ""},    // not an object
+		{"address[0]", ""},    // not an array
+		{"tags[x]", ""},       // malformed
+		{"address..city", ""}, // malformed
+	}
+	for _, tc := range tests {
+		result := JSONPathExtractor(tc.path)(record)
+		if tc.expected == "" && result != nil {
+			t.Errorf("%s: expected nil, got '%s'", tc.path, result)
+		} else if tc.expected != "" && string(result) != tc.expected {
+			t.Errorf("%s: expected '%s', got '%s'", tc.path, tc.expected, result)
+		}
+	}
+
+	if got := JSONPathExtractor("[1].id")([]byte(`[{"id":1},{"id":2}]`)); string(got) != "2" {
+		t.Errorf("[1].id on a top-level array = '%s', want '2'", got)
+	}
+
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("city", JSONPathExtractor("address.city"), false)
+	db.Insert([]byte("user:1"), record)
+	db.Insert([]byte("user:2"), []byte(`{"address":{"city":"Paris"}}`))
+	keys, err := db.FindAllByIndex("city", []byte("London"))
+	if err != nil || len(keys) != 1 || string(keys[0]) != "user:1" {
+		t.Errorf("FindAllByIndex(city, London) = %q, %v", keys, err)
+	}
+}
+
+func TestIndexNormalizer(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.Insert([]byte("user:1"), []byte(`{"email":"Alice@Example.com"}`))
+	err := db.CreateIndexWithConfig(IndexConfig{
+		Name:       "email",
+		Extractor:  JSONFieldExtractor("email"),
+		Unique:     true,
+		Normalizer: LowercaseNormalizer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Insert([]byte("user:2"), []byte(`{"email":"BOB@example.com"}`))
+
+	for query, want := range map[string]string{
+		"alice@example.com": "user:1",
+		"ALICE@EXAMPLE.COM": "user:1",
+		"Bob@Example.Com":   "user:2",
+	} {
+		pk, err := db.FindByIndex("email", []byte(query))
+		if err != nil || string(pk) != want {
+			t.Errorf("FindByIndex(%s) = %s, %v, want %s", query, pk, err, want)
+		}
+	}
+	// Uniqueness holds across case
+	if err := db.Insert([]byte("user:3"), []byte(`{"email":"alice@EXAMPLE.com"}`)); err == nil {
+		t.Error("A case variant of a unique email was accepted")
+	}
+	keys, _ := db.FindRangeByIndex("email", []byte("A"), []byte("B~"))
+	if len(keys) != 2 {
+		t.Errorf("FindRangeByIndex(A, B~) = %q, want both users", keys)
+	}
+}
+
+func TestCaseFoldNormalizer(t *testing.T) {
+	// Kelvin sign, and final vs medial sigma, fold together
+	pairs := [][2]string{{"\u212a", "k"}, {"K", "k"}, {"ς", "σ"}, {"ΣΑΣ", "σας"}}
+	for _, p := range pairs {
+		a, b := CaseFoldNormalizer([]byte(p[0])), CaseFoldNormalizer([]byte(p[1]))
+		if !bytes.Equal(a, b) {
+			t.Errorf("%q and %q fold to %q and %q", p[0], p[1], a, b)
+		}
+	}
+}
+
+func TestCoveringIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	err := db.CreateIndexWithConfig(IndexConfig{
+		Name:      "email",
+		Extractor: JSONFieldExtractor("email"),
+		Unique:    true,
+		Covering:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateIndexWithConfig(IndexConfig{
+		Name:       "city",
+		Extractor:  JSONFieldExtractor("city"),
+		Covering:   true,
+		Projection: JSONFieldExtractor("name"),
+	})
+
+	db.Insert([]byte("user:1"), []byte(`{"email":"a@x.com","city":"NYC","name":"Ann"}`))
+	db.Insert([]byte("user:2"), []byte(`{"email":"b@x.com","city":"NYC","name":"Bob"}`))
+
+	pk, value, err := db.FindRecordByIndex("email", []byte("a@x.com"))
+	if err != nil || string(pk) != "user:1" || string(value) != `{"email":"a@x.com","city":"NYC","name":"Ann"}` {
+		t.Errorf("FindRecordByIndex = %s, %s, %v", pk, value, err)
+	}
+
+	// Changing only non-indexed fields refreshes the stored copies
+	db.Update([]byte("user:1"), []byte(`{"email":"a@x.com","city":"NYC","name":"Anna"}`))
+	if _, value, _ := db.FindRecordByIndex("email", []byte("a@x.com")); !bytes.Contains(value, []byte("Anna")) {
+		t.Errorf("Covered record not refreshed: %s", value)
+	}
+
+	db.mu.RLock()
+	city := db.indexes["city"]
+	db.mu.RUnlock()
+	keys, names, err := city.FindCovered([]byte("NYC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for i := range keys {
+		got[string(keys[i])] = string(names[i])
+	}
+	if len(got) != 2 || got["user:1"] != "Anna" || got["user:2"] != "Bob" {
+		t.Errorf("FindCovered(NYC) = %v", got)
+	}
+	if all, _ := db.FindAllByIndex("city", []byte("NYC")); len(all) != 2 {
+		t.Errorf("FindAllByIndex on a covering index = %q", all)
+	}
+
+	db.Delete([]byte("user:2"))
+	if keys, _, _ := city.FindCovered([]byte("NYC")); len(keys) != 1 || string(keys[0]) != "user:1" {
+		t.Errorf("FindCovered after delete = %q", keys)
+	}
+
+	// A plain index still answers, with a second lookup
+	db.CreateIndexWithRebuild("name", JSONFieldExtractor("name"), true)
+	if pk, value, err := db.FindRecordByIndex("name", []byte("Anna")); err != nil || string(pk) != "user:1" || value == nil {
+		t.Errorf("FindRecordByIndex on a plain index = %s, %s, %v", pk, value, err)
+	}
+}
+
 func TestPrefixExtractor(t *testing.T) {
 	extractor := PrefixExtractor(4)
 
@@ -356,6 +523,58 @@ func TestIndexedBTreeDropIndex(t *testing.T) {
 	}
 }
 
+func TestIndexedBTreeDropIndexConcurrent(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4, AsyncIndexThreshold: 1, AsyncIndexQueue: 64})
+	defer db.Close()
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+	db.mu.RLock()
+	city := db.indexes["city"]
+	db.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				db.Insert(Keytype(fmt.Sprintf("w%d:%d", w, i)), Valuetype(`{"city":"NYC"}`))
+			}
+		}(w)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := db.DropIndex("city"); err != nil {
+		t.Fatal(err)
+	}
+	// Writes and queued updates from before the drop are done or discarded
+	db.SyncIndexes()
+	entries := city.Count()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	db.SyncIndexes()
+
+	if n := city.Count(); n != entries {
+		t.Errorf("Dropped index went from %d to %d entries", entries, n)
+	}
+	if _, err := db.FindAllByIndex("city", []byte("NYC")); err == nil {
+		t.Error("Query on a dropped index succeeded")
+	}
+
+	// A new index of the same name starts from the data, not the old index
+	db.CreateIndexWithRebuild("city", JSONFieldExtractor("city"), false)
+	pks, err := db.FindAllByIndex("city", []byte("NYC"))
+	if err != nil || int64(len(pks)) != db.Count() {
+		t.Errorf("Recreated index has %d keys, %v; tree has %d", len(pks), err, db.Count())
+	}
+}
+
 func TestIndexedBTreeInsertAndFind(t *testing.T) {
 	db := NewIndexedBTreeDefault()
 	db.CreateIndex("email", JSONFieldExtractor("email"), true)
@@ -407,6 +626,45 @@ func TestIndexedBTreeUniqueConstraint(t *testing.T) {
 	}
 }
 
+func TestUniqueViolationError(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndexWithConfig(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true, Normalizer: LowercaseNormalizer})
+	db.Insert([]byte("user:1"), []byte(`{"email":"alice@example.com"}`))
+	db.Insert([]byte("user:2"), []byte(`{"email":"bob@example.com"}`))
+
+	check := func(what string, err error, wantExisting string) {
+		t.Helper()
+		var conflict *UniqueViolationError
+		if !errors.As(err, &conflict) || !errors.Is(err, ErrUniqueViolation) {
+			t.Fatalf("%s: %v is not a UniqueViolationError", what, err)
+		}
+		if conflict.Index != "email" || string(conflict.IndexKey) != "alice@example.com" || string(conflict.ExistingPrimaryKey) != wantExisting {
+			t.Errorf("%s: %+v", what, conflict)
+		}
+	}
+
+	check("Insert", db.Insert([]byte("user:3"), []byte(`{"email":"Alice@Example.com"}`)), "user:1")
+	check("Update", db.Update([]byte("user:2"), []byte(`{"email":"alice@example.com"}`)), "user:1")
+
+	idx := NewSecondaryIndex(IndexConfig{Name: "email", Extractor: JSONFieldExtractor("email"), Unique: true})
+	idx.Index([]byte("user:1"), []byte(`{"email":"alice@example.com"}`))
+	check("SecondaryIndex.Index", idx.Index([]byte("user:9"), []byte(`{"email":"alice@example.com"}`)), "user:1")
+
+	err := db.BulkInsert(
+		[]Keytype{[]byte("user:4"), []byte("user:5"), []byte("user:6")},
+		[]Valuetype{[]byte(`{"email":"carol@example.com"}`), []byte(`{"email":"ALICE@example.com"}`), []byte(`{"email":"carol@example.com"}`)},
+		BulkOptions{DeferUnique: true})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("BulkInsert = %v, want a BulkError", err)
+	}
+	check("BulkInsert against the index", bulkErr.Errs[1], "user:1")
+	var conflict *UniqueViolationError
+	if !errors.As(bulkErr.Errs[2], &conflict) || string(conflict.ExistingPrimaryKey) != "user:4" {
+		t.Errorf("BulkInsert within the batch: %v", bulkErr.Errs[2])
+	}
+}
+
 func TestIndexedBTreeUpdate(t *testing.T) {
 	db := NewIndexedBTreeDefault()
 	db.CreateIndex("email", JSONFieldExtractor("email"), true)
@@ -503,6 +761,152 @@ func TestIndexedBTreeFindAllByIndex(t *testing.T) {
 	}
 }
 
+func TestIndexedBTreeFindAllByIndexPage(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndexWithConfig(IndexConfig{Name: "city", Extractor: JSONFieldExtractor("city"), Normalizer: LowercaseNormalizer})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	for i := 0; i < 25; i++ {
+		db.Insert(Keytype(fmt.Sprintf("user:%02d", i)), Valuetype(fmt.Sprintf(`{"city":"NYC","email":"u%d@x.com"}`, i)))
+	}
+	db.Insert([]byte("user:99"), []byte(`{"city":"LA"}`))
+
+	var all []Keytype
+	var pages []int
+	var cursor []byte
+	for {
+		keys, next, err := db.FindAllByIndexPage("city", []byte("nyc"), 10, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, pages = append(all, keys...), append(pages, len(keys))
+		if next == nil {
+			break
+		}
+		// Writes between pages do not disturb the cursor
+		db.Delete(next)
+		db.Insert(Keytype("user:00a"), Valuetype(`{"city":"NYC"}`))
+		cursor = next
+	}
+	if fmt.Sprint(pages) != "[10 10 5]" || len(all) != 25 || string(all[0]) != "user:00" || string(all[24]) != "user:24" {
+		t.Errorf("Pages %v gave %s", pages, all)
+	}
+	for i := 1; i < len(all); i++ {
+		if bytes.Compare(all[i-1], all[i]) >= 0 {
+			t.Fatalf("Keys out of order: %s", all)
+		}
+	}
+
+	if keys, next, err := db.FindAllByIndexPage("city", []byte("NYC"), 0, []byte("user:20")); err != nil || len(keys) != 4 || next != nil {
+		t.Errorf("Rest after user:20 = %s, %q, %v", keys, next, err)
+	}
+	if keys, next, err := db.FindAllByIndexPage("city", []byte("NYC"), 5, []byte("user:99")); err != nil || len(keys) != 0 || next != nil {
+		t.Errorf("Page past the end = %s, %q, %v", keys, next, err)
+	}
+	if _, _, err := db.FindAllByIndexPage("city", []byte("Paris"), 5, nil); err == nil {
+		t.Error("Page of a missing key succeeded")
+	}
+	if keys, next, err := db.FindAllByIndexPage("email", []byte("u3@x.com"), 5, nil); err != nil || fmt.Sprintf("%s", keys) != "[user:03]" || next != nil {
+		t.Errorf("Unique page = %s, %q, %v", keys, next, err)
+	}
+	if keys, _, _ := db.FindAllByIndexPage("email", []byte("u3@x.com"), 5, []byte("user:03")); len(keys) != 0 {
+		t.Errorf("Unique page after its key = %s", keys)
+	}
+}
+
+func TestIndexedBTreeCountByIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndexWithConfig(IndexConfig{Name: "city", Extractor: JSONFieldExtractor("city"), Normalizer: LowercaseNormalizer})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	for i := 0; i < 30; i++ {
+		db.Insert(Keytype(fmt.Sprintf("user:%02d", i)), Valuetype(fmt.Sprintf(`{"city":"c%d","email":"u%02d@x.com"}`, i%4, i)))
+	}
+
+	counts := []struct {
+		index      string
+		start, end string
+		want       uint64
+	}{
+		{"city", "C1", "C1", 8},
+		{"city", "c3", "c3", 7},
+		{"city", "paris", "paris", 0},
+		{"city", "c1", "c2", 15},
+		{"city", "a", "z", 30},
+		{"email", "u05@x.com", "u05@x.com", 1},
+		{"email", "u10@x.com", "u19@x.com", 10},
+		{"email", "nobody", "nobody", 0},
+	}
+	for _, c := range counts {
+		var n uint64
+		var err error
+		if c.start == c.end {
+			n, err = db.CountByIndex(c.index, []byte(c.start))
+		} else {
+			n, err = db.CountRangeByIndex(c.index, []byte(c.start), []byte(c.end))
+		}
+		if err != nil || n != c.want {
+			t.Errorf("Count %s [%s, %s] = %d, %v, want %d", c.index, c.start, c.end, n, err, c.want)
+		}
+	}
+
+	// Counts read the index, not the primary tree
+	db.tree.Delete(Keytype("user:01"))
+	if n, _ := db.CountByIndex("city", []byte("c1")); n != 8 {
+		t.Errorf("CountByIndex after a primary-only delete = %d, want 8", n)
+	}
+
+	if _, err := db.CountRangeByIndex("city", []byte("z"), []byte("a")); err == nil {
+		t.Error("Expected error for an inverted range")
+	}
+	if _, err := db.CountByIndex("missing", []byte("x")); err == nil {
+		t.Error("Expected error for non-existent index")
+	}
+}
+
+func TestIndexedBTreeFindValuesByIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndexWithConfig(IndexConfig{Name: "email_covering", Extractor: JSONFieldExtractor("email"), Unique: true, Covering: true})
+
+	records := map[string]string{
+		"user:1": `{"city":"NYC","email":"alice@x.com"}`,
+		"user:2": `{"city":"NYC","email":"bob@x.com"}`,
+		"user:3": `{"city":"LA","email":"carol@x.com"}`,
+	}
+	for pk, record := range records {
+		db.Insert([]byte(pk), []byte(record))
+	}
+
+	for _, index := range []string{"email", "email_covering"} {
+		value, err := db.FindValueByIndex(index, []byte("bob@x.com"))
+		if err != nil || string(value) != records["user:2"] {
+			t.Errorf("FindValueByIndex(%s) = %s, %v", index, value, err)
+		}
+	}
+	if _, err := db.FindValueByIndex("city", []byte("NYC")); err == nil {
+		t.Error("FindValueByIndex on a non-unique index succeeded")
+	}
+	if _, err := db.FindValueByIndex("email", []byte("nobody@x.com")); err == nil {
+		t.Error("FindValueByIndex found a missing key")
+	}
+
+	pks, values, err := db.FindAllValuesByIndex("city", []byte("NYC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pks) != 2 || len(values) != 2 {
+		t.Fatalf("FindAllValuesByIndex returned %d keys, %d values, want 2", len(pks), len(values))
+	}
+	for i, pk := range pks {
+		if string(values[i]) != records[string(pk)] {
+			t.Errorf("%s: value %s, want %s", pk, values[i], records[string(pk)])
+		}
+	}
+	if _, _, err := db.FindAllValuesByIndex("city", []byte("Paris")); err == nil {
+		t.Error("FindAllValuesByIndex found a missing key")
+	}
+}
+
 func TestIndexedBTreeFindRangeByIndex(t *testing.T) {
 	db := NewIndexedBTreeDefault()
 	db.CreateIndex("name", JSONFieldExtractor("name"), true)
@@ -526,6 +930,169 @@ func TestIndexedBTreeFindRangeByIndex(t *testing.T) {
 	}
 }
 
+func TestIndexedBTreeFindRangeByIndexReverse(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("created_at", JSONFieldExtractor("created_at"), false)
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+
+	for i := 0; i < 300; i++ {
+		db.Insert(
+			[]byte(fmt.Sprintf("user:%03d", i)),
+			[]byte(fmt.Sprintf(`{"created_at":"2026-01-%02d","email":"u%03d@x.com"}`, 1+i/10, i)),
+		)
+	}
+
+	// The 12 most recent signups span the last two days
+	pks, err := db.FindRangeByIndexReverse("created_at", []byte("2026-01-01"), []byte("2026-01-31"), 12)
+	if err != nil {
+		t.Fatalf("FindRangeByIndexReverse failed: %v", err)
+	}
+	want := []string{"user:299", "user:298", "user:297", "user:296", "user:295", "user:294",
+		"user:293", "user:292", "user:291", "user:290", "user:289", "user:288"}
+	if got := fmt.Sprintf("%s", pks); got != fmt.Sprint(want) {
+		t.Errorf("Got %s, want %v", got, want)
+	}
+
+	all, _ := db.FindRangeByIndexReverse("email", []byte("u100@x.com"), []byte("u199@x.com"), 0)
+	forward, _ := db.FindRangeByIndex("email", []byte("u100@x.com"), []byte("u199@x.com"))
+	slices.Reverse(forward)
+	if len(all) != 100 || fmt.Sprintf("%s", all) != fmt.Sprintf("%s", forward) {
+		t.Errorf("Unique reverse range = %d keys, want the forward range reversed", len(all))
+	}
+
+	if _, err := db.FindRangeByIndexReverse("email", []byte("b"), []byte("a"), 1); err == nil {
+		t.Error("FindRangeByIndexReverse accepted an inverted range")
+	}
+}
+
+func TestIndexedBTreeFindPrefixByIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndexWithConfig(IndexConfig{Name: "name", Extractor: JSONFieldExtractor("name"), Normalizer: LowercaseNormalizer})
+	db.CreateIndex("domain", ReversedDomainExtractor(JSONFieldExtractor("email")), true)
+
+	users := []struct{ name, email string }{
+		{"Alice", "alice@example.com"},
+		{"albert", "albert@mail.example.com"},
+		{"Bob", "bob@example.org"},
+		{"Alan", "alan@example.com"},
+		{"Carol", "carol@notexample.com"},
+	}
+	for i, u := range users {
+		db.Insert([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf(`{"name":%q,"email":%q}`, u.name, u.email)))
+	}
+
+	cases := []struct {
+		index, prefix, want string
+	}{
+		{"name", "Al", "[user:3 user:1 user:0]"}, // alan, albert, alice
+		{"name", "ali", "[user:0]"},
+		{"name", "z", "[]"},
+		{"domain", "com.example@", "[user:3 user:0]"},
+		{"domain", "com.example.", "[user:1]"},
+		{"domain", "com.", "[user:1 user:3 user:0 user:4]"},
+	}
+	for _, tc := range cases {
+		pks, err := db.FindPrefixByIndex(tc.index, []byte(tc.prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s", pks); got != tc.want {
+			t.Errorf("FindPrefixByIndex(%s, %q) = %s, want %s", tc.index, tc.prefix, got, tc.want)
+		}
+	}
+	if _, err := db.FindPrefixByIndex("missing", []byte("a")); err == nil {
+		t.Error("FindPrefixByIndex on a missing index succeeded")
+	}
+}
+
+func TestReversedDomainExtractor(t *testing.T) {
+	extract := ReversedDomainExtractor(func(v Valuetype) []byte { return v })
+	for in, want := range map[string]string{
+		"alice@mail.example.com": "com.example.mail@alice",
+		"www.example.com":        "com.example.www",
+		"a@b@example.com":        "com.example@a@b",
+		"localhost":              "localhost",
+	} {
+		if got := extract(Valuetype(in)); string(got) != want {
+			t.Errorf("%q -> %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIndexedBTreeRangeByIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+
+	cities := []string{"paris", "berlin", "paris", "rome", "austin"}
+	for i, city := range cities {
+		db.Insert(
+			[]byte(fmt.Sprintf("user:%d", i)),
+			[]byte(fmt.Sprintf(`{"city":"%s"}`, city)),
+		)
+	}
+
+	seq, err := db.RangeByIndex("city", []byte("b"), []byte("q"))
+	if err != nil {
+		t.Fatalf("RangeByIndex failed: %v", err)
+	}
+
+	var order []string
+	for pk, value := range seq {
+		if !bytes.Contains(value, []byte("city")) {
+			t.Errorf("Unexpected record %s for %s", value, pk)
+		}
+		order = append(order, string(JSONFieldExtractor("city")(value)))
+	}
+
+	want := []string{"berlin", "paris", "paris"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("RangeByIndex order = %v, want %v", order, want)
+	}
+
+	if _, err := db.RangeByIndex("missing", nil, nil); err == nil {
+		t.Error("Expected error for non-existent index")
+	}
+}
+
+func TestIndexedBTreeForEachByIndex(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+
+	// More records than one fetch batch, inserted out of email order
+	for i := 0; i < 300; i++ {
+		n := (i * 7) % 300
+		db.Insert(Keytype(fmt.Sprintf("user:%03d", i)), Valuetype(fmt.Sprintf(`{"email":"e%03d@x.com","city":"c%d"}`, n, i%3)))
+	}
+	db.Insert([]byte("user:nomail"), []byte(`{"city":"c0"}`))
+
+	var emails []string
+	err := db.ForEachByIndex("email", func(key Keytype, value Valuetype) bool {
+		emails = append(emails, string(JSONFieldExtractor("email")(value)))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 300 || !slices.IsSorted(emails) {
+		t.Errorf("Visited %d records, sorted %v", len(emails), slices.IsSorted(emails))
+	}
+
+	// Ties are broken by primary key; fn can stop early
+	var keys []string
+	db.ForEachByIndex("city", func(key Keytype, value Valuetype) bool {
+		keys = append(keys, string(key))
+		return len(keys) < 3
+	})
+	if fmt.Sprint(keys) != "[user:000 user:003 user:006]" {
+		t.Errorf("First c0 records = %v", keys)
+	}
+
+	if err := db.ForEachByIndex("missing", func(Keytype, Valuetype) bool { return true }); err == nil {
+		t.Error("Expected error for non-existent index")
+	}
+}
+
 func TestIndexedBTreeCreateIndexWithRebuild(t *testing.T) {
 	db := NewIndexedBTreeDefault()
 
@@ -553,6 +1120,49 @@ func TestIndexedBTreeCreateIndexWithRebuild(t *testing.T) {
 	}
 }
 
+func TestIndexedBTreeCreateIndexWithRebuildConcurrentWrites(t *testing.T) {
+	db := NewIndexedBTreeDefault()
+	for i := 0; i < 20000; i++ {
+		db.Insert([]byte(fmt.Sprintf("user:%05d", i)), []byte(fmt.Sprintf(`{"city":"c%d"}`, i%10)))
+	}
+
+	// Writers insert and delete throughout the rebuild's scan
+	var stop atomic.Bool
+	var writes atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; !stop.Load(); i += 4 {
+				key := []byte(fmt.Sprintf("user:%05d", i%25000))
+				if i%3 == 0 {
+					db.Delete(key)
+				} else {
+					db.Insert(key, []byte(fmt.Sprintf(`{"city":"c%d"}`, i%10)))
+				}
+				writes.Add(1)
+			}
+		}(w)
+	}
+	for writes.Load() < 100 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := db.CreateIndexWithRebuild("city", JSONFieldExtractor("city"), false); err != nil {
+		t.Fatalf("CreateIndexWithRebuild failed: %v", err)
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	problems, err := db.VerifyIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("Index diverged from the records: %d problems, first %+v", len(problems), problems[0])
+	}
+}
+
 func TestIndexedBTreeClear(t *testing.T) {
 	db := NewIndexedBTreeDefault()
 	db.CreateIndex("email", JSONFieldExtractor("email"), true)
@@ -644,6 +1254,41 @@ func TestIndexedBTreeStats(t *testing.T) {
 	if emailStats.Entries != 100 {
 		t.Errorf("Expected 100 index entries, got %d", emailStats.Entries)
 	}
+	if emailStats.DistinctKeys != 100 || emailStats.AvgPostings != 1 {
+		t.Errorf("Expected 100 distinct keys of 1 posting, got %d of %f", emailStats.DistinctKeys, emailStats.AvgPostings)
+	}
+}
+
+func TestIndexStatsCardinality(t *testing.T) {
+	idx := NewSecondaryIndex(IndexConfig{
+		Name:      "city",
+		Extractor: JSONFieldExtractor("city"),
+	})
+	// NYC: 5 records, LA: 2, SF: 1
+	for i, city := range []string{"NYC", "NYC", "LA", "NYC", "SF", "NYC", "LA", "NYC"} {
+		idx.Index([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf(`{"city":"%s"}`, city)))
+	}
+
+	stats := idx.Stats()
+	if stats.Entries != 8 || stats.DistinctKeys != 3 || stats.MaxPostings != 5 {
+		t.Errorf("Expected 8 entries, 3 distinct keys, max 5, got %d, %d, %d",
+			stats.Entries, stats.DistinctKeys, stats.MaxPostings)
+	}
+	if want := 8.0 / 3; stats.AvgPostings != want {
+		t.Errorf("Expected %f postings per key, got %f", want, stats.AvgPostings)
+	}
+	// SF in [1,1], LA in [2,3], NYC in [4,7]
+	if !slices.Equal(stats.PostingsHistogram, []uint64{1, 1, 1}) {
+		t.Errorf("Expected histogram [1 1 1], got %v", stats.PostingsHistogram)
+	}
+	if want := 1.0 / 3; stats.Selectivity() != want {
+		t.Errorf("Expected selectivity %f, got %f", want, stats.Selectivity())
+	}
+
+	idx.Clear()
+	if stats := idx.Stats(); stats.DistinctKeys != 0 || stats.Selectivity() != 0 || stats.PostingsHistogram != nil {
+		t.Errorf("Expected empty stats after Clear, got %+v", stats)
+	}
 }
 
 // ==================== Concurrent Tests ====================
@@ -677,6 +1322,100 @@ func TestIndexedBTreeConcurrentInserts(t *testing.T) {
 	}
 }
 
+func TestIndexedBTreeConcurrentUniqueInserts(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 8})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+
+	for round := 0; round < 50; round++ {
+		email := fmt.Sprintf("shared%d@example.com", round)
+		const numGoroutines = 8
+		var wg sync.WaitGroup
+		var succeeded atomic.Int32
+		start := make(chan struct{})
+		for g := 0; g < numGoroutines; g++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				<-start
+				key := []byte(fmt.Sprintf("user:%d_%d", round, id))
+				err := db.Insert(key, []byte(fmt.Sprintf(`{"email":"%s","city":"NYC"}`, email)))
+				if err == nil {
+					succeeded.Add(1)
+				} else if !errors.Is(err, ErrUniqueViolation) {
+					t.Errorf("Expected unique violation, got %v", err)
+				}
+			}(g)
+		}
+		close(start)
+		wg.Wait()
+
+		if n := succeeded.Load(); n != 1 {
+			t.Fatalf("Round %d: expected exactly 1 insert to succeed, got %d", round, n)
+		}
+		pk, err := db.FindByIndex("email", []byte(email))
+		if err != nil {
+			t.Fatalf("Round %d: email not indexed: %v", round, err)
+		}
+		if _, err := db.Find(pk); err != nil {
+			t.Fatalf("Round %d: indexed record %q missing", round, pk)
+		}
+	}
+
+	if db.Count() != 50 {
+		t.Errorf("Expected 50 records, got %d", db.Count())
+	}
+	if n, _ := db.CountByIndex("city", []byte("NYC")); n != 50 {
+		t.Errorf("Expected 50 city postings, got %d", n)
+	}
+}
+
+func TestIndexedBTreeIndexFailureReleasesClaims(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{})
+	db.CreateIndex("email", JSONFieldExtractor("email"), true)
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+
+	// A unique index whose online build goes ready between a write's claims
+	// and its index updates: the write is not claimed in it, and fails there
+	late := NewSecondaryIndex(IndexConfig{Name: "late", Extractor: JSONFieldExtractor("late"), Unique: true})
+	late.build.Store(&IndexBuild{idx: late, phase: BuildReady})
+	if err := db.addIndex(late, false); err != nil {
+		t.Fatal(err)
+	}
+	late.Index([]byte("other"), []byte(`{"late":"taken"}`))
+
+	err := db.Insert([]byte("user:1"), []byte(`{"email":"a@example.com","city":"NYC","late":"taken"}`))
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("Insert = %v, want a unique violation from the late index", err)
+	}
+	if _, err := db.Find([]byte("user:1")); err == nil {
+		t.Error("Failed insert left its record")
+	}
+	if err := db.Insert([]byte("user:2"), []byte(`{"email":"a@example.com","city":"NYC","late":"free"}`)); err != nil {
+		t.Fatalf("Re-insert of the released email = %v", err)
+	}
+
+	err = db.Update([]byte("user:2"), []byte(`{"email":"b@example.com","city":"LA","late":"taken"}`))
+	if !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("Update = %v, want a unique violation from the late index", err)
+	}
+	if err := db.Insert([]byte("user:3"), []byte(`{"email":"b@example.com","city":"SF"}`)); err != nil {
+		t.Errorf("Insert of the email the failed update released = %v", err)
+	}
+	if pk, err := db.FindByIndex("email", []byte("a@example.com")); err != nil || string(pk) != "user:2" {
+		t.Errorf("email a = %q, %v, want user:2", pk, err)
+	}
+	if n, _ := db.CountByIndex("city", []byte("NYC")); n != 1 {
+		t.Errorf("NYC postings = %d, want only user:2", n)
+	}
+	if n, _ := db.CountByIndex("city", []byte("LA")); n != 0 {
+		t.Errorf("LA postings = %d, want the failed update's removed", n)
+	}
+	if pk, err := late.FindOne([]byte("taken")); err != nil || string(pk) != "other" {
+		t.Errorf("late taken = %q, %v, want other", pk, err)
+	}
+}
+
 func TestIndexedBTreeConcurrentMixed(t *testing.T) {
 	db := NewIndexedBTree(IndexedConfig{NumShards: 8})
 	db.CreateIndex("id", JSONFieldExtractor("id"), true)
@@ -732,6 +1471,94 @@ func TestIndexedBTreeConcurrentMixed(t *testing.T) {
 	t.Logf("Final count: %d", db.Count())
 }
 
+func TestIndexedBTreeAsyncIndexUpdates(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 4, AsyncIndexThreshold: 1})
+	defer db.Close()
+	db.CreateIndex("id", JSONFieldExtractor("id"), true)
+	db.CreateIndex("city", JSONFieldExtractor("city"), false)
+
+	// Simulate overload: more writes in flight than the threshold
+	atomic.AddInt64(&db.inflight, 10)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("user:%d", i))
+		value := []byte(fmt.Sprintf(`{"id":"u%d","city":"c%d"}`, i, i%5))
+		if err := db.Insert(key, value); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	db.Update([]byte("user:0"), []byte(`{"id":"u0","city":"moved"}`))
+	db.Delete([]byte("user:1"))
+	atomic.AddInt64(&db.inflight, -10)
+
+	// Unique indexes stay current even while degraded
+	if _, err := db.FindByIndex("id", []byte("u42")); err != nil {
+		t.Errorf("Unique index lagged under load: %v", err)
+	}
+	if err := db.Insert([]byte("dup"), []byte(`{"id":"u42"}`)); err == nil {
+		t.Error("Unique constraint not enforced while degraded")
+	}
+
+	if got := db.Stats().DeferredIndexUpdates; got != 102 {
+		t.Errorf("DeferredIndexUpdates = %d, want 102", got)
+	}
+
+	db.SyncIndexes()
+	if lag := db.IndexLag(); lag != 0 {
+		t.Errorf("IndexLag after SyncIndexes = %d, want 0", lag)
+	}
+
+	pks, _ := db.FindAllByIndex("city", []byte("c2"))
+	if len(pks) != 20 {
+		t.Errorf("Expected 20 records in c2 after catch-up, got %d", len(pks))
+	}
+	if pks, _ := db.FindAllByIndex("city", []byte("moved")); len(pks) != 1 {
+		t.Errorf("Expected deferred update to be applied, got %v", pks)
+	}
+	if pks, _ := db.FindAllByIndex("city", []byte("c1")); len(pks) != 19 {
+		t.Errorf("Expected deferred delete to be applied, got %d records", len(pks))
+	}
+}
+
+func TestIndexedBTreeAsyncIndexConcurrent(t *testing.T) {
+	db := NewIndexedBTree(IndexedConfig{NumShards: 8, AsyncIndexThreshold: 1, AsyncIndexQueue: 16})
+	db.CreateIndex("group", JSONFieldExtractor("group"), false)
+
+	const numGoroutines = 10
+	const opsPerGoroutine = 100
+
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for g := 0; g < numGoroutines; g++ {
+		go func(id int) {
+			defer wg.Done()
+			for i := 0; i < opsPerGoroutine; i++ {
+				key := []byte(fmt.Sprintf("item:g%d_%d", id, i))
+				value := []byte(fmt.Sprintf(`{"group":"g%d"}`, id))
+				db.Insert(key, value)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Close drains the queue
+	db.Close()
+	if lag := db.IndexLag(); lag != 0 {
+		t.Errorf("IndexLag after Close = %d, want 0", lag)
+	}
+	for g := 0; g < numGoroutines; g++ {
+		pks, _ := db.FindAllByIndex("group", []byte(fmt.Sprintf("g%d", g)))
+		if len(pks) != opsPerGoroutine {
+			t.Errorf("Group g%d has %d index entries, want %d", g, len(pks), opsPerGoroutine)
+		}
+	}
+
+	// Writes after Close update indexes inline
+	db.Insert([]byte("late"), []byte(`{"group":"late"}`))
+	if pks, _ := db.FindAllByIndex("group", []byte("late")); len(pks) != 1 {
+		t.Errorf("Expected inline index update after Close, got %v", pks)
+	}
+}
+
 // ==================== Edge Case Tests ====================
 
 func TestIndexedBTreeFindNonExistentIndex(t *testing.T) {
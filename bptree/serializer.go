@@ -0,0 +1,144 @@
+package bptree
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Serializer encodes stats and admin payloads (ShardStats, DurableStats,
+// Recommendation, ...) for export.
+//
+// DESIGN:
+// - Payloads are plain structs; the wire format is the embedder's choice
+// - JSON and gob ship built in; msgpack, protobuf etc. plug in through RegisterSerializer
+// - Gzip wraps any Serializer for scrapers with tight payload budgets
+//
+// USAGE:
+//
+//	s, _ := SerializerByName("gob+gzip")
+//	payload, err := s.Marshal(tree.Stats(true))
+type Serializer interface {
+	// Name identifies the format, e.g. "json"
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONSerializer encodes payloads as JSON, optionally indented.
+type JSONSerializer struct {
+	Indent bool
+}
+
+func (JSONSerializer) Name() string { return "json" }
+
+func (s JSONSerializer) Marshal(v any) ([]byte, error) {
+	if s.Indent {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
+func (JSONSerializer) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobSerializer encodes payloads with encoding/gob. Each payload carries its
+// type description, so it beats JSON only on large payloads (many shards or
+// indexes), and only Go consumers can read it.
+type GobSerializer struct{}
+
+func (GobSerializer) Name() string { return "gob" }
+
+func (GobSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Gzip compresses the output of another Serializer.
+type Gzip struct {
+	Serializer Serializer
+}
+
+func (g Gzip) Name() string { return g.Serializer.Name() + "+gzip" }
+
+func (g Gzip) Marshal(v any) ([]byte, error) {
+	data, err := g.Serializer.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g Gzip) Unmarshal(data []byte, v any) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	return g.Serializer.Unmarshal(raw, v)
+}
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[string]Serializer{}
+)
+
+func init() {
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}} {
+		RegisterSerializer(s)
+		RegisterSerializer(Gzip{s})
+	}
+}
+
+// RegisterSerializer makes s available to SerializerByName under s.Name(),
+// replacing any serializer of the same name.
+func RegisterSerializer(s Serializer) {
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	serializers[s.Name()] = s
+}
+
+// SerializerByName returns the registered serializer with the given name.
+func SerializerByName(name string) (Serializer, error) {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+	if s, ok := serializers[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown serializer %q (have %v)", name, serializerNames())
+}
+
+// serializerNames lists the registered names in order. Called with
+// serializersMu held.
+func serializerNames() []string {
+	names := make([]string, 0, len(serializers))
+	for name := range serializers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package bptree
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSerializersRoundTripStats(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 1000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	stats := tree.Stats(false)
+
+	for _, name := range []string{"json", "gob", "json+gzip", "gob+gzip"} {
+		s, err := SerializerByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := s.Marshal(stats)
+		if err != nil {
+			t.Fatalf("%s: Marshal: %v", name, err)
+		}
+		var decoded ShardStats
+		if err := s.Unmarshal(payload, &decoded); err != nil {
+			t.Fatalf("%s: Unmarshal: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, stats) {
+			t.Errorf("%s: round trip = %+v, want %+v", name, decoded, stats)
+		}
+	}
+}
+
+type customSerializer struct{ JSONSerializer }
+
+func (customSerializer) Name() string { return "test-custom" }
+
+func TestRegisterSerializer(t *testing.T) {
+	if _, err := SerializerByName("test-custom"); err == nil {
+		t.Fatal("Expected error for an unregistered serializer")
+	}
+	RegisterSerializer(customSerializer{})
+	if s, err := SerializerByName("test-custom"); err != nil || s.Name() != "test-custom" {
+		t.Errorf("SerializerByName(test-custom) = %v, %v", s, err)
+	}
+	if err := (Gzip{JSONSerializer{}}).Unmarshal([]byte("not gzip"), new(ShardStats)); err == nil {
+		t.Error("Expected error decompressing garbage")
+	}
+}
@@ -306,7 +306,7 @@ func BenchmarkShardedBulkInsert(b *testing.B) {
 
 // BenchmarkShardedLatency measures operation latencies
 func BenchmarkShardedLatency(b *testing.B) {
-	tree := NewShardedBTree(ShardConfig{NumShards: 8})
+	tree := NewShardedBTree(ShardConfig{NumShards: 8, RecordHistograms: true})
 
 	// Pre-populate
 	for i := 0; i < 100000; i++ {
@@ -314,18 +314,22 @@ func BenchmarkShardedLatency(b *testing.B) {
 	}
 
 	b.Run("Insert", func(b *testing.B) {
+		tree.ResetHistograms()
 		for i := 0; i < b.N; i++ {
 			key := fmt.Sprintf("new-key-%08d", i)
 			tree.Insert(Keytype(key), Valuetype("val"))
 		}
+		reportPercentiles(b, tree.Latency(LatencyInsert))
 	})
 
 	b.Run("Find", func(b *testing.B) {
 		rng := rand.New(rand.NewSource(42))
+		tree.ResetHistograms()
 		for i := 0; i < b.N; i++ {
 			key := fmt.Sprintf("key-%08d", rng.Intn(100000))
 			tree.Find(Keytype(key))
 		}
+		reportPercentiles(b, tree.Latency(LatencyFind))
 	})
 
 	b.Run("Delete", func(b *testing.B) {
@@ -333,10 +337,12 @@ func BenchmarkShardedLatency(b *testing.B) {
 		for i := 0; i < b.N; i++ {
 			tree.Insert(Keytype(fmt.Sprintf("del-key-%08d", i)), Valuetype("val"))
 		}
+		tree.ResetHistograms()
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			tree.Delete(Keytype(fmt.Sprintf("del-key-%08d", i)))
 		}
+		reportPercentiles(b, tree.Latency(LatencyDelete))
 	})
 }
 
@@ -2,9 +2,11 @@ package bptree
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"iter"
 	"runtime"
-	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -12,7 +14,7 @@ import (
 // ShardedBTree distributes data across multiple B-Trees for linear scaling.
 //
 // DESIGN:
-// - Keys are hashed to determine which shard they belong to
+// - Keys are hashed (FNV-1a unless ShardConfig.HashFunc) to determine which shard they belong to
 // - Each shard is an independent B-Tree with its own lock
 // - Operations on different shards can proceed in parallel
 //
@@ -27,11 +29,37 @@ import (
 type ShardedBTree struct {
 	shards    []*Btree
 	numShards uint32
+	hash      HashFunc // Places keys on shards (see ShardConfig.HashFunc)
+	affinity  byte     // Hash only the key up to this byte, 0 for the whole key
 
 	// Statistics (atomic for lock-free reads)
 	totalInserts uint64
 	totalDeletes uint64
 	totalFinds   uint64
+
+	// Per-shard histograms, nil unless ShardConfig.RecordHistograms
+	metrics []*shardMetrics
+
+	counters   []*shardCounters // Per-shard activity (see ShardActivity)
+	sampleRate atomic.Int64     // Time one in sampleRate operations, 0 for none
+
+	// Per-shard hot-key trackers, nil unless ShardConfig.HotKeySampleRate
+	hot     []*hotKeyTracker
+	hotTopK int
+
+	hooks hookRegistry // Mutation hooks (see OnInsert)
+
+	// Per-shard expiry times, nil unless ShardConfig.EnableTTL
+	ttl []*ttlShard
+
+	// Resizable placement, set by ShardConfig.ConsistentHashing or
+	// Rendezvous. The fields below resizable change only under layoutMu
+	// (see Resize).
+	resizable     bool
+	rebalanceSkew float64
+	layoutMu      sync.RWMutex
+	place         placement
+	migration     *shardMigration // Running resize, nil if none
 }
 
 // ShardConfig configures the sharded B-Tree.
@@ -39,6 +67,68 @@ type ShardConfig struct {
 	// NumShards is the number of shards. Default: runtime.NumCPU()
 	// Power of 2 recommended for faster modulo operation.
 	NumShards int
+
+	// HashFunc places keys on shards (default: FNV1a). NewMaphash resists
+	// crafted keys that would skew FNV-1a placement.
+	HashFunc HashFunc
+
+	// AffinityDelimiter places keys by the part before the first
+	// AffinityDelimiter (default: 0, the whole key), so that e.g. with ':'
+	// all "tenant:..." keys share a shard: AtomicUpdate can span them, and a
+	// range within one prefix reads one shard. Keys without it are placed
+	// whole. Skews shards when a few prefixes hold most keys.
+	AffinityDelimiter byte
+
+	// RecordHistograms enables per-shard latency and batch size histograms
+	// (see Latency and BatchSizes). Costs two clock reads per operation.
+	RecordHistograms bool
+
+	// LatencySampleRate times one in every LatencySampleRate operations on a
+	// shard for the moving-average latencies in ShardStats.Shards (default:
+	// 0, none). Each timed operation costs two clock reads.
+	LatencySampleRate int
+
+	// HotKeySampleRate samples one in every HotKeySampleRate accesses (Find,
+	// writes and Delete) on a shard to estimate its most accessed keys,
+	// reported in ShardStats.Shards (default: 0, off). HotKeys is how many
+	// per shard (default: 10).
+	HotKeySampleRate int
+	HotKeys          int
+
+	// PanicFree makes shards return an InvariantError instead of panicking.
+	// A failed shard keeps failing until Clear.
+	PanicFree bool
+
+	// MaxKeySize and MaxValueSize bound entries in bytes (default: 0, no
+	// limit). TryInsert reports a SizeLimitError for an oversized entry;
+	// Insert panics with it.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// EnableTTL allows InsertWithTTL (default: false). Writes to a shard
+	// then serialize on a per-shard lock.
+	EnableTTL bool
+
+	// ConsistentHashing places keys on a hash ring instead of by hash modulo
+	// NumShards (default: false), so Resize can add shards while moving only
+	// about 1/N of the keys. Every operation then takes a shared layout lock.
+	ConsistentHashing bool
+
+	// VirtualNodes is the number of ring points per shard with
+	// ConsistentHashing (default: 128). More points spread keys more evenly.
+	VirtualNodes int
+
+	// Rendezvous places keys by rendezvous (highest random weight) hashing
+	// instead (default: false), and like ConsistentHashing allows Resize. It
+	// spreads keys evenly without virtual nodes and a new shard takes only
+	// the keys it wins, but each placement costs one hash per shard and
+	// Rebalance has nothing to move. Takes precedence over
+	// ConsistentHashing.
+	Rendezvous bool
+
+	// RebalanceSkew is the Stats(true).Skew above which Rebalance moves keys
+	// (default: 0.1).
+	RebalanceSkew float64
 }
 
 // ShardStats provides statistics about shard distribution.
@@ -52,6 +142,8 @@ type ShardStats struct {
 	TotalInserts uint64
 	TotalDeletes uint64
 	TotalFinds   uint64
+	Tree         TreeStats       // Shape of all shards combined
+	Shards       []ShardActivity // Operations, lock waits and latency by shard
 }
 
 // NewShardedBTree creates a new sharded B-Tree with the given configuration.
@@ -64,10 +156,68 @@ func NewShardedBTree(config ShardConfig) *ShardedBTree {
 	s := &ShardedBTree{
 		shards:    make([]*Btree, numShards),
 		numShards: uint32(numShards),
+		counters:  make([]*shardCounters, numShards),
+		hash:      config.HashFunc,
+	}
+	if s.hash == nil {
+		s.hash = FNV1a
 	}
+	if config.AffinityDelimiter != 0 {
+		s.affinity = config.AffinityDelimiter
+		hash := s.hash
+		s.hash = func(key []byte) uint32 {
+			return hash(s.affinityPrefix(key))
+		}
+	}
+	s.SetLatencySampling(config.LatencySampleRate)
 
 	for i := 0; i < numShards; i++ {
+		s.counters[i] = &shardCounters{}
 		s.shards[i] = &Btree{}
+		s.shards[i].SetPanicFree(config.PanicFree)
+		s.shards[i].SetSizeLimits(config.MaxKeySize, config.MaxValueSize)
+	}
+
+	if config.EnableTTL {
+		s.ttl = make([]*ttlShard, numShards)
+		for i := range s.ttl {
+			s.ttl[i] = &ttlShard{expires: make(map[string]int64)}
+		}
+	}
+
+	if config.HotKeySampleRate > 0 {
+		s.hotTopK = config.HotKeys
+		if s.hotTopK <= 0 {
+			s.hotTopK = defaultHotKeys
+		}
+		s.hot = make([]*hotKeyTracker, numShards)
+		for i := range s.hot {
+			s.hot[i] = newHotKeyTracker(config.HotKeySampleRate, s.hotTopK)
+		}
+	}
+
+	if config.RecordHistograms {
+		s.metrics = make([]*shardMetrics, numShards)
+		for i := range s.metrics {
+			s.metrics[i] = &shardMetrics{}
+		}
+	}
+
+	if config.ConsistentHashing || config.Rendezvous {
+		s.resizable = true
+		if config.Rendezvous {
+			s.place = newRendezvous(numShards)
+		} else {
+			vnodes := config.VirtualNodes
+			if vnodes <= 0 {
+				vnodes = defaultVirtualNodes
+			}
+			s.place = newHashRing(numShards, vnodes)
+		}
+		s.rebalanceSkew = config.RebalanceSkew
+		if s.rebalanceSkew <= 0 {
+			s.rebalanceSkew = defaultRebalanceSkew
+		}
 	}
 
 	return s
@@ -94,26 +244,116 @@ func fnv32a(key []byte) uint32 {
 	return hash
 }
 
-// getShard returns the shard for a given key.
+// getShard returns the shard for a given key. Called pinned.
 func (s *ShardedBTree) getShard(key Keytype) *Btree {
-	hash := fnv32a(key)
-	// Use bitwise AND if numShards is power of 2, otherwise modulo
-	// For simplicity, we always use modulo (compiler optimizes power of 2)
-	return s.shards[hash%s.numShards]
+	return s.shards[s.getShardIndex(key)]
 }
 
-// getShardIndex returns the shard index for a given key.
+// getShardIndex returns the shard index for a given key. Called pinned: when
+// resizable, a key a running resize has not moved yet stays on its
+// old shard.
 func (s *ShardedBTree) getShardIndex(key Keytype) int {
-	hash := fnv32a(key)
-	return int(hash % s.numShards)
+	hash := s.hash(key)
+	if !s.resizable {
+		// For simplicity, we always use modulo (compiler optimizes power of 2)
+		return int(hash % s.numShards)
+	}
+	idx := s.place.locate(hash)
+	if m := s.migration; m != nil {
+		if from := m.from.locate(hash); from != idx && !m.migrated(from, key) {
+			return from
+		}
+	}
+	return idx
+}
+
+// affinityPrefix returns the part of key that places it: up to the first
+// affinity delimiter, or all of it.
+func (s *ShardedBTree) affinityPrefix(key []byte) []byte {
+	if s.affinity != 0 {
+		if i := bytes.IndexByte(key, s.affinity); i >= 0 {
+			return key[:i]
+		}
+	}
+	return key
+}
+
+// rangeShard returns the only shard that can hold keys in [startKey,
+// endKey], or -1 if any may. With AffinityDelimiter, that is the case when
+// both bounds carry the same delimited prefix, which every key between them
+// then shares. Called pinned.
+func (s *ShardedBTree) rangeShard(startKey, endKey []byte) int {
+	if s.affinity == 0 || s.migration != nil {
+		return -1 // A migrating prefix may be split between two shards
+	}
+	prefix := s.affinityPrefix(startKey)
+	if len(prefix) == len(startKey) || !bytes.HasPrefix(endKey, append(prefix[:len(prefix):len(prefix)], s.affinity)) {
+		return -1
+	}
+	return s.getShardIndex(startKey)
 }
 
 // Insert inserts a key-value pair into the appropriate shard.
-// Thread-safe: each shard has its own lock.
+// Thread-safe: each shard has its own lock. Like Btree.Insert, it panics
+// with a *SizeLimitError for an oversized entry.
 func (s *ShardedBTree) Insert(key Keytype, value Valuetype) {
-	shard := s.getShard(key)
-	shard.Insert(key, value)
+	if err := s.TryInsert(key, value); errors.Is(err, ErrTooLarge) {
+		panic(err)
+	}
+}
+
+// TryInsert is Insert reporting an oversized entry, and an invariant
+// violation in panic-free mode.
+func (s *ShardedBTree) TryInsert(key Keytype, value Valuetype) error {
+	_, err := s.Upsert(key, value)
+	return err
+}
+
+// Upsert inserts or overwrites key and reports whether it replaced a value.
+// An expired key counts as absent.
+func (s *ShardedBTree) Upsert(key Keytype, value Valuetype) (replaced bool, err error) {
+	return s.write(key, value, false)
+}
+
+// InsertNX inserts key only if it is absent, and returns ErrKeyExists
+// otherwise. An expired key counts as absent.
+func (s *ShardedBTree) InsertNX(key Keytype, value Valuetype) error {
+	_, err := s.write(key, value, true)
+	return err
+}
+
+// write inserts key, or overwrites it unless onlyNew is set.
+func (s *ShardedBTree) write(key Keytype, value Valuetype, onlyNew bool) (replaced bool, err error) {
+	s.pin()
+	idx := s.getShardIndex(key)
+	shard := s.shards[idx]
+	ts := s.ttlShard(idx)
+	start := s.startTimer(idx, LatencyInsert)
+	s.hotKeys(idx).touch(key)
+	ts.lock()
+	wasExpired := ts.expired(key, ttlNow())
+	var old Valuetype
+	var existed bool
+	if onlyNew && !wasExpired {
+		err = shard.InsertNX(key, value)
+	} else {
+		old, existed, err = shard.upsert(key, value)
+	}
+	if err == nil {
+		ts.forget(key)
+	}
+	ts.unlock()
+	s.observe(idx, LatencyInsert, start)
+	s.unpin()
+	if err != nil {
+		return false, err
+	}
 	atomic.AddUint64(&s.totalInserts, 1)
+	if wasExpired {
+		old, existed = nil, false
+	}
+	s.hooked().fireWrite(key, old, existed, value)
+	return existed, nil
 }
 
 // Put is an alias for Insert.
@@ -125,9 +365,39 @@ func (s *ShardedBTree) Put(key Keytype, value Valuetype) {
 // Returns the value and nil error if found, nil and error otherwise.
 // Thread-safe: uses read lock on the shard.
 func (s *ShardedBTree) Find(key Keytype) (Valuetype, error) {
-	shard := s.getShard(key)
+	return s.find(key, (*Btree).Find)
+}
+
+// FindInto is Find copying the value into dst. See Btree.FindInto.
+func (s *ShardedBTree) FindInto(key Keytype, dst []byte) ([]byte, error) {
+	return s.find(key, func(shard *Btree, key []byte) ([]byte, error) {
+		return shard.FindInto(key, dst)
+	})
+}
+
+// FindUnsafe is Find returning the stored value without copying it. See
+// Btree.FindUnsafe.
+func (s *ShardedBTree) FindUnsafe(key Keytype) ([]byte, error) {
+	return s.find(key, (*Btree).FindUnsafe)
+}
+
+// find runs lookup on key's shard, counting it and hiding an expired key.
+func (s *ShardedBTree) find(key Keytype, lookup func(shard *Btree, key []byte) ([]byte, error)) (Valuetype, error) {
+	s.pin()
+	defer s.unpin()
+	idx := s.getShardIndex(key)
 	atomic.AddUint64(&s.totalFinds, 1)
-	return shard.Find(key)
+	ts := s.ttlShard(idx)
+	start := s.startTimer(idx, LatencyFind)
+	s.hotKeys(idx).touch(key)
+	ts.rlock()
+	value, err := lookup(s.shards[idx], key)
+	if err == nil && ts.expired(key, ttlNow()) {
+		value, err = nil, errors.New("key not found")
+	}
+	ts.runlock()
+	s.observe(idx, LatencyFind, start)
+	return value, err
 }
 
 // Get is an alias for Find.
@@ -139,14 +409,152 @@ func (s *ShardedBTree) Get(key Keytype) (Valuetype, error) {
 // Returns true if the key was found and deleted, false otherwise.
 // Thread-safe: uses write lock on the shard.
 func (s *ShardedBTree) Delete(key Keytype) bool {
-	shard := s.getShard(key)
-	deleted := shard.Delete(key)
+	deleted, _ := s.TryDelete(key)
+	return deleted
+}
+
+// TryDelete is Delete reporting an invariant violation in panic-free mode.
+func (s *ShardedBTree) TryDelete(key Keytype) (bool, error) {
+	s.pin()
+	idx := s.getShardIndex(key)
+	ts := s.ttlShard(idx)
+	start := s.startTimer(idx, LatencyDelete)
+	s.hotKeys(idx).touch(key)
+	ts.lock()
+	wasExpired := ts.expired(key, ttlNow())
+	old, deleted, err := s.shards[idx].extract(key)
+	if deleted {
+		ts.forget(key)
+	}
+	ts.unlock()
+	s.observe(idx, LatencyDelete, start)
+	s.unpin()
+	if deleted {
+		atomic.AddUint64(&s.totalDeletes, 1)
+		s.hooked().fireDelete(key, old)
+	}
+	return deleted && !wasExpired, err
+}
+
+// Err returns the first invariant violation that failed a shard, or nil.
+func (s *ShardedBTree) Err() error {
+	s.pin()
+	defer s.unpin()
+	for _, shard := range s.shards {
+		if err := shard.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckInvariants verifies every shard's structure (see Btree.CheckInvariants)
+// and that each key sits in the shard it hashes to.
+func (s *ShardedBTree) CheckInvariants() error {
+	s.pin()
+	defer s.unpin()
+	for i, shard := range s.shards {
+		if err := shard.CheckInvariants(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		for key := range shard.All() {
+			if want := s.getShardIndex(key); want != i {
+				return fmt.Errorf("shard %d: %w", i, &InvariantError{
+					Op:     "CheckInvariants",
+					Key:    key,
+					Detail: fmt.Sprintf("key belongs in shard %d", want),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// CompareAndSwap sets key to newValue only if its current value equals oldValue.
+// Returns true if the swap happened.
+// Thread-safe: the comparison and write happen under the shard's write lock.
+func (s *ShardedBTree) CompareAndSwap(key Keytype, oldValue, newValue Valuetype) bool {
+	s.pin()
+	idx := s.getShardIndex(key)
+	ts := s.ttlShard(idx)
+	ts.lock()
+	swapped := !ts.expired(key, ttlNow()) && s.shards[idx].CompareAndSwap(key, oldValue, newValue)
+	if swapped {
+		ts.forget(key)
+	}
+	ts.unlock()
+	s.unpin()
+	if swapped {
+		atomic.AddUint64(&s.totalInserts, 1)
+		s.hooked().fireWrite(key, oldValue, true, newValue)
+	}
+	return swapped
+}
+
+// CompareAndDelete removes key only if its current value equals oldValue.
+// Returns true if the key was deleted.
+// Thread-safe: the comparison and delete happen under the shard's write lock.
+func (s *ShardedBTree) CompareAndDelete(key Keytype, oldValue Valuetype) bool {
+	s.pin()
+	idx := s.getShardIndex(key)
+	ts := s.ttlShard(idx)
+	ts.lock()
+	deleted := !ts.expired(key, ttlNow()) && s.shards[idx].CompareAndDelete(key, oldValue)
+	if deleted {
+		ts.forget(key)
+	}
+	ts.unlock()
+	s.unpin()
 	if deleted {
 		atomic.AddUint64(&s.totalDeletes, 1)
+		s.hooked().fireDelete(key, oldValue)
 	}
 	return deleted
 }
 
+// Modify performs an atomic read-modify-write of a single key in its shard.
+// See Btree.Modify for the callback contract.
+func (s *ShardedBTree) Modify(key Keytype, fn func(old Valuetype, exists bool) (Valuetype, bool)) bool {
+	s.pin()
+	idx := s.getShardIndex(key)
+	shard := s.shards[idx]
+	hooks := s.hooked()
+	ts := s.ttlShard(idx)
+	if hooks == nil && ts == nil {
+		written := shard.Modify(key, fn)
+		s.unpin()
+		if written {
+			atomic.AddUint64(&s.totalInserts, 1)
+		}
+		return written
+	}
+
+	// Hide an expired value from fn, and keep what fn saw and wrote for the hooks
+	ts.lock()
+	wasExpired := ts.expired(key, ttlNow())
+	var old, value Valuetype
+	var existed bool
+	written := shard.Modify(key, func(current Valuetype, exists bool) (Valuetype, bool) {
+		if wasExpired {
+			current, exists = nil, false
+		}
+		old, existed = current, exists
+		var ok bool
+		value, ok = fn(current, exists)
+		return value, ok
+	})
+	if written {
+		ts.forget(key)
+	}
+	ts.unlock()
+	s.unpin()
+	if written {
+		atomic.AddUint64(&s.totalInserts, 1)
+		hooks.fireWrite(key, old, existed, value)
+	}
+	return written
+}
+
 // keyValuePair holds a key-value pair for sorting.
 type keyValuePair struct {
 	key   Keytype
@@ -154,119 +562,219 @@ type keyValuePair struct {
 }
 
 // GetRange returns all key-value pairs in the range [startKey, endKey].
-// Queries all shards in parallel and merges results.
-// Thread-safe: each shard uses its own read lock.
+// Shards are merged through cursors as GetRangeLimit merges them, so only a
+// batch per shard is held besides the result, and a Resize or Rebalance
+// waits for the merge rather than moving keys under it.
+// Thread-safe: each batch is copied under its shard's read latches.
 func (s *ShardedBTree) GetRange(startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
+	return s.GetRangeCtx(context.Background(), startKey, endKey)
+}
+
+// GetRangeCtx is GetRange stopping with ctx's error once ctx is done.
+// ctx is checked every ctxCheckInterval keys.
+func (s *ShardedBTree) GetRangeCtx(ctx context.Context, startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
 	if bytes.Compare(startKey, endKey) > 0 {
 		return nil, nil, errors.New("invalid range: startKey is greater than endKey")
 	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	// Query all shards in parallel
-	type shardResult struct {
-		keys   []Keytype
-		values []Valuetype
-		err    error
+	var keys []Keytype
+	var values []Valuetype
+	s.pin()
+	defer s.unpin()
+	mergeCursors(s.timedRangeCursors(startKey, endKey, LatencyGetRange, rangeBatchSize), func(key Keytype, value Valuetype) bool {
+		keys, values = append(keys, key), append(values, value)
+		return len(keys)%ctxCheckInterval != 0 || ctx.Err() == nil
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
+	return keys, values, nil
+}
 
-	results := make([]shardResult, len(s.shards))
-	var wg sync.WaitGroup
+// KeysInRange returns the keys in the range [startKey, endKey] in ascending
+// order. Shards are merged as GetRangeLimit merges them.
+func (s *ShardedBTree) KeysInRange(startKey, endKey []byte) ([]Keytype, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
 
-	for i, shard := range s.shards {
-		wg.Add(1)
-		go func(idx int, sh *Btree) {
-			defer wg.Done()
-			keys, values, err := sh.GetRange(startKey, endKey)
-			results[idx] = shardResult{keys: keys, values: values, err: err}
-		}(i, shard)
+	var keys []Keytype
+	s.pin()
+	defer s.unpin()
+	mergeCursors(s.pinnedRangeCursors(startKey, endKey, true, rangeBatchSize), func(key Keytype, _ Valuetype) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, nil
+}
+
+// ValuesInRange returns the values of the keys in the range [startKey,
+// endKey], in ascending key order. Shards are merged as GetRangeLimit
+// merges them.
+func (s *ShardedBTree) ValuesInRange(startKey, endKey []byte) ([]Valuetype, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
 	}
 
-	wg.Wait()
+	var values []Valuetype
+	s.pin()
+	defer s.unpin()
+	mergeCursors(s.pinnedRangeCursors(startKey, endKey, true, rangeBatchSize), func(_ Keytype, value Valuetype) bool {
+		values = append(values, value)
+		return true
+	})
+	return values, nil
+}
 
-	// Check for errors and collect results
-	var pairs []keyValuePair
+// DeleteRange deletes all keys in the range [startKey, endKey].
+// Returns the number of live keys deleted, not counting expired ones.
+// Atomic per shard: each shard is emptied in its own goroutine holding only
+// that shard's treeLock (and TTL lock) exclusively, so no insert lands in
+// its part of the range meanwhile and the other shards stay available. A
+// reader may see the range gone from some shards and not yet from others.
+// Only the shard an affinity-prefixed range maps to (see AffinityDelimiter)
+// is touched.
+func (s *ShardedBTree) DeleteRange(startKey, endKey []byte) (int, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return 0, errors.New("invalid range: startKey is greater than endKey")
+	}
+
+	hooks := s.hooked()
+	s.pin()
+	results := s.deleteRangeLocked(startKey, endKey, hooks != nil)
+	s.unpin()
+
+	var deletedCount, liveCount int
+	var err error
 	for _, r := range results {
-		if r.err != nil {
-			return nil, nil, r.err
+		deletedCount += r.deleted
+		liveCount += r.deleted - r.expired
+		if err == nil {
+			err = r.err
 		}
-		for i := range r.keys {
-			pairs = append(pairs, keyValuePair{key: r.keys[i], value: r.values[i]})
+	}
+	atomic.AddUint64(&s.totalDeletes, uint64(deletedCount))
+	for _, r := range results {
+		for _, pair := range r.pairs {
+			hooks.fireDelete(pair.key, pair.value)
 		}
 	}
+	return liveCount, err
+}
 
-	// Sort by key for consistent ordering
-	sort.Slice(pairs, func(i, j int) bool {
-		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
-	})
+// rangeDeletion is what deleteRangeLocked removed from one shard.
+type rangeDeletion struct {
+	deleted int            // Keys removed
+	expired int            // Of which already expired
+	pairs   []keyValuePair // Removed pairs, if collected
+	err     error
+}
 
-	// Extract sorted keys and values
-	keys := make([]Keytype, len(pairs))
-	values := make([]Valuetype, len(pairs))
-	for i, p := range pairs {
-		keys[i] = p.key
-		values[i] = p.value
-	}
+// deleteRangeLocked runs DeleteRange on every shard that may hold the range,
+// in parallel, each under its own treeLock and TTL lock. Collects the
+// removed pairs if collect is set. Called pinned.
+func (s *ShardedBTree) deleteRangeLocked(startKey, endKey []byte, collect bool) []rangeDeletion {
+	results := make([]rangeDeletion, len(s.shards))
+	only := s.rangeShard(startKey, endKey)
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		if only >= 0 && i != only {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, sh *Btree) {
+			defer wg.Done()
+			r := &results[idx]
+			ts := s.ttlShard(idx)
+			ts.lock()
+			defer ts.unlock()
+			sh.treeLock.Lock()
+			defer sh.treeLock.Unlock()
 
-	return keys, values, nil
+			cutoff := ttlNow()
+			r.deleted, r.err = sh.deleteRangeLocked(startKey, endKey, func(key Keytype, value Valuetype) {
+				if ts.expired(key, cutoff) {
+					r.expired++
+				}
+				ts.forget(key)
+				if collect {
+					r.pairs = append(r.pairs, keyValuePair{key: key, value: value})
+				}
+			})
+		}(i, shard)
+	}
+	wg.Wait()
+	return results
 }
 
-// DeleteRange deletes all keys in the range [startKey, endKey].
-// Returns the number of keys deleted.
-// Thread-safe: queries then deletes (not atomic across the range).
-func (s *ShardedBTree) DeleteRange(startKey, endKey []byte) (int, error) {
-	keys, _, err := s.GetRange(startKey, endKey)
-	if err != nil {
-		return 0, err
+// lockShards takes every shard's TTL lock and then its treeLock exclusively,
+// in shard order, stopping every operation on the tree. Called pinned.
+func (s *ShardedBTree) lockShards() {
+	for _, ts := range s.ttl {
+		ts.lock()
+	}
+	for _, shard := range s.shards {
+		shard.treeLock.Lock()
 	}
+}
 
-	deletedCount := 0
-	for _, key := range keys {
-		if s.Delete(key) {
-			deletedCount++
-		}
+// unlockShards releases lockShards.
+func (s *ShardedBTree) unlockShards() {
+	for _, shard := range s.shards {
+		shard.treeLock.Unlock()
+	}
+	for _, ts := range s.ttl {
+		ts.unlock()
 	}
-	return deletedCount, nil
 }
 
 // Count returns the total number of keys across all shards.
-// Note: This is an O(n) operation that traverses all trees.
+// O(shards): sums each shard's maintained key counter.
 func (s *ShardedBTree) Count() int64 {
+	s.pin()
+	defer s.unpin()
 	var total int64
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
 	for _, shard := range s.shards {
-		wg.Add(1)
-		go func(sh *Btree) {
-			defer wg.Done()
-			count := sh.countKeys()
-			mu.Lock()
-			total += count
-			mu.Unlock()
-		}(shard)
+		total += shard.Len()
 	}
-
-	wg.Wait()
 	return total
 }
 
-// countKeys counts keys in a single B-Tree.
+// countKeys counts keys in a single B-Tree by traversal.
 func (t *Btree) countKeys() int64 {
 	t.treeLock.RLock()
 	defer t.treeLock.RUnlock()
 
-	if t.root == nil {
+	root := t.rlockRoot()
+	if root == nil {
 		return 0
 	}
-	return t.root.countKeys()
+	defer root.mu.RUnlock()
+	return root.countKeys()
 }
 
 // countKeys counts keys in a node and its children recursively.
+// The caller holds n read-latched; children are latched on the way down.
 func (n *Node) countKeys() int64 {
 	count := int64(len(n.keys))
 	if !n.isleaf {
 		for _, child := range n.children {
 			if child != nil {
+				child.mu.RLock()
 				count += child.countKeys()
+				child.mu.RUnlock()
 			}
 		}
 	}
@@ -274,7 +782,15 @@ func (n *Node) countKeys() int64 {
 }
 
 // Stats returns statistics about shard distribution.
-func (s *ShardedBTree) Stats() ShardStats {
+//
+// With fast set, key counts and the tree shape come from counters kept up
+// to date by every write, in O(shards); the shape then has only Height,
+// Nodes, Keys and FillFactor. Otherwise every shard is walked in parallel
+// for the full shape, in O(n). VerifyStats checks the counters against a
+// walk.
+func (s *ShardedBTree) Stats(fast bool) ShardStats {
+	s.pin()
+	defer s.unpin()
 	stats := ShardStats{
 		NumShards:    len(s.shards),
 		KeysPerShard: make([]int64, len(s.shards)),
@@ -283,16 +799,32 @@ func (s *ShardedBTree) Stats() ShardStats {
 		TotalFinds:   atomic.LoadUint64(&s.totalFinds),
 	}
 
-	// Count keys per shard in parallel
-	var wg sync.WaitGroup
-	for i, shard := range s.shards {
-		wg.Add(1)
-		go func(idx int, sh *Btree) {
-			defer wg.Done()
-			stats.KeysPerShard[idx] = sh.countKeys()
-		}(i, shard)
+	shapes := make([]TreeStats, len(s.shards))
+	if fast {
+		for i, shard := range s.shards {
+			shapes[i] = shard.countedStats()
+			stats.KeysPerShard[i] = shapes[i].Keys
+		}
+	} else {
+		// Walk shards in parallel
+		var wg sync.WaitGroup
+		for i, shard := range s.shards {
+			wg.Add(1)
+			go func(idx int, sh *Btree) {
+				defer wg.Done()
+				shapes[idx] = sh.TreeStats()
+				stats.KeysPerShard[idx] = shapes[idx].Keys
+			}(i, shard)
+		}
+		wg.Wait()
+	}
+	for _, shape := range shapes {
+		stats.Tree.merge(shape)
+	}
+	stats.Shards = make([]ShardActivity, len(s.shards))
+	for i := range stats.Shards {
+		stats.Shards[i] = s.activity(i)
 	}
-	wg.Wait()
 
 	// Calculate statistics
 	stats.MinShardKeys = stats.KeysPerShard[0]
@@ -341,82 +873,272 @@ func sqrt(x float64) float64 {
 
 // NumShards returns the number of shards.
 func (s *ShardedBTree) NumShards() int {
+	s.pin()
+	defer s.unpin()
 	return int(s.numShards)
 }
 
 // GetShard returns a specific shard by index (for testing/debugging).
 func (s *ShardedBTree) GetShard(index int) *Btree {
+	s.pin()
+	defer s.unpin()
 	if index < 0 || index >= len(s.shards) {
 		return nil
 	}
 	return s.shards[index]
 }
 
+// BulkError reports the pairs a BulkInsert could not insert; the others
+// were inserted. errors.Is and errors.As see through it to each pair's error.
+type BulkError struct {
+	Errs   []error // Errs[i] is the error of pair i, nil if it was inserted
+	Failed int     // Number of non-nil Errs
+}
+
+func (e *BulkError) Error() string {
+	for i, err := range e.Errs {
+		if err != nil {
+			return fmt.Sprintf("bulk insert: %d of %d pairs failed, first pair %d: %v", e.Failed, len(e.Errs), i, err)
+		}
+	}
+	return "bulk insert: no pairs failed"
+}
+
+func (e *BulkError) Unwrap() []error {
+	errs := make([]error, 0, e.Failed)
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
 // BulkInsert inserts multiple key-value pairs efficiently.
-// Groups keys by shard to minimize lock acquisition overhead.
+// Groups keys by shard to minimize lock acquisition overhead. A group that
+// is strictly ascending and bound for an empty shard is bulk loaded.
+//
+// A pair that fails (an oversized entry, say) does not stop the others: the
+// result is then a *BulkError giving each pair's outcome. After an invariant
+// violation in panic-free mode, the rest of that shard's pairs fail with it.
 func (s *ShardedBTree) BulkInsert(keys []Keytype, values []Valuetype) error {
 	if len(keys) != len(values) {
 		return errors.New("keys and values must have the same length")
 	}
 
 	// Group by shard
+	s.pin()
 	shardGroups := make(map[int][]int) // shard index -> key indices
 	for i, key := range keys {
 		shardIdx := s.getShardIndex(key)
 		shardGroups[shardIdx] = append(shardGroups[shardIdx], i)
 	}
 
-	// Insert into each shard in parallel
+	// Insert into each shard in parallel, keeping the hook events to fire
+	// once unpinned
+	hooks := s.hooked()
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(s.shards))
+	errs := make([]error, len(keys))
+	var failed atomic.Int64
+	fail := func(keyIdx int, err error) {
+		errs[keyIdx] = err
+		failed.Add(1)
+	}
+	events := make([][]writeEvent, len(s.shards))
 
 	for shardIdx, keyIndices := range shardGroups {
 		wg.Add(1)
 		go func(idx int, indices []int) {
 			defer wg.Done()
+			if s.metrics != nil {
+				s.metrics[idx].batchSizes.Record(uint64(len(indices)))
+			}
 			shard := s.shards[idx]
-			for _, keyIdx := range indices {
-				shard.Insert(keys[keyIdx], values[keyIdx])
+			ts := s.ttlShard(idx)
+			if shard.Len() == 0 && ascending(keys, indices) {
+				groupKeys := make([]Keytype, len(indices))
+				groupValues := make([]Valuetype, len(indices))
+				for i, keyIdx := range indices {
+					groupKeys[i], groupValues[i] = keys[keyIdx], values[keyIdx]
+				}
+				ts.lock()
+				err := shard.BulkLoad(groupKeys, groupValues)
+				if err == nil && ts != nil {
+					clear(ts.expires)
+				}
+				ts.unlock()
+				if err == nil {
+					atomic.AddUint64(&s.totalInserts, uint64(len(indices)))
+					if hooks != nil {
+						for i := range groupKeys {
+							events[idx] = append(events[idx], writeEvent{key: groupKeys[i], value: groupValues[i]})
+						}
+					}
+					return
+				}
+				if errors.Is(err, ErrInvariant) {
+					for _, keyIdx := range indices {
+						fail(keyIdx, err)
+					}
+					return
+				}
+				// A concurrent writer got there first, or some pair is
+				// invalid: insert one by one
+			}
+			for i, keyIdx := range indices {
+				key := keys[keyIdx]
+				ts.lock()
+				wasExpired := ts.expired(key, ttlNow())
+				old, existed, err := shard.upsert(key, values[keyIdx])
+				if err == nil {
+					ts.forget(key)
+				}
+				ts.unlock()
+				if errors.Is(err, ErrInvariant) {
+					for _, keyIdx := range indices[i:] {
+						fail(keyIdx, err)
+					}
+					return
+				}
+				if err != nil {
+					fail(keyIdx, err)
+					continue
+				}
 				atomic.AddUint64(&s.totalInserts, 1)
+				if wasExpired {
+					old, existed = nil, false
+				}
+				if hooks != nil {
+					events[idx] = append(events[idx], writeEvent{key: key, old: old, existed: existed, value: values[keyIdx]})
+				}
 			}
 		}(shardIdx, keyIndices)
 	}
 
 	wg.Wait()
-	close(errChan)
-
-	// Check for errors
-	for err := range errChan {
-		if err != nil {
-			return err
+	s.unpin()
+	for _, shardEvents := range events {
+		for _, e := range shardEvents {
+			hooks.fireWrite(e.key, e.old, e.existed, e.value)
 		}
 	}
 
+	if n := failed.Load(); n > 0 {
+		return &BulkError{Errs: errs, Failed: int(n)}
+	}
 	return nil
 }
 
+// writeEvent is a write whose hooks BulkInsert fires after the fact.
+type writeEvent struct {
+	key        Keytype
+	old, value Valuetype
+	existed    bool
+}
+
+// ascending reports whether keys[indices[0]], keys[indices[1]], ... are
+// strictly ascending.
+func ascending(keys []Keytype, indices []int) bool {
+	for i := 1; i < len(indices); i++ {
+		if bytes.Compare(keys[indices[i-1]], keys[indices[i]]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // ForEach iterates over all key-value pairs in the tree.
 // The callback is called for each key-value pair.
 // Order is not guaranteed (depends on shard iteration order).
-// Thread-safe: each shard is locked during iteration.
+// Thread-safe: each shard is read-latched during iteration.
 func (s *ShardedBTree) ForEach(callback func(key Keytype, value Valuetype) bool) {
-	for _, shard := range s.shards {
-		shard.treeLock.RLock()
-		if shard.root != nil {
-			if !shard.root.forEach(callback) {
-				shard.treeLock.RUnlock()
-				return
-			}
+	s.pin()
+	defer s.unpin()
+	for idx, shard := range s.shards {
+		ts := s.ttlShard(idx)
+		ts.rlock()
+		cutoff := ttlNow()
+		more := shard.forEach(func(key Keytype, value Valuetype) bool {
+			return ts.expired(key, cutoff) || callback(key, value)
+		})
+		ts.runlock()
+		if !more {
+			return
 		}
-		shard.treeLock.RUnlock()
 	}
 }
 
+// ForEachParallel is ForEach walking up to concurrency shards at once, for
+// full-table work such as index rebuilds. A concurrency of 0 or less uses
+// GOMAXPROCS workers.
+//
+// callback is called from several goroutines at once and must be safe for
+// that. Returning false stops every worker, but pairs already being visited
+// on other shards may still be passed to callback. Like ForEach, callback
+// must not write to the tree.
+func (s *ShardedBTree) ForEachParallel(callback func(key Keytype, value Valuetype) bool, concurrency int) {
+	s.pin()
+	defer s.unpin()
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	concurrency = min(concurrency, len(s.shards))
+
+	var next atomic.Int64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				idx := int(next.Add(1) - 1)
+				if idx >= len(s.shards) {
+					return
+				}
+				ts := s.ttlShard(idx)
+				ts.rlock()
+				cutoff := ttlNow()
+				more := s.shards[idx].forEach(func(key Keytype, value Valuetype) bool {
+					if stop.Load() {
+						return false
+					}
+					if ts.expired(key, cutoff) || callback(key, value) {
+						return true
+					}
+					stop.Store(true)
+					return false
+				})
+				ts.runlock()
+				if !more {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// forEach iterates over all key-value pairs in the tree.
+// Returns false if the callback stopped the iteration.
+func (t *Btree) forEach(callback func(key Keytype, value Valuetype) bool) bool {
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	root := t.rlockRoot()
+	if root == nil {
+		return true
+	}
+	defer root.mu.RUnlock()
+	return root.forEach(callback)
+}
+
 // forEach iterates over all key-value pairs in a node.
+// The caller holds n read-latched; children are latched on the way down.
 func (n *Node) forEach(callback func(key Keytype, value Valuetype) bool) bool {
 	if n.isleaf {
 		for i := range n.keys {
-			if !callback(n.keys[i], n.values[i]) {
+			if !callback(n.key(i), n.values[i]) {
 				return false
 			}
 		}
@@ -425,13 +1147,16 @@ func (n *Node) forEach(callback func(key Keytype, value Valuetype) bool) bool {
 
 	// Internal node: traverse children and keys
 	for i := 0; i < len(n.children); i++ {
-		if n.children[i] != nil {
-			if !n.children[i].forEach(callback) {
+		if child := n.children[i]; child != nil {
+			child.mu.RLock()
+			more := child.forEach(callback)
+			child.mu.RUnlock()
+			if !more {
 				return false
 			}
 		}
 		if i < len(n.keys) {
-			if !callback(n.keys[i], n.values[i]) {
+			if !callback(n.key(i), n.values[i]) {
 				return false
 			}
 		}
@@ -439,11 +1164,44 @@ func (n *Node) forEach(callback func(key Keytype, value Valuetype) bool) bool {
 	return true
 }
 
-// Clear removes all data from all shards.
+// All returns an iterator over all key-value pairs, shard by shard.
+// Like ForEach, order is not guaranteed; unlike ForEach, no shard lock is
+// held while the loop body runs, so during a Resize keys that migrate may be
+// missed or repeated.
+func (s *ShardedBTree) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		s.pin()
+		shards := s.shards
+		s.unpin()
+		for _, shard := range shards {
+			for k, v := range shard.All() {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Clear removes all data from all shards, recycling their nodes.
+//
+// Atomic: every shard is locked (see lockShards) before any is cleared,
+// so a single-key operation either completes before Clear, which erases its
+// effect, or starts after it and survives; no reader sees some shards
+// cleared and others not. Operations spanning shards (BulkInsert,
+// MultiDelete, ...) may straddle it, and their hooks may fire after it.
+// Clear does not fire delete hooks.
 func (s *ShardedBTree) Clear() {
-	for i := range s.shards {
-		s.shards[i] = &Btree{}
+	s.pin()
+	defer s.unpin()
+	s.lockShards()
+	for _, shard := range s.shards {
+		shard.clearLocked()
+	}
+	for _, ts := range s.ttl {
+		clear(ts.expires)
 	}
+	s.unlockShards()
 	atomic.StoreUint64(&s.totalInserts, 0)
 	atomic.StoreUint64(&s.totalDeletes, 0)
 	atomic.StoreUint64(&s.totalFinds, 0)
@@ -2,6 +2,7 @@ package bptree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sort"
@@ -138,6 +139,55 @@ func TestShardedBTreeDelete(t *testing.T) {
 	}
 }
 
+func TestShardedBTreeCompareAndSwap(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	tree.Insert(Keytype("k"), Valuetype("old"))
+
+	if tree.CompareAndSwap(Keytype("k"), Valuetype("other"), Valuetype("new")) {
+		t.Error("CompareAndSwap should fail on mismatched value")
+	}
+	if !tree.CompareAndSwap(Keytype("k"), Valuetype("old"), Valuetype("new")) {
+		t.Error("CompareAndSwap should succeed on matching value")
+	}
+	if v, _ := tree.Find(Keytype("k")); string(v) != "new" {
+		t.Errorf("Find returned %q, want %q", v, "new")
+	}
+
+	if tree.CompareAndDelete(Keytype("k"), Valuetype("old")) {
+		t.Error("CompareAndDelete should fail on stale value")
+	}
+	if !tree.CompareAndDelete(Keytype("k"), Valuetype("new")) {
+		t.Error("CompareAndDelete should succeed on matching value")
+	}
+	if _, err := tree.Find(Keytype("k")); err == nil {
+		t.Error("Key should be gone after CompareAndDelete")
+	}
+}
+
+func TestShardedBTreeIterators(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 100; i++ {
+		key := Keytype(fmt.Sprintf("key%03d", i))
+		tree.Insert(key, Valuetype(key))
+	}
+
+	seen := make(map[string]bool)
+	for k := range tree.All() {
+		seen[string(k)] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("All yielded %d distinct keys, want 100", len(seen))
+	}
+
+	var got []string
+	for k := range tree.Range([]byte("key010"), []byte("key019")) {
+		got = append(got, string(k))
+	}
+	if len(got) != 10 || !sort.StringsAreSorted(got) {
+		t.Errorf("Range yielded %v, want 10 sorted keys", got)
+	}
+}
+
 func TestShardedBTreeGetRange(t *testing.T) {
 	tree := NewShardedBTree(ShardConfig{NumShards: 4})
 
@@ -187,6 +237,48 @@ func TestShardedBTreeGetRange(t *testing.T) {
 	}
 }
 
+func TestKeysAndValuesInRange(t *testing.T) {
+	tree := &Btree{}
+	sharded := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 50; i++ {
+		key, value := Keytype(fmt.Sprintf("k%02d", i)), Valuetype(fmt.Sprintf("v%02d", i))
+		tree.Insert(key, value)
+		sharded.Insert(key, value)
+	}
+
+	keys, err := tree.KeysInRange([]byte("k10"), []byte("k19"))
+	if err != nil || len(keys) != 10 || string(keys[0]) != "k10" || string(keys[9]) != "k19" {
+		t.Errorf("Btree.KeysInRange = %q, %v", keys, err)
+	}
+	values, err := tree.ValuesInRange([]byte("k10"), []byte("k19"))
+	if err != nil || len(values) != 10 || string(values[0]) != "v10" || string(values[9]) != "v19" {
+		t.Errorf("Btree.ValuesInRange = %q, %v", values, err)
+	}
+
+	keys, err = sharded.KeysInRange([]byte("k10"), []byte("k19"))
+	if err != nil || len(keys) != 10 {
+		t.Fatalf("ShardedBTree.KeysInRange = %q, %v", keys, err)
+	}
+	for i, key := range keys {
+		if string(key) != fmt.Sprintf("k%02d", 10+i) {
+			t.Errorf("ShardedBTree.KeysInRange[%d] = %q", i, key)
+		}
+	}
+	values, err = sharded.ValuesInRange([]byte("k10"), []byte("k19"))
+	if err != nil || len(values) != 10 {
+		t.Fatalf("ShardedBTree.ValuesInRange = %q, %v", values, err)
+	}
+	for i, value := range values {
+		if string(value) != fmt.Sprintf("v%02d", 10+i) {
+			t.Errorf("ShardedBTree.ValuesInRange[%d] = %q, want key order", i, value)
+		}
+	}
+
+	if _, err := sharded.KeysInRange([]byte("z"), []byte("a")); err == nil {
+		t.Error("KeysInRange accepted an inverted range")
+	}
+}
+
 func TestShardedBTreeGetRangeInvalidRange(t *testing.T) {
 	tree := NewShardedBTree(ShardConfig{NumShards: 4})
 
@@ -239,6 +331,48 @@ func TestShardedBTreeDeleteRange(t *testing.T) {
 	}
 }
 
+func TestShardedBTreeClearIsAtomic(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	const n = 500
+	fill := func() {
+		for i := 0; i < n; i++ {
+			tree.Insert(Keytype(fmt.Sprintf("key-%03d", i)), Valuetype("v"))
+		}
+	}
+	fill()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			tree.Clear()
+		}
+	}()
+
+	// A snapshot locks every shard like Clear does, so it must see either
+	// none of the shards cleared or all of them.
+	for i := 0; i < 200; i++ {
+		snap := tree.Snapshot()
+		if got := snap.Len(); got != 0 && got != n {
+			t.Fatalf("snapshot saw %d keys mid-Clear, want 0 or %d", got, n)
+		}
+		snap.Release()
+	}
+	wg.Wait()
+
+	if tree.Count() != 0 {
+		t.Errorf("Count after Clear = %d", tree.Count())
+	}
+	if err := tree.VerifyStats(); err != nil {
+		t.Errorf("VerifyStats after Clear: %v", err)
+	}
+	fill()
+	if tree.Count() != n {
+		t.Errorf("Count after refill = %d, want %d", tree.Count(), n)
+	}
+}
+
 // ============================================================================
 // Shard Distribution Tests
 // ============================================================================
@@ -253,7 +387,7 @@ func TestShardDistribution(t *testing.T) {
 		tree.Insert(Keytype(key), Valuetype(fmt.Sprintf("val-%d", i)))
 	}
 
-	stats := tree.Stats()
+	stats := tree.Stats(false)
 
 	// Check total keys
 	if stats.TotalKeys != int64(numKeys) {
@@ -336,7 +470,7 @@ func TestShardedBTreeStats(t *testing.T) {
 		tree.Delete(Keytype(fmt.Sprintf("key-%d", i)))
 	}
 
-	stats := tree.Stats()
+	stats := tree.Stats(false)
 
 	if stats.TotalInserts != 100 {
 		t.Errorf("TotalInserts = %d, want 100", stats.TotalInserts)
@@ -386,6 +520,32 @@ func TestShardedBTreeCount(t *testing.T) {
 // Bulk Operations Tests
 // ============================================================================
 
+func TestShardedBTreeCountMatchesTraversal(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 500; i++ {
+		tree.Insert(Keytype(fmt.Sprintf("key%04d", i)), Valuetype("v"))
+	}
+	for i := 0; i < 500; i += 2 {
+		tree.Insert(Keytype(fmt.Sprintf("key%04d", i)), Valuetype("updated"))
+	}
+	for i := 0; i < 500; i += 5 {
+		tree.Delete(Keytype(fmt.Sprintf("key%04d", i)))
+	}
+
+	var walked int64
+	for _, shard := range tree.shards {
+		walked += shard.countKeys()
+	}
+	if tree.Count() != walked || walked != 400 {
+		t.Errorf("Count() = %d, traversal = %d, want 400", tree.Count(), walked)
+	}
+
+	tree.Clear()
+	if tree.Count() != 0 {
+		t.Errorf("Count() = %d after Clear, want 0", tree.Count())
+	}
+}
+
 func TestShardedBTreeBulkInsert(t *testing.T) {
 	tree := NewShardedBTree(ShardConfig{NumShards: 8})
 
@@ -432,6 +592,45 @@ func TestShardedBTreeBulkInsertMismatchedLengths(t *testing.T) {
 	}
 }
 
+func TestShardedBTreeBulkInsertPerKeyErrors(t *testing.T) {
+	for _, sorted := range []bool{true, false} {
+		tree := NewShardedBTree(ShardConfig{NumShards: 4, MaxValueSize: 4})
+		var keys []Keytype
+		var values []Valuetype
+		for i := 0; i < 100; i++ {
+			value := Valuetype("ok")
+			if i%10 == 3 {
+				value = Valuetype("too long")
+			}
+			keys = append(keys, Keytype(fmt.Sprintf("key-%03d", i)))
+			values = append(values, value)
+		}
+		if !sorted {
+			keys[0], keys[99] = keys[99], keys[0]
+			values[0], values[99] = values[99], values[0]
+		}
+
+		// Oversized pairs fail alone; the rest, including bulk-loadable groups, go in
+		err := tree.BulkInsert(keys, values)
+		var bulkErr *BulkError
+		if !errors.As(err, &bulkErr) || bulkErr.Failed != 10 || len(bulkErr.Errs) != 100 {
+			t.Fatalf("sorted %v: BulkInsert = %v, want a BulkError with 10 failures", sorted, err)
+		}
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("sorted %v: BulkError does not unwrap to ErrTooLarge", sorted)
+		}
+		for i, key := range keys {
+			_, findErr := tree.Find(key)
+			if failed := bulkErr.Errs[i] != nil; failed != (len(values[i]) > 4) || failed != (findErr != nil) {
+				t.Errorf("sorted %v: %s: pair error %v, Find error %v", sorted, key, bulkErr.Errs[i], findErr)
+			}
+		}
+		if tree.Count() != 90 {
+			t.Errorf("sorted %v: Count = %d, want 90", sorted, tree.Count())
+		}
+	}
+}
+
 // ============================================================================
 // ForEach and Clear Tests
 // ============================================================================
@@ -482,6 +681,41 @@ func TestShardedBTreeForEachEarlyTermination(t *testing.T) {
 	}
 }
 
+func TestShardedBTreeForEachParallel(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8})
+	for i := 0; i < 1000; i++ {
+		tree.Insert(Keytype(fmt.Sprintf("key-%d", i)), Valuetype(fmt.Sprintf("val-%d", i)))
+	}
+
+	for _, concurrency := range []int{0, 1, 3, 16} {
+		var mu sync.Mutex
+		visited := make(map[string]string)
+		tree.ForEachParallel(func(key Keytype, value Valuetype) bool {
+			mu.Lock()
+			visited[string(key)] = string(value)
+			mu.Unlock()
+			return true
+		}, concurrency)
+		if len(visited) != 1000 {
+			t.Fatalf("concurrency %d: visited %d keys, want 1000", concurrency, len(visited))
+		}
+		for k, v := range visited {
+			if v != "val-"+k[len("key-"):] {
+				t.Fatalf("concurrency %d: key %q has value %q", concurrency, k, v)
+			}
+		}
+	}
+
+	// Stopping one worker stops them all, give or take pairs already in flight
+	var count atomic.Int64
+	tree.ForEachParallel(func(Keytype, Valuetype) bool {
+		return count.Add(1) < 10
+	}, 4)
+	if n := count.Load(); n < 10 || n > 20 {
+		t.Errorf("ForEachParallel visited %d keys after being stopped at 10", n)
+	}
+}
+
 func TestShardedBTreeClear(t *testing.T) {
 	tree := NewShardedBTree(ShardConfig{NumShards: 4})
 
@@ -499,7 +733,7 @@ func TestShardedBTreeClear(t *testing.T) {
 		t.Errorf("After clear: Count() = %d, want 0", tree.Count())
 	}
 
-	stats := tree.Stats()
+	stats := tree.Stats(false)
 	if stats.TotalInserts != 0 || stats.TotalDeletes != 0 || stats.TotalFinds != 0 {
 		t.Error("Stats should be reset after Clear()")
 	}
@@ -621,7 +855,7 @@ func TestShardedBTreeConcurrentMixed(t *testing.T) {
 	count := tree.Count()
 	t.Logf("Final count: %d", count)
 
-	stats := tree.Stats()
+	stats := tree.Stats(false)
 	t.Logf("Stats: inserts=%d, finds=%d, deletes=%d",
 		stats.TotalInserts, stats.TotalFinds, stats.TotalDeletes)
 }
@@ -752,7 +986,7 @@ func TestShardedBTreeSingleShard(t *testing.T) {
 		t.Errorf("Count() = %d, want 100", tree.Count())
 	}
 
-	stats := tree.Stats()
+	stats := tree.Stats(false)
 	if stats.KeysPerShard[0] != 100 {
 		t.Errorf("Single shard should have all keys: got %d", stats.KeysPerShard[0])
 	}
@@ -880,3 +1114,75 @@ func TestShardedBTreeRangeQueryOrdering(t *testing.T) {
 		t.Error("GetRange results should be sorted")
 	}
 }
+
+func TestShardedBTreeDeleteRangeAtomic(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 2000; i++ {
+		tree.Insert(Keytype(fmt.Sprintf("key-%04d", i)), Valuetype("v"))
+	}
+
+	// Writers keep inserting into the range; whatever they insert after
+	// DeleteRange returns survives, but nothing is half-deleted
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tree.Insert(Keytype(fmt.Sprintf("key-%04d-%d", i%2000, w)), Valuetype("new"))
+			}
+		}(w)
+	}
+
+	deleted, err := tree.DeleteRange([]byte("key-0000"), []byte("key-1999~"))
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted < 2000 {
+		t.Errorf("DeleteRange deleted %d keys, want at least 2000", deleted)
+	}
+	for i := 0; i < 2000; i++ {
+		if _, err := tree.Find(Keytype(fmt.Sprintf("key-%04d", i))); err == nil {
+			t.Fatalf("key-%04d survived DeleteRange", i)
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedBTreeDeleteRangeCountsLiveKeys(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8, EnableTTL: true})
+	for i := 0; i < 1000; i++ {
+		key := Keytype(fmt.Sprintf("key-%04d", i))
+		if i%10 == 0 {
+			tree.InsertWithTTL(key, Valuetype("v"), time.Nanosecond)
+		} else {
+			tree.Insert(key, Valuetype("v"))
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	// 500 keys in range, of which 50 have expired
+	deleted, err := tree.DeleteRange([]byte("key-0250"), []byte("key-0749"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 450 {
+		t.Errorf("DeleteRange = %d, want 450 live keys", deleted)
+	}
+	if n := tree.Count(); n != 500 {
+		t.Errorf("Count = %d, want 500 outside the range", n)
+	}
+	if err := tree.VerifyStats(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,183 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+)
+
+// ShardedSnapshot is a read-only, point-in-time view of a whole
+// ShardedBTree, for backups that must not interleave with writes.
+//
+// DESIGN:
+// - Taking it pauses every writer briefly: all shard treeLocks are held while each shard takes an O(1) Snapshot
+// - Shards then diverge copy-on-write, as for Btree.Snapshot
+// - Expiry times are copied and keys expired at the pause stay hidden
+// - Placement is captured too, so a later Resize does not misroute Find
+//
+// LIMITATIONS:
+// - Each shard keeps saved node copies until Release, as for Btree.Snapshot
+// - Copying expiry times costs O(keys with a TTL) during the pause
+//
+// USAGE:
+//
+//	snap := tree.Snapshot()
+//	defer snap.Release()
+//	snap.ForEach(func(key Keytype, value Valuetype) bool {
+//		backup.Write(key, value) // Concurrent writes to tree are not observed
+//		return true
+//	})
+type ShardedSnapshot struct {
+	shards  []*Snapshot
+	expires []map[string]int64 // Per-shard expiry times, nil without TTL
+	cutoff  int64              // Keys expiring at or before it are hidden
+
+	numShards uint32
+	hash      HashFunc
+	place     placement // Placement of a resizable tree, else nil
+	migrating bool      // A resize was running: keys may be on either shard
+}
+
+// Snapshot returns a consistent view of every shard as of now. Call Release
+// when done with it.
+func (s *ShardedBTree) Snapshot() *ShardedSnapshot {
+	s.pin()
+	defer s.unpin()
+	for _, ts := range s.ttl {
+		ts.rlock()
+	}
+	for _, shard := range s.shards {
+		shard.treeLock.Lock()
+	}
+	defer func() {
+		for _, shard := range s.shards {
+			shard.treeLock.Unlock()
+		}
+		for _, ts := range s.ttl {
+			ts.runlock()
+		}
+	}()
+
+	snap := &ShardedSnapshot{
+		shards:    make([]*Snapshot, len(s.shards)),
+		cutoff:    ttlNow(),
+		numShards: s.numShards,
+		hash:      s.hash,
+		migrating: s.migration != nil,
+	}
+	if s.resizable {
+		snap.place = s.place
+	}
+	for i, shard := range s.shards {
+		snap.shards[i] = shard.snapshotLocked()
+	}
+	if s.ttl != nil {
+		snap.expires = make([]map[string]int64, len(s.ttl))
+		for i, ts := range s.ttl {
+			snap.expires[i] = maps.Clone(ts.expires)
+		}
+	}
+	return snap
+}
+
+// Release releases every shard's Snapshot. Safe to call more than once.
+func (ss *ShardedSnapshot) Release() {
+	for _, shard := range ss.shards {
+		shard.Release()
+	}
+}
+
+// Len returns the number of keys in the snapshot, counting expired keys
+// not yet swept when it was taken.
+func (ss *ShardedSnapshot) Len() int64 {
+	var total int64
+	for _, shard := range ss.shards {
+		total += shard.Len()
+	}
+	return total
+}
+
+// expired reports whether key on shard idx had expired when the snapshot
+// was taken.
+func (ss *ShardedSnapshot) expired(idx int, key []byte) bool {
+	if ss.expires == nil {
+		return false
+	}
+	expiry, ok := ss.expires[idx][string(key)]
+	return ok && expiry <= ss.cutoff
+}
+
+// Find returns the value of key as of the snapshot.
+func (ss *ShardedSnapshot) Find(key Keytype) (Valuetype, error) {
+	candidates := []int{ss.shardIndex(key)}
+	if ss.migrating {
+		// A key being migrated is on its old shard or its new one
+		candidates = make([]int, len(ss.shards))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+	for _, idx := range candidates {
+		if value, err := ss.shards[idx].Find(key); err == nil && !ss.expired(idx, key) {
+			return value, nil
+		}
+	}
+	return nil, errors.New("key not found")
+}
+
+// shardIndex is ShardedBTree.getShardIndex as of the snapshot, ignoring a
+// running resize.
+func (ss *ShardedSnapshot) shardIndex(key Keytype) int {
+	hash := ss.hash(key)
+	if ss.place == nil {
+		return int(hash % ss.numShards)
+	}
+	return ss.place.locate(hash)
+}
+
+// GetRange returns all key-value pairs in [startKey, endKey] as of the
+// snapshot, in ascending key order.
+func (ss *ShardedSnapshot) GetRange(startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+
+	total := 0
+	cursors := make([]*mergeCursor, len(ss.shards))
+	for idx, shard := range ss.shards {
+		var keys []Keytype
+		var values []Valuetype
+		shard.scan(startKey, endKey, true, func(key Keytype, value Valuetype) bool {
+			if !ss.expired(idx, key) {
+				keys, values = append(keys, key), append(values, value)
+			}
+			return true
+		})
+		total += len(keys)
+		cursors[idx] = sliceCursor(keys, values)
+	}
+
+	keys := make([]Keytype, 0, total)
+	values := make([]Valuetype, 0, total)
+	mergeCursors(cursors, func(key Keytype, value Valuetype) bool {
+		keys, values = append(keys, key), append(values, value)
+		return true
+	})
+	return keys, values, nil
+}
+
+// ForEach iterates over all key-value pairs in the snapshot, shard by shard,
+// until callback returns false. As with ShardedBTree.ForEach, order is not
+// guaranteed. callback receives copies and may write to the live tree.
+func (ss *ShardedSnapshot) ForEach(callback func(key Keytype, value Valuetype) bool) {
+	for idx, shard := range ss.shards {
+		more := true
+		shard.scan(nil, nil, false, func(key Keytype, value Valuetype) bool {
+			more = ss.expired(idx, key) || callback(key, value)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bptree
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedSnapshot(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	for i := 0; i < 500; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%03d", i)), []byte("old"))
+	}
+	tree.InsertWithTTL([]byte("expired"), []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	// Writes after the snapshot are not observed
+	for i := 0; i < 500; i += 2 {
+		tree.Insert([]byte(fmt.Sprintf("key-%03d", i)), []byte("new"))
+		tree.Delete([]byte(fmt.Sprintf("key-%03d", i+1)))
+	}
+	tree.Insert([]byte("later"), []byte("x"))
+
+	if v, err := snap.Find([]byte("key-001")); err != nil || string(v) != "old" {
+		t.Errorf("Find(key-001) = %q, %v", v, err)
+	}
+	for _, key := range []string{"later", "expired", "missing"} {
+		if _, err := snap.Find([]byte(key)); err == nil {
+			t.Errorf("Find(%q) found a key absent from the snapshot", key)
+		}
+	}
+
+	keys, values, err := snap.GetRange([]byte("key-100"), []byte("key-199"))
+	if err != nil || len(keys) != 100 {
+		t.Fatalf("GetRange returned %d keys, %v", len(keys), err)
+	}
+	for i := range keys {
+		if string(keys[i]) != fmt.Sprintf("key-%03d", 100+i) || string(values[i]) != "old" {
+			t.Fatalf("GetRange[%d] = %q, %q", i, keys[i], values[i])
+		}
+	}
+	if _, _, err := snap.GetRange([]byte("b"), []byte("a")); err == nil {
+		t.Error("GetRange accepted an inverted range")
+	}
+
+	count := 0
+	snap.ForEach(func(key Keytype, value Valuetype) bool {
+		if string(value) != "old" {
+			t.Fatalf("ForEach saw %q = %q", key, value)
+		}
+		count++
+		return true
+	})
+	if count != 500 {
+		t.Errorf("ForEach visited %d keys, want 500", count)
+	}
+}
+
+func TestShardedSnapshotDuringWrites(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 8})
+
+	// Snapshots taken while writers run stay consistent with their own Len
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tree.BulkInsert([]Keytype{[]byte(fmt.Sprintf("a-%d", w)), []byte(fmt.Sprintf("b-%d", w))},
+					[]Valuetype{[]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i))})
+			}
+		}(w)
+	}
+
+	for i := 0; i < 50; i++ {
+		snap := tree.Snapshot()
+		n := snap.Len()
+		seen := 0
+		snap.ForEach(func(Keytype, Valuetype) bool { seen++; return true })
+		if int64(seen) != n {
+			t.Errorf("snapshot of %d keys visited %d", n, seen)
+		}
+		snap.Release()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestShardedSnapshotAcrossResize(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2, ConsistentHashing: true})
+	for i := 0; i < 1000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key-%d", i)), []byte("v"))
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+	if err := tree.Resize(6); err != nil {
+		t.Fatal(err)
+	}
+	tree.WaitMigration()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := snap.Find([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("key-%d missing from the snapshot after a resize", i)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"slices"
+	"sync/atomic"
+)
+
+// Snapshot is an immutable, point-in-time view of a Btree that shares its
+// nodes with the live tree.
+//
+// DESIGN:
+// - Taking a snapshot is O(1): it starts a new generation under a brief exclusive treeLock
+// - A writer about to change a node's keys, values or children first saves its contents, once per generation
+// - A snapshot reads each node's contents as of its generation: the live ones, or a saved copy
+// - Writers never wait on snapshot reads: a snapshot latches one node at a time, only to copy it
+// - Saved copies and the nodes holding them are not recycled while any snapshot is live
+//
+// LIMITATIONS:
+// - Saved copies are dropped only by a node's next write after every snapshot is released
+// - A Snapshot must not be used after Release: the tree may then recycle its nodes
+//
+// USAGE:
+//
+//	snap := tree.Snapshot()
+//	defer snap.Release()
+//	for key, value := range snap.All() {
+//		backup.Write(key, value) // Concurrent writes to tree are not observed
+//	}
+type Snapshot struct {
+	tree     *Btree
+	root     *Node
+	gen      uint64
+	size     int64
+	released atomic.Bool
+}
+
+// Snapshot returns a consistent view of the tree as of now. Call Release
+// when done with it.
+func (t *Btree) Snapshot() *Snapshot {
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+	return t.snapshotLocked()
+}
+
+// snapshotLocked is Snapshot. Called with treeLock held exclusively.
+func (t *Btree) snapshotLocked() *Snapshot {
+	t.snapshots.Add(1)
+	return &Snapshot{
+		tree: t,
+		root: t.root,
+		gen:  t.snapGen.Add(1),
+		size: atomic.LoadInt64(&t.size),
+	}
+}
+
+// Release lets the tree drop the snapshot's saved copies and recycle its
+// nodes. Safe to call more than once.
+func (s *Snapshot) Release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.tree.snapshots.Add(-1)
+	}
+}
+
+// preserve saves n's contents for live snapshots before a write changes
+// them. Called with n exclusively latched (or treeLock held exclusively).
+func (t *Btree) preserve(n *Node) {
+	if t.snapshots.Load() == 0 {
+		n.versions = nil
+		return
+	}
+	gen := t.snapGen.Load()
+	if n.gen > gen {
+		return // Already saved for every snapshot, or created after them
+	}
+	saved := &Node{
+		keys:     slices.Clone(n.keys),
+		prefix:   n.prefix,
+		values:   slices.Clone(n.values),
+		children: slices.Clone(n.children),
+		isleaf:   n.isleaf,
+		gen:      n.gen,
+	}
+	n.versions = append([]*Node{saved}, n.versions...)
+	n.gen = gen + 1
+}
+
+// view returns n's contents as of the snapshot, as a detached node that no
+// writer touches.
+func (s *Snapshot) view(n *Node) *Node {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if s.gen >= n.gen {
+		return &Node{
+			keys:     slices.Clone(n.keys),
+			prefix:   n.prefix,
+			values:   slices.Clone(n.values),
+			children: slices.Clone(n.children),
+			isleaf:   n.isleaf,
+		}
+	}
+	for _, saved := range n.versions {
+		if saved.gen <= s.gen {
+			return saved
+		}
+	}
+	panic(invariantf(n, "no contents saved for snapshot generation %d", s.gen))
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot) Len() int64 {
+	return s.size
+}
+
+// Find returns the value of key as of the snapshot.
+func (s *Snapshot) Find(key []byte) ([]byte, error) {
+	if s.root == nil {
+		return nil, errors.New("key not found")
+	}
+	n := s.view(s.root)
+	for {
+		pos := n.findindex(key)
+		if n.hasKeyAt(pos, key) {
+			return append([]byte(nil), n.values[pos]...), nil
+		}
+		if n.isleaf || pos >= len(n.children) {
+			return nil, errors.New("key not found")
+		}
+		n = s.view(n.children[pos])
+	}
+}
+
+// GetRange returns all key-value pairs in [startKey, endKey] as of the snapshot.
+func (s *Snapshot) GetRange(startKey, endKey []byte) ([]Keytype, []Valuetype, error) {
+	if bytes.Compare(startKey, endKey) > 0 {
+		return nil, nil, errors.New("invalid range: startKey is greater than endKey")
+	}
+	keys := make([]Keytype, 0)
+	values := make([]Valuetype, 0)
+	s.scan(startKey, endKey, true, func(key Keytype, value Valuetype) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	return keys, values, nil
+}
+
+// All returns an iterator over every pair in the snapshot in ascending key
+// order. No latch is held while the loop body runs.
+func (s *Snapshot) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		s.scan(nil, nil, false, func(key Keytype, value Valuetype) bool {
+			return yield(key, value)
+		})
+	}
+}
+
+// Range returns an iterator over pairs in [startKey, endKey] in ascending
+// key order. An inverted range yields nothing.
+func (s *Snapshot) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		if bytes.Compare(startKey, endKey) > 0 {
+			return
+		}
+		s.scan(startKey, endKey, true, func(key Keytype, value Valuetype) bool {
+			return yield(key, value)
+		})
+	}
+}
+
+// scan visits pairs from startKey (to endKey when bounded) in ascending
+// order until fn returns false. fn receives copies.
+func (s *Snapshot) scan(startKey, endKey []byte, bounded bool, fn func(key Keytype, value Valuetype) bool) {
+	if s.root != nil {
+		s.scanNode(s.view(s.root), startKey, endKey, bounded, fn)
+	}
+}
+
+// scanNode is Node.scan over snapshot views. Returns false if the scan
+// stopped early.
+func (s *Snapshot) scanNode(n *Node, startKey, endKey []byte, bounded bool, fn func(key Keytype, value Valuetype) bool) bool {
+	for i := n.findindex(startKey); i <= len(n.keys); i++ {
+		if !n.isleaf && i < len(n.children) {
+			if !s.scanNode(s.view(n.children[i]), startKey, endKey, bounded, fn) {
+				return false
+			}
+		}
+		if i == len(n.keys) {
+			break
+		}
+		if bounded && n.compareKey(i, endKey) > 0 {
+			return false
+		}
+		if !fn(n.copyKey(i), append([]byte(nil), n.values[i]...)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the tree as of now, sharing no nodes,
+// keys or values with it. Writers are blocked only while a Snapshot is taken;
+// the copy is then bulk loaded from it, so its nodes are packed full.
+// Panic-free mode and size limits carry over.
+func (t *Btree) Clone() *Btree {
+	snap := t.Snapshot()
+	defer snap.Release()
+
+	keys := make([]Keytype, 0, snap.Len())
+	values := make([]Valuetype, 0, snap.Len())
+	for key, value := range snap.All() {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	clone := &Btree{}
+	if err := clone.BulkLoad(keys, values); err != nil {
+		// A snapshot yields sorted, unique keys, so this is a broken tree
+		panic(&InvariantError{Op: "Clone", Detail: err.Error()})
+	}
+	clone.SetPanicFree(t.panicFree.Load())
+	clone.SetSizeLimits(int(t.maxKeySize.Load()), int(t.maxValueSize.Load()))
+	return clone
+}
@@ -0,0 +1,203 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// snapshotContents returns every pair a snapshot yields, checking order.
+func snapshotContents(t *testing.T, snap *Snapshot) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	var last []byte
+	for key, value := range snap.All() {
+		if last != nil && bytes.Compare(last, key) >= 0 {
+			t.Fatalf("Snapshot keys out of order: %q then %q", last, key)
+		}
+		last = key
+		contents[string(key)] = string(value)
+	}
+	return contents
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	tree := &Btree{}
+	want := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key:%04d", i)
+		tree.Insert([]byte(key), []byte("v1"))
+		want[key] = "v1"
+	}
+
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key:%04d", rng.Intn(1000)))
+		switch rng.Intn(4) {
+		case 0:
+			tree.Delete(key)
+		case 1:
+			tree.CompareAndSwap(key, []byte("v1"), []byte("swapped"))
+		default:
+			tree.Insert(key, []byte("v2"))
+		}
+	}
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatalf("Live tree invalid after copy-on-write: %v", err)
+	}
+
+	got := snapshotContents(t, snap)
+	if len(got) != len(want) || snap.Len() != int64(len(want)) {
+		t.Fatalf("Snapshot has %d keys (Len %d), want %d", len(got), snap.Len(), len(want))
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("Snapshot %s = %q, want %q", key, got[key], value)
+		}
+	}
+	if value, err := snap.Find([]byte("key:0123")); err != nil || string(value) != "v1" {
+		t.Errorf("Snapshot Find = %q, %v", value, err)
+	}
+	if _, err := snap.Find([]byte("key:0999")); err == nil {
+		t.Error("Snapshot found a key inserted after it")
+	}
+	keys, _, err := snap.GetRange([]byte("key:0100"), []byte("key:0199"))
+	if err != nil || len(keys) != 100 {
+		t.Errorf("Snapshot GetRange returned %d keys, %v", len(keys), err)
+	}
+}
+
+func TestSnapshotsAtDifferentTimes(t *testing.T) {
+	tree := &Btree{}
+	var snaps []*Snapshot
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte(fmt.Sprintf("round%d", round)))
+		}
+		snaps = append(snaps, tree.Snapshot())
+	}
+	tree.Clear()
+
+	for round, snap := range snaps {
+		contents := snapshotContents(t, snap)
+		if len(contents) != 100 {
+			t.Fatalf("Snapshot %d has %d keys, want 100", round, len(contents))
+		}
+		for key, value := range contents {
+			if value != fmt.Sprintf("round%d", round) {
+				t.Fatalf("Snapshot %d: %s = %q", round, key, value)
+			}
+		}
+		snap.Release()
+	}
+	if tree.Len() != 0 {
+		t.Errorf("Len after Clear = %d", tree.Len())
+	}
+}
+
+func TestSnapshotReleaseDropsSavedCopies(t *testing.T) {
+	tree := &Btree{}
+	tree.Insert([]byte("a"), []byte("1"))
+	snap := tree.Snapshot()
+
+	tree.Insert([]byte("a"), []byte("2"))
+	tree.Insert([]byte("a"), []byte("3")) // Same generation: saved once
+	if n := len(tree.root.versions); n != 1 {
+		t.Fatalf("Root has %d saved copies, want 1", n)
+	}
+	if value, _ := snap.Find([]byte("a")); string(value) != "1" {
+		t.Errorf("Snapshot sees %q, want 1", value)
+	}
+
+	snap.Release()
+	snap.Release() // Idempotent
+	tree.Insert([]byte("a"), []byte("4"))
+	if tree.root.versions != nil {
+		t.Error("Write after Release kept saved copies")
+	}
+}
+
+func TestSnapshotDuringConcurrentWrites(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key:%04d", i))
+		tree.Insert(key, key)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key:%04d", rng.Intn(600)))
+				if rng.Intn(2) == 0 {
+					tree.Delete(key)
+				} else {
+					tree.Insert(key, []byte("changed"))
+				}
+			}
+		}(w)
+	}
+
+	for pass := 0; pass < 5; pass++ {
+		contents := snapshotContents(t, snap)
+		if len(contents) != 300 {
+			t.Fatalf("Pass %d: snapshot has %d keys, want 300", pass, len(contents))
+		}
+		for key, value := range contents {
+			if key != value {
+				t.Fatalf("Pass %d: snapshot %s = %q", pass, key, value)
+			}
+		}
+	}
+	wg.Wait()
+
+	if err := validateBTreeProperties(tree); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	tree := &Btree{}
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("key:%04d", i))
+		tree.Insert(key, key)
+	}
+	tree.SetSizeLimits(0, 32)
+
+	clone := tree.Clone()
+	if err := clone.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to either side, including in-place value edits, stay there
+	value, _ := clone.Find([]byte("key:0001"))
+	value[0] = 'X'
+	tree.Delete([]byte("key:0002"))
+	clone.Insert([]byte("key:9999"), []byte("clone only"))
+	if got, _ := tree.Find([]byte("key:0001")); string(got) != "key:0001" {
+		t.Errorf("Original sees the clone's edit: %q", got)
+	}
+	if _, err := clone.Find([]byte("key:0002")); err != nil {
+		t.Error("Delete from the original reached the clone")
+	}
+	if _, err := tree.Find([]byte("key:9999")); err == nil {
+		t.Error("Insert into the clone reached the original")
+	}
+	if tree.Len() != 499 || clone.Len() != 501 {
+		t.Errorf("Len = %d and %d, want 499 and 501", tree.Len(), clone.Len())
+	}
+	if err := clone.TryInsert([]byte("big"), make([]byte, 33)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Clone did not keep size limits: %v", err)
+	}
+}
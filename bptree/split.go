@@ -0,0 +1,173 @@
+package bptree
+
+import (
+	"bytes"
+	"iter"
+	"sort"
+)
+
+// KeyRange is a half-open key interval [Start, End) used to partition scans.
+// A nil Start or End is unbounded.
+//
+// USAGE:
+//
+//	for _, r := range tree.SplitRanges(workers) {
+//	    go func(r KeyRange) {
+//	        for k, v := range tree.InRange(r) {
+//	            process(k, v)
+//	            checkpoint(r.ResumeAfter(k)) // Restart point if the worker fails
+//	        }
+//	    }(r)
+//	}
+type KeyRange struct {
+	Start Keytype
+	End   Keytype
+}
+
+// Contains reports whether key falls in the range.
+func (r KeyRange) Contains(key []byte) bool {
+	if r.Start != nil && bytes.Compare(key, r.Start) < 0 {
+		return false
+	}
+	return r.End == nil || bytes.Compare(key, r.End) < 0
+}
+
+// ResumeAfter returns the part of the range strictly after key, for
+// restarting a partially completed scan.
+func (r KeyRange) ResumeAfter(key []byte) KeyRange {
+	start := make(Keytype, len(key)+1)
+	copy(start, key)
+	return KeyRange{Start: start, End: r.End}
+}
+
+// splitOversample is how many samples per requested range SplitRanges
+// gathers from each shard.
+const splitOversample = 8
+
+// SplitRanges returns at most n contiguous key ranges covering the whole key
+// space, each holding roughly the same number of keys. Boundaries come from
+// the upper levels of every shard, so no full scan is needed; fewer ranges
+// are returned when there are too few keys to split n ways.
+func (s *ShardedBTree) SplitRanges(n int) []KeyRange {
+	if n <= 1 {
+		return []KeyRange{{}}
+	}
+
+	type sample struct {
+		key    Keytype
+		weight float64
+	}
+	var samples []sample
+	var total float64
+	s.pin()
+	shards := s.shards
+	s.unpin()
+	for _, shard := range shards {
+		keys := shard.sampleKeys(n * splitOversample)
+		if len(keys) == 0 {
+			continue
+		}
+		// Samples cut the shard into len(keys)+1 similar gaps
+		weight := float64(shard.Len()) / float64(len(keys)+1)
+		for _, key := range keys {
+			samples = append(samples, sample{key: key, weight: weight})
+		}
+		total += float64(shard.Len())
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return bytes.Compare(samples[i].key, samples[j].key) < 0
+	})
+
+	var bounds []Keytype
+	var cumulative float64
+	next := 1
+	for _, smp := range samples {
+		// cumulative estimates the keys below smp.key
+		if next < n && cumulative >= total*float64(next)/float64(n) {
+			bounds = append(bounds, smp.key)
+			for next < n && cumulative >= total*float64(next)/float64(n) {
+				next++
+			}
+		}
+		cumulative += smp.weight
+	}
+
+	ranges := make([]KeyRange, 0, len(bounds)+1)
+	var start Keytype
+	for _, bound := range bounds {
+		ranges = append(ranges, KeyRange{Start: start, End: bound})
+		start = bound
+	}
+	return append(ranges, KeyRange{Start: start})
+}
+
+// InRange returns an iterator over the key-value pairs in r.
+// Keys are ascending within each shard but not across shards. As with All,
+// keys migrating during a Resize may be missed or repeated.
+func (s *ShardedBTree) InRange(r KeyRange) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		s.pin()
+		shards := s.shards
+		s.unpin()
+		for _, shard := range shards {
+			for k, v := range shard.iterate(r.Start, nil, false) {
+				if !r.Contains(k) {
+					break
+				}
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// sampleKeys returns, in order, copies of the keys in the shallowest top
+// levels of the tree that hold at least target keys (or all keys). Between
+// consecutive samples lies one subtree of the next level down, and subtrees
+// of equal height hold similar numbers of keys, so the samples are spread
+// roughly evenly through the key order.
+func (t *Btree) sampleKeys(target int) []Keytype {
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	for depth := 1; ; depth++ {
+		root := t.rlockRoot()
+		if root == nil {
+			return nil
+		}
+		var keys []Keytype
+		truncated := root.collectTop(depth, &keys)
+		root.mu.RUnlock()
+
+		if len(keys) >= target || !truncated {
+			return keys
+		}
+	}
+}
+
+// collectTop appends the keys in the top depth levels below n in order.
+// Returns true if deeper levels were left out. The caller holds n
+// read-latched; children are latched on the way down.
+func (n *Node) collectTop(depth int, keys *[]Keytype) bool {
+	truncated := false
+	for i := 0; i <= len(n.keys); i++ {
+		if !n.isleaf && i < len(n.children) {
+			if depth > 1 {
+				child := n.children[i]
+				child.mu.RLock()
+				if child.collectTop(depth-1, keys) {
+					truncated = true
+				}
+				child.mu.RUnlock()
+			} else {
+				truncated = true
+			}
+		}
+		if i < len(n.keys) {
+			*keys = append(*keys, n.copyKey(i))
+		}
+	}
+	return truncated
+}
@@ -0,0 +1,99 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSplitRangesBalanced(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	const numKeys = 10000
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key:%06d", i))
+		tree.Insert(key, key)
+	}
+
+	const n = 8
+	ranges := tree.SplitRanges(n)
+	if len(ranges) != n {
+		t.Fatalf("Expected %d ranges, got %d", n, len(ranges))
+	}
+	if ranges[0].Start != nil || ranges[n-1].End != nil {
+		t.Error("Ranges should be unbounded at both ends")
+	}
+
+	seen := 0
+	for i, r := range ranges {
+		if i > 0 && !bytes.Equal(r.Start, ranges[i-1].End) {
+			t.Errorf("Range %d does not start where range %d ends", i, i-1)
+		}
+		count := 0
+		for k := range tree.InRange(r) {
+			if !r.Contains(k) {
+				t.Errorf("Key %s outside range %d", k, i)
+			}
+			count++
+		}
+		// Sampling is approximate: allow each range within 50% of the ideal size
+		if ideal := numKeys / n; count < ideal/2 || count > ideal*3/2 {
+			t.Errorf("Range %d holds %d keys, ideal %d", i, count, ideal)
+		}
+		seen += count
+	}
+	if seen != numKeys {
+		t.Errorf("Ranges cover %d keys, want %d", seen, numKeys)
+	}
+}
+
+func TestSplitRangesSmallTree(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2})
+	if ranges := tree.SplitRanges(4); len(ranges) != 1 {
+		t.Errorf("Empty tree: expected 1 range, got %d", len(ranges))
+	}
+
+	for i := 0; i < 3; i++ {
+		tree.Insert([]byte(fmt.Sprintf("k%d", i)), []byte("v"))
+	}
+	ranges := tree.SplitRanges(10)
+	if len(ranges) > 3 {
+		t.Errorf("Expected at most 3 ranges for 3 keys, got %d", len(ranges))
+	}
+	seen := 0
+	for _, r := range ranges {
+		for range tree.InRange(r) {
+			seen++
+		}
+	}
+	if seen != 3 {
+		t.Errorf("Ranges cover %d keys, want 3", seen)
+	}
+}
+
+func TestKeyRangeResumeAfter(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 1})
+	for i := 0; i < 100; i++ {
+		tree.Insert([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+	}
+
+	r := KeyRange{Start: []byte("k010"), End: []byte("k020")}
+	var last []byte
+	count := 0
+	for k := range tree.InRange(r) {
+		last = append([]byte(nil), k...)
+		if count++; count == 4 {
+			break
+		}
+	}
+
+	resumed := r.ResumeAfter(last)
+	for k := range tree.InRange(resumed) {
+		if bytes.Compare(k, last) <= 0 {
+			t.Errorf("Resumed scan revisited %s", k)
+		}
+		count++
+	}
+	if count != 10 {
+		t.Errorf("Interrupted and resumed scans saw %d keys, want 10", count)
+	}
+}
@@ -0,0 +1,282 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Standby is a warm secondary: it keeps applying a primary's WAL and can
+// take over as primary with Promote.
+//
+// DESIGN:
+// - Replication is a DurableReader tailing the primary's WAL every RefreshInterval
+// - Health is observed from the WAL: Status reports how long it has been idle and whether refreshes fail
+// - Promote fences the old primary by bumping the epoch in its WAL header (see WAL FENCING)
+// - It then applies the last entries and moves a copy of the applied WAL into place, so the tree it already holds is reused
+//
+// LIMITATIONS:
+// - The primary's WAL must be version 2; a version 1 file has no epoch to fence with
+// - Deciding when to promote is the caller's: an idle WAL may be a quiet primary, not a dead one
+//
+// USAGE:
+//
+//	standby, err := OpenStandby(StandbyConfig{WALPath: "/shared/stundb.wal"})
+//	if status := standby.Status(); status.Idle > 10*time.Second && primaryUnreachable() {
+//		db, err := standby.Promote() // The old primary now fails with ErrFenced
+//	}
+type Standby struct {
+	config StandbyConfig
+	reader *DurableReader
+
+	lastAdvance  atomic.Int64 // UnixNano when the applied sequence last moved
+	lastSequence uint64       // Owned by the refresh loop
+
+	promoteMu sync.Mutex
+	promoted  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StandbyConfig configures a Standby.
+type StandbyConfig struct {
+	// WALPath is the primary's WAL (required)
+	WALPath string
+
+	// NumShards for the in-memory tree (default: NumCPU)
+	NumShards int
+
+	// RefreshInterval is how often new WAL entries are applied (default: 100ms)
+	RefreshInterval time.Duration
+
+	// SyncMode and BatchSize for the DurableBTree Promote returns
+	SyncMode  SyncMode
+	BatchSize int
+}
+
+// StandbyStatus reports a standby's replication health.
+type StandbyStatus struct {
+	Sequence      uint64        // Last applied WAL sequence
+	Idle          time.Duration // Since the applied sequence last advanced
+	RefreshErrors uint64
+	Promoted      bool
+}
+
+// ErrPromoted is returned by a Standby that has already been promoted.
+var ErrPromoted = errors.New("standby already promoted")
+
+const defaultStandbyRefresh = 100 * time.Millisecond
+
+// OpenStandby replays the primary's WAL and starts following it.
+func OpenStandby(config StandbyConfig) (*Standby, error) {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultStandbyRefresh
+	}
+	reader, err := OpenDurableReader(ReaderConfig{WALPath: config.WALPath, NumShards: config.NumShards})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Standby{
+		config:       config,
+		reader:       reader,
+		lastSequence: reader.Sequence(),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	s.lastAdvance.Store(time.Now().UnixNano())
+	go s.follow()
+	return s, nil
+}
+
+// follow applies the WAL every RefreshInterval until stopped.
+func (s *Standby) follow() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.reader.Refresh(); err != nil {
+				atomic.AddUint64(&s.reader.refreshErrors, 1)
+			}
+			if sequence := s.reader.Sequence(); sequence != s.lastSequence {
+				s.lastSequence = sequence
+				s.lastAdvance.Store(time.Now().UnixNano())
+			}
+		}
+	}
+}
+
+// Status reports replication progress and health.
+func (s *Standby) Status() StandbyStatus {
+	s.promoteMu.Lock()
+	promoted := s.promoted
+	s.promoteMu.Unlock()
+
+	return StandbyStatus{
+		Sequence:      s.reader.Sequence(),
+		Idle:          time.Since(time.Unix(0, s.lastAdvance.Load())),
+		RefreshErrors: atomic.LoadUint64(&s.reader.refreshErrors),
+		Promoted:      promoted,
+	}
+}
+
+// Find searches for a key as of the last applied entry.
+func (s *Standby) Find(key Keytype) (Valuetype, error) {
+	return s.reader.Find(key)
+}
+
+// Count returns the number of keys as of the last applied entry.
+func (s *Standby) Count() int64 {
+	return s.reader.Count()
+}
+
+// Promote makes the standby the primary: it fences the old primary, applies
+// the rest of its WAL and returns a DurableBTree writing under the next
+// epoch. The Standby stops following and must not be used afterwards.
+// After an error the old primary may already be fenced; Promote can be
+// retried.
+func (s *Standby) Promote() (*DurableBTree, error) {
+	s.promoteMu.Lock()
+	defer s.promoteMu.Unlock()
+	if s.promoted {
+		return nil, ErrPromoted
+	}
+	s.stopFollowing()
+
+	epoch, err := fenceWAL(s.config.WALPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries the old primary flushed before it was fenced
+	if err := s.reader.Refresh(); err != nil {
+		return nil, fmt.Errorf("failed to apply the rest of the WAL: %w", err)
+	}
+	s.reader.refreshMu.Lock()
+	applied := s.reader.pos.offset
+	s.reader.refreshMu.Unlock()
+
+	// The old primary keeps its handle on the old file, so anything it
+	// still writes never reaches the new one
+	if err := copyWALPrefix(s.config.WALPath, applied); err != nil {
+		return nil, err
+	}
+	wal, err := NewWAL(WALConfig{
+		Path:      s.config.WALPath,
+		SyncMode:  s.config.SyncMode,
+		BatchSize: s.config.BatchSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open promoted WAL: %w", err)
+	}
+	if wal.Epoch() != epoch {
+		wal.Close()
+		return nil, fmt.Errorf("promoted WAL has epoch %d, want %d", wal.Epoch(), epoch)
+	}
+
+	s.promoted = true
+	return &DurableBTree{
+		tree: s.reader.current(),
+		wal:  wal,
+		config: DurableConfig{
+			WALPath:   s.config.WALPath,
+			NumShards: s.config.NumShards,
+			SyncMode:  s.config.SyncMode,
+			BatchSize: s.config.BatchSize,
+			EnableTTL: true, // The reader's tree keeps expiries
+		},
+	}, nil
+}
+
+// Close stops following the WAL. Safe to call more than once.
+func (s *Standby) Close() error {
+	s.promoteMu.Lock()
+	defer s.promoteMu.Unlock()
+	s.stopFollowing()
+	return nil
+}
+
+// stopFollowing stops the refresh loop. Called with promoteMu held.
+func (s *Standby) stopFollowing() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+// fenceWAL bumps the epoch in the WAL header at path and syncs it, fencing
+// its writer. Returns the new epoch.
+func fenceWAL(path string) (uint64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open WAL to fence it: %w", err)
+	}
+	defer file.Close()
+
+	header, _, err := readWALHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	if header.Version < walVersion {
+		return 0, fmt.Errorf("WAL version %d has no epoch to fence the primary with", header.Version)
+	}
+
+	epoch := header.Epoch + 1
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], epoch)
+	if _, err := file.WriteAt(buf[:], 8); err != nil {
+		return 0, fmt.Errorf("failed to fence WAL: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to fence WAL: %w", err)
+	}
+	return epoch, nil
+}
+
+// copyWALPrefix replaces the WAL at path with a copy of its first n bytes.
+func copyWALPrefix(path string, n int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	defer src.Close()
+
+	tmpPath := path + ".promote"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	if _, err := io.CopyN(dst, src, n); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to install promoted WAL: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForCount polls until the standby has applied want keys.
+func waitForCount(t *testing.T, standby *Standby, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for standby.Count() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Standby has %d keys, want %d", standby.Count(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStandbyPromote(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	primary, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	for i := 0; i < 100; i++ {
+		primary.Insert([]byte(fmt.Sprintf("key:%03d", i)), []byte("v"))
+	}
+
+	standby, err := OpenStandby(StandbyConfig{WALPath: walPath, NumShards: 2, RefreshInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Close()
+	primary.Insert([]byte("key:100"), []byte("v"))
+	waitForCount(t, standby, 101)
+	if status := standby.Status(); status.Sequence != 101 || status.Promoted {
+		t.Errorf("Status = %+v", status)
+	}
+
+	// Written after the last refresh: Promote must still apply it
+	primary.Delete([]byte("key:000"))
+
+	db, err := standby.Promote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Epoch() != 1 || db.Count() != 100 {
+		t.Errorf("Promoted db: epoch %d, %d keys; want 1, 100", db.Epoch(), db.Count())
+	}
+	if _, err := standby.Promote(); !errors.Is(err, ErrPromoted) {
+		t.Errorf("Second Promote = %v, want ErrPromoted", err)
+	}
+
+	// The old primary is fenced, and cannot truncate the new WAL
+	if err := primary.Insert([]byte("split-brain"), []byte("v")); !errors.Is(err, ErrFenced) {
+		t.Errorf("Old primary Insert = %v, want ErrFenced", err)
+	}
+	if err := primary.Checkpoint(); !errors.Is(err, ErrFenced) {
+		t.Errorf("Old primary Checkpoint = %v, want ErrFenced", err)
+	}
+
+	if err := db.Insert([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	reopened, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Count() != 101 || reopened.Epoch() != 1 || reopened.WALSequence() != 103 {
+		t.Errorf("Reopened: %d keys, epoch %d, sequence %d; want 101, 1, 103", reopened.Count(), reopened.Epoch(), reopened.WALSequence())
+	}
+	if _, err := reopened.Find([]byte("split-brain")); err == nil {
+		t.Error("Fenced write reached the new primary")
+	}
+}
+
+func TestStandbyIdle(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	primary, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	standby, err := OpenStandby(StandbyConfig{WALPath: walPath, RefreshInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if idle := standby.Status().Idle; idle < 40*time.Millisecond {
+		t.Errorf("Idle = %v with no writes", idle)
+	}
+	primary.Insert([]byte("a"), []byte("1"))
+	waitForCount(t, standby, 1)
+	if idle := standby.Status().Idle; idle > 40*time.Millisecond {
+		t.Errorf("Idle = %v right after a write", idle)
+	}
+}
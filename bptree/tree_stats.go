@@ -0,0 +1,143 @@
+package bptree
+
+import "fmt"
+
+// TreeStats describes the shape of a tree, for tuning MaxKeys and spotting
+// degenerate shapes (a tall, sparsely filled tree after heavy deletes).
+//
+// Levels are counted from the root: KeysPerLevel[0] is the root's keys and
+// the last entry is the leaves'. For a ShardedBTree the per-level counts of
+// all shards are summed by depth.
+type TreeStats struct {
+	Height        int // Levels, 0 for an empty tree
+	Nodes         int64
+	Leaves        int64
+	Keys          int64
+	FillFactor    float64 // Mean keys per node as a fraction of MaxKeys
+	KeysPerLevel  []int64
+	NodesPerLevel []int64
+}
+
+// TreeStats walks the tree and reports its shape. O(n); concurrent writes
+// may proceed in parts of the tree already visited.
+func (t *Btree) TreeStats() TreeStats {
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	var stats TreeStats
+	root := t.rlockRoot()
+	if root == nil {
+		return stats
+	}
+	defer root.mu.RUnlock()
+	root.collectStats(0, &stats)
+	stats.computeFill()
+	return stats
+}
+
+// collectStats adds n and its subtree, at the given depth, to stats.
+// The caller holds n read-latched; children are latched on the way down.
+func (n *Node) collectStats(depth int, stats *TreeStats) {
+	if depth == len(stats.KeysPerLevel) {
+		stats.KeysPerLevel = append(stats.KeysPerLevel, 0)
+		stats.NodesPerLevel = append(stats.NodesPerLevel, 0)
+		stats.Height = depth + 1
+	}
+	stats.Nodes++
+	stats.Keys += int64(len(n.keys))
+	stats.KeysPerLevel[depth] += int64(len(n.keys))
+	stats.NodesPerLevel[depth]++
+	if n.isleaf {
+		stats.Leaves++
+		return
+	}
+	for _, child := range n.children {
+		if child != nil {
+			child.mu.RLock()
+			child.collectStats(depth+1, stats)
+			child.mu.RUnlock()
+		}
+	}
+}
+
+// merge adds other's counts to s, summing levels by depth.
+func (s *TreeStats) merge(other TreeStats) {
+	for len(s.KeysPerLevel) < len(other.KeysPerLevel) {
+		s.KeysPerLevel = append(s.KeysPerLevel, 0)
+		s.NodesPerLevel = append(s.NodesPerLevel, 0)
+	}
+	for depth := range other.KeysPerLevel {
+		s.KeysPerLevel[depth] += other.KeysPerLevel[depth]
+		s.NodesPerLevel[depth] += other.NodesPerLevel[depth]
+	}
+	s.Height = max(s.Height, other.Height)
+	s.Nodes += other.Nodes
+	s.Leaves += other.Leaves
+	s.Keys += other.Keys
+	s.computeFill()
+}
+
+// computeFill derives FillFactor from the counts.
+func (s *TreeStats) computeFill() {
+	if s.Nodes > 0 {
+		s.FillFactor = float64(s.Keys) / float64(s.Nodes*MaxKeys)
+	}
+}
+
+// countedStats reports the shape known without a walk: Keys and Nodes from
+// the counters writes maintain, and Height from the leftmost path. Leaves and
+// the per-level counts are left empty.
+func (t *Btree) countedStats() TreeStats {
+	t.treeLock.RLock()
+	defer t.treeLock.RUnlock()
+
+	stats := TreeStats{Keys: t.Len(), Nodes: t.nodes.Load()}
+	n := t.rlockRoot()
+	for n != nil {
+		stats.Height++
+		var child *Node
+		if !n.isleaf && len(n.children) > 0 {
+			child = n.children[0]
+			child.mu.RLock()
+		}
+		n.mu.RUnlock()
+		n = child
+	}
+	stats.computeFill()
+	return stats
+}
+
+// VerifyStats walks every shard and checks the counters behind Stats(true)
+// and Count against what the shard actually holds. Each shard's treeLock is
+// held exclusively while it is walked, so writes to it wait; the shards are
+// checked one at a time.
+func (s *ShardedBTree) VerifyStats() error {
+	s.pin()
+	defer s.unpin()
+	for idx, shard := range s.shards {
+		if err := shard.verifyCounts(); err != nil {
+			return fmt.Errorf("shard %d: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// verifyCounts checks Len and the node counter against a walk.
+func (t *Btree) verifyCounts() error {
+	t.treeLock.Lock()
+	defer t.treeLock.Unlock()
+
+	var walked TreeStats
+	if t.root != nil {
+		t.root.mu.RLock()
+		t.root.collectStats(0, &walked)
+		t.root.mu.RUnlock()
+	}
+	if keys := t.Len(); keys != walked.Keys {
+		return fmt.Errorf("Len is %d but the tree holds %d keys", keys, walked.Keys)
+	}
+	if nodes := t.nodes.Load(); nodes != walked.Nodes {
+		return fmt.Errorf("node count is %d but the tree holds %d nodes", nodes, walked.Nodes)
+	}
+	return nil
+}
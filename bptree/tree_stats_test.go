@@ -0,0 +1,98 @@
+package bptree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTreeStats(t *testing.T) {
+	tree := &Btree{}
+	if stats := tree.TreeStats(); stats.Height != 0 || stats.Nodes != 0 || stats.FillFactor != 0 {
+		t.Errorf("Empty tree stats = %+v", stats)
+	}
+
+	for i := 0; i < 1000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	stats := tree.TreeStats()
+
+	height := 1
+	for n := tree.root; !n.isleaf; n = n.children[0] {
+		height++
+	}
+	if stats.Height != height || len(stats.KeysPerLevel) != height {
+		t.Errorf("Height = %d with %d levels, want %d", stats.Height, len(stats.KeysPerLevel), height)
+	}
+	if stats.Keys != 1000 || stats.Leaves != stats.NodesPerLevel[height-1] || stats.NodesPerLevel[0] != 1 {
+		t.Errorf("Keys = %d, Leaves = %d, NodesPerLevel = %v", stats.Keys, stats.Leaves, stats.NodesPerLevel)
+	}
+	var keys, nodes int64
+	for depth := range stats.KeysPerLevel {
+		keys += stats.KeysPerLevel[depth]
+		nodes += stats.NodesPerLevel[depth]
+	}
+	if keys != stats.Keys || nodes != stats.Nodes {
+		t.Errorf("Levels sum to %d keys in %d nodes, want %d in %d", keys, nodes, stats.Keys, stats.Nodes)
+	}
+	// Every node but the root holds at least MinKeys
+	if stats.FillFactor < float64(MinKeys)/MaxKeys*0.9 || stats.FillFactor > 1 {
+		t.Errorf("FillFactor = %.2f", stats.FillFactor)
+	}
+}
+
+func TestShardedBTreeStatsAggregatesShape(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 2000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	stats := tree.Stats(false)
+
+	var height int
+	var nodes int64
+	for _, shard := range tree.shards {
+		shape := shard.TreeStats()
+		height = max(height, shape.Height)
+		nodes += shape.Nodes
+	}
+	if stats.Tree.Keys != stats.TotalKeys || stats.TotalKeys != 2000 {
+		t.Errorf("Tree.Keys = %d, TotalKeys = %d, want 2000", stats.Tree.Keys, stats.TotalKeys)
+	}
+	if stats.Tree.Height != height || stats.Tree.Nodes != nodes || stats.Tree.NodesPerLevel[0] != 4 {
+		t.Errorf("Tree = %+v; want height %d, %d nodes, 4 roots", stats.Tree, height, nodes)
+	}
+}
+
+func TestShardedBTreeFastStats(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	for i := 0; i < 3000; i++ {
+		tree.Insert([]byte(fmt.Sprintf("key:%04d", i)), []byte("v"))
+	}
+	for i := 0; i < 3000; i += 3 {
+		tree.Delete([]byte(fmt.Sprintf("key:%04d", i)))
+	}
+
+	fast, full := tree.Stats(true), tree.Stats(false)
+	if fast.TotalKeys != 2000 || fast.Skew != full.Skew {
+		t.Errorf("fast TotalKeys = %d, Skew = %v; want 2000, %v", fast.TotalKeys, fast.Skew, full.Skew)
+	}
+	for i := range fast.KeysPerShard {
+		if fast.KeysPerShard[i] != full.KeysPerShard[i] {
+			t.Errorf("shard %d: fast count %d, walked %d", i, fast.KeysPerShard[i], full.KeysPerShard[i])
+		}
+	}
+	if fast.Tree.Height != full.Tree.Height || fast.Tree.Nodes != full.Tree.Nodes || fast.Tree.FillFactor != full.Tree.FillFactor {
+		t.Errorf("fast Tree = %+v, full Tree = %+v", fast.Tree, full.Tree)
+	}
+	if fast.Tree.KeysPerLevel != nil {
+		t.Errorf("fast stats walked levels: %v", fast.Tree.KeysPerLevel)
+	}
+
+	if err := tree.VerifyStats(); err != nil {
+		t.Fatalf("VerifyStats: %v", err)
+	}
+	tree.shards[2].size++ // Simulate a drifted counter
+	if err := tree.VerifyStats(); err == nil || !strings.Contains(err.Error(), "shard 2") {
+		t.Errorf("VerifyStats with a drifted counter = %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTTLDisabled is returned by InsertWithTTL on a tree without
+// ShardConfig.EnableTTL (DurableConfig.EnableTTL).
+var ErrTTLDisabled = errors.New("TTL is not enabled for this tree")
+
+// ttlShard holds the expiry times of one shard's keys that have one.
+//
+// DESIGN:
+// - Writes to the shard hold mu exclusively around the tree write, so a key's value and expiry change together
+// - Reads hold mu shared and treat a key past its expiry as missing (lazy expiration)
+// - Expired keys stay in the tree until SweepExpired, Delete or an overwrite removes them
+//
+// A nil *ttlShard is a shard without TTL: every method is a no-op.
+type ttlShard struct {
+	mu      sync.RWMutex
+	expires map[string]int64 // UnixNano expiry by key
+}
+
+func (ts *ttlShard) lock() {
+	if ts != nil {
+		ts.mu.Lock()
+	}
+}
+
+func (ts *ttlShard) unlock() {
+	if ts != nil {
+		ts.mu.Unlock()
+	}
+}
+
+func (ts *ttlShard) rlock() {
+	if ts != nil {
+		ts.mu.RLock()
+	}
+}
+
+func (ts *ttlShard) runlock() {
+	if ts != nil {
+		ts.mu.RUnlock()
+	}
+}
+
+// expired reports whether key has an expiry at or before now. Called with
+// mu held.
+func (ts *ttlShard) expired(key []byte, now int64) bool {
+	if ts == nil || len(ts.expires) == 0 {
+		return false
+	}
+	expiry, ok := ts.expires[string(key)]
+	return ok && expiry <= now
+}
+
+// forget drops key's expiry. Called with mu held exclusively.
+func (ts *ttlShard) forget(key []byte) {
+	if ts != nil && len(ts.expires) > 0 {
+		delete(ts.expires, string(key))
+	}
+}
+
+// ttlShard returns the TTL state of shard idx, nil if TTL is disabled.
+func (s *ShardedBTree) ttlShard(idx int) *ttlShard {
+	if s.ttl == nil {
+		return nil
+	}
+	return s.ttl[idx]
+}
+
+// ttlNow is the clock expiries are compared with.
+func ttlNow() int64 {
+	return time.Now().UnixNano()
+}
+
+// InsertWithTTL inserts a key-value pair that expires after ttl: from then on
+// Find, GetRange and ForEach treat it as missing. A later write without a
+// TTL makes the key permanent again.
+//
+// DESIGN:
+// - Needs ShardConfig.EnableTTL; every write to a shard then holds a per-shard lock, so writes within a shard serialize
+// - Expired keys are removed lazily, by SweepExpired or a sweeper started with StartSweeper
+//
+// LIMITATIONS:
+// - Count, Stats, MemoryUsage and the All and InRange iterators still see expired keys until they are swept
+// - Expiry uses the wall clock: a clock jump expires keys early or late
+func (s *ShardedBTree) InsertWithTTL(key Keytype, value Valuetype, ttl time.Duration) error {
+	if s.ttl == nil {
+		return ErrTTLDisabled
+	}
+	return s.insertWithExpiry(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// insertWithExpiry inserts key to expire at the given UnixNano time.
+func (s *ShardedBTree) insertWithExpiry(key Keytype, value Valuetype, expiry int64) error {
+	if s.ttl == nil {
+		return ErrTTLDisabled
+	}
+	s.pin()
+	idx := s.getShardIndex(key)
+	ts := s.ttl[idx]
+
+	ts.lock()
+	wasExpired := ts.expired(key, ttlNow())
+	old, existed, err := s.shards[idx].upsert(key, value)
+	if err == nil {
+		ts.expires[string(key)] = expiry
+	}
+	ts.unlock()
+	s.unpin()
+	if err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&s.totalInserts, 1)
+	if wasExpired {
+		old, existed = nil, false
+	}
+	s.hooked().fireWrite(key, old, existed, value)
+	return nil
+}
+
+// SweepExpired deletes every expired key and returns how many it deleted.
+// Delete hooks fire for each.
+func (s *ShardedBTree) SweepExpired() int {
+	if s.ttl == nil {
+		return 0
+	}
+
+	hooks := s.hooked()
+	swept := 0
+	s.pin()
+	numShards := len(s.ttl)
+	s.unpin()
+	for idx := 0; idx < numShards; idx++ {
+		var removed []keyValuePair
+
+		s.pin()
+		ts := s.ttl[idx]
+		ts.lock()
+		cutoff := ttlNow()
+		for key, expiry := range ts.expires {
+			if expiry > cutoff {
+				continue
+			}
+			old, deleted, err := s.shards[idx].extract([]byte(key))
+			if err != nil {
+				break // A failed shard keeps its keys until Clear
+			}
+			delete(ts.expires, key)
+			if deleted {
+				removed = append(removed, keyValuePair{key: Keytype(key), value: old})
+			}
+		}
+		ts.unlock()
+		s.unpin()
+
+		swept += len(removed)
+		atomic.AddUint64(&s.totalDeletes, uint64(len(removed)))
+		for _, pair := range removed {
+			hooks.fireDelete(pair.key, pair.value)
+		}
+	}
+	return swept
+}
+
+// StartSweeper runs SweepExpired every interval until the returned stop
+// function is called. stop waits for a sweep in progress to finish.
+func (s *ShardedBTree) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.SweepExpired()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// ttlEntrySize is the expiry prefixed to the value of an OpInsertTTL entry.
+const ttlEntrySize = 8
+
+// encodeTTLValue prefixes value with its UnixNano expiry for the WAL.
+func encodeTTLValue(value Valuetype, expiry int64) []byte {
+	buf := make([]byte, ttlEntrySize+len(value))
+	binary.LittleEndian.PutUint64(buf, uint64(expiry))
+	copy(buf[ttlEntrySize:], value)
+	return buf
+}
+
+// decodeTTLValue splits an OpInsertTTL value into value and expiry.
+func decodeTTLValue(data []byte) (Valuetype, int64, error) {
+	if len(data) < ttlEntrySize {
+		return nil, 0, fmt.Errorf("TTL entry value is %d bytes, shorter than its expiry", len(data))
+	}
+	return data[ttlEntrySize:], int64(binary.LittleEndian.Uint64(data)), nil
+}
@@ -0,0 +1,93 @@
+package bptree
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInsertWithTTL(t *testing.T) {
+	if err := NewShardedBTree(ShardConfig{}).InsertWithTTL([]byte("k"), nil, time.Hour); !errors.Is(err, ErrTTLDisabled) {
+		t.Fatalf("InsertWithTTL without EnableTTL = %v", err)
+	}
+
+	tree := NewShardedBTree(ShardConfig{NumShards: 4, EnableTTL: true})
+	var deletes int
+	tree.OnDelete(func(Keytype, Valuetype, Valuetype) { deletes++ })
+
+	tree.InsertWithTTL([]byte("short"), []byte("1"), 20*time.Millisecond)
+	tree.InsertWithTTL([]byte("long"), []byte("2"), time.Hour)
+	tree.InsertWithTTL([]byte("renewed"), []byte("3"), 20*time.Millisecond)
+	tree.Insert([]byte("renewed"), []byte("4")) // Now permanent
+	if _, err := tree.Find([]byte("short")); err != nil {
+		t.Fatalf("Find before expiry = %v", err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, err := tree.Find([]byte("short")); err == nil {
+		t.Error("Expired key found")
+	}
+	keys, _, _ := tree.GetRange([]byte("a"), []byte("z"))
+	if len(keys) != 2 {
+		t.Errorf("GetRange = %q, want long and renewed", keys)
+	}
+	if tree.CompareAndSwap([]byte("short"), []byte("1"), []byte("x")) {
+		t.Error("CompareAndSwap matched an expired value")
+	}
+	if tree.Count() != 3 {
+		t.Errorf("Count before sweep = %d, want 3", tree.Count())
+	}
+
+	if swept := tree.SweepExpired(); swept != 1 || deletes != 1 || tree.Count() != 2 {
+		t.Errorf("SweepExpired = %d (%d hooks), Count %d", swept, deletes, tree.Count())
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 2, EnableTTL: true})
+	stop := tree.StartSweeper(5 * time.Millisecond)
+	defer stop()
+
+	tree.InsertWithTTL([]byte("k"), []byte("v"), time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for tree.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Sweeper never removed the expired key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDurableTTLRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	config := DurableConfig{WALPath: walPath, NumShards: 2, SyncMode: SyncNone, EnableTTL: true}
+	db, err := NewDurableBTree(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.InsertWithTTL([]byte("short"), []byte("1"), 30*time.Millisecond)
+	db.InsertWithTTL([]byte("long"), []byte("2"), time.Hour)
+	db.Close()
+
+	// Without EnableTTL the TTL entries cannot replay
+	if _, err := NewDurableBTree(DurableConfig{WALPath: walPath, NumShards: 2}); !errors.Is(err, ErrTTLDisabled) {
+		t.Errorf("Recovery without EnableTTL = %v, want ErrTTLDisabled", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	db, err = NewDurableBTree(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Find([]byte("short")); err == nil {
+		t.Error("Key that expired while down was found")
+	}
+	if value, err := db.Find([]byte("long")); err != nil || string(value) != "2" {
+		t.Errorf("Find(long) = %q, %v", value, err)
+	}
+}
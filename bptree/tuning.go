@@ -0,0 +1,222 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TuningConfig configures Recommend, which runs a short load sample and
+// suggests a shard count, WAL batch size and sync mode for this machine.
+//
+// METHOD:
+// - Shard count: smallest candidate within 90% of the best sampled throughput
+// - Sync mode: SyncAlways if one fsync costs under ~10µs, else SyncBatch
+// - Batch size: enough entries per fsync to amortize it to ~10µs per write
+//
+// USAGE:
+//
+//	rec, err := Recommend(TuningConfig{SampleDuration: 200 * time.Millisecond})
+//	tree := NewShardedBTree(ShardConfig{NumShards: rec.NumShards})
+type TuningConfig struct {
+	// Dir is a scratch directory for the fsync probe (default: a new temp dir)
+	Dir string
+
+	// SampleDuration is how long each shard-count trial runs (default:
+	// 100ms). A trial runs on until each worker has done minSampleOps
+	// operations, so a short duration still measures something.
+	SampleDuration time.Duration
+
+	// Workers is the number of concurrent goroutines (default: runtime.NumCPU())
+	Workers int
+
+	// WriteRatio is the fraction of operations that are writes (default: 0.5)
+	WriteRatio float64
+
+	// KeySpace is the number of distinct keys touched (default: 10000)
+	KeySpace int
+
+	// RequireDurability forces SyncAlways regardless of fsync cost
+	RequireDurability bool
+}
+
+// Recommendation is the tuning advice produced by Recommend.
+type Recommendation struct {
+	NumShards int
+	BatchSize int
+	SyncMode  SyncMode
+
+	// Throughput observed per candidate shard count (ops/sec)
+	Throughput map[int]float64
+
+	// FsyncLatency is the mean latency of one SyncAlways WAL append
+	FsyncLatency time.Duration
+
+	// Notes explains each choice
+	Notes []string
+}
+
+const (
+	defaultSampleDuration = 100 * time.Millisecond
+	defaultTuningKeySpace = 10000
+	fsyncProbeWrites      = 20
+	// minSampleOps is the fewest operations each worker runs per trial
+	minSampleOps = 100
+	// targetSyncCostPerWrite is the fsync cost per write SyncBatch aims for
+	targetSyncCostPerWrite = 10 * time.Microsecond
+	maxRecommendedBatch    = 1000
+)
+
+// Recommend measures this machine and returns tuning advice.
+func Recommend(config TuningConfig) (Recommendation, error) {
+	if config.SampleDuration <= 0 {
+		config.SampleDuration = defaultSampleDuration
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.WriteRatio <= 0 || config.WriteRatio > 1 {
+		config.WriteRatio = 0.5
+	}
+	if config.KeySpace <= 0 {
+		config.KeySpace = defaultTuningKeySpace
+	}
+
+	rec := Recommendation{Throughput: make(map[int]float64)}
+
+	// Shard count: smallest candidate within 90% of the best throughput
+	candidates := shardCandidates(config.Workers)
+	best := 0.0
+	for _, n := range candidates {
+		ops := sampleThroughput(n, config)
+		rec.Throughput[n] = ops
+		if ops > best {
+			best = ops
+		}
+	}
+	for _, n := range candidates {
+		if rec.Throughput[n] >= 0.9*best {
+			rec.NumShards = n
+			break
+		}
+	}
+	rec.Notes = append(rec.Notes, fmt.Sprintf(
+		"%d shards reach %.0f ops/sec, within 90%% of the best observed (%.0f ops/sec)",
+		rec.NumShards, rec.Throughput[rec.NumShards], best))
+
+	// Sync mode and batch size from fsync latency
+	latency, err := measureFsyncLatency(config.Dir)
+	if err != nil {
+		return rec, fmt.Errorf("failed to measure fsync latency: %w", err)
+	}
+	rec.FsyncLatency = latency
+
+	batch := int((latency + targetSyncCostPerWrite - 1) / targetSyncCostPerWrite)
+	if batch > maxRecommendedBatch {
+		batch = maxRecommendedBatch
+	}
+
+	switch {
+	case config.RequireDurability:
+		rec.SyncMode = SyncAlways
+		rec.BatchSize = 1
+		rec.Notes = append(rec.Notes, fmt.Sprintf(
+			"durability required: SyncAlways caps a single writer near %.0f writes/sec (fsync %v)",
+			float64(time.Second)/float64(latency), latency))
+	case batch <= 1:
+		rec.SyncMode = SyncAlways
+		rec.BatchSize = 1
+		rec.Notes = append(rec.Notes, fmt.Sprintf(
+			"fsync takes %v, cheap enough to sync every write", latency))
+	default:
+		rec.SyncMode = SyncBatch
+		rec.BatchSize = batch
+		rec.Notes = append(rec.Notes, fmt.Sprintf(
+			"fsync takes %v; batches of %d keep sync cost near %v per write (up to %d writes at risk on crash)",
+			latency, batch, targetSyncCostPerWrite, batch))
+	}
+
+	return rec, nil
+}
+
+// shardCandidates returns powers of two from 1 up to twice the worker count.
+func shardCandidates(workers int) []int {
+	var candidates []int
+	for n := 1; n <= 2*workers; n *= 2 {
+		candidates = append(candidates, n)
+	}
+	return candidates
+}
+
+// sampleThroughput runs the mixed workload against numShards shards and
+// returns observed operations per second.
+func sampleThroughput(numShards int, config TuningConfig) float64 {
+	tree := NewShardedBTree(ShardConfig{NumShards: numShards})
+	keys := make([]Keytype, config.KeySpace)
+	for i := range keys {
+		keys[i] = Keytype(fmt.Sprintf("tune:%08d", i))
+		tree.Insert(keys[i], Valuetype(keys[i]))
+	}
+
+	var ops uint64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for w := 0; w < config.Workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			var local uint64
+			for local < minSampleOps || !stop.Load() {
+				key := keys[rng.Intn(len(keys))]
+				if rng.Float64() < config.WriteRatio {
+					tree.Insert(key, Valuetype(key))
+				} else {
+					tree.Find(key)
+				}
+				local++
+			}
+			atomic.AddUint64(&ops, local)
+		}(int64(w))
+	}
+
+	time.Sleep(config.SampleDuration)
+	stop.Store(true)
+	wg.Wait()
+
+	return float64(ops) / time.Since(start).Seconds()
+}
+
+// measureFsyncLatency returns the mean latency of a SyncAlways WAL append.
+func measureFsyncLatency(dir string) (time.Duration, error) {
+	probeDir, err := os.MkdirTemp(dir, "stundb-tune-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(probeDir)
+
+	wal, err := NewWAL(WALConfig{
+		Path:     filepath.Join(probeDir, "probe.wal"),
+		SyncMode: SyncAlways,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer wal.Close()
+
+	value := make([]byte, 100)
+	start := time.Now()
+	for i := 0; i < fsyncProbeWrites; i++ {
+		if _, err := wal.AppendInsert([]byte(fmt.Sprintf("probe:%d", i)), value); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start) / fsyncProbeWrites, nil
+}
@@ -0,0 +1,60 @@
+package bptree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecommend(t *testing.T) {
+	rec, err := Recommend(TuningConfig{
+		Dir:            t.TempDir(),
+		SampleDuration: 10 * time.Millisecond,
+		Workers:        2,
+		KeySpace:       500,
+	})
+	if err != nil {
+		t.Fatalf("Recommend failed: %v", err)
+	}
+
+	if _, ok := rec.Throughput[rec.NumShards]; !ok {
+		t.Errorf("Recommended %d shards, which was not a measured candidate", rec.NumShards)
+	}
+	for n, ops := range rec.Throughput {
+		if ops <= 0 {
+			t.Errorf("No throughput recorded for %d shards", n)
+		}
+	}
+	if rec.FsyncLatency <= 0 {
+		t.Error("Expected a positive fsync latency")
+	}
+	if rec.BatchSize < 1 || rec.BatchSize > maxRecommendedBatch {
+		t.Errorf("BatchSize %d out of range", rec.BatchSize)
+	}
+	if rec.SyncMode == SyncBatch && rec.BatchSize < 2 {
+		t.Error("SyncBatch should come with a batch size above 1")
+	}
+	if len(rec.Notes) == 0 {
+		t.Error("Expected explanatory notes")
+	}
+}
+
+func TestRecommendRequireDurability(t *testing.T) {
+	rec, err := Recommend(TuningConfig{
+		Dir:               t.TempDir(),
+		SampleDuration:    time.Nanosecond, // Still minSampleOps per worker
+		Workers:           1,
+		KeySpace:          100,
+		RequireDurability: true,
+	})
+	if err != nil {
+		t.Fatalf("Recommend failed: %v", err)
+	}
+	if rec.SyncMode != SyncAlways {
+		t.Errorf("SyncMode = %v, want SyncAlways", rec.SyncMode)
+	}
+	for n, ops := range rec.Throughput {
+		if ops <= 0 {
+			t.Errorf("No throughput recorded for %d shards", n)
+		}
+	}
+}
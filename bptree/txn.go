@@ -0,0 +1,192 @@
+package bptree
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrCrossShard is returned by AtomicUpdate for keys that live on different
+// shards.
+var ErrCrossShard = errors.New("keys belong to different shards")
+
+// ShardTxn reads and writes the keys of one shard inside AtomicUpdate.
+// Writes are buffered and applied only if the callback succeeds; reads see
+// them. Every method fails with ErrCrossShard for a key on another shard.
+type ShardTxn interface {
+	// Get returns a copy of key's value, or an error if it is absent.
+	Get(key Keytype) (Valuetype, error)
+	// Put sets key to value. Oversized entries fail with a SizeLimitError.
+	Put(key Keytype, value Valuetype) error
+	// Delete removes key and reports whether it was present.
+	Delete(key Keytype) (bool, error)
+}
+
+// AtomicUpdate runs fn with the shard holding keys locked, so that fn can
+// read and write several of its keys atomically. If fn returns an error,
+// none of its writes are applied and AtomicUpdate returns that error.
+//
+// DESIGN:
+// - The shard's treeLock (and TTL lock) is held exclusively while fn runs and its writes apply
+// - Writes are buffered in the transaction and applied in the order first made
+// - Hooks fire after the shard is unlocked, as for single-key writes
+//
+// LIMITATIONS:
+// - All of keys must map to one shard: colocate related keys with ShardConfig.AffinityDelimiter
+// - fn blocks every other operation on the shard: keep it short, and do not call back into the tree
+// - Invariant violations while applying (panic-free mode) can leave the writes partially applied
+func (s *ShardedBTree) AtomicUpdate(keys []Keytype, fn func(view ShardTxn) error) error {
+	if len(keys) == 0 {
+		return errors.New("AtomicUpdate needs at least one key")
+	}
+
+	s.pin()
+	idx := s.getShardIndex(keys[0])
+	for _, key := range keys[1:] {
+		if s.getShardIndex(key) != idx {
+			s.unpin()
+			return ErrCrossShard
+		}
+	}
+	shard := s.shards[idx]
+	if err := shard.Err(); err != nil {
+		s.unpin()
+		return err
+	}
+
+	ts := s.ttlShard(idx)
+	ts.lock()
+	shard.treeLock.Lock()
+	txn := &shardTxn{s: s, idx: idx, shard: shard, ts: ts, cutoff: ttlNow(), writes: make(map[string]txnWrite)}
+	err := fn(txn)
+	var events []txnEvent
+	if err == nil {
+		events, err = txn.commit()
+	}
+	shard.treeLock.Unlock()
+	ts.unlock()
+	s.unpin()
+
+	hooks := s.hooked()
+	for _, e := range events {
+		if e.deleted {
+			atomic.AddUint64(&s.totalDeletes, 1)
+			hooks.fireDelete(e.key, e.old)
+		} else {
+			atomic.AddUint64(&s.totalInserts, 1)
+			hooks.fireWrite(e.key, e.old, e.existed, e.value)
+		}
+	}
+	return err
+}
+
+// shardTxn is the ShardTxn of AtomicUpdate. Its methods run with the shard's
+// treeLock held exclusively.
+type shardTxn struct {
+	s      *ShardedBTree
+	idx    int
+	shard  *Btree
+	ts     *ttlShard
+	cutoff int64
+
+	writes map[string]txnWrite
+	order  []string // Written keys, in first-write order
+}
+
+// txnWrite is a buffered write: a new value, or a delete.
+type txnWrite struct {
+	value   Valuetype
+	deleted bool
+}
+
+// txnEvent is an applied write whose hooks fire after the shard unlocks.
+type txnEvent struct {
+	writeEvent
+	deleted bool
+}
+
+func (tx *shardTxn) Get(key Keytype) (Valuetype, error) {
+	if tx.s.getShardIndex(key) != tx.idx {
+		return nil, ErrCrossShard
+	}
+	if w, ok := tx.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, errors.New("key not found")
+		}
+		return append(Valuetype{}, w.value...), nil
+	}
+
+	value, err := func() (value []byte, err error) {
+		defer tx.shard.guard("AtomicUpdate", key, &err)
+		return tx.shard.lookup(key, func(stored []byte) []byte {
+			return append([]byte{}, stored...)
+		})
+	}()
+	if err == nil && tx.ts.expired(key, tx.cutoff) {
+		return nil, errors.New("key not found")
+	}
+	return value, err
+}
+
+func (tx *shardTxn) Put(key Keytype, value Valuetype) error {
+	if tx.s.getShardIndex(key) != tx.idx {
+		return ErrCrossShard
+	}
+	if err := tx.shard.checkSizes(key, value); err != nil {
+		return err
+	}
+	tx.buffer(key, txnWrite{value: append(Valuetype{}, value...)})
+	return nil
+}
+
+func (tx *shardTxn) Delete(key Keytype) (bool, error) {
+	_, err := tx.Get(key)
+	if errors.Is(err, ErrCrossShard) || errors.Is(err, ErrInvariant) {
+		return false, err
+	}
+	tx.buffer(key, txnWrite{deleted: true})
+	return err == nil, nil
+}
+
+// buffer records w as the pending write of key.
+func (tx *shardTxn) buffer(key Keytype, w txnWrite) {
+	if _, ok := tx.writes[string(key)]; !ok {
+		tx.order = append(tx.order, string(key))
+	}
+	tx.writes[string(key)] = w
+}
+
+// commit applies the buffered writes to the shard and returns them as hook
+// events. Stops at the first invariant violation.
+func (tx *shardTxn) commit() ([]txnEvent, error) {
+	events := make([]txnEvent, 0, len(tx.order))
+	for _, k := range tx.order {
+		key, w := Keytype(k), tx.writes[k]
+		wasExpired := tx.ts.expired(key, tx.cutoff)
+		var e txnEvent
+		err := func() (err error) {
+			defer tx.shard.guard("AtomicUpdate", key, &err)
+			if w.deleted {
+				var deleted bool
+				e.old, deleted = tx.shard.remove(key)
+				e.deleted = true
+				e.existed = deleted
+			} else {
+				e.old, e.existed = tx.shard.put(key, w.value)
+			}
+			return nil
+		}()
+		if err != nil {
+			return events, err
+		}
+		tx.ts.forget(key)
+		if w.deleted && !e.existed {
+			continue
+		}
+		if wasExpired && !w.deleted {
+			e.old, e.existed = nil, false
+		}
+		e.key, e.value = key, w.value
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,135 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// sameShardKeys returns n keys that tree places on one shard, and one that
+// it places elsewhere.
+func sameShardKeys(tree *ShardedBTree, n int) (keys []Keytype, other Keytype) {
+	want := tree.getShardIndex([]byte("k0"))
+	for i := 0; len(keys) < n || other == nil; i++ {
+		key := Keytype(fmt.Sprintf("k%d", i))
+		if tree.getShardIndex(key) == want {
+			if len(keys) < n {
+				keys = append(keys, key)
+			}
+		} else if other == nil {
+			other = key
+		}
+	}
+	return keys, other
+}
+
+func TestAtomicUpdate(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	keys, other := sameShardKeys(tree, 3)
+	a, b, c := keys[0], keys[1], keys[2]
+	tree.Insert(a, []byte("100"))
+	tree.Insert(b, []byte("0"))
+	var writes, deletes int
+	tree.OnInsert(func(Keytype, Valuetype, Valuetype) { writes++ })
+	tree.OnUpdate(func(Keytype, Valuetype, Valuetype) { writes++ })
+	tree.OnDelete(func(Keytype, Valuetype, Valuetype) { deletes++ })
+
+	// Reads see the transaction's own writes; the tree sees them on success
+	err := tree.AtomicUpdate(keys, func(tx ShardTxn) error {
+		if err := tx.Put(a, []byte("60")); err != nil {
+			return err
+		}
+		if err := tx.Put(b, []byte("40")); err != nil {
+			return err
+		}
+		if v, err := tx.Get(a); err != nil || string(v) != "60" {
+			t.Errorf("Get(a) inside the transaction = %q, %v", v, err)
+		}
+		if present, err := tx.Delete(c); present || err != nil {
+			t.Errorf("Delete of an absent key = %v, %v", present, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AtomicUpdate: %v", err)
+	}
+	if v, _ := tree.Find(a); string(v) != "60" {
+		t.Errorf("a = %q after commit", v)
+	}
+	if v, _ := tree.Find(b); string(v) != "40" {
+		t.Errorf("b = %q after commit", v)
+	}
+	if writes != 2 || deletes != 0 {
+		t.Errorf("hooks saw %d writes and %d deletes, want 2 and 0", writes, deletes)
+	}
+
+	// A failing callback applies nothing
+	boom := errors.New("boom")
+	err = tree.AtomicUpdate(keys, func(tx ShardTxn) error {
+		tx.Put(a, []byte("0"))
+		tx.Delete(b)
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("AtomicUpdate = %v, want the callback's error", err)
+	}
+	if v, _ := tree.Find(a); string(v) != "60" {
+		t.Errorf("a = %q after a failed transaction", v)
+	}
+	if _, err := tree.Find(b); err != nil {
+		t.Errorf("b deleted by a failed transaction")
+	}
+
+	// Keys on different shards are rejected
+	if err := tree.AtomicUpdate([]Keytype{a, other}, func(ShardTxn) error { return nil }); !errors.Is(err, ErrCrossShard) {
+		t.Errorf("AtomicUpdate across shards = %v", err)
+	}
+	tree.AtomicUpdate(keys, func(tx ShardTxn) error {
+		if _, err := tx.Get(other); !errors.Is(err, ErrCrossShard) {
+			t.Errorf("Get of a key on another shard = %v", err)
+		}
+		if err := tx.Put(other, nil); !errors.Is(err, ErrCrossShard) {
+			t.Errorf("Put of a key on another shard = %v", err)
+		}
+		return nil
+	})
+	if err := tree.AtomicUpdate(nil, func(ShardTxn) error { return nil }); err == nil {
+		t.Error("AtomicUpdate without keys succeeded")
+	}
+}
+
+func TestAtomicUpdateConcurrent(t *testing.T) {
+	tree := NewShardedBTree(ShardConfig{NumShards: 4})
+	keys, _ := sameShardKeys(tree, 2)
+	tree.Insert(keys[0], []byte("1000"))
+	tree.Insert(keys[1], []byte("0"))
+
+	// Concurrent transfers keep the total constant
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				tree.AtomicUpdate(keys, func(tx ShardTxn) error {
+					from, _ := tx.Get(keys[0])
+					to, _ := tx.Get(keys[1])
+					f, _ := strconv.Atoi(string(from))
+					g, _ := strconv.Atoi(string(to))
+					tx.Put(keys[0], []byte(strconv.Itoa(f-1)))
+					return tx.Put(keys[1], []byte(strconv.Itoa(g+1)))
+				})
+				tree.Find(keys[0]) // Interleave plain reads
+			}
+		}()
+	}
+	wg.Wait()
+
+	from, _ := tree.Find(keys[0])
+	to, _ := tree.Find(keys[1])
+	if string(from) != "600" || string(to) != "400" {
+		t.Errorf("after 400 transfers: %s and %s, want 600 and 400", from, to)
+	}
+}
@@ -0,0 +1,191 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"iter"
+)
+
+// Codec converts values of type T to and from bytes.
+// A codec used for keys must preserve order: a < b exactly when
+// bytes.Compare(Encode(a), Encode(b)) < 0.
+type Codec[T any] interface {
+	Encode(T) []byte
+	Decode([]byte) (T, error)
+}
+
+// BtreeOf is a type-safe B-Tree with keys of type K and values of type V,
+// layered on the byte-slice Btree.
+//
+// DESIGN:
+// - Keys are stored in their order-preserving encoding, so ranges and iteration follow K's order
+// - Values are stored in any encoding, e.g. JSONCodec
+// - The underlying tree keeps its concurrency and panic-free behavior
+//
+// LIMITATIONS:
+// - Entries written through Tree() that fail to decode are skipped by range reads
+//
+// USAGE:
+//
+//	users := NewBtreeOf[int64, User](Int64Codec{}, JSONCodec[User]{})
+//	users.Insert(42, User{Name: "Ada"})
+//	user, err := users.Find(42)
+//
+//	for id, user := range users.Range(1, 100) {
+//		...
+//	}
+type BtreeOf[K, V any] struct {
+	tree   *Btree
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// NewBtreeOf creates an empty typed tree. keys must preserve order.
+func NewBtreeOf[K, V any](keys Codec[K], values Codec[V]) *BtreeOf[K, V] {
+	return &BtreeOf[K, V]{tree: &Btree{}, keys: keys, values: values}
+}
+
+// Tree returns the underlying byte-slice tree.
+func (t *BtreeOf[K, V]) Tree() *Btree {
+	return t.tree
+}
+
+// Insert inserts or updates a key.
+func (t *BtreeOf[K, V]) Insert(key K, value V) error {
+	return t.tree.TryInsert(t.keys.Encode(key), t.values.Encode(value))
+}
+
+// Find returns the value for key.
+func (t *BtreeOf[K, V]) Find(key K) (V, error) {
+	raw, err := t.tree.Find(t.keys.Encode(key))
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return t.values.Decode(raw)
+}
+
+// Delete removes key, reporting whether it was present.
+func (t *BtreeOf[K, V]) Delete(key K) (bool, error) {
+	return t.tree.TryDelete(t.keys.Encode(key))
+}
+
+// Len returns the number of keys.
+func (t *BtreeOf[K, V]) Len() int64 {
+	return t.tree.Len()
+}
+
+// GetRange returns all pairs with startKey <= key <= endKey, in key order.
+func (t *BtreeOf[K, V]) GetRange(startKey, endKey K) ([]K, []V, error) {
+	rawKeys, rawValues, err := t.tree.GetRange(t.keys.Encode(startKey), t.keys.Encode(endKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]K, 0, len(rawKeys))
+	values := make([]V, 0, len(rawValues))
+	for i := range rawKeys {
+		key, value, ok := t.decode(rawKeys[i], rawValues[i])
+		if !ok {
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// All returns an iterator over all pairs in key order.
+func (t *BtreeOf[K, V]) All() iter.Seq2[K, V] {
+	return t.typed(t.tree.All())
+}
+
+// Range returns an iterator over pairs with startKey <= key <= endKey, in
+// key order.
+func (t *BtreeOf[K, V]) Range(startKey, endKey K) iter.Seq2[K, V] {
+	return t.typed(t.tree.Range(t.keys.Encode(startKey), t.keys.Encode(endKey)))
+}
+
+// typed decodes the pairs of a byte-slice iterator.
+func (t *BtreeOf[K, V]) typed(raw iter.Seq2[[]byte, []byte]) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for rawKey, rawValue := range raw {
+			key, value, ok := t.decode(rawKey, rawValue)
+			if ok && !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// decode decodes a stored pair, reporting whether both halves decoded.
+func (t *BtreeOf[K, V]) decode(rawKey, rawValue []byte) (K, V, bool) {
+	key, keyErr := t.keys.Decode(rawKey)
+	value, valueErr := t.values.Decode(rawValue)
+	return key, value, keyErr == nil && valueErr == nil
+}
+
+// ============================================================================
+// Common Codecs
+// ============================================================================
+
+// StringCodec stores strings as their bytes. Order-preserving.
+type StringCodec struct{}
+
+func (StringCodec) Encode(s string) []byte { return []byte(s) }
+
+func (StringCodec) Decode(b []byte) (string, error) { return string(b), nil }
+
+// BytesCodec stores byte slices as is. Order-preserving.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(b []byte) []byte { return b }
+
+func (BytesCodec) Decode(b []byte) ([]byte, error) { return b, nil }
+
+// Uint64Codec stores integers as 8 big-endian bytes. Order-preserving.
+type Uint64Codec struct{}
+
+func (Uint64Codec) Encode(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func (Uint64Codec) Decode(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("uint64 key has %d bytes, want 8", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// Int64Codec stores integers as 8 big-endian bytes with the sign bit
+// flipped, so negative numbers sort first. Order-preserving.
+type Int64Codec struct{}
+
+func (Int64Codec) Encode(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v)^1<<63)
+}
+
+func (Int64Codec) Decode(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("int64 key has %d bytes, want 8", len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ 1<<63), nil
+}
+
+// JSONCodec stores values as JSON. Not order-preserving: use it for values.
+// Encode panics if T cannot be marshaled (channels, funcs), a programming error.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("JSONCodec: %v", err))
+	}
+	return data
+}
+
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
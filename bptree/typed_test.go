@@ -0,0 +1,98 @@
+package bptree
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+type typedTestUser struct {
+	Name string
+	Age  int
+}
+
+func TestBtreeOfBasic(t *testing.T) {
+	users := NewBtreeOf[string, typedTestUser](StringCodec{}, JSONCodec[typedTestUser]{})
+
+	if err := users.Insert("ada", typedTestUser{"Ada", 36}); err != nil {
+		t.Fatal(err)
+	}
+	users.Insert("alan", typedTestUser{"Alan", 41})
+
+	user, err := users.Find("ada")
+	if err != nil || user != (typedTestUser{"Ada", 36}) {
+		t.Errorf("Find(ada) = %+v, %v", user, err)
+	}
+	if _, err := users.Find("grace"); err == nil {
+		t.Error("Expected error for missing key")
+	}
+
+	if deleted, err := users.Delete("alan"); !deleted || err != nil {
+		t.Errorf("Delete(alan) = %v, %v", deleted, err)
+	}
+	if users.Len() != 1 {
+		t.Errorf("Len = %d, want 1", users.Len())
+	}
+}
+
+func TestBtreeOfIntegerOrder(t *testing.T) {
+	tree := NewBtreeOf[int64, string](Int64Codec{}, StringCodec{})
+	for _, k := range []int64{5, -3, 0, math.MinInt64, 100, -1, math.MaxInt64} {
+		tree.Insert(k, "v")
+	}
+
+	var got []int64
+	for k := range tree.All() {
+		got = append(got, k)
+	}
+	want := []int64{math.MinInt64, -3, -1, 0, 5, 100, math.MaxInt64}
+	if len(got) != len(want) {
+		t.Fatalf("All = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("All = %v, want %v", got, want)
+		}
+	}
+
+	keys, _, err := tree.GetRange(-3, 5)
+	if err != nil || len(keys) != 4 || keys[0] != -3 || keys[3] != 5 {
+		t.Errorf("GetRange(-3, 5) = %v, %v", keys, err)
+	}
+
+	var ranged []int64
+	for k := range tree.Range(-1, 100) {
+		if ranged = append(ranged, k); len(ranged) == 2 {
+			break
+		}
+	}
+	if len(ranged) != 2 || ranged[0] != -1 || ranged[1] != 0 {
+		t.Errorf("Range(-1, 100) with early stop = %v", ranged)
+	}
+}
+
+func TestBtreeOfSkipsUndecodable(t *testing.T) {
+	tree := NewBtreeOf[uint64, string](Uint64Codec{}, StringCodec{})
+	tree.Insert(1, "one")
+	tree.Tree().Insert([]byte("raw"), []byte("not a uint64 key"))
+
+	keys, values, err := tree.GetRange(0, math.MaxUint64)
+	if err != nil || len(keys) != 1 || values[0] != "one" {
+		t.Errorf("GetRange = %v, %v, %v", keys, values, err)
+	}
+}
+
+func TestCodecsPreserveOrder(t *testing.T) {
+	ints := []int64{math.MinInt64, -256, -1, 0, 1, 255, 256, math.MaxInt64}
+	for i := 1; i < len(ints); i++ {
+		if bytes.Compare(Int64Codec{}.Encode(ints[i-1]), Int64Codec{}.Encode(ints[i])) >= 0 {
+			t.Errorf("Int64Codec: %d does not sort before %d", ints[i-1], ints[i])
+		}
+		if v, _ := (Int64Codec{}).Decode(Int64Codec{}.Encode(ints[i])); v != ints[i] {
+			t.Errorf("Int64Codec round trip: %d -> %d", ints[i], v)
+		}
+	}
+	if _, err := (Uint64Codec{}).Decode([]byte{1, 2}); err == nil {
+		t.Error("Expected error decoding a short uint64")
+	}
+}
@@ -2,6 +2,7 @@ package bptree
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // WAL (Write-Ahead Log) provides durability for the B-Tree.
@@ -19,24 +21,83 @@ import (
 // - All mutations are logged BEFORE being applied to the tree
 // - On crash, replay the log to recover the tree state
 // - Checkpointing truncates the log after tree is persisted
+// - Reading stops at the first damaged entry; Corruption reports where, TruncateCorruptTail cuts it off
+// - RepairWAL salvages the intact entries past damage into a fresh file
+// - RotateLog archives the log as <path>.<sequence>; PurgeArchives deletes archives per the retention config
+// - Follow streams entries to subscribers as they are appended, for replicas and change capture
 //
 // LOG FORMAT:
+// Header: [magic:4][version:4][epoch:8] (version 1 has no epoch)
 // Each entry: [length:4][sequence:8][op:1][keyLen:4][key][valueLen:4][value][checksum:4]
+// An OpBatch entry (AppendBatch) carries several entries in its value, under one checksum
+//
+// FENCING:
+// - The epoch counts promotions (see Standby.Promote), which bump it in the old WAL's header
+// - After each flush the WAL rereads its header; a newer epoch fences it and the write fails with ErrFenced
+// - Since the check follows the flush, a write that succeeded is in the file a promotion copies
+//
+// ENCRYPTION:
+// - With WALConfig.EncryptionKey (AES-128/192/256) each entry's key and value are sealed with AES-GCM
+// - A sealed entry's op has opEncrypted set; its key field is [keyID:4][nonce:12][ciphertext], its value empty
+// - Nonces are random per entry; the sequence and op are authenticated as associated data
+// - Entries name their key by ID, so RotateEncryptionKey and DecryptionKeys let old entries replay
+// - Entries written without a key replay as they are, so encryption can be enabled on an existing log
+// - DurableReader and Standby take no key, and skip sealed entries
+//
+// PREALLOCATION:
+// - With WALConfig.PreallocateSize, space is reserved in extents ahead of the end of the log
+// - Reserving keeps the file size (fallocate with FALLOC_FL_KEEP_SIZE), so readers and replay see no padding
+// - Appends then fill reserved blocks, saving the allocation metadata each fsync would otherwise journal
+// - Linux only; elsewhere, or on a filesystem without fallocate, it is a no-op
 //
 // DURABILITY LEVELS:
 // - SyncNone: No fsync (fastest, least durable)
 // - SyncBatch: Fsync every N entries
-// - SyncAlways: Fsync every entry (slowest, most durable)
+// - SyncInterval: A background goroutine fsyncs every SyncInterval, bounding loss by time
+// - SyncAlways: Append returns once its entry is fsynced (slowest, most durable)
+//
+// GROUP COMMIT:
+// - Under SyncAlways, Append writes its entry under mu, then releases mu and waits for an fsync
+// - One waiter at a time leads: it flushes everything written so far and fsyncs once, outside mu
+// - Appends arriving during that fsync queue behind it and share the next one
+// - Callers serializing writes under their own lock log with appendUncommitted under it, then awaitCommit after releasing it
+// - Such callers apply a write only once awaitCommit returns, so nothing unsynced becomes visible
 type WAL struct {
 	file     *os.File
 	mu       sync.Mutex
+	syncMu   sync.Mutex // Held by the group commit leader; taken before mu
+	synced   uint64     // Entries up to this sequence are fsynced
 	sequence uint64
 	path     string
 
 	// Configuration
-	syncMode   SyncMode
-	batchSize  int
-	batchCount int
+	syncMode     SyncMode
+	batchSize    int
+	batchCount   int
+	maxKeySize   int
+	maxValueSize int
+	retention    archiveRetention
+	prealloc     int64      // PreallocateSize
+	cipher       *walCipher // Nil unless encrypted
+
+	// Background flusher for SyncInterval
+	stopFlusher chan struct{}
+	flusherDone chan struct{}
+	flushErr    error // First failed background fsync; fails later appends
+
+	followers map[*walFollower]struct{} // Follow subscribers
+	unsynced  []*LogEntry               // Published under SyncAlways, held back until fsynced
+
+	// Fencing (see FENCING)
+	epoch      uint64
+	headerSize int64 // Entries start here
+	fenced     bool
+
+	corruption *CorruptionReport // Where the last open or replay stopped, if not at the end
+
+	// Preallocation (see PREALLOCATION)
+	end          int64 // File size once the buffer is flushed
+	preallocated int64 // Space is reserved up to here
 
 	// Statistics
 	totalWrites    uint64
@@ -58,8 +119,26 @@ const (
 	SyncBatch
 	// SyncAlways calls fsync after every entry
 	SyncAlways
+	// SyncInterval calls fsync in the background every SyncInterval
+	SyncInterval
 )
 
+// String returns the name of the sync mode.
+func (m SyncMode) String() string {
+	switch m {
+	case SyncNone:
+		return "SyncNone"
+	case SyncBatch:
+		return "SyncBatch"
+	case SyncAlways:
+		return "SyncAlways"
+	case SyncInterval:
+		return "SyncInterval"
+	default:
+		return fmt.Sprintf("SyncMode(%d)", int(m))
+	}
+}
+
 // OpType represents the type of operation in the log.
 type OpType byte
 
@@ -67,6 +146,10 @@ const (
 	OpInsert OpType = iota + 1
 	OpDelete
 	OpClear
+	OpInsertTTL   // Value is the UnixNano expiry (8 bytes, little-endian) then the value
+	OpCreateIndex // Key is the index name, value its IndexDefinition as JSON
+	OpDropIndex   // Key is the index name
+	OpBatch       // Value holds the entries of an AppendBatch (see encodeBatch)
 )
 
 // LogEntry represents a single entry in the WAL.
@@ -86,8 +169,32 @@ type WALConfig struct {
 	SyncMode SyncMode
 	// BatchSize for SyncBatch mode (default: 100)
 	BatchSize int
+	// SyncInterval for SyncInterval mode (default: 5ms)
+	SyncInterval time.Duration
 	// BufferSize for buffered writes (default: 64KB)
 	BufferSize int
+	// MaxKeySize and MaxValueSize bound logged entries in bytes (default: 0,
+	// no limit). Append reports a SizeLimitError for an oversized entry.
+	MaxKeySize   int
+	MaxValueSize int
+	// Epoch for a new WAL file; an existing file keeps its own (default: 0)
+	Epoch uint64
+	// RetainArchives, RetainBytes and RetainAge bound the RotateLog archives
+	// PurgeArchives keeps: the newest N, the newest totalling M bytes, those
+	// younger than D (default: 0, no limit; with no limit set, PurgeArchives
+	// deletes every archive the last checkpoint covers)
+	RetainArchives int
+	RetainBytes    int64
+	RetainAge      time.Duration
+	// EncryptionKey seals entries with AES-GCM; 16, 24 or 32 bytes
+	// (default: nil, plaintext). See ENCRYPTION on WAL.
+	EncryptionKey []byte
+	// DecryptionKeys are retired keys entries in the file may be sealed
+	// under; without an EncryptionKey, new entries are plaintext
+	DecryptionKeys [][]byte
+	// PreallocateSize reserves disk space for the log in extents of this
+	// many bytes, ahead of the writes (default: 0, none). See PREALLOCATION.
+	PreallocateSize int64
 }
 
 // WALStats provides statistics about WAL operations.
@@ -101,16 +208,49 @@ type WALStats struct {
 }
 
 const (
-	defaultBatchSize  = 100
-	defaultBufferSize = 64 * 1024  // 64KB
-	walMagic          = 0x57414C31 // "WAL1"
-	walVersion        = 1
+	defaultBatchSize    = 100
+	defaultBufferSize   = 64 * 1024 // 64KB
+	defaultSyncInterval = 5 * time.Millisecond
+	walMagic            = 0x57414C31 // "WAL1"
+	walVersion          = 2          // Version 2 adds the epoch
+	walHeaderSize       = 16
+	walHeaderSizeV1     = 8
 )
 
+// ErrFenced is returned by a WAL whose file a newer epoch has taken over.
+var ErrFenced = errors.New("WAL fenced by a newer epoch")
+
 // Header written at the start of each WAL file
 type walHeader struct {
 	Magic   uint32
 	Version uint32
+	Epoch   uint64
+}
+
+// readWALHeader reads and validates a header of either version, returning
+// it and its size.
+func readWALHeader(r io.Reader) (walHeader, int64, error) {
+	var header walHeader
+	if err := binary.Read(r, binary.LittleEndian, &header.Magic); err != nil {
+		return header, 0, fmt.Errorf("failed to read WAL header: %w", err)
+	}
+	if header.Magic != walMagic {
+		return header, 0, errors.New("invalid WAL magic number")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header.Version); err != nil {
+		return header, 0, fmt.Errorf("failed to read WAL header: %w", err)
+	}
+	switch header.Version {
+	case 1:
+		return header, walHeaderSizeV1, nil
+	case walVersion:
+		if err := binary.Read(r, binary.LittleEndian, &header.Epoch); err != nil {
+			return header, 0, fmt.Errorf("failed to read WAL header: %w", err)
+		}
+		return header, walHeaderSize, nil
+	default:
+		return header, 0, fmt.Errorf("unsupported WAL version: %d", header.Version)
+	}
 }
 
 // NewWAL creates a new WAL with the given configuration.
@@ -127,6 +267,18 @@ func NewWAL(config WALConfig) (*WAL, error) {
 		config.BufferSize = defaultBufferSize
 	}
 
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = defaultSyncInterval
+	}
+
+	var walCipher *walCipher
+	if config.EncryptionKey != nil || len(config.DecryptionKeys) > 0 {
+		var err error
+		if walCipher, err = newWALCipher(config.EncryptionKey, config.DecryptionKeys); err != nil {
+			return nil, err
+		}
+	}
+
 	// Ensure directory exists
 	dir := filepath.Dir(config.Path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -140,11 +292,17 @@ func NewWAL(config WALConfig) (*WAL, error) {
 	}
 
 	w := &WAL{
-		file:      file,
-		path:      config.Path,
-		syncMode:  config.SyncMode,
-		batchSize: config.BatchSize,
-		writer:    bufio.NewWriterSize(file, config.BufferSize),
+		file:         file,
+		path:         config.Path,
+		syncMode:     config.SyncMode,
+		batchSize:    config.BatchSize,
+		maxKeySize:   config.MaxKeySize,
+		maxValueSize: config.MaxValueSize,
+		epoch:        config.Epoch,
+		retention:    archiveRetention{config.RetainArchives, config.RetainBytes, config.RetainAge},
+		cipher:       walCipher,
+		prealloc:     config.PreallocateSize,
+		writer:       bufio.NewWriterSize(file, config.BufferSize),
 	}
 
 	// Check if file is empty (new WAL)
@@ -167,20 +325,55 @@ func NewWAL(config WALConfig) (*WAL, error) {
 			return nil, fmt.Errorf("failed to validate WAL: %w", err)
 		}
 	}
+	w.preallocateAhead()
+
+	if w.syncMode == SyncInterval {
+		w.stopFlusher = make(chan struct{})
+		w.flusherDone = make(chan struct{})
+		go w.flushLoop(config.SyncInterval)
+	}
 
 	return w, nil
 }
 
+// flushLoop fsyncs entries written since the last fsync every interval,
+// until Close.
+func (w *WAL) flushLoop(interval time.Duration) {
+	defer close(w.flusherDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopFlusher:
+			return
+		case <-ticker.C:
+		}
+		w.syncMu.Lock()
+		w.mu.Lock()
+		if w.flushErr == nil && atomic.LoadUint64(&w.synced) < w.sequence {
+			if err := w.sync(); err != nil {
+				w.flushErr = fmt.Errorf("failed to sync WAL: %w", err)
+			}
+		}
+		w.mu.Unlock()
+		w.syncMu.Unlock()
+	}
+}
+
 // writeHeader writes the WAL file header.
 func (w *WAL) writeHeader() error {
 	header := walHeader{
 		Magic:   walMagic,
 		Version: walVersion,
+		Epoch:   w.epoch,
 	}
 
 	if err := binary.Write(w.writer, binary.LittleEndian, header); err != nil {
 		return err
 	}
+	w.headerSize = walHeaderSize
+	w.end = walHeaderSize
 
 	return w.writer.Flush()
 }
@@ -193,58 +386,109 @@ func (w *WAL) validateAndRecover() error {
 	}
 
 	// Read header
-	var header walHeader
-	if err := binary.Read(w.file, binary.LittleEndian, &header); err != nil {
-		return fmt.Errorf("failed to read WAL header: %w", err)
-	}
-
-	if header.Magic != walMagic {
-		return errors.New("invalid WAL magic number")
-	}
-
-	if header.Version != walVersion {
-		return fmt.Errorf("unsupported WAL version: %d", header.Version)
+	header, size, err := readWALHeader(w.file)
+	if err != nil {
+		return err
 	}
+	w.epoch, w.headerSize = header.Epoch, size
 
 	// Scan through entries to find last sequence
 	reader := bufio.NewReader(w.file)
 	var lastSeq uint64
+	offset := size
 
 	for {
 		entry, err := readEntry(reader)
-		if err == io.EOF {
-			break
-		}
 		if err != nil {
-			// Truncate corrupted tail
+			// EOF, or a corrupted tail (see Corruption)
+			w.noteCorruption(lastSeq, offset, err)
 			break
 		}
 		lastSeq = entry.Sequence
+		offset += entryFrameSize(entry)
 	}
 
 	w.sequence = lastSeq
 
 	// Seek to end for appending
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+	end, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
 		return err
 	}
+	w.end = end
 
 	return nil
 }
 
 // Append logs an operation to the WAL.
 func (w *WAL) Append(op OpType, key, value []byte) (uint64, error) {
+	seq, err := w.appendUncommitted(op, key, value)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.awaitCommit(seq); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// appendUncommitted is Append without waiting for the fsync SyncAlways
+// requires. The caller must awaitCommit the returned sequence before
+// acknowledging the write, holding none of its own locks so that other
+// writers can join the same fsync (see GROUP COMMIT).
+func (w *WAL) appendUncommitted(op OpType, key, value []byte) (uint64, error) {
+	if err := w.checkEntrySize(op, key, value); err != nil {
+		return 0, err
+	}
+	return w.write(1, func(seq uint64) LogEntry {
+		return LogEntry{Sequence: seq, Op: op, Key: key, Value: value}
+	})
+}
+
+// awaitCommit waits for the entry at seq to be fsynced if the sync mode
+// requires it before the write is acknowledged, and returns at once if not.
+func (w *WAL) awaitCommit(seq uint64) error {
+	if w.syncMode != SyncAlways {
+		return nil
+	}
+	return w.commit(seq)
+}
+
+// checkEntrySize reports a SizeLimitError for an entry over the limits.
+func (w *WAL) checkEntrySize(op OpType, key, value []byte) error {
+	limited := value
+	if op == OpInsertTTL && len(value) >= ttlEntrySize {
+		limited = value[ttlEntrySize:] // The expiry does not count toward the limit
+	}
+	return checkSizes(key, limited, w.maxKeySize, w.maxValueSize)
+}
+
+// write takes n sequence numbers, logs the entry build returns for the last
+// to the buffer and, unless under SyncAlways (see commit), syncs it as the
+// mode requires. It returns the last sequence.
+func (w *WAL) write(n uint64, build func(seq uint64) LogEntry) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.fenced {
+		return 0, ErrFenced
+	}
+	if w.flushErr != nil {
+		return 0, w.flushErr
+	}
+	if w.corruption != nil {
+		return 0, fmt.Errorf("%w: %v", ErrCorruptTail, w.corruption)
+	}
+
 	// Increment sequence
-	seq := atomic.AddUint64(&w.sequence, 1)
+	seq := atomic.AddUint64(&w.sequence, n)
+	entry := build(seq)
+	plain := entry
 
-	entry := LogEntry{
-		Sequence: seq,
-		Op:       op,
-		Key:      key,
-		Value:    value,
+	if w.cipher != nil {
+		if err := w.cipher.seal(&entry); err != nil {
+			return 0, err
+		}
 	}
 
 	// Calculate checksum
@@ -255,22 +499,76 @@ func (w *WAL) Append(op OpType, key, value []byte) (uint64, error) {
 		return 0, fmt.Errorf("failed to write WAL entry: %w", err)
 	}
 
-	atomic.AddUint64(&w.totalWrites, 1)
-	w.batchCount++
+	atomic.AddUint64(&w.totalWrites, n)
+	w.batchCount += int(n)
+	w.publish(&plain)
+	w.preallocateAhead()
+	if w.syncMode == SyncAlways {
+		return seq, nil
+	}
 
 	// Handle sync based on mode
 	if err := w.maybeSync(); err != nil {
 		return 0, fmt.Errorf("failed to sync WAL: %w", err)
 	}
+	if err := w.checkFence(); err != nil {
+		return 0, err
+	}
 
 	return seq, nil
 }
 
+// commit waits until the entry at seq is fsynced, leading a group commit
+// if no fsync in flight covers it (see GROUP COMMIT).
+func (w *WAL) commit(seq uint64) error {
+	if atomic.LoadUint64(&w.synced) < seq {
+		w.syncMu.Lock()
+		if atomic.LoadUint64(&w.synced) < seq {
+			w.mu.Lock()
+			target := atomic.LoadUint64(&w.sequence)
+			err := w.writer.Flush()
+			file := w.file
+			w.mu.Unlock()
+
+			if err == nil {
+				atomic.AddUint64(&w.totalSyncs, 1)
+				err = file.Sync()
+			}
+			if err != nil {
+				w.syncMu.Unlock()
+				return fmt.Errorf("failed to sync WAL: %w", err)
+			}
+			w.markSynced(target)
+		}
+		w.syncMu.Unlock()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.releaseSynced()
+	return w.checkFence()
+}
+
+// markSynced records that entries up to seq are fsynced.
+func (w *WAL) markSynced(seq uint64) {
+	for {
+		synced := atomic.LoadUint64(&w.synced)
+		if synced >= seq || atomic.CompareAndSwapUint64(&w.synced, synced, seq) {
+			return
+		}
+	}
+}
+
 // AppendInsert logs an insert operation.
 func (w *WAL) AppendInsert(key, value []byte) (uint64, error) {
 	return w.Append(OpInsert, key, value)
 }
 
+// AppendInsertTTL logs an insert of a key that expires at the given time.
+func (w *WAL) AppendInsertTTL(key, value []byte, expiry time.Time) (uint64, error) {
+	return w.Append(OpInsertTTL, key, encodeTTLValue(value, expiry.UnixNano()))
+}
+
 // AppendDelete logs a delete operation.
 func (w *WAL) AppendDelete(key []byte) (uint64, error) {
 	return w.Append(OpDelete, key, nil)
@@ -324,17 +622,32 @@ func (w *WAL) writeEntry(entry *LogEntry) error {
 	}
 
 	atomic.AddUint64(&w.totalBytes, uint64(4+entryLen)) // length prefix + entry
+	w.end += int64(4 + entryLen)
 
 	return nil
 }
 
-// readEntry reads a single log entry from the reader.
+// readEntry reads a single log entry from the reader. It returns io.EOF
+// only at a clean end, io.ErrUnexpectedEOF for a partial entry.
 func readEntry(reader *bufio.Reader) (*LogEntry, error) {
 	// Read length
 	var length uint32
 	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
 		return nil, err
 	}
+	entry, err := readEntryBody(reader, length)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return entry, err
+}
+
+// readEntryBody reads the rest of an entry of the given length.
+func readEntryBody(reader *bufio.Reader, length uint32) (*LogEntry, error) {
+	const fixed = 8 + 1 + 4 + 4 + 4 // Length without key and value
+	if length < fixed {
+		return nil, errors.New("invalid entry length")
+	}
 
 	// Read sequence
 	var seq uint64
@@ -353,6 +666,9 @@ func readEntry(reader *bufio.Reader) (*LogEntry, error) {
 	if err := binary.Read(reader, binary.LittleEndian, &keyLen); err != nil {
 		return nil, err
 	}
+	if keyLen > length-fixed {
+		return nil, errors.New("invalid key length")
+	}
 	key := make([]byte, keyLen)
 	if _, err := io.ReadFull(reader, key); err != nil {
 		return nil, err
@@ -363,6 +679,9 @@ func readEntry(reader *bufio.Reader) (*LogEntry, error) {
 	if err := binary.Read(reader, binary.LittleEndian, &valueLen); err != nil {
 		return nil, err
 	}
+	if valueLen != length-fixed-keyLen {
+		return nil, errors.New("invalid value length")
+	}
 	value := make([]byte, valueLen)
 	if _, err := io.ReadFull(reader, value); err != nil {
 		return nil, err
@@ -428,30 +747,112 @@ func (w *WAL) maybeSync() error {
 			return w.sync()
 		}
 		return w.writer.Flush() // At least flush to OS buffer
-	default: // SyncNone
+	default: // SyncNone, or SyncInterval's flusher fsyncs
 		return w.writer.Flush()
 	}
 }
 
-// sync flushes the buffer and calls fsync.
+// sync flushes the buffer and calls fsync. Called with w.mu held.
 func (w *WAL) sync() error {
 	if err := w.writer.Flush(); err != nil {
 		return err
 	}
 	atomic.AddUint64(&w.totalSyncs, 1)
-	return w.file.Sync()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.markSynced(w.sequence)
+	w.releaseSynced()
+	return nil
 }
 
 // Sync forces a sync to disk.
 func (w *WAL) Sync() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return w.checkFence()
+}
+
+// checkFence rereads the epoch in the file's header and fails with
+// ErrFenced once a promotion has bumped it. Version 1 files have no epoch
+// and are never fenced. Called with w.mu held.
+func (w *WAL) checkFence() error {
+	if w.fenced {
+		return ErrFenced
+	}
+	if w.headerSize != walHeaderSize {
+		return nil
+	}
+	var buf [8]byte
+	if _, err := w.file.ReadAt(buf[:], 8); err != nil {
+		return fmt.Errorf("failed to read WAL epoch: %w", err)
+	}
+	if binary.LittleEndian.Uint64(buf[:]) != w.epoch {
+		w.fenced = true
+		return ErrFenced
+	}
+	return nil
+}
+
+// Epoch returns the WAL's epoch, the number of promotions before it.
+func (w *WAL) Epoch() uint64 {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.sync()
+	return w.epoch
 }
 
 // Replay reads all entries from the WAL and applies them using the callback.
 // Returns the number of entries replayed.
 func (w *WAL) Replay(callback func(*LogEntry) error) (int, error) {
+	return w.ReplayCtx(context.Background(), callback)
+}
+
+// ReplayCtx is Replay stopping with ctx's error once ctx is done, checked
+// every ctxCheckInterval entries. The entries replayed so far stay applied.
+func (w *WAL) ReplayCtx(ctx context.Context, callback func(*LogEntry) error) (int, error) {
+	return w.replay(ctx, 0, callback, nil)
+}
+
+// ReplayFrom is Replay skipping the entries at or below seq, for a caller
+// that has already applied them. Returns the number of entries replayed.
+func (w *WAL) ReplayFrom(seq uint64, callback func(*LogEntry) error) (int, error) {
+	return w.replay(context.Background(), seq, callback, nil)
+}
+
+// ReplayProgress reports how far a replay has read.
+type ReplayProgress struct {
+	Entries    int   // Entries replayed
+	Bytes      int64 // Bytes read past the header
+	TotalBytes int64 // Bytes past the header when the replay started
+	Done       bool  // The final report
+}
+
+// Percent returns the share of the file read, from 0 to 100.
+func (p ReplayProgress) Percent() float64 {
+	if p.TotalBytes == 0 {
+		return 100
+	}
+	return 100 * float64(p.Bytes) / float64(p.TotalBytes)
+}
+
+// ReplayWithProgress is Replay calling progress every ctxCheckInterval
+// entries read, and once more when done, so a long recovery can report
+// how far along it is.
+func (w *WAL) ReplayWithProgress(callback func(*LogEntry) error, progress func(ReplayProgress)) (int, error) {
+	return w.replay(context.Background(), 0, callback, progress)
+}
+
+// replay implements the Replay variants. progress may be nil.
+func (w *WAL) replay(ctx context.Context, fromSeq uint64, callback func(*LogEntry) error, progress func(ReplayProgress)) (count int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -460,44 +861,104 @@ func (w *WAL) Replay(callback func(*LogEntry) error) (int, error) {
 		return 0, err
 	}
 
+	// Seek back to end for appending, however replay ends
+	defer func() {
+		if _, seekErr := w.file.Seek(0, io.SeekEnd); seekErr != nil && err == nil {
+			err = seekErr
+		}
+	}()
+
 	// Seek to beginning (after header)
-	if _, err := w.file.Seek(8, io.SeekStart); err != nil { // 8 bytes for header
+	if _, err := w.file.Seek(w.headerSize, io.SeekStart); err != nil {
 		return 0, err
 	}
 
-	reader := bufio.NewReader(w.file)
-	count := 0
+	var report ReplayProgress
+	if progress != nil {
+		if info, err := w.file.Stat(); err == nil {
+			report.TotalBytes = info.Size() - w.headerSize
+		}
+	}
 
-	for {
+	reader := bufio.NewReader(w.file)
+	frames := 0
+	var lastSeq uint64
+	var cancelled error
+
+	for ; ; frames++ {
+		if frames > 0 && frames%ctxCheckInterval == 0 {
+			if cancelled = ctx.Err(); cancelled != nil {
+				break
+			}
+			if progress != nil {
+				report.Entries = count
+				progress(report)
+			}
+		}
 		entry, err := readEntry(reader)
-		if err == io.EOF {
+		if err != nil {
+			// EOF, or corruption: stop at the last good entry (see Corruption)
+			w.noteCorruption(lastSeq, w.headerSize+report.Bytes, err)
 			break
 		}
+		lastSeq = entry.Sequence
+		report.Bytes += entryFrameSize(entry)
+		if entry.Sequence <= fromSeq {
+			continue // A batch's frame carries its last sequence
+		}
+		if err := w.cipher.open(entry); err != nil {
+			return count, err
+		}
+		entries, err := unbatch(entry)
 		if err != nil {
-			// Log corruption - stop replay at last good entry
-			break
+			return count, err
 		}
 
-		if err := callback(entry); err != nil {
-			return count, fmt.Errorf("replay callback failed at seq %d: %w", entry.Sequence, err)
+		for _, entry := range entries {
+			if entry.Sequence <= fromSeq {
+				continue
+			}
+			if err := callback(entry); err != nil {
+				return count, fmt.Errorf("replay callback failed at seq %d: %w", entry.Sequence, err)
+			}
+			count++
 		}
-		count++
 	}
 
-	// Seek back to end for appending
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
-		return count, err
+	if progress != nil {
+		report.Entries, report.Done = count, true
+		progress(report)
 	}
+	return count, cancelled
+}
 
-	return count, nil
+// entryFrameSize returns the bytes entry takes in the file, as read.
+func entryFrameSize(entry *LogEntry) int64 {
+	// length(4) + sequence(8) + op(1) + keyLen(4) + key + valueLen(4) + value + checksum(4)
+	return int64(4 + 8 + 1 + 4 + len(entry.Key) + 4 + len(entry.Value) + 4)
 }
 
 // Checkpoint truncates the WAL after confirming tree is persisted.
 // This should be called after the tree has been fully persisted to disk.
 func (w *WAL) Checkpoint() error {
+	return w.checkpoint(nil)
+}
+
+// checkpoint is Checkpoint starting the new file with carry, logged after
+// the checkpoint under fresh sequence numbers. The new file is written and
+// fsynced beside the old one, then renamed over it, so a crash leaves
+// either the old file or the new one with carry, never an empty one.
+func (w *WAL) checkpoint(carry []LogEntry) error {
+	w.syncMu.Lock() // The file must outlive a group commit's fsync
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	// A fenced WAL's path belongs to the new primary
+	if err := w.checkFence(); err != nil {
+		return err
+	}
+
 	// Flush pending writes
 	if err := w.writer.Flush(); err != nil {
 		return err
@@ -507,26 +968,66 @@ func (w *WAL) Checkpoint() error {
 	if err := w.file.Sync(); err != nil {
 		return err
 	}
+	w.markSynced(w.sequence)
+	w.releaseSynced()
 
-	// Record checkpoint sequence
-	atomic.StoreUint64(&w.lastCheckpoint, w.sequence)
-
-	// Close current file
-	if err := w.file.Close(); err != nil {
+	// Write the new file, header and carried entries, beside the old one
+	tmpPath := w.path + ".checkpoint"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
 		return err
 	}
-
-	// Truncate file (keep header)
-	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err != nil {
+	defer os.Remove(tmpPath) // No-op once renamed
+	old, oldWriter, oldEnd, oldHeaderSize := w.file, w.writer, w.end, w.headerSize
+	w.file, w.writer = file, bufio.NewWriterSize(file, defaultBufferSize)
+	restore := func(err error) error {
+		file.Close()
+		w.file, w.writer, w.end, w.headerSize = old, oldWriter, oldEnd, oldHeaderSize
 		return err
 	}
-
-	w.file = file
-	w.writer = bufio.NewWriterSize(file, defaultBufferSize)
-
-	// Write fresh header
 	if err := w.writeHeader(); err != nil {
+		return restore(err)
+	}
+	checkpoint := w.sequence
+	seq := checkpoint
+	carried := make([]LogEntry, 0, len(carry))
+	for _, entry := range carry {
+		seq++
+		entry.Sequence = seq
+		plain := entry
+		if w.cipher != nil {
+			if err := w.cipher.seal(&entry); err != nil {
+				return restore(err)
+			}
+		}
+		entry.Checksum = w.calculateChecksum(&entry)
+		if err := w.writeEntry(&entry); err != nil {
+			return restore(fmt.Errorf("failed to write WAL entry: %w", err))
+		}
+		carried = append(carried, plain)
+	}
+	if err := w.writer.Flush(); err != nil {
+		return restore(err)
+	}
+	if err := file.Sync(); err != nil {
+		return restore(err)
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return restore(err)
+	}
+	old.Close()
+
+	atomic.StoreUint64(&w.sequence, seq)
+	atomic.AddUint64(&w.totalWrites, uint64(len(carry)))
+	w.markSynced(seq)
+	atomic.StoreUint64(&w.lastCheckpoint, checkpoint)
+	w.corruption = nil
+	w.preallocated = 0
+	w.preallocateAhead()
+	for i := range carried {
+		w.publish(&carried[i])
+	}
+	if err := syncDir(w.path); err != nil {
 		return err
 	}
 
@@ -536,12 +1037,30 @@ func (w *WAL) Checkpoint() error {
 	return nil
 }
 
+// syncDir fsyncs the directory holding path, so a rename or a file created
+// there survives a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
 // RotateLog rotates the WAL to a new file (for archiving).
 // Returns the path to the archived file.
 func (w *WAL) RotateLog() (string, error) {
+	w.syncMu.Lock() // The file must outlive a group commit's fsync
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	// A fenced WAL's path belongs to the new primary
+	if err := w.checkFence(); err != nil {
+		return "", err
+	}
+
 	// Flush and sync
 	if err := w.writer.Flush(); err != nil {
 		return "", err
@@ -549,6 +1068,8 @@ func (w *WAL) RotateLog() (string, error) {
 	if err := w.file.Sync(); err != nil {
 		return "", err
 	}
+	w.markSynced(w.sequence)
+	w.releaseSynced()
 
 	// Close current file
 	if err := w.file.Close(); err != nil {
@@ -569,11 +1090,14 @@ func (w *WAL) RotateLog() (string, error) {
 
 	w.file = file
 	w.writer = bufio.NewWriterSize(file, defaultBufferSize)
+	w.corruption = nil
+	w.preallocated = 0
 
 	// Write header
 	if err := w.writeHeader(); err != nil {
 		return "", err
 	}
+	w.preallocateAhead()
 
 	return archivePath, nil
 }
@@ -600,8 +1124,17 @@ func (w *WAL) Stats() WALStats {
 
 // Close closes the WAL file.
 func (w *WAL) Close() error {
+	if w.stopFlusher != nil {
+		close(w.stopFlusher)
+		<-w.flusherDone
+		w.stopFlusher = nil
+	}
+
+	w.syncMu.Lock() // The file must outlive a group commit's fsync
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	defer w.closeFollowers()
 
 	if err := w.writer.Flush(); err != nil {
 		return err
@@ -609,6 +1142,8 @@ func (w *WAL) Close() error {
 	if err := w.file.Sync(); err != nil {
 		return err
 	}
+	w.markSynced(w.sequence)
+	w.releaseSynced()
 	return w.file.Close()
 }
 
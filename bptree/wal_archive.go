@@ -0,0 +1,103 @@
+package bptree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// archiveRetention bounds the archives PurgeArchives keeps (see WALConfig).
+type archiveRetention struct {
+	count int
+	bytes int64
+	age   time.Duration
+}
+
+// WALArchive describes a file RotateLog archived.
+type WALArchive struct {
+	Path     string
+	Sequence uint64 // Last sequence in the archive
+	Size     int64
+	ModTime  time.Time
+}
+
+// Archives returns the WAL's archives, oldest first.
+func (w *WAL) Archives() ([]WALArchive, error) {
+	dir, base := filepath.Split(w.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []WALArchive
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(suffix, 10, 64)
+		if err != nil {
+			continue // Not an archive
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		archives = append(archives, WALArchive{
+			Path:     filepath.Join(dir, entry.Name()),
+			Sequence: seq,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Sequence < archives[j].Sequence })
+	return archives, nil
+}
+
+// PurgeArchives deletes the archives the last checkpoint covers that fall
+// outside the retention limits, and returns their paths. Archives with
+// entries past the last checkpoint are always kept, as are all archives
+// before this WAL's first Checkpoint.
+func (w *WAL) PurgeArchives() ([]string, error) {
+	// Rotation and checkpointing wait, so the checkpoint stays current
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	archives, err := w.Archives()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := atomic.LoadUint64(&w.lastCheckpoint)
+	limited := w.retention != archiveRetention{}
+
+	var purged []string
+	var newerBytes int64
+	now := time.Now()
+	for i := len(archives) - 1; i >= 0; i-- { // Newest first
+		archive := archives[i]
+		newerBytes += archive.Size
+		retained := limited &&
+			(w.retention.count <= 0 || len(archives)-i <= w.retention.count) &&
+			(w.retention.bytes <= 0 || newerBytes <= w.retention.bytes) &&
+			(w.retention.age <= 0 || now.Sub(archive.ModTime) <= w.retention.age)
+		if retained || checkpoint == 0 || archive.Sequence > checkpoint {
+			continue
+		}
+		if err := os.Remove(archive.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return purged, err
+		}
+		purged = append(purged, archive.Path)
+	}
+	return purged, nil
+}
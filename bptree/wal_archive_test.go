@@ -0,0 +1,89 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func rotateArchives(t *testing.T, wal *WAL, rotations, entries int) {
+	t.Helper()
+	for r := 0; r < rotations; r++ {
+		for i := 0; i < entries; i++ {
+			if _, err := wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+				t.Fatalf("Failed to append: %v", err)
+			}
+		}
+		if _, err := wal.RotateLog(); err != nil {
+			t.Fatalf("Rotation failed: %v", err)
+		}
+	}
+}
+
+func TestWALPurgeArchivesRetention(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	os.WriteFile(walPath+".tmp", nil, 0644) // Not an archive
+
+	wal, err := NewWAL(WALConfig{Path: walPath, RetainArchives: 1})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	rotateArchives(t, wal, 3, 10)
+	if purged, err := wal.PurgeArchives(); err != nil || len(purged) != 0 {
+		t.Fatalf("Expected nothing purged before a checkpoint, got %v, %v", purged, err)
+	}
+
+	if err := wal.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	rotateArchives(t, wal, 1, 5)
+
+	purged, err := wal.PurgeArchives()
+	if err != nil || len(purged) != 3 {
+		t.Fatalf("Expected the 3 checkpointed archives purged, got %v, %v", purged, err)
+	}
+	archives, err := wal.Archives()
+	if err != nil || len(archives) != 1 || archives[0].Sequence != 35 {
+		t.Errorf("Expected only the archive past the checkpoint, got %+v, %v", archives, err)
+	}
+	if _, err := os.Stat(walPath + ".tmp"); err != nil {
+		t.Errorf("Non-archive file was touched: %v", err)
+	}
+}
+
+func TestWALPurgeArchivesLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WALConfig
+		kept   int
+	}{
+		{"NoLimit", WALConfig{}, 0},
+		{"Count", WALConfig{RetainArchives: 2}, 2},
+		{"Bytes", WALConfig{RetainBytes: 1}, 0},
+		{"Age", WALConfig{RetainAge: time.Hour}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Path = filepath.Join(t.TempDir(), "test.wal")
+			wal, err := NewWAL(tt.config)
+			if err != nil {
+				t.Fatalf("Failed to create WAL: %v", err)
+			}
+			defer wal.Close()
+
+			rotateArchives(t, wal, 4, 3)
+			wal.Checkpoint()
+			if _, err := wal.PurgeArchives(); err != nil {
+				t.Fatalf("PurgeArchives failed: %v", err)
+			}
+			archives, _ := wal.Archives()
+			if len(archives) != tt.kept {
+				t.Errorf("Expected %d archives kept, got %d", tt.kept, len(archives))
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// AppendBatch logs entries as one OpBatch frame: one lock, one checksum and
+// one sync decision for the whole batch. It sets each entry's Sequence,
+// consecutive in order, and returns the last. Replay yields the entries one
+// by one; a torn frame fails its checksum, so a batch replays whole or not
+// at all.
+func (w *WAL) AppendBatch(entries []LogEntry) (uint64, error) {
+	if len(entries) == 0 {
+		return w.Sequence(), nil
+	}
+	for i := range entries {
+		if entries[i].Op == OpBatch {
+			return 0, errors.New("cannot nest a WAL batch")
+		}
+		if err := w.checkEntrySize(entries[i].Op, entries[i].Key, entries[i].Value); err != nil {
+			return 0, fmt.Errorf("batch entry %d: %w", i, err)
+		}
+	}
+
+	n := uint64(len(entries))
+	seq, err := w.write(n, func(last uint64) LogEntry {
+		for i := range entries {
+			entries[i].Sequence = last - n + 1 + uint64(i)
+		}
+		return LogEntry{Sequence: last, Op: OpBatch, Value: encodeBatch(entries)}
+	})
+	if err != nil || w.syncMode != SyncAlways {
+		return seq, err
+	}
+	if err := w.commit(seq); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// encodeBatch serializes entries as the value of an OpBatch frame:
+// [count:4] then [sequence:8][op:1][keyLen:4][key][valueLen:4][value] each.
+func encodeBatch(entries []LogEntry) []byte {
+	size := 4
+	for i := range entries {
+		size += 8 + 1 + 4 + len(entries[i].Key) + 4 + len(entries[i].Value)
+	}
+	buf := make([]byte, 4, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(entries)))
+	for i := range entries {
+		buf = binary.LittleEndian.AppendUint64(buf, entries[i].Sequence)
+		buf = append(buf, byte(entries[i].Op))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries[i].Key)))
+		buf = append(buf, entries[i].Key...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries[i].Value)))
+		buf = append(buf, entries[i].Value...)
+	}
+	return buf
+}
+
+// unbatch returns the entries an OpBatch frame holds, or entry alone if it
+// is not a batch.
+func unbatch(entry *LogEntry) ([]*LogEntry, error) {
+	if entry.Op != OpBatch {
+		return []*LogEntry{entry}, nil
+	}
+
+	malformed := fmt.Errorf("malformed WAL batch at seq %d", entry.Sequence)
+	buf := entry.Value
+	if len(buf) < 4 {
+		return nil, malformed
+	}
+	count := binary.LittleEndian.Uint32(buf)
+	buf = buf[4:]
+
+	field := func() ([]byte, bool) {
+		if len(buf) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(buf)
+		if uint64(n) > uint64(len(buf)-4) {
+			return nil, false
+		}
+		data := buf[4 : 4+n]
+		buf = buf[4+n:]
+		return data, true
+	}
+
+	entries := make([]*LogEntry, 0, min(int(count), len(buf)/(8+1+4+4)))
+	for i := uint32(0); i < count; i++ {
+		if len(buf) < 9 {
+			return nil, malformed
+		}
+		e := &LogEntry{Sequence: binary.LittleEndian.Uint64(buf), Op: OpType(buf[8])}
+		buf = buf[9:]
+		var ok bool
+		if e.Key, ok = field(); !ok {
+			return nil, malformed
+		}
+		if e.Value, ok = field(); !ok {
+			return nil, malformed
+		}
+		entries = append(entries, e)
+	}
+	if len(buf) != 0 {
+		return nil, malformed
+	}
+	return entries, nil
+}
@@ -0,0 +1,114 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALAppendBatch(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	for _, key := range [][]byte{nil, bytes.Repeat([]byte{3}, 16)} {
+		os.Remove(walPath)
+		wal, err := NewWAL(WALConfig{Path: walPath, SyncMode: SyncAlways, EncryptionKey: key})
+		if err != nil {
+			t.Fatalf("Failed to create WAL: %v", err)
+		}
+
+		wal.AppendInsert([]byte("before"), []byte("0"))
+		batch := []LogEntry{
+			{Op: OpInsert, Key: []byte("a"), Value: []byte("1")},
+			{Op: OpDelete, Key: []byte("before")},
+			{Op: OpInsert, Key: []byte("b"), Value: []byte("2")},
+		}
+		last, err := wal.AppendBatch(batch)
+		if err != nil || last != 4 || batch[0].Sequence != 2 || batch[2].Sequence != 4 {
+			t.Fatalf("AppendBatch = %d, %v with sequences %d..%d", last, err, batch[0].Sequence, batch[2].Sequence)
+		}
+		if seq, _ := wal.AppendInsert([]byte("after"), []byte("5")); seq != 5 {
+			t.Errorf("Expected sequence 5 after the batch, got %d", seq)
+		}
+		if stats := wal.Stats(); stats.TotalWrites != 5 || stats.TotalSyncs != 3 {
+			t.Errorf("Expected 5 writes in 3 syncs, got %d in %d", stats.TotalWrites, stats.TotalSyncs)
+		}
+		wal.Close()
+
+		entries, err := replayAll(t, WALConfig{Path: walPath, EncryptionKey: key})
+		if err != nil || len(entries) != 5 {
+			t.Fatalf("Expected 5 entries, got %d, %v", len(entries), err)
+		}
+		for i, want := range []string{"before", "a", "before", "b", "after"} {
+			if entries[i].Sequence != uint64(i+1) || string(entries[i].Key) != want {
+				t.Errorf("Entry %d: got seq %d key %q, want seq %d key %q", i, entries[i].Sequence, entries[i].Key, i+1, want)
+			}
+		}
+		if entries[2].Op != OpDelete {
+			t.Errorf("Expected OpDelete in the batch, got %d", entries[2].Op)
+		}
+	}
+}
+
+func TestWALAppendBatchTornFrame(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	wal.AppendInsert([]byte("kept"), []byte("0"))
+	batch := make([]LogEntry, 10)
+	for i := range batch {
+		batch[i] = LogEntry{Op: OpInsert, Key: []byte(fmt.Sprintf("k%d", i)), Value: []byte("v")}
+	}
+	wal.AppendBatch(batch)
+	wal.Close()
+
+	// Tear the batch frame
+	info, _ := os.Stat(walPath)
+	os.Truncate(walPath, info.Size()-10)
+
+	entries, err := replayAll(t, WALConfig{Path: walPath})
+	if err != nil || len(entries) != 1 || string(entries[0].Key) != "kept" {
+		t.Errorf("Expected only the entry before the torn batch, got %d entries, %v", len(entries), err)
+	}
+}
+
+func TestWALAppendBatchRejects(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), MaxKeySize: 4})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	if _, err := wal.AppendBatch([]LogEntry{{Op: OpInsert, Key: []byte("ok")}, {Op: OpInsert, Key: []byte("too long")}}); err == nil {
+		t.Error("Expected error for an oversized entry")
+	}
+	if _, err := wal.AppendBatch([]LogEntry{{Op: OpBatch}}); err == nil {
+		t.Error("Expected error for a nested batch")
+	}
+	if seq, err := wal.AppendBatch(nil); err != nil || seq != 0 || wal.Sequence() != 0 {
+		t.Errorf("Expected empty batch to log nothing, got %d, %v", seq, err)
+	}
+}
+
+func TestDurableReaderAppliesBatches(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	defer db.Close()
+	if err := db.BulkInsert([]Keytype{Keytype("a"), Keytype("b")}, []Valuetype{Valuetype("1"), Valuetype("2")}); err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+
+	reader, err := OpenDurableReader(ReaderConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("OpenDurableReader failed: %v", err)
+	}
+	defer reader.Close()
+	if reader.Count() != 2 || reader.Sequence() != 2 {
+		t.Errorf("Expected 2 records at sequence 2, got %d at %d", reader.Count(), reader.Sequence())
+	}
+}
@@ -0,0 +1,95 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorruptTail is returned by appends to a WAL with a corrupt tail (see
+// Corruption): entries written after it could never be replayed.
+// TruncateCorruptTail, Checkpoint, RotateLog or RepairWAL clear it.
+var ErrCorruptTail = errors.New("WAL has a corrupt tail; truncate or repair it before appending")
+
+// CorruptionReport describes where a WAL's readable entries end. Replay
+// stops there; entries after it, even intact ones, are not replayed, and
+// appends fail with ErrCorruptTail until it is dealt with.
+type CorruptionReport struct {
+	LastGoodSeq uint64 // Sequence of the last entry before the damage
+	Offset      int64  // File offset of the first bad entry
+	Reason      string // Why it could not be read, e.g. "checksum mismatch"
+	Size        int64  // Bytes from Offset to the end of the file
+}
+
+func (r *CorruptionReport) String() string {
+	return fmt.Sprintf("WAL corrupt at offset %d after seq %d: %s (%d bytes)", r.Offset, r.LastGoodSeq, r.Reason, r.Size)
+}
+
+// Corruption returns the damage the last open or replay stopped at, or nil
+// if the file read cleanly to its end.
+func (w *WAL) Corruption() *CorruptionReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.corruption == nil {
+		return nil
+	}
+	report := *w.corruption
+	return &report
+}
+
+// noteCorruption records that reading stopped at offset with err, or clears
+// the record if err is a clean io.EOF. Called with w.mu held.
+func (w *WAL) noteCorruption(lastGood uint64, offset int64, err error) {
+	if err == io.EOF {
+		w.corruption = nil
+		return
+	}
+	var size int64
+	if info, statErr := w.file.Stat(); statErr == nil {
+		size = info.Size() - offset
+	}
+	w.corruption = &CorruptionReport{LastGoodSeq: lastGood, Offset: offset, Reason: err.Error(), Size: size}
+}
+
+// TruncateCorruptTail cuts the file at the damage Corruption reports,
+// discarding everything after it, and returns the bytes removed; appends
+// then succeed again. It fails if the damage lies before entries this WAL
+// appended, as they would be lost too; RepairWAL salvages them instead.
+func (w *WAL) TruncateCorruptTail() (int64, error) {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.checkFence(); err != nil {
+		return 0, err
+	}
+	report := w.corruption
+	if report == nil {
+		return 0, nil
+	}
+	if w.sequence != report.LastGoodSeq {
+		return 0, errors.New("entries were appended after the corrupt tail")
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return 0, err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := w.file.Truncate(report.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, err
+	}
+	w.corruption = nil
+	w.end, w.preallocated = report.Offset, report.Offset // Truncate freed the reserve
+	w.preallocateAhead()
+	return info.Size() - report.Offset, nil
+}
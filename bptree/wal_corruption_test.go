@@ -0,0 +1,154 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDamagedWAL writes n entries, then garbage, and returns the clean size.
+func writeDamagedWAL(t *testing.T, walPath string, n int, garbage []byte) int64 {
+	t.Helper()
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 1; i <= n; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	wal.Close()
+
+	info, _ := os.Stat(walPath)
+	f, _ := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write(garbage)
+	f.Close()
+	return info.Size()
+}
+
+func TestWALCorruptionReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		garbage []byte
+		reason  string
+	}{
+		{"Garbage", []byte("garbage data that will corrupt parsing"), "invalid key length"},
+		{"TornLength", []byte{40, 0}, "unexpected EOF"},
+		{"TornEntry", []byte{40, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0}, "unexpected EOF"},
+		{"BadChecksum", func() []byte {
+			frame := make([]byte, 25)
+			frame[0] = 21 // Length of an entry with no key or value
+			return frame
+		}(), "checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walPath := filepath.Join(t.TempDir(), "test.wal")
+			clean := writeDamagedWAL(t, walPath, 5, tt.garbage)
+
+			wal, err := NewWAL(WALConfig{Path: walPath})
+			if err != nil {
+				t.Fatalf("Failed to reopen WAL: %v", err)
+			}
+			defer wal.Close()
+
+			want := CorruptionReport{LastGoodSeq: 5, Offset: clean, Reason: tt.reason, Size: int64(len(tt.garbage))}
+			if report := wal.Corruption(); report == nil || *report != want {
+				t.Fatalf("Corruption() = %v, want %v", report, &want)
+			}
+			count, err := wal.Replay(func(*LogEntry) error { return nil })
+			if err != nil || count != 5 {
+				t.Errorf("Replay = %d, %v; want the 5 good entries", count, err)
+			}
+			if report := wal.Corruption(); report == nil || *report != want {
+				t.Errorf("Corruption() after replay = %v, want %v", report, &want)
+			}
+
+			removed, err := wal.TruncateCorruptTail()
+			if err != nil || removed != int64(len(tt.garbage)) {
+				t.Fatalf("TruncateCorruptTail = %d, %v; want %d", removed, err, len(tt.garbage))
+			}
+			if wal.Corruption() != nil {
+				t.Error("Expected no corruption after truncating")
+			}
+
+			// Appends now land where replay reaches them
+			wal.AppendInsert([]byte("key6"), []byte("value"))
+			count, _ = wal.Replay(func(*LogEntry) error { return nil })
+			if count != 6 || wal.Corruption() != nil {
+				t.Errorf("Expected 6 clean entries after truncating, got %d, %v", count, wal.Corruption())
+			}
+		})
+	}
+}
+
+func TestWALRefusesAppendPastCorruptTail(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	writeDamagedWAL(t, walPath, 3, []byte("garbage"))
+
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+
+	// An entry after the damage could never be replayed
+	if _, err := wal.AppendInsert([]byte("late"), []byte("value")); !errors.Is(err, ErrCorruptTail) {
+		t.Errorf("Append past a corrupt tail = %v, want ErrCorruptTail", err)
+	}
+	if wal.Sequence() != 3 || wal.Corruption() == nil {
+		t.Errorf("Refused append changed the WAL: seq %d, corruption %v", wal.Sequence(), wal.Corruption())
+	}
+
+	if _, err := wal.TruncateCorruptTail(); err != nil {
+		t.Fatalf("TruncateCorruptTail failed: %v", err)
+	}
+	if seq, err := wal.AppendInsert([]byte("late"), []byte("value")); err != nil || seq != 4 {
+		t.Errorf("Append after truncating = %d, %v; want seq 4", seq, err)
+	}
+}
+
+func TestWALReplayErrorKeepsAppendsAtEnd(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+	// A checkpointed file is not opened for appending, so its offset matters
+	if err := wal.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	// Entries past the replay's read buffer, so the failed replay stops mid-file
+	for i := 0; i < 3; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), make([]byte, 8192))
+	}
+
+	failed := errors.New("stop")
+	if _, err := wal.Replay(func(*LogEntry) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("Replay = %v, want the callback's error", err)
+	}
+	wal.AppendInsert([]byte("key3"), []byte("value"))
+
+	count, err := wal.Replay(func(*LogEntry) error { return nil })
+	if err != nil || count != 4 || wal.Corruption() != nil {
+		t.Errorf("Expected 4 clean entries, got %d, %v, %v", count, err, wal.Corruption())
+	}
+}
+
+func TestWALCleanHasNoCorruption(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	writeDamagedWAL(t, walPath, 3, nil)
+
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if report := wal.Corruption(); report != nil {
+		t.Errorf("Expected no corruption, got %v", report)
+	}
+	if removed, err := wal.TruncateCorruptTail(); err != nil || removed != 0 {
+		t.Errorf("TruncateCorruptTail = %d, %v; want a no-op", removed, err)
+	}
+}
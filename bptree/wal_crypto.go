@@ -0,0 +1,170 @@
+package bptree
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// opEncrypted flags the op of an entry whose key and value are sealed.
+const opEncrypted OpType = 0x80
+
+// ErrNoDecryptionKey is returned when replaying an entry sealed under a key
+// the WAL was not given.
+var ErrNoDecryptionKey = errors.New("no key to decrypt WAL entry")
+
+// walCipher seals entries under the current key and opens them under any
+// known key (see ENCRYPTION on WAL).
+type walCipher struct {
+	current   cipher.AEAD
+	currentID uint32
+	keys      map[uint32]cipher.AEAD
+}
+
+// newWALCipher returns a cipher sealing under key, or not at all if key is
+// nil, and also opening entries sealed under previous.
+func newWALCipher(key []byte, previous [][]byte) (*walCipher, error) {
+	c := &walCipher{keys: make(map[uint32]cipher.AEAD)}
+	for _, old := range previous {
+		if err := c.addKey(old); err != nil {
+			return nil, err
+		}
+	}
+	if key != nil {
+		if err := c.setKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// clone returns a copy of c that later key changes leave alone, or nil if
+// c is nil.
+func (c *walCipher) clone() *walCipher {
+	if c == nil {
+		return nil
+	}
+	return &walCipher{current: c.current, currentID: c.currentID, keys: maps.Clone(c.keys)}
+}
+
+// setKey seals from now on under key.
+func (c *walCipher) setKey(key []byte) error {
+	if err := c.addKey(key); err != nil {
+		return err
+	}
+	c.currentID = encryptionKeyID(key)
+	c.current = c.keys[c.currentID]
+	return nil
+}
+
+// addKey makes entries sealed under key openable.
+func (c *walCipher) addKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid WAL encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	c.keys[encryptionKeyID(key)] = aead
+	return nil
+}
+
+// encryptionKeyID names key in sealed entries without revealing it.
+func encryptionKeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.LittleEndian.Uint32(sum[:4])
+}
+
+// sealAAD binds a sealed payload to its entry's sequence and op.
+func sealAAD(seq uint64, op OpType) []byte {
+	aad := make([]byte, 9)
+	binary.LittleEndian.PutUint64(aad, seq)
+	aad[8] = byte(op)
+	return aad
+}
+
+// seal replaces entry's key and value with
+// [keyID:4][nonce:12][AES-GCM of [keyLen:4][key][value]] and flags its op.
+// Without a current key entry is left as it is.
+func (c *walCipher) seal(entry *LogEntry) error {
+	if c.current == nil {
+		return nil
+	}
+	nonceSize := c.current.NonceSize()
+	plain := make([]byte, 4+len(entry.Key)+len(entry.Value))
+	binary.LittleEndian.PutUint32(plain, uint32(len(entry.Key)))
+	copy(plain[4:], entry.Key)
+	copy(plain[4+len(entry.Key):], entry.Value)
+
+	sealed := make([]byte, 4+nonceSize, 4+nonceSize+len(plain)+c.current.Overhead())
+	binary.LittleEndian.PutUint32(sealed, c.currentID)
+	if _, err := rand.Read(sealed[4:]); err != nil {
+		return fmt.Errorf("failed to generate WAL nonce: %w", err)
+	}
+	sealed = c.current.Seal(sealed, sealed[4:], plain, sealAAD(entry.Sequence, entry.Op))
+
+	entry.Op |= opEncrypted
+	entry.Key, entry.Value = sealed, nil
+	return nil
+}
+
+// open reverses seal. Entries that are not sealed are left as they are.
+func (c *walCipher) open(entry *LogEntry) error {
+	if entry.Op&opEncrypted == 0 {
+		return nil
+	}
+	if c == nil || len(entry.Key) < 4 {
+		return fmt.Errorf("WAL entry %d: %w", entry.Sequence, ErrNoDecryptionKey)
+	}
+	aead, ok := c.keys[binary.LittleEndian.Uint32(entry.Key)]
+	if !ok {
+		return fmt.Errorf("WAL entry %d: %w", entry.Sequence, ErrNoDecryptionKey)
+	}
+
+	op := entry.Op &^ opEncrypted
+	sealed := entry.Key[4:]
+	if len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("WAL entry %d: sealed payload too short", entry.Sequence)
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, sealAAD(entry.Sequence, op))
+	if err != nil {
+		return fmt.Errorf("WAL entry %d: failed to decrypt: %w", entry.Sequence, err)
+	}
+	if len(plain) < 4 || int(binary.LittleEndian.Uint32(plain)) > len(plain)-4 {
+		return fmt.Errorf("WAL entry %d: malformed decrypted payload", entry.Sequence)
+	}
+
+	keyLen := int(binary.LittleEndian.Uint32(plain))
+	entry.Op = op
+	entry.Key = plain[4 : 4+keyLen]
+	entry.Value = plain[4+keyLen:]
+	return nil
+}
+
+// RotateEncryptionKey seals entries appended from now on under key, and
+// enables encryption if the WAL had no key. Entries already logged stay
+// readable under the old key, which the WAL keeps; pass it in
+// WALConfig.DecryptionKeys when reopening until a Checkpoint or RotateLog
+// has dropped its entries.
+func (w *WAL) RotateEncryptionKey(key []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c := w.cipher
+	if c == nil {
+		c = &walCipher{keys: make(map[uint32]cipher.AEAD)}
+	}
+	if err := c.setKey(key); err != nil {
+		return err
+	}
+	w.cipher = c
+	return nil
+}
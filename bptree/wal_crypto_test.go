@@ -0,0 +1,129 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func replayAll(t *testing.T, config WALConfig) ([]*LogEntry, error) {
+	t.Helper()
+	wal, err := NewWAL(config)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer wal.Close()
+
+	var entries []*LogEntry
+	_, err = wal.Replay(func(entry *LogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func TestWALEncryption(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	wal, err := NewWAL(WALConfig{Path: walPath, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	wal.AppendInsert([]byte("secret-key"), []byte("secret-value"))
+	wal.AppendDelete([]byte("secret-key"))
+	wal.AppendClear()
+	wal.Close()
+
+	data, _ := os.ReadFile(walPath)
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("WAL file contains plaintext")
+	}
+
+	entries, err := replayAll(t, WALConfig{Path: walPath, EncryptionKey: key})
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d, %v", len(entries), err)
+	}
+	if entries[0].Op != OpInsert || string(entries[0].Key) != "secret-key" || string(entries[0].Value) != "secret-value" {
+		t.Errorf("Insert decrypted wrong: %+v", entries[0])
+	}
+	if entries[1].Op != OpDelete || string(entries[1].Key) != "secret-key" || len(entries[1].Value) != 0 {
+		t.Errorf("Delete decrypted wrong: %+v", entries[1])
+	}
+	if entries[2].Op != OpClear || len(entries[2].Key) != 0 {
+		t.Errorf("Clear decrypted wrong: %+v", entries[2])
+	}
+
+	if _, err := replayAll(t, WALConfig{Path: walPath}); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Replay without key = %v, want ErrNoDecryptionKey", err)
+	}
+	if _, err := replayAll(t, WALConfig{Path: walPath, EncryptionKey: bytes.Repeat([]byte{2}, 32)}); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Replay with wrong key = %v, want ErrNoDecryptionKey", err)
+	}
+	if _, err := NewWAL(WALConfig{Path: walPath, EncryptionKey: []byte("short")}); err == nil {
+		t.Error("Expected error for an invalid key size")
+	}
+}
+
+func TestWALEncryptionKeyRotation(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 16)
+
+	// Plaintext entries, then one under each key
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	wal.AppendInsert([]byte("plain"), []byte("1"))
+	if err := wal.RotateEncryptionKey(oldKey); err != nil {
+		t.Fatalf("RotateEncryptionKey failed: %v", err)
+	}
+	wal.AppendInsert([]byte("old"), []byte("2"))
+	if err := wal.RotateEncryptionKey(newKey); err != nil {
+		t.Fatalf("RotateEncryptionKey failed: %v", err)
+	}
+	wal.AppendInsert([]byte("new"), []byte("3"))
+	wal.Close()
+
+	if _, err := replayAll(t, WALConfig{Path: walPath, EncryptionKey: newKey}); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Replay without the retired key = %v, want ErrNoDecryptionKey", err)
+	}
+	entries, err := replayAll(t, WALConfig{Path: walPath, EncryptionKey: newKey, DecryptionKeys: [][]byte{oldKey}})
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d, %v", len(entries), err)
+	}
+	for i, want := range []string{"plain", "old", "new"} {
+		if string(entries[i].Key) != want {
+			t.Errorf("Entry %d: expected key %s, got %s", i, want, entries[i].Key)
+		}
+	}
+}
+
+func TestDurableBTreeEncryptedRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	config := DurableConfig{WALPath: walPath, EncryptionKey: bytes.Repeat([]byte{7}, 24)}
+
+	db, err := NewDurableBTree(config)
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	db.Insert([]byte("k1"), []byte("v1"))
+	db.Insert([]byte("k2"), []byte("v2"))
+	db.Delete([]byte("k1"))
+	db.Close()
+
+	db, err = NewDurableBTree(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen DurableBTree: %v", err)
+	}
+	defer db.Close()
+	if value, err := db.Find([]byte("k2")); err != nil || string(value) != "v2" {
+		t.Errorf("Expected v2, got %q, %v", value, err)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Expected 1 record, got %d", db.Count())
+	}
+}
@@ -0,0 +1,260 @@
+package bptree
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// followerQueueLimit is how many entries a follower may have queued before
+// it is dropped (see Follow).
+const followerQueueLimit = 1 << 16
+
+// walFollower queues entries for one Follow channel, so writers never wait
+// on a slow consumer.
+type walFollower struct {
+	mu      sync.Mutex
+	queue   []*LogEntry
+	sending int  // Entries run took from queue and has yet to send
+	closed  bool // The WAL closed or dropped it: drain the queue, then close the channel
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Follow streams the entries after fromSeq: those already in the file, then
+// each entry as it is appended, in sequence order and with batches split
+// into their entries. The channel closes when cancel is called or the WAL
+// is closed. Entries reach followers once written, before their fsync;
+// under SyncAlways, once their commit has fsynced them.
+//
+// Entries a Checkpoint or RotateLog dropped from the file are not sent: a
+// first Sequence past fromSeq+1 reveals the gap. The entries already in the
+// file are streamed from it a frame at a time, so a long backlog costs no
+// memory. A follower that falls followerQueueLimit new entries behind is
+// dropped: its channel closes once the queued entries are received, and
+// Following from the last Sequence received resumes from the file. If the
+// file cannot be read the channel closes early.
+func (w *WAL) Follow(fromSeq uint64) (entries <-chan *LogEntry, cancel func()) {
+	f := &walFollower{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	out := make(chan *LogEntry)
+	cancel = func() {
+		w.mu.Lock()
+		delete(w.followers, f)
+		w.mu.Unlock()
+		f.stopOnce.Do(func() { close(f.stop) })
+	}
+
+	// Registering with the file's end in hand leaves no gap: entries written
+	// later are queued, and the backlog is read up to that end
+	file, start, end, cipher, err := w.register(f)
+	go func() {
+		defer close(out)
+		if err != nil {
+			return
+		}
+		err := f.sendBacklog(out, file, start, end, fromSeq, cipher)
+		file.Close()
+		if err != nil {
+			cancel()
+			return
+		}
+		f.run(out)
+	}()
+	return out, cancel
+}
+
+// register adds f to the followers and returns the file as it stands, where
+// its entries start and end and a copy of the cipher, for reading the
+// backlog without w.mu. Under SyncAlways the file is fsynced first, so the
+// backlog holds only committed entries and none is still held back.
+func (w *WAL) register(f *walFollower) (file *os.File, start, end int64, cipher *walCipher, err error) {
+	if w.syncMode == SyncAlways {
+		w.syncMu.Lock()
+		defer w.syncMu.Unlock()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.syncMode == SyncAlways {
+		err = w.sync()
+	} else {
+		err = w.writer.Flush()
+	}
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if file, err = os.Open(w.path); err != nil {
+		return nil, 0, 0, nil, err
+	}
+
+	if w.followers == nil {
+		w.followers = make(map[*walFollower]struct{})
+	}
+	w.followers[f] = struct{}{}
+	return file, w.headerSize, w.end, w.cipher.clone(), nil
+}
+
+// sendBacklog sends out the entries after fromSeq between start and end in
+// file, reading one frame at a time, until done or stopped. Entries
+// published meanwhile wait in the queue.
+func (f *walFollower) sendBacklog(out chan<- *LogEntry, file *os.File, start, end int64, fromSeq uint64, cipher *walCipher) error {
+	reader := bufio.NewReader(io.NewSectionReader(file, start, end-start))
+	for {
+		frame, err := readEntry(reader)
+		if err != nil {
+			return nil // EOF, or corruption that Replay also stops at
+		}
+		if frame.Sequence <= fromSeq {
+			continue // A batch frame carries its last sequence
+		}
+		if err := cipher.open(frame); err != nil {
+			return err
+		}
+		entries, err := unbatch(frame)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Sequence <= fromSeq {
+				continue
+			}
+			select {
+			case out <- entry:
+			case <-f.stop:
+				return nil // run returns at once
+			}
+		}
+	}
+}
+
+// publish queues a written entry, before sealing, for every follower. Under
+// SyncAlways the entry is held back until it is fsynced (see
+// releaseSynced). Called with w.mu held.
+func (w *WAL) publish(entry *LogEntry) {
+	if len(w.followers) == 0 {
+		return
+	}
+	var entries []*LogEntry
+	if entry.Op == OpBatch {
+		entries, _ = unbatch(entry) // Encoded by AppendBatch, so well formed
+	} else {
+		// The caller may reuse its buffers once Append returns
+		entries = []*LogEntry{{
+			Sequence: entry.Sequence,
+			Op:       entry.Op,
+			Key:      append([]byte(nil), entry.Key...),
+			Value:    append([]byte(nil), entry.Value...),
+		}}
+	}
+	if w.syncMode == SyncAlways {
+		w.unsynced = append(w.unsynced, entries...)
+		return
+	}
+	w.deliver(entries)
+}
+
+// releaseSynced publishes the held-back entries that are now fsynced.
+// Called with w.mu held.
+func (w *WAL) releaseSynced() {
+	synced := atomic.LoadUint64(&w.synced)
+	n := 0
+	for n < len(w.unsynced) && w.unsynced[n].Sequence <= synced {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	w.deliver(w.unsynced[:n])
+	w.unsynced = append(w.unsynced[:0], w.unsynced[n:]...)
+}
+
+// deliver queues entries for every follower, dropping any that has fallen
+// too far behind. Called with w.mu held.
+func (w *WAL) deliver(entries []*LogEntry) {
+	for f := range w.followers {
+		if !f.push(entries) {
+			delete(w.followers, f)
+		}
+	}
+}
+
+// closeFollowers closes every Follow channel once drained. Called with
+// w.mu held.
+func (w *WAL) closeFollowers() {
+	for f := range w.followers {
+		f.close()
+	}
+	w.followers = nil
+	w.unsynced = nil
+}
+
+// push queues entries and wakes run. If they would take the queue past
+// followerQueueLimit, it closes f instead and reports false.
+func (f *walFollower) push(entries []*LogEntry) bool {
+	f.mu.Lock()
+	kept := f.sending+len(f.queue)+len(entries) <= followerQueueLimit
+	if kept {
+		f.queue = append(f.queue, entries...)
+	} else {
+		f.closed = true
+	}
+	f.mu.Unlock()
+	f.signal()
+	return kept
+}
+
+// close makes run return once the queue is drained.
+func (f *walFollower) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.signal()
+}
+
+// signal wakes run if it is waiting.
+func (f *walFollower) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends queued entries to out until stopped, or until the WAL closes
+// and the queue is empty.
+func (f *walFollower) run(out chan<- *LogEntry) {
+	for {
+		f.mu.Lock()
+		batch, closed := f.queue, f.closed
+		f.queue, f.sending = nil, len(batch)
+		f.mu.Unlock()
+
+		for _, entry := range batch {
+			select {
+			case out <- entry:
+			case <-f.stop:
+				return
+			}
+		}
+		if len(batch) > 0 {
+			f.mu.Lock()
+			f.sending = 0
+			f.mu.Unlock()
+			continue
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-f.wake:
+		case <-f.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package bptree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, entries <-chan *LogEntry) *LogEntry {
+	t.Helper()
+	select {
+	case entry := <-entries:
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a followed entry")
+		return nil
+	}
+}
+
+func TestWALFollow(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+
+	entries, cancel := wal.Follow(1)
+	defer cancel()
+
+	// The backlog after fromSeq, then new appends, batches split
+	key := []byte("key4")
+	wal.AppendInsert(key, []byte("value"))
+	key[0] = 'X' // Followers get their own copy
+	wal.AppendBatch([]LogEntry{{Op: OpInsert, Key: []byte("key5")}, {Op: OpDelete, Key: []byte("key1")}})
+
+	for i, want := range []string{"key2", "key3", "key4", "key5", "key1"} {
+		entry := receive(t, entries)
+		if entry.Sequence != uint64(i+2) || string(entry.Key) != want {
+			t.Errorf("Entry %d: got seq %d key %q, want seq %d key %q", i, entry.Sequence, entry.Key, i+2, want)
+		}
+	}
+
+	// Close drains, then closes the channel
+	wal.AppendClear()
+	wal.Close()
+	if entry := receive(t, entries); entry.Op != OpClear {
+		t.Errorf("Expected OpClear before close, got %+v", entry)
+	}
+	if _, ok := <-entries; ok {
+		t.Error("Expected channel closed after the WAL closed")
+	}
+}
+
+func TestWALFollowCancel(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	entries, cancel := wal.Follow(0)
+	wal.AppendInsert([]byte("key"), []byte("value"))
+	cancel()
+	cancel() // Safe to call twice
+
+	for range entries { // Closes even with entries left unreceived
+	}
+	if _, err := wal.AppendInsert([]byte("key2"), []byte("value")); err != nil {
+		t.Errorf("Append after cancel failed: %v", err)
+	}
+	if len(wal.followers) != 0 {
+		t.Errorf("Expected no followers after cancel, got %d", len(wal.followers))
+	}
+}
+
+func TestWALFollowSyncAlwaysAfterCommit(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	entries, cancel := wal.Follow(0)
+	defer cancel()
+
+	// Stand in for a leader's fsync: the entry is written but not committed
+	wal.syncMu.Lock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := wal.AppendInsert([]byte("key"), []byte("value"))
+		done <- err
+	}()
+	for wal.Sequence() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case entry := <-entries:
+		t.Errorf("Entry %d reached a follower before its fsync", entry.Sequence)
+	case <-time.After(50 * time.Millisecond):
+	}
+	wal.syncMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if entry := receive(t, entries); entry.Sequence != 1 || string(entry.Key) != "key" {
+		t.Errorf("Expected seq 1 after the commit, got %+v", entry)
+	}
+}
+
+func TestWALFollowDropsSlowFollower(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	entries, cancel := wal.Follow(0)
+	defer cancel()
+	for i := 0; i < followerQueueLimit+10; i++ {
+		if _, err := wal.AppendDelete([]byte("k")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The queued entries arrive in order, then the channel closes
+	var received uint64
+	for entry := range entries {
+		if received++; entry.Sequence != received {
+			t.Fatalf("Entry %d has seq %d", received, entry.Sequence)
+		}
+	}
+	if received == 0 || received > followerQueueLimit {
+		t.Errorf("Received %d entries, want between 1 and %d", received, followerQueueLimit)
+	}
+	wal.mu.Lock()
+	followers := len(wal.followers)
+	wal.mu.Unlock()
+	if followers != 0 {
+		t.Errorf("Expected the slow follower dropped, %d left", followers)
+	}
+
+	// Following again from the last entry received resumes from the file
+	resumed, cancelResumed := wal.Follow(received)
+	defer cancelResumed()
+	if entry := receive(t, resumed); entry.Sequence != received+1 {
+		t.Errorf("Resumed at seq %d, want %d", entry.Sequence, received+1)
+	}
+}
+
+func TestWALFollowLongBacklog(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal"), SyncMode: SyncNone})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+	const backlog = followerQueueLimit + 10
+	for i := 0; i < backlog; i++ {
+		if _, err := wal.AppendDelete([]byte("k")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A backlog past the queue limit streams from the file without a drop
+	entries, cancel := wal.Follow(0)
+	defer cancel()
+	for want := uint64(1); want <= backlog; want++ {
+		if entry := receive(t, entries); entry.Sequence != want {
+			t.Fatalf("Received seq %d, want %d", entry.Sequence, want)
+		}
+	}
+	wal.AppendDelete([]byte("k"))
+	if entry := receive(t, entries); entry.Sequence != backlog+1 {
+		t.Errorf("Received seq %d after the backlog, want %d", entry.Sequence, backlog+1)
+	}
+}
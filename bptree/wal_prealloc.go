@@ -0,0 +1,16 @@
+package bptree
+
+// preallocateAhead reserves the next PreallocateSize bytes once the log
+// reaches the end of its reserve. A failed reservation turns preallocation
+// off, leaving the log to grow as it would without it. Called with w.mu
+// held.
+func (w *WAL) preallocateAhead() {
+	if w.prealloc <= 0 || w.end < w.preallocated {
+		return
+	}
+	if err := preallocate(w.file, w.end, w.prealloc); err != nil {
+		w.prealloc = 0
+		return
+	}
+	w.preallocated = w.end + w.prealloc
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package bptree
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: reserve blocks past the end of
+// the file without changing its size.
+const fallocKeepSize = 0x1
+
+// preallocate reserves length bytes of file from offset.
+func preallocate(file *os.File, offset, length int64) error {
+	for {
+		err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, offset, length)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
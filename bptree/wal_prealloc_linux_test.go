@@ -0,0 +1,69 @@
+//go:build linux
+
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// allocatedBytes returns the disk space reserved for path.
+func allocatedBytes(t *testing.T, path string) int64 {
+	t.Helper()
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return stat.Blocks * 512
+}
+
+func TestWALPreallocate(t *testing.T) {
+	const extent = 1 << 20
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	wal, err := NewWAL(WALConfig{Path: walPath, PreallocateSize: extent})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if wal.prealloc == 0 {
+		t.Skip("Filesystem does not support fallocate")
+	}
+	if allocated := allocatedBytes(t, walPath); allocated < extent {
+		t.Errorf("Expected at least %d bytes reserved, got %d", extent, allocated)
+	}
+
+	// Fill past the first extent
+	value := make([]byte, 4096)
+	for i := 0; i < 300; i++ {
+		if _, err := wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), value); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	wal.Sync()
+	info, _ := os.Stat(walPath)
+	if info.Size() != wal.end {
+		t.Errorf("Expected file size %d to match the log, got %d", wal.end, info.Size())
+	}
+	if allocated := allocatedBytes(t, walPath); allocated < info.Size()+extent/2 {
+		t.Errorf("Expected space reserved past %d bytes of log, got %d", info.Size(), allocated)
+	}
+
+	if err := wal.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	wal.AppendInsert([]byte("after"), []byte("value"))
+	wal.Close()
+
+	wal, err = NewWAL(WALConfig{Path: walPath, PreallocateSize: extent})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	count, err := wal.Replay(func(*LogEntry) error { return nil })
+	if err != nil || count != 1 || wal.Corruption() != nil {
+		t.Errorf("Expected 1 clean entry after checkpoint, got %d, %v, %v", count, err, wal.Corruption())
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bptree
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocate is not supported off Linux, which turns preallocation off.
+func preallocate(file *os.File, offset, length int64) error {
+	return errors.New("preallocation is not supported on this platform")
+}
@@ -0,0 +1,197 @@
+package bptree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RepairReport describes what RepairWAL kept and dropped.
+type RepairReport struct {
+	Entries int            // Entries salvaged, a batch counting once
+	LastSeq uint64         // Sequence of the last entry salvaged
+	Dropped []DroppedRange // Unreadable stretches of the old file, in order
+	Backup  string         // Where the damaged file was moved
+}
+
+// DroppedBytes returns the total size of the dropped ranges.
+func (r RepairReport) DroppedBytes() int64 {
+	var total int64
+	for _, d := range r.Dropped {
+		total += d.Size
+	}
+	return total
+}
+
+// DroppedRange is a stretch of a damaged WAL that held no verifiable entry.
+type DroppedRange struct {
+	Offset   int64  // File offset in the damaged file
+	Size     int64  // Bytes dropped
+	AfterSeq uint64 // Sequence of the entry salvaged just before, 0 if none
+}
+
+// RepairWAL rewrites the closed WAL at path with every entry that still
+// verifies, wherever it lies: after a damaged stretch it resyncs on the
+// next offset where a whole entry passes its checksum and continues the
+// sequence. The damaged file is kept at path.damaged.
+//
+// The file is streamed, holding one entry in memory at a time, or the
+// frame a damaged length prefix claims. Entries need no key to verify, so
+// encrypted WALs are repaired without one.
+func RepairWAL(path string) (RepairReport, error) {
+	var report RepairReport
+	damaged, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer damaged.Close()
+	info, err := damaged.Stat()
+	if err != nil {
+		return report, err
+	}
+	header, headerSize, err := readWALHeader(damaged)
+	if err != nil {
+		return report, err
+	}
+
+	tmpPath := path + ".repair"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(tmpPath) // No-op once renamed
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, defaultBufferSize)
+	fresh := &WAL{epoch: header.Epoch, writer: writer}
+	if err := fresh.writeHeader(); err != nil {
+		return report, err
+	}
+
+	scan := &repairScanner{reader: damaged, offset: headerSize, size: info.Size()}
+	dropStart := int64(-1)
+	for {
+		frame, seq, err := scan.next(report.LastSeq)
+		if err != nil {
+			return report, err
+		}
+		if frame == nil {
+			if scan.offset >= scan.size {
+				break
+			}
+			if dropStart < 0 {
+				dropStart = scan.offset
+			}
+			scan.skip(1)
+			continue
+		}
+		if dropStart >= 0 {
+			report.Dropped = append(report.Dropped, DroppedRange{Offset: dropStart, Size: scan.offset - dropStart, AfterSeq: report.LastSeq})
+			dropStart = -1
+		}
+		if _, err := writer.Write(frame); err != nil {
+			return report, err
+		}
+		report.Entries++
+		report.LastSeq = seq
+		scan.skip(len(frame))
+	}
+	if dropStart >= 0 {
+		report.Dropped = append(report.Dropped, DroppedRange{Offset: dropStart, Size: scan.size - dropStart, AfterSeq: report.LastSeq})
+	}
+
+	if err := writer.Flush(); err != nil {
+		return report, err
+	}
+	if err := file.Sync(); err != nil {
+		return report, err
+	}
+
+	report.Backup = path + ".damaged"
+	if err := os.Rename(path, report.Backup); err != nil {
+		return report, fmt.Errorf("failed to keep damaged WAL: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return report, fmt.Errorf("failed to replace damaged WAL: %w", err)
+	}
+	return report, syncDir(path)
+}
+
+// repairScanner reads a damaged WAL through a window that slides forward
+// as entries are kept or bytes skipped.
+type repairScanner struct {
+	reader io.Reader
+	window []byte // The file's bytes from offset on, as far as read
+	offset int64  // File offset of window[0]
+	size   int64  // File size
+}
+
+// next returns the frame of the entry at the scanner's offset and its
+// sequence, or a nil frame if no whole, verified entry past afterSeq starts
+// there. Cheap checks on the length prefix and sequence come first, so
+// most damaged offsets are rejected without reading a claimed frame.
+func (s *repairScanner) next(afterSeq uint64) ([]byte, uint64, error) {
+	const head = 4 + 8 + 1 + 4 // Length prefix, sequence, operation, key length
+	const fixed = 8 + 1 + 4 + 4 + 4
+	if s.size-s.offset < head {
+		return nil, 0, nil
+	}
+	if err := s.fill(head); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(s.window))
+	seq := binary.LittleEndian.Uint64(s.window[4:])
+	keyLen := int64(binary.LittleEndian.Uint32(s.window[13:]))
+	if length < fixed || 4+length > s.size-s.offset || seq <= afterSeq || keyLen > length-fixed {
+		return nil, 0, nil
+	}
+	if err := s.fill(int(4 + length)); err != nil {
+		return nil, 0, err
+	}
+	frame := s.window[:4+length]
+	if !verifyFrame(frame, keyLen) {
+		return nil, 0, nil
+	}
+	return frame, seq, nil
+}
+
+// skip moves the scanner n bytes forward.
+func (s *repairScanner) skip(n int) {
+	s.offset += int64(n)
+	s.window = s.window[min(n, len(s.window)):]
+}
+
+// fill reads until the window holds at least n bytes, which the caller
+// has checked are in the file.
+func (s *repairScanner) fill(n int) error {
+	if len(s.window) >= n {
+		return nil
+	}
+	grown := make([]byte, len(s.window), max(n, defaultBufferSize))
+	copy(grown, s.window)
+	read, err := io.ReadAtLeast(s.reader, grown[len(grown):cap(grown)], n-len(grown))
+	s.window = grown[:len(grown)+read]
+	return err
+}
+
+// verifyFrame reports whether frame, an entry with its length prefix whose
+// key length has been checked, is well formed and passes its checksum.
+func verifyFrame(frame []byte, keyLen int64) bool {
+	const fixed = 8 + 1 + 4 + 4 + 4
+	length := int64(len(frame)) - 4
+	valueLen := length - fixed - keyLen
+	key := frame[17 : 17+keyLen]
+	if int64(binary.LittleEndian.Uint32(frame[17+keyLen:])) != valueLen {
+		return false
+	}
+	value := frame[21+keyLen : 21+keyLen+valueLen]
+	entry := &LogEntry{
+		Sequence: binary.LittleEndian.Uint64(frame[4:]),
+		Op:       OpType(frame[12]),
+		Key:      key,
+		Value:    value,
+	}
+	return binary.LittleEndian.Uint32(frame[len(frame)-4:]) == calculateEntryChecksum(entry)
+}
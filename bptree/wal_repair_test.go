@@ -0,0 +1,110 @@
+package bptree
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepairWAL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 1; i <= 10; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("key%02d", i)), []byte("value"))
+	}
+	wal.Close()
+
+	// Damage entry 4 in the middle and tear the end
+	data, _ := os.ReadFile(walPath)
+	at := bytes.Index(data, []byte("key04"))
+	data[at] ^= 0xFF
+	data = append(data, 40, 0, 0)
+	os.WriteFile(walPath, data, 0644)
+
+	report, err := RepairWAL(walPath)
+	if err != nil {
+		t.Fatalf("RepairWAL failed: %v", err)
+	}
+	if report.Entries != 9 || report.LastSeq != 10 {
+		t.Errorf("Expected 9 entries up to seq 10, got %d up to %d", report.Entries, report.LastSeq)
+	}
+	if len(report.Dropped) != 2 || report.Dropped[0].AfterSeq != 3 || report.Dropped[1].AfterSeq != 10 || report.Dropped[1].Size != 3 {
+		t.Errorf("Unexpected dropped ranges: %+v", report.Dropped)
+	}
+	frame := entryFrameSize(&LogEntry{Key: []byte("key04"), Value: []byte("value")})
+	if report.DroppedBytes() != frame+3 {
+		t.Errorf("Expected %d bytes dropped, got %d", frame+3, report.DroppedBytes())
+	}
+	if backup, _ := os.ReadFile(report.Backup); !bytes.Equal(backup, data) {
+		t.Error("Damaged file was not kept as the backup")
+	}
+
+	wal, err = NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to open repaired WAL: %v", err)
+	}
+	defer wal.Close()
+	var seqs []uint64
+	wal.Replay(func(entry *LogEntry) error {
+		seqs = append(seqs, entry.Sequence)
+		return nil
+	})
+	if fmt.Sprint(seqs) != "[1 2 3 5 6 7 8 9 10]" || wal.Corruption() != nil {
+		t.Errorf("Expected a clean log without seq 4, got %v, %v", seqs, wal.Corruption())
+	}
+	if seq, _ := wal.AppendInsert([]byte("next"), []byte("value")); seq != 11 {
+		t.Errorf("Expected sequence to continue at 11, got %d", seq)
+	}
+}
+
+func TestRepairWALInvalidHeader(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	os.WriteFile(walPath, []byte("not a wal"), 0644)
+	if _, err := RepairWAL(walPath); err == nil {
+		t.Error("Expected error for a file without a WAL header")
+	}
+}
+
+func TestRepairWALLongDamage(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	wal, err := NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	wal.AppendInsert([]byte("first"), []byte("value"))
+	wal.Close()
+
+	// Garbage spanning several read buffers, then entries written past it
+	garbage := make([]byte, 3*defaultBufferSize+7)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	data, _ := os.ReadFile(walPath)
+	data = append(data, garbage...)
+	var tail bytes.Buffer
+	writer := bufio.NewWriter(&tail)
+	fresh := &WAL{writer: writer}
+	for i := uint64(2); i <= 4; i++ {
+		entry := &LogEntry{Sequence: i, Op: OpInsert, Key: []byte(fmt.Sprintf("key%d", i)), Value: []byte("value")}
+		entry.Checksum = calculateEntryChecksum(entry)
+		fresh.writeEntry(entry)
+	}
+	writer.Flush()
+	os.WriteFile(walPath, append(data, tail.Bytes()...), 0644)
+
+	report, err := RepairWAL(walPath)
+	if err != nil {
+		t.Fatalf("RepairWAL failed: %v", err)
+	}
+	if report.Entries != 4 || report.LastSeq != 4 {
+		t.Errorf("Expected 4 entries up to seq 4, got %d up to %d", report.Entries, report.LastSeq)
+	}
+	if len(report.Dropped) != 1 || report.DroppedBytes() != int64(len(garbage)) || report.Dropped[0].AfterSeq != 1 {
+		t.Errorf("Expected the garbage dropped after seq 1, got %+v", report.Dropped)
+	}
+}
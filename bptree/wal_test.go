@@ -9,7 +9,9 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // ==================== WAL Basic Tests ====================
@@ -209,6 +211,77 @@ func TestWALReplay(t *testing.T) {
 	}
 }
 
+func TestWALReplayFrom(t *testing.T) {
+	wal, err := NewWAL(WALConfig{Path: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	for i := 1; i <= 5; i++ {
+		wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	wal.AppendBatch([]LogEntry{{Op: OpInsert, Key: []byte("key6")}, {Op: OpInsert, Key: []byte("key7")}})
+
+	for _, tt := range []struct {
+		from  uint64
+		first uint64
+		count int
+	}{{0, 1, 7}, {3, 4, 4}, {6, 7, 1}, {7, 0, 0}, {100, 0, 0}} {
+		var seqs []uint64
+		count, err := wal.ReplayFrom(tt.from, func(entry *LogEntry) error {
+			seqs = append(seqs, entry.Sequence)
+			return nil
+		})
+		if err != nil || count != tt.count || len(seqs) != tt.count {
+			t.Errorf("ReplayFrom(%d) = %d, %v; want %d entries", tt.from, count, err, tt.count)
+			continue
+		}
+		if tt.count > 0 && seqs[0] != tt.first {
+			t.Errorf("ReplayFrom(%d) started at seq %d, want %d", tt.from, seqs[0], tt.first)
+		}
+	}
+}
+
+func TestWALReplayWithProgress(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	db, err := NewDurableBTree(DurableConfig{WALPath: walPath})
+	if err != nil {
+		t.Fatalf("Failed to create DurableBTree: %v", err)
+	}
+	const n = 3*ctxCheckInterval + 10
+	for i := 0; i < n; i++ {
+		db.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+	}
+	db.Close()
+
+	var reports []ReplayProgress
+	db, err = NewDurableBTree(DurableConfig{
+		WALPath:        walPath,
+		ReplayProgress: func(p ReplayProgress) { reports = append(reports, p) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to reopen DurableBTree: %v", err)
+	}
+	defer db.Close()
+
+	if len(reports) != 4 {
+		t.Fatalf("Expected 3 interim reports and a final one, got %d", len(reports))
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Entries <= reports[i-1].Entries || reports[i].Bytes <= reports[i-1].Bytes {
+			t.Errorf("Progress did not advance: %+v then %+v", reports[i-1], reports[i])
+		}
+	}
+	last := reports[len(reports)-1]
+	if !last.Done || last.Entries != n || last.Bytes != last.TotalBytes || last.Percent() != 100 {
+		t.Errorf("Expected a final report of all %d entries, got %+v (%.1f%%)", n, last, last.Percent())
+	}
+	if reports[0].Done || reports[0].Percent() <= 0 || reports[0].Percent() >= 50 {
+		t.Errorf("Expected an early interim report, got %+v (%.1f%%)", reports[0], reports[0].Percent())
+	}
+}
+
 func TestWALReplayWithTreeRecovery(t *testing.T) {
 	tmpDir := t.TempDir()
 	walPath := filepath.Join(tmpDir, "test.wal")
@@ -437,7 +510,7 @@ func TestWALRotation(t *testing.T) {
 	defer archiveFile.Close()
 
 	// Skip header
-	archiveFile.Seek(8, io.SeekStart)
+	archiveFile.Seek(walHeaderSize, io.SeekStart)
 	reader := bufio.NewReader(archiveFile)
 
 	archiveCount := 0
@@ -504,6 +577,97 @@ func TestWALConcurrentAppends(t *testing.T) {
 	}
 }
 
+func TestWALGroupCommit(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	wal, err := NewWAL(WALConfig{Path: walPath, SyncMode: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	// Stand in for a leader's fsync so every append queues behind it
+	const numGoroutines = 20
+	wal.syncMu.Lock()
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for g := 0; g < numGoroutines; g++ {
+		go func(id int) {
+			defer wg.Done()
+			seq, err := wal.AppendInsert([]byte(fmt.Sprintf("key%d", id)), []byte("value"))
+			if err != nil {
+				t.Errorf("Concurrent append failed: %v", err)
+				return
+			}
+			if synced := atomic.LoadUint64(&wal.synced); synced < seq {
+				t.Errorf("Append of seq %d returned with only %d synced", seq, synced)
+			}
+		}(g)
+	}
+	for wal.Sequence() < numGoroutines {
+		time.Sleep(time.Millisecond)
+	}
+	wal.syncMu.Unlock()
+	wg.Wait()
+
+	if stats := wal.Stats(); stats.TotalSyncs != 1 {
+		t.Errorf("Expected one fsync for %d queued appends, got %d", numGoroutines, stats.TotalSyncs)
+	}
+	wal.Close()
+
+	wal, err = NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	count, err := wal.Replay(func(*LogEntry) error { return nil })
+	if err != nil || count != numGoroutines {
+		t.Errorf("Expected %d entries replayed, got %d, %v", numGoroutines, count, err)
+	}
+}
+
+func TestWALSyncInterval(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	wal, err := NewWAL(WALConfig{Path: walPath, SyncMode: SyncInterval, SyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if SyncInterval.String() != "SyncInterval" {
+		t.Errorf("Expected SyncInterval, got %s", SyncInterval)
+	}
+
+	var seq uint64
+	for i := 0; i < 50; i++ {
+		if seq, err = wal.AppendInsert([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	// The flusher catches up without further appends
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint64(&wal.synced) < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("Background flusher did not sync seq %d", seq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := wal.Stats(); stats.TotalSyncs == 0 || stats.TotalSyncs > stats.TotalWrites {
+		t.Errorf("Expected between 1 and %d syncs, got %d", stats.TotalWrites, stats.TotalSyncs)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	wal, err = NewWAL(WALConfig{Path: walPath})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if count, err := wal.Replay(func(*LogEntry) error { return nil }); err != nil || count != 50 {
+		t.Errorf("Expected 50 entries replayed, got %d, %v", count, err)
+	}
+}
+
 // ==================== WAL Edge Case Tests ====================
 
 func TestWALEmptyKeyValue(t *testing.T) {
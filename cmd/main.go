@@ -1,5 +1,91 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"Database/bptree"
+)
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "recommend":
+		recommend(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stundb recommend [flags]")
+}
+
+// recommend samples this machine and prints suggested tuning settings.
+func recommend(args []string) {
+	fs := flag.NewFlagSet("recommend", flag.ExitOnError)
+	dir := fs.String("dir", "", "scratch directory for the fsync probe (default: temp dir)")
+	duration := fs.Duration("duration", 100*time.Millisecond, "sample duration per shard count")
+	workers := fs.Int("workers", 0, "concurrent workers (default: NumCPU)")
+	writeRatio := fs.Float64("write-ratio", 0.5, "fraction of operations that are writes")
+	durable := fs.Bool("durable", false, "require SyncAlways durability")
+	format := fs.String("format", "text", "output format: text or a registered serializer (json, gob, json+gzip, ...)")
+	fs.Parse(args)
+
+	var serializer bptree.Serializer
+	if *format != "text" {
+		var err error
+		if serializer, err = bptree.SerializerByName(*format); err != nil {
+			fmt.Fprintln(os.Stderr, "recommend:", err)
+			os.Exit(2)
+		}
+	}
+
+	rec, err := bptree.Recommend(bptree.TuningConfig{
+		Dir:               *dir,
+		SampleDuration:    *duration,
+		Workers:           *workers,
+		WriteRatio:        *writeRatio,
+		RequireDurability: *durable,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "recommend:", err)
+		os.Exit(1)
+	}
+
+	if serializer != nil {
+		payload, err := serializer.Marshal(rec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "recommend:", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(payload)
+		return
+	}
+
+	shardCounts := make([]int, 0, len(rec.Throughput))
+	for n := range rec.Throughput {
+		shardCounts = append(shardCounts, n)
+	}
+	sort.Ints(shardCounts)
 
+	fmt.Println("Throughput by shard count:")
+	for _, n := range shardCounts {
+		fmt.Printf("  %3d shards: %12.0f ops/sec\n", n, rec.Throughput[n])
+	}
+	fmt.Printf("fsync latency: %v\n\n", rec.FsyncLatency)
+	fmt.Printf("NumShards: %d\n", rec.NumShards)
+	fmt.Printf("SyncMode:  %s\n", rec.SyncMode)
+	fmt.Printf("BatchSize: %d\n", rec.BatchSize)
+	for _, note := range rec.Notes {
+		fmt.Println("-", note)
+	}
 }